@@ -1,15 +1,23 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
-	"orchestrator/node"
+	neturl "net/url"
+	"orchestrator/logger"
+	"orchestrator/manager"
 	"orchestrator/task"
+	"orchestrator/worker"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/docker/go-connections/nat"
@@ -18,14 +26,51 @@ import (
 )
 
 type taskInput struct {
-	Name          string
-	Image         string
-	Cpu           float64
-	Memory        int64
-	Disk          int64
-	ExposedPorts  []string
-	PortBindings  map[string]string
-	RestartPolicy string
+	Name             string
+	Namespace        string
+	Hostname         string
+	ContainerId      string
+	Image            string
+	PullPolicy       string
+	RegistryAuth     *task.RegistryAuth
+	Cpu              float64
+	Memory           int64
+	Disk             int64
+	CpusetCpus       string
+	ReadonlyRootfs   bool
+	Tmpfs            map[string]string
+	Volumes          []string
+	Env              []string
+	Cmd              []string
+	ExposedPorts     []string
+	PortBindings     map[string]string
+	RestartPolicy    string
+	StopSignal       string
+	StopTimeout      *int
+	HealthCheck      *healthCheckInput
+	CaptureOutput    bool
+	MaxLogSize       string
+	MaxLogFiles      int
+	Priority         int
+	Labels           map[string]string
+	AffinityTaskId   uuid.UUID
+	AffinityRequired bool
+	PreferredNode    string
+	NodeSelector     map[string]string
+	AntiAffinity     []string
+	MaxRestarts      int
+	Replicas         int
+}
+
+type healthCheckInput struct {
+	Cmd      []string
+	Interval string // Duration such as "30s"
+	Retries  int
+}
+
+type groupInput struct {
+	Name  string
+	Tasks []taskInput
 }
 
 func main() {
@@ -46,22 +91,99 @@ func main() {
 			},
 		},
 		Commands: []*cli.Command{
+			{
+				Name:      "init",
+				Usage:     "write a commented template of a task file",
+				ArgsUsage: "path of the file to create, the template is written to stdout when omitted",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "full",
+						Usage: "write all the task definition fields, the default",
+					},
+					&cli.BoolFlag{
+						Name:  "minimal",
+						Usage: "write only the fields commonly set",
+					},
+				},
+				Action: func(ctx *cli.Context) error {
+					if ctx.Args().Len() > 1 {
+						return fmt.Errorf("wrong arguments count, expected at most 1, got=%d", ctx.Args().Len())
+					}
+					if ctx.Bool("full") && ctx.Bool("minimal") {
+						return errors.New("the full and minimal options are mutually exclusive")
+					}
+					return initTaskFile(ctx.Args().First(), !ctx.Bool("minimal"))
+				},
+			},
 			{
 				Name:      "start",
 				Usage:     "submit a start task request",
-				ArgsUsage: "path to the file containing the yaml representation of the task to start",
+				ArgsUsage: `path to the file containing the json representation of the task to start, "-" to read it from stdin`,
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:    "wait",
+						Aliases: []string{"attach"},
+						Usage:   "follow the submitted tasks events until they reach a final state",
+					},
+				},
 				Action: func(ctx *cli.Context) error {
 					if ctx.Args().Len() != 1 {
 						return fmt.Errorf("wrong arguments count, expected=1, got=%d", ctx.Args().Len())
 					}
 					url := getUrl(ctx.String("host"), ctx.Int("port"))
-					return startTask(url, ctx.Args().First())
+					return startTask(url, ctx.Args().First(), ctx.Bool("wait"))
+				},
+			},
+			{
+				Name:      "deploy-group",
+				Usage:     "submit tasks to be deployed together, all of them are stopped if one can't be scheduled",
+				ArgsUsage: `path to the file containing the json representation of the group, "-" to read it from stdin`,
+				Action: func(ctx *cli.Context) error {
+					if ctx.Args().Len() != 1 {
+						return fmt.Errorf("wrong arguments count, expected=1, got=%d", ctx.Args().Len())
+					}
+					url := getUrl(ctx.String("host"), ctx.Int("port"))
+					return deployGroup(url, ctx.Args().First())
 				},
 			},
 			{
 				Name:      "stop",
 				Usage:     "submit a stop task request",
-				ArgsUsage: "id of the task to stop",
+				ArgsUsage: "id of the task to stop, omitted when selecting tasks by label",
+				Flags: []cli.Flag{
+					labelFlag("stop all the tasks having the given label(s), in the key=value format"),
+				},
+				Action: func(ctx *cli.Context) error {
+					url := getUrl(ctx.String("host"), ctx.Int("port"))
+					return runTaskAction(ctx, url, stopTask)
+				},
+			},
+			{
+				Name:      "restart",
+				Usage:     "submit a restart task request",
+				ArgsUsage: "id of the task to restart, omitted when selecting tasks by label",
+				Flags: []cli.Flag{
+					labelFlag("restart all the tasks having the given label(s), in the key=value format"),
+				},
+				Action: func(ctx *cli.Context) error {
+					url := getUrl(ctx.String("host"), ctx.Int("port"))
+					return runTaskAction(ctx, url, restartTask)
+				},
+			},
+			{
+				Name:      "set-restart-policy",
+				Usage:     "change the restart settings of a task, applied on its next restart",
+				ArgsUsage: "id of the task to update",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "policy",
+						Usage: `docker restart policy, allowed values: "no", "always", "on-failure", "unless-stopped"`,
+					},
+					&cli.IntFlag{
+						Name:  "maxRestarts",
+						Usage: "maximum number of restarts of the task after a failure, 0 to use the manager default",
+					},
+				},
 				Action: func(ctx *cli.Context) error {
 					if ctx.Args().Len() != 1 {
 						return fmt.Errorf("wrong arguments count, expected=1, got=%d", ctx.Args().Len())
@@ -71,15 +193,85 @@ func main() {
 					if err != nil {
 						return err
 					}
-					return stopTask(url, id)
+
+					request := manager.RestartPolicyRequest{}
+					if ctx.IsSet("policy") {
+						policy := ctx.String("policy")
+						request.RestartPolicy = &policy
+					}
+					if ctx.IsSet("maxRestarts") {
+						maxRestarts := ctx.Int("maxRestarts")
+						request.MaxRestarts = &maxRestarts
+					}
+					if request.RestartPolicy == nil && request.MaxRestarts == nil {
+						return errors.New("nothing to update, expected at least one of --policy and --maxRestarts")
+					}
+					return updateRestartPolicy(url, id, request)
+				},
+			},
+			{
+				Name:      "scale",
+				Usage:     "change the number of replicas of a replicated task, starting or stopping replicas as needed",
+				ArgsUsage: "name of the replicated task and number of replicas",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "namespace",
+						Usage:    "namespace of the replicated task",
+						Required: true,
+					},
+				},
+				Action: func(ctx *cli.Context) error {
+					if ctx.Args().Len() != 2 {
+						return fmt.Errorf("wrong arguments count, expected=2, got=%d", ctx.Args().Len())
+					}
+					url := getUrl(ctx.String("host"), ctx.Int("port"))
+					replicas, err := strconv.Atoi(ctx.Args().Get(1))
+					if err != nil || replicas < 0 {
+						return fmt.Errorf("invalid replicas %q: must be a positive number", ctx.Args().Get(1))
+					}
+					return scaleTask(url, ctx.String("namespace"), ctx.Args().First(), replicas)
 				},
 			},
 			{
 				Name:  "list",
 				Usage: "get all tasks from the manager",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "stream",
+						Usage: "receive and print tasks one by one instead of loading the whole list",
+					},
+					&cli.StringFlag{
+						Name:  "namespace",
+						Usage: "only list the tasks of the given namespace",
+					},
+					labelFlag("only list the tasks having the given label(s), in the key=value format"),
+					&cli.TimestampFlag{
+						Name:   "startedAfter",
+						Usage:  "only list the tasks started after the given RFC 3339 time, e.g. 2024-01-02T15:04:05Z",
+						Layout: time.RFC3339,
+					},
+					&cli.TimestampFlag{
+						Name:   "finishedBefore",
+						Usage:  "only list the tasks finished before the given RFC 3339 time, e.g. 2024-01-02T15:04:05Z",
+						Layout: time.RFC3339,
+					},
+				},
 				Action: func(ctx *cli.Context) error {
 					url := getUrl(ctx.String("host"), ctx.Int("port"))
-					return listTasks(url)
+					filter := taskFilter{
+						Namespace: ctx.String("namespace"),
+						Labels:    ctx.StringSlice("label"),
+					}
+					if startedAfter := ctx.Timestamp("startedAfter"); startedAfter != nil {
+						filter.StartedAfter = *startedAfter
+					}
+					if finishedBefore := ctx.Timestamp("finishedBefore"); finishedBefore != nil {
+						filter.FinishedBefore = *finishedBefore
+					}
+					if ctx.Bool("stream") {
+						return streamTasks(url, filter)
+					}
+					return listTasks(url, filter)
 				},
 			},
 			{
@@ -98,6 +290,91 @@ func main() {
 					return getTask(url, id)
 				},
 			},
+			{
+				Name:      "events",
+				Usage:     "get the events submitted for a task, in chronological order",
+				ArgsUsage: "id of the task, all the events are listed when omitted",
+				Action: func(ctx *cli.Context) error {
+					if ctx.Args().Len() > 1 {
+						return fmt.Errorf("wrong arguments count, expected at most 1, got=%d", ctx.Args().Len())
+					}
+					url := getUrl(ctx.String("host"), ctx.Int("port"))
+					var id uuid.UUID
+					if ctx.Args().Len() == 1 {
+						var err error
+						if id, err = uuid.Parse(ctx.Args().First()); err != nil {
+							return err
+						}
+					}
+					return getEvents(url, id)
+				},
+			},
+			{
+				Name:      "stats",
+				Usage:     "get the cpu, memory and network usage of a task container",
+				ArgsUsage: "id of the task to query",
+				Action: func(ctx *cli.Context) error {
+					if ctx.Args().Len() != 1 {
+						return fmt.Errorf("wrong arguments count, expected=1, got=%d", ctx.Args().Len())
+					}
+					url := getUrl(ctx.String("host"), ctx.Int("port"))
+					id, err := uuid.Parse(ctx.Args().First())
+					if err != nil {
+						return err
+					}
+					return getTaskStats(url, id)
+				},
+			},
+			{
+				Name:      "logs",
+				Usage:     "print the logs of a task container, or the recent logs of the manager or of a worker",
+				ArgsUsage: "id of the task whose container logs are printed, omitted with --manager and --worker",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "manager",
+						Usage: "print the manager logs",
+					},
+					&cli.StringFlag{
+						Name:  "worker",
+						Usage: "name of the worker whose logs are printed",
+					},
+					&cli.DurationFlag{
+						Name:  "since",
+						Usage: "only print the logs written during the given duration, e.g. 10m",
+					},
+					&cli.BoolFlag{
+						Name:    "follow",
+						Aliases: []string{"f"},
+						Usage:   "keep printing new logs, reconnecting when the stream is interrupted",
+					},
+				},
+				Action: func(ctx *cli.Context) error {
+					url := getUrl(ctx.String("host"), ctx.Int("port"))
+					if ctx.Args().Len() != 0 {
+						if ctx.Args().Len() != 1 || ctx.Bool("manager") || ctx.IsSet("worker") || ctx.IsSet("since") {
+							return errors.New("expected a single task id, without --manager, --worker and --since")
+						}
+						id, err := uuid.Parse(ctx.Args().First())
+						if err != nil {
+							return err
+						}
+						return printTaskLogs(url, id, ctx.Bool("follow"))
+					}
+					if ctx.Bool("manager") == ctx.IsSet("worker") {
+						return errors.New("expected exactly one of --manager and --worker")
+					}
+					if ctx.IsSet("worker") {
+						url = fmt.Sprintf("%s/nodes/%s/logs", url, neturl.PathEscape(ctx.String("worker")))
+					} else {
+						url = fmt.Sprintf("%s/logs", url)
+					}
+					var since time.Time
+					if ctx.IsSet("since") {
+						since = time.Now().Add(-ctx.Duration("since"))
+					}
+					return printLogs(url, since, ctx.Bool("follow"))
+				},
+			},
 			{
 				Name:  "list-nodes",
 				Usage: "get registered nodes from the manager",
@@ -106,6 +383,34 @@ func main() {
 					return listNodes(url)
 				},
 			},
+			{
+				Name:      "drain-node",
+				Usage:     "move the tasks of a worker node to other nodes then remove it from the cluster",
+				ArgsUsage: "name of the worker node to remove",
+				Action: func(ctx *cli.Context) error {
+					if ctx.Args().Len() != 1 {
+						return fmt.Errorf("wrong arguments count, expected=1, got=%d", ctx.Args().Len())
+					}
+					url := getUrl(ctx.String("host"), ctx.Int("port"))
+					return removeNode(url, ctx.Args().First())
+				},
+			},
+			{
+				Name:  "shutdown",
+				Usage: "stop all tasks of the cluster then shut down the manager",
+				Action: func(ctx *cli.Context) error {
+					url := getUrl(ctx.String("host"), ctx.Int("port"))
+					return shutdownCluster(url)
+				},
+			},
+			{
+				Name:  "rebuild-assignments",
+				Usage: "rebuild the tasks assignments of the manager from the tasks reported by the workers",
+				Action: func(ctx *cli.Context) error {
+					url := getUrl(ctx.String("host"), ctx.Int("port"))
+					return rebuildAssignments(url)
+				},
+			},
 		},
 	}
 
@@ -114,16 +419,10 @@ func main() {
 	}
 }
 
-func startTask(baseUrl string, filePath string) error {
-	f, err := os.Open(filePath)
-	if err != nil {
-		return fmt.Errorf("failed to open task file, err: %v", err)
-	}
-	defer f.Close()
-
-	buffer, err := io.ReadAll(f)
+func startTask(baseUrl string, filePath string, wait bool) error {
+	buffer, err := readTaskFile(filePath)
 	if err != nil {
-		return fmt.Errorf("failed to read task file, err: %v", err)
+		return err
 	}
 
 	var tasks []taskInput
@@ -136,27 +435,18 @@ func startTask(baseUrl string, filePath string) error {
 	}
 
 	url := fmt.Sprintf("%s/tasks", baseUrl)
+	submitted := make([]task.Task, 0, len(tasks))
 	for _, t := range tasks {
-		exposedPorts, err := portSliceToPortSet(t.ExposedPorts)
+		newTask, err := t.toTask()
 		if err != nil {
-			return fmt.Errorf("failed to parse exposed ports, err: %v", err)
+			return err
 		}
 		tEvent := task.TaskEvent{
-			Id:        uuid.New(),
-			State:     task.Scheduled,
-			Timestamp: time.Now(),
-			Task: task.Task{
-				Id:            uuid.New(),
-				State:         task.Scheduled,
-				Name:          t.Name,
-				Image:         t.Image,
-				Cpu:           t.Cpu,
-				Memory:        t.Memory,
-				Disk:          t.Disk,
-				ExposedPorts:  exposedPorts,
-				PortBindings:  t.PortBindings,
-				RestartPolicy: t.RestartPolicy,
-			},
+			Id:           uuid.New(),
+			State:        task.Scheduled,
+			Timestamp:    time.Now(),
+			Task:         newTask,
+			RegistryAuth: newTask.RegistryAuth,
 		}
 		jsonTaskEvent, err := json.Marshal(tEvent)
 		if err != nil {
@@ -169,13 +459,279 @@ func startTask(baseUrl string, filePath string) error {
 		}
 		defer response.Body.Close()
 
-		if response.StatusCode != http.StatusCreated {
-			return fmt.Errorf("received invalid http status code for task %s: %d", t.Name, response.StatusCode)
+		if err := checkResponse(response, http.StatusCreated); err != nil {
+			return fmt.Errorf("task %s creation request failed: %w", t.Name, err)
 		}
 
 		fmt.Printf("[OK] '%s' task creation request successfully submitted\n", t.Name)
+		submitted = append(submitted, tEvent.Task)
+	}
+
+	if !wait {
+		return nil
+	}
+	return waitForTasks(baseUrl, submitted)
+}
+
+// Build a new task from its json representation and validate it
+func (t taskInput) toTask() (task.Task, error) {
+	exposedPorts, err := portSliceToPortSet(t.ExposedPorts)
+	if err != nil {
+		return task.Task{}, fmt.Errorf("failed to parse exposed ports, err: %v", err)
 	}
+	healthCheck, err := t.HealthCheck.toHealthCheck()
+	if err != nil {
+		return task.Task{}, fmt.Errorf("failed to parse health check, err: %v", err)
+	}
+	newTask := task.Task{
+		Id:               uuid.New(),
+		State:            task.Scheduled,
+		Name:             t.Name,
+		Namespace:        t.Namespace,
+		Hostname:         t.Hostname,
+		ContainerId:      t.ContainerId,
+		Image:            t.Image,
+		PullPolicy:       t.PullPolicy,
+		RegistryAuth:     t.RegistryAuth,
+		Cpu:              t.Cpu,
+		Memory:           t.Memory,
+		Disk:             t.Disk,
+		CpusetCpus:       t.CpusetCpus,
+		ReadonlyRootfs:   t.ReadonlyRootfs,
+		Tmpfs:            t.Tmpfs,
+		Volumes:          t.Volumes,
+		Env:              t.Env,
+		Cmd:              t.Cmd,
+		ExposedPorts:     exposedPorts,
+		PortBindings:     t.PortBindings,
+		RestartPolicy:    t.RestartPolicy,
+		StopSignal:       t.StopSignal,
+		StopTimeout:      t.StopTimeout,
+		HealthCheck:      healthCheck,
+		CaptureOutput:    t.CaptureOutput,
+		MaxLogSize:       t.MaxLogSize,
+		MaxLogFiles:      t.MaxLogFiles,
+		Priority:         t.Priority,
+		Labels:           t.Labels,
+		AffinityTaskId:   t.AffinityTaskId,
+		AffinityRequired: t.AffinityRequired,
+		PreferredNode:    t.PreferredNode,
+		NodeSelector:     t.NodeSelector,
+		AntiAffinity:     t.AntiAffinity,
+		MaxRestarts:      t.MaxRestarts,
+		Replicas:         t.Replicas,
+	}
+	if err := newTask.Validate(); err != nil {
+		return task.Task{}, fmt.Errorf("invalid task %s, err: %v", t.Name, err)
+	}
+	return newTask, nil
+}
+
+// Build the task health check from its json representation, nil when it isn't set
+func (h *healthCheckInput) toHealthCheck() (*task.HealthCheck, error) {
+	if h == nil {
+		return nil, nil
+	}
+	check := &task.HealthCheck{Cmd: h.Cmd, Retries: h.Retries}
+	if h.Interval != "" {
+		interval, err := time.ParseDuration(h.Interval)
+		if err != nil {
+			return nil, err
+		}
+		check.Interval = interval
+	}
+	return check, nil
+}
+
+func deployGroup(baseUrl string, filePath string) error {
+	buffer, err := readTaskFile(filePath)
+	if err != nil {
+		return err
+	}
+
+	var group groupInput
+	err = json.Unmarshal(buffer, &group)
+	if err != nil {
+		return fmt.Errorf("invalid json representation of group in file, err: %v", err)
+	}
+	if len(group.Tasks) == 0 {
+		return fmt.Errorf("found no task in group")
+	}
+
+	request := manager.GroupRequest{
+		Name:  group.Name,
+		Tasks: make([]task.Task, 0, len(group.Tasks)),
+	}
+	for _, t := range group.Tasks {
+		newTask, err := t.toTask()
+		if err != nil {
+			return err
+		}
+		request.Tasks = append(request.Tasks, newTask)
+		if newTask.RegistryAuth != nil {
+			if request.RegistryAuths == nil {
+				request.RegistryAuths = make(map[uuid.UUID]task.RegistryAuth)
+			}
+			request.RegistryAuths[newTask.Id] = *newTask.RegistryAuth
+		}
+	}
+	jsonRequest, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/groups", baseUrl)
+	response, err := http.Post(url, "application/json", bytes.NewBuffer(jsonRequest))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if err := checkResponse(response, http.StatusCreated); err != nil {
+		return fmt.Errorf("group %s deployment failed: %w", group.Name, err)
+	}
+
+	deployed := task.TaskGroup{}
+	if err := json.NewDecoder(response.Body).Decode(&deployed); err != nil {
+		return err
+	}
+	fmt.Printf("[OK] '%s' group deployed, id: %v\n", deployed.Name, deployed.Id)
+	return nil
+}
+
+// Read the content of the given task file, "-" reads from the standard input
+func readTaskFile(filePath string) ([]byte, error) {
+	if filePath == "-" {
+		buffer, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read task from stdin, err: %v", err)
+		}
+		return buffer, nil
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open task file, err: %v", err)
+	}
+	defer f.Close()
+
+	buffer, err := io.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read task file, err: %v", err)
+	}
+	return buffer, nil
+}
+
+// Follow the events of the given tasks until all of them reach a final state
+//
+// An error is returned if a task failed
+func waitForTasks(baseUrl string, tasks []task.Task) error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(tasks))
+	for i, t := range tasks {
+		wg.Add(1)
+		go func(i int, t task.Task) {
+			defer wg.Done()
+			errs[i] = followTaskEvents(baseUrl, t)
+		}(i, t)
+	}
+	wg.Wait()
 
+	return errors.Join(errs...)
+}
+
+// Print the events of a task as they are received from the manager, until the task reaches a final state
+func followTaskEvents(baseUrl string, t task.Task) error {
+	url := fmt.Sprintf("%s/tasks/%v/events", baseUrl, t.Id)
+	response, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if err := checkResponse(response, http.StatusOK); err != nil {
+		return fmt.Errorf("task %s events request failed: %w", t.Name, err)
+	}
+
+	// The manager ends the stream once the task reached a final state, a failed task may be restarted before
+	scanner := bufio.NewScanner(response.Body)
+	var lastState *task.State
+	for scanner.Scan() {
+		data, found := strings.CutPrefix(scanner.Text(), "data: ")
+		if !found {
+			continue
+		}
+
+		var tEvent task.TaskEvent
+		if err := json.Unmarshal([]byte(data), &tEvent); err != nil {
+			return fmt.Errorf("invalid event received for task %s, err: %v", t.Name, err)
+		}
+		fmt.Printf("[EVENT] '%s' task is %s\n", t.Name, tEvent.State)
+		lastState = &tEvent.State
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	switch {
+	case lastState != nil && *lastState == task.Completed:
+		return nil
+	case lastState != nil && *lastState == task.Failed:
+		return fmt.Errorf("task %s failed", t.Name)
+	default:
+		return fmt.Errorf("event stream of task %s ended before it reached a final state", t.Name)
+	}
+}
+
+func labelFlag(usage string) cli.Flag {
+	return &cli.StringSliceFlag{
+		Name:    "label",
+		Aliases: []string{"l"},
+		Usage:   usage,
+	}
+}
+
+// Run an action on the task given as argument, or on all the tasks matching the label flag
+func runTaskAction(ctx *cli.Context, baseUrl string, action func(baseUrl string, taskId uuid.UUID) error) error {
+	labels := ctx.StringSlice("label")
+	if len(labels) != 0 {
+		if ctx.Args().Len() != 0 {
+			return fmt.Errorf("wrong arguments count, expected=0 when selecting tasks by label, got=%d", ctx.Args().Len())
+		}
+		return runLabeledTasksAction(baseUrl, labels, action)
+	}
+
+	if ctx.Args().Len() != 1 {
+		return fmt.Errorf("wrong arguments count, expected=1, got=%d", ctx.Args().Len())
+	}
+	id, err := uuid.Parse(ctx.Args().First())
+	if err != nil {
+		return err
+	}
+	return action(baseUrl, id)
+}
+
+// Run an action on all the tasks matching the labels selector and report the outcome for each of them
+func runLabeledTasksAction(baseUrl string, labels []string, action func(baseUrl string, taskId uuid.UUID) error) error {
+	tasks, err := getTasksFromManager(baseUrl, taskFilter{Labels: labels})
+	if err != nil {
+		return err
+	}
+	if len(tasks) == 0 {
+		fmt.Println("No task found")
+		return nil
+	}
+
+	failures := 0
+	for _, t := range tasks {
+		if err := action(baseUrl, t.Id); err != nil {
+			fmt.Printf("[ERROR] '%s' task %v: %v\n", t.Name, t.Id, err)
+			failures++
+		}
+	}
+	if failures != 0 {
+		return fmt.Errorf("request failed for %d of %d task(s)", failures, len(tasks))
+	}
 	return nil
 }
 
@@ -193,16 +749,100 @@ func stopTask(baseUrl string, taskId uuid.UUID) error {
 	}
 	defer response.Body.Close()
 
-	if response.StatusCode != http.StatusNoContent {
-		return fmt.Errorf("received invalid http status code: %d", response.StatusCode)
+	if err := checkResponse(response, http.StatusNoContent); err != nil {
+		return err
+	}
+
+	fmt.Printf("[OK] task %v deletion request successfully submitted\n", taskId)
+	return nil
+}
+
+func restartTask(baseUrl string, taskId uuid.UUID) error {
+	url := fmt.Sprintf("%s/tasks/%v/restart", baseUrl, taskId)
+	response, err := http.Post(url, "application/json", nil)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if err := checkResponse(response, http.StatusNoContent); err != nil {
+		return err
 	}
 
-	fmt.Println("[OK] task deletion request successfully submitted")
+	fmt.Printf("[OK] task %v restart request successfully submitted\n", taskId)
 	return nil
 }
 
-func listTasks(baseUrl string) error {
-	tasks, err := getTasksFromManager(baseUrl)
+func updateRestartPolicy(baseUrl string, taskId uuid.UUID, request manager.RestartPolicyRequest) error {
+	data, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/tasks/%v/restart-policy", baseUrl, taskId)
+	req, err := http.NewRequest(http.MethodPatch, url, bytes.NewBuffer(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := http.Client{}
+	response, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if err := checkResponse(response, http.StatusOK); err != nil {
+		return err
+	}
+
+	fmt.Printf("[OK] task %v restart policy successfully updated\n", taskId)
+	return nil
+}
+
+func scaleTask(baseUrl string, namespace string, name string, replicas int) error {
+	data, err := json.Marshal(manager.ScaleRequest{Replicas: replicas})
+	if err != nil {
+		return err
+	}
+
+	query := neturl.Values{}
+	query.Set("namespace", namespace)
+	url := fmt.Sprintf("%s/tasks/%s/scale?%s", baseUrl, neturl.PathEscape(name), query.Encode())
+	req, err := http.NewRequest(http.MethodPut, url, bytes.NewBuffer(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := http.Client{}
+	response, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if err := checkResponse(response, http.StatusOK); err != nil {
+		return err
+	}
+
+	var result manager.ScaleResponse
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return err
+	}
+	fmt.Printf("[OK] task %s scaled to %d replica(s)\n", name, result.Replicas)
+	for _, taskId := range result.Started {
+		fmt.Printf("- started %v\n", taskId)
+	}
+	for _, taskId := range result.Stopped {
+		fmt.Printf("- stopped %v\n", taskId)
+	}
+	return nil
+}
+
+func listTasks(baseUrl string, filter taskFilter) error {
+	tasks, err := getTasksFromManager(baseUrl, filter)
 	if err != nil {
 		return err
 	}
@@ -219,39 +859,271 @@ func listTasks(baseUrl string) error {
 	return nil
 }
 
-func getTask(baseUrl string, taskId uuid.UUID) error {
-	tasks, err := getTasksFromManager(baseUrl)
+// Print tasks as they are received from the manager, as JSON lines
+func streamTasks(baseUrl string, filter taskFilter) error {
+	req, err := http.NewRequest(http.MethodGet, tasksUrl(baseUrl, filter), nil)
 	if err != nil {
 		return err
 	}
+	req.Header.Set("Accept", "application/x-ndjson")
 
-	var foundTask *task.Task
-	for _, t := range tasks {
-		if t.Id == taskId {
-			foundTask = &t
+	client := http.Client{}
+	response, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if err := checkResponse(response, http.StatusOK); err != nil {
+		return err
+	}
+
+	count := 0
+	decoder := json.NewDecoder(response.Body)
+	for {
+		var t task.Task
+		err := decoder.Decode(&t)
+		if errors.Is(err, io.EOF) {
 			break
 		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("- %#v\n", t)
+		count++
 	}
-	if foundTask == nil {
-		return fmt.Errorf("task with id %v not found", taskId)
+
+	if count == 0 {
+		fmt.Println("No task found")
+		return nil
 	}
+	fmt.Printf("[OK] found %d task(s)\n", count)
+	return nil
+}
 
-	fmt.Printf("%#v\n", *foundTask)
+func getTask(baseUrl string, taskId uuid.UUID) error {
+	response, err := http.Get(fmt.Sprintf("%s/tasks/%v", baseUrl, taskId))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if err := checkResponse(response, http.StatusOK); err != nil {
+		return err
+	}
+	t := task.Task{}
+	if err := json.NewDecoder(response.Body).Decode(&t); err != nil {
+		return fmt.Errorf("error decoding task: %w", err)
+	}
+
+	fmt.Printf("%#v\n", t)
 	return nil
 }
 
-func getTasksFromManager(baseUrl string) ([]task.Task, error) {
-	url := fmt.Sprintf("%s/tasks", baseUrl)
+// Print the events of a task, or of all the tasks when the id is nil
+func getEvents(baseUrl string, taskId uuid.UUID) error {
+	url := fmt.Sprintf("%s/events", baseUrl)
+	if taskId != uuid.Nil {
+		url += "?taskId=" + taskId.String()
+	}
 	response, err := http.Get(url)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer response.Body.Close()
 
+	if err := checkResponse(response, http.StatusOK); err != nil {
+		return err
+	}
+	var events []task.TaskEvent
+	if err := json.NewDecoder(response.Body).Decode(&events); err != nil {
+		return fmt.Errorf("error decoding task events: %w", err)
+	}
+
+	if len(events) == 0 {
+		fmt.Println("No event found")
+		return nil
+	}
+	fmt.Printf("[OK] found %d event(s):\n", len(events))
+	for _, e := range events {
+		fmt.Printf("- %s %v %s (%s)\n", e.Timestamp.Format(time.RFC3339), e.Task.Id, e.State, e.Task.Name)
+	}
+	return nil
+}
+
+func getTaskStats(baseUrl string, taskId uuid.UUID) error {
+	response, err := http.Get(fmt.Sprintf("%s/tasks/%v/stats", baseUrl, taskId))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if err := checkResponse(response, http.StatusOK); err != nil {
+		return err
+	}
+	stats := worker.TaskStats{}
+	if err := json.NewDecoder(response.Body).Decode(&stats); err != nil {
+		return fmt.Errorf("error decoding task stats: %w", err)
+	}
+
+	fmt.Printf("%#v\n", stats)
+	return nil
+}
+
+// Criteria of the tasks listed by the manager, the zero value lists all the tasks
+type taskFilter struct {
+	Namespace      string
+	Labels         []string
+	StartedAfter   time.Time
+	FinishedBefore time.Time
+}
+
+// Get the URL of the tasks list, filtered with the given criteria
+func tasksUrl(baseUrl string, filter taskFilter) string {
+	url := fmt.Sprintf("%s/tasks", baseUrl)
+	query := neturl.Values{}
+	if filter.Namespace != "" {
+		query.Set("namespace", filter.Namespace)
+	}
+	if len(filter.Labels) != 0 {
+		query["label"] = filter.Labels
+	}
+	if !filter.StartedAfter.IsZero() {
+		query.Set("startedAfter", filter.StartedAfter.Format(time.RFC3339Nano))
+	}
+	if !filter.FinishedBefore.IsZero() {
+		query.Set("finishedBefore", filter.FinishedBefore.Format(time.RFC3339Nano))
+	}
+	if len(query) == 0 {
+		return url
+	}
+	return fmt.Sprintf("%s?%s", url, query.Encode())
+}
+
+// Number of tasks requested per page of the tasks list
+const tasksPageSize = 100
+
+// Get all the tasks matching the filter, page by page
+func getTasksFromManager(baseUrl string, filter taskFilter) ([]task.Task, error) {
 	var tasks []task.Task
-	data := json.NewDecoder(response.Body)
-	err = data.Decode(&tasks)
-	return tasks, err
+	for {
+		page, err := getTasksPage(baseUrl, filter, len(tasks))
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, page.Items...)
+		if len(page.Items) == 0 || len(tasks) >= page.Total {
+			return tasks, nil
+		}
+	}
+}
+
+// Get the page of the tasks matching the filter starting at the given offset
+func getTasksPage(baseUrl string, filter taskFilter, offset int) (manager.TaskPage, error) {
+	url, err := neturl.Parse(tasksUrl(baseUrl, filter))
+	if err != nil {
+		return manager.TaskPage{}, err
+	}
+	query := url.Query()
+	query.Set("limit", strconv.Itoa(tasksPageSize))
+	query.Set("offset", strconv.Itoa(offset))
+	url.RawQuery = query.Encode()
+
+	response, err := http.Get(url.String())
+	if err != nil {
+		return manager.TaskPage{}, err
+	}
+	defer response.Body.Close()
+
+	if err := checkResponse(response, http.StatusOK); err != nil {
+		return manager.TaskPage{}, err
+	}
+
+	page := manager.TaskPage{}
+	err = json.NewDecoder(response.Body).Decode(&page)
+	return page, err
+}
+
+// Print the logs of a task container as they are received
+func printTaskLogs(baseUrl string, taskId uuid.UUID, follow bool) error {
+	url := fmt.Sprintf("%s/tasks/%v/logs", baseUrl, taskId)
+	if follow {
+		url += "?follow=true"
+	}
+	response, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if err := checkResponse(response, http.StatusOK); err != nil {
+		return err
+	}
+	_, err = io.Copy(os.Stdout, response.Body)
+	return err
+}
+
+// Print the log lines served by the given logs route
+//
+// When following, the stream is opened again after an interruption, starting after the last received line
+func printLogs(url string, since time.Time, follow bool) error {
+	for {
+		last, err := streamLogs(url, since, follow)
+		if !last.IsZero() {
+			since = last
+		}
+		if !follow || errors.Is(err, errLogsRejected) {
+			return err
+		}
+		if err != nil {
+			fmt.Printf("[WARN] logs stream interrupted, reconnecting: %v\n", err)
+		}
+		time.Sleep(logsReconnectDelay)
+	}
+}
+
+// Delay before opening again an interrupted logs stream
+const logsReconnectDelay = time.Second
+
+var errLogsRejected = errors.New("logs request rejected")
+
+// Print the log lines written after the given time, the time of the last printed line is returned
+func streamLogs(url string, since time.Time, follow bool) (time.Time, error) {
+	query := neturl.Values{}
+	if !since.IsZero() {
+		query.Set("since", since.Format(time.RFC3339Nano))
+	}
+	if follow {
+		query.Set("follow", "true")
+	}
+	if len(query) != 0 {
+		url = fmt.Sprintf("%s?%s", url, query.Encode())
+	}
+
+	var last time.Time
+	response, err := http.Get(url)
+	if err != nil {
+		return last, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(response.Body)
+		return last, fmt.Errorf("%w, status code %d: %s", errLogsRejected, response.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	decoder := json.NewDecoder(response.Body)
+	for {
+		var entry logger.Entry
+		err := decoder.Decode(&entry)
+		if errors.Is(err, io.EOF) {
+			return last, nil
+		}
+		if err != nil {
+			return last, err
+		}
+		fmt.Print(entry.Line)
+		last = entry.Time
+	}
 }
 
 func listNodes(baseUrl string) error {
@@ -262,7 +1134,7 @@ func listNodes(baseUrl string) error {
 	}
 	defer response.Body.Close()
 
-	var nodes []node.Node
+	var nodes []manager.NodeResponse
 	data := json.NewDecoder(response.Body)
 	err = data.Decode(&nodes)
 	if err != nil {
@@ -281,6 +1153,119 @@ func listNodes(baseUrl string) error {
 	return nil
 }
 
+func removeNode(baseUrl string, name string) error {
+	url := fmt.Sprintf("%s/nodes/%s", baseUrl, neturl.PathEscape(name))
+	req, err := http.NewRequest(http.MethodDelete, url, nil)
+	if err != nil {
+		return err
+	}
+
+	client := http.Client{}
+	response, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if err := checkResponse(response, http.StatusOK); err != nil {
+		return err
+	}
+	var result worker.DrainResponse
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return err
+	}
+
+	fmt.Printf("[OK] node %s removed, %d task(s) moved to other nodes\n", result.Node, result.MigratedTasks)
+	return nil
+}
+
+func shutdownCluster(baseUrl string) error {
+	url := fmt.Sprintf("%s/admin/shutdown", baseUrl)
+	response, err := http.Post(url, "application/json", nil)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	// The shutdown is idempotent, a repeated request gets the result of the shutdown in progress
+	if err := checkResponse(response, http.StatusOK); err != nil {
+		return err
+	}
+
+	var result manager.ShutdownResponse
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return err
+	}
+	if result.RemainingTasks != 0 {
+		fmt.Printf("[WARN] %d task(s) stopped, %d task(s) couldn't be confirmed as stopped, manager shutting down\n", result.StoppedTasks, result.RemainingTasks)
+		return nil
+	}
+	fmt.Printf("[OK] %d task(s) stopped, manager shutting down\n", result.StoppedTasks)
+	return nil
+}
+
+func rebuildAssignments(baseUrl string) error {
+	url := fmt.Sprintf("%s/admin/rebuild-assignments", baseUrl)
+	response, err := http.Post(url, "application/json", nil)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if err := checkResponse(response, http.StatusOK); err != nil {
+		return err
+	}
+
+	var result manager.RebuildResponse
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return err
+	}
+	for _, c := range result.Corrections {
+		switch {
+		case c.Rescheduled:
+			fmt.Printf("  task %v: unassigned from %s, rescheduled\n", c.TaskId, c.PreviousWorker)
+		case c.Worker == "":
+			fmt.Printf("  task %v: unassigned from %s\n", c.TaskId, c.PreviousWorker)
+		case c.PreviousWorker == "":
+			fmt.Printf("  task %v: assigned to %s\n", c.TaskId, c.Worker)
+		default:
+			fmt.Printf("  task %v: moved from %s to %s\n", c.TaskId, c.PreviousWorker, c.Worker)
+		}
+	}
+	for worker, ids := range result.Unknown {
+		for _, id := range ids {
+			fmt.Printf("  task %v: reported by %s but absent from the manager store\n", id, worker)
+		}
+	}
+	if len(result.Unreachable) != 0 {
+		fmt.Printf("[WARN] %d correction(s) made from %d worker(s), unreachable workers: %s\n", len(result.Corrections), result.Workers, strings.Join(result.Unreachable, ", "))
+		return nil
+	}
+	fmt.Printf("[OK] %d correction(s) made from %d worker(s)\n", len(result.Corrections), result.Workers)
+	return nil
+}
+
+// Verify the response status code, on mismatch the error message sent by the manager is returned when available
+func checkResponse(response *http.Response, expectedStatusCode int) error {
+	if response.StatusCode == expectedStatusCode {
+		return nil
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(response.Header.Get("Content-Type"))
+	if mediaType == "application/json" {
+		e := manager.ErrResponse{}
+		if err := json.NewDecoder(response.Body).Decode(&e); err == nil && len(e.Violations) != 0 {
+			violations := make([]string, len(e.Violations))
+			for i, v := range e.Violations {
+				violations[i] = fmt.Sprintf("\n  - %s: %s", v.Field, v.Message)
+			}
+			return fmt.Errorf("received http status code %d, invalid specification:%s", response.StatusCode, strings.Join(violations, ""))
+		} else if err == nil && e.Message != "" {
+			return fmt.Errorf("received http status code %d: %s", response.StatusCode, e.Message)
+		}
+	}
+	return fmt.Errorf("received invalid http status code: %d", response.StatusCode)
+}
+
 func getUrl(host string, port int) string {
 	if !strings.HasPrefix(host, "http") {
 		host = fmt.Sprintf("http://%s:%d", host, port)
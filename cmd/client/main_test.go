@@ -0,0 +1,492 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/google/uuid"
+
+	"orchestrator/logger"
+	"orchestrator/manager"
+	"orchestrator/task"
+	"orchestrator/worker"
+)
+
+// Create a manager double streaming the given states as the events of any task
+func newEventsStub(t *testing.T, states ...task.State) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, state := range states {
+			data, _ := json.Marshal(task.TaskEvent{Id: uuid.New(), State: state})
+			fmt.Fprintf(w, "data: %s\n\n", data)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestWaitForTasksFollowsLifecycle(t *testing.T) {
+	server := newEventsStub(t, task.Scheduled, task.Running, task.Failed, task.Scheduled, task.Running, task.Completed)
+	if err := waitForTasks(server.URL, []task.Task{{Id: uuid.New(), Name: "web"}}); err != nil {
+		t.Errorf("expected the restarted task to complete, got %v", err)
+	}
+}
+
+func TestWaitForTasksReportsFailure(t *testing.T) {
+	server := newEventsStub(t, task.Scheduled, task.Running, task.Failed)
+	if err := waitForTasks(server.URL, []task.Task{{Id: uuid.New(), Name: "web"}}); err == nil {
+		t.Error("expected the failed task to be reported")
+	}
+}
+
+func TestWaitForTasksReportsInterruptedStream(t *testing.T) {
+	server := newEventsStub(t, task.Scheduled, task.Running)
+	if err := waitForTasks(server.URL, []task.Task{{Id: uuid.New(), Name: "web"}}); err == nil {
+		t.Error("expected the interrupted stream to be reported")
+	}
+}
+
+func TestStartTaskReadsStdin(t *testing.T) {
+	var received []task.TaskEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var tEvent task.TaskEvent
+		if r.Method != http.MethodPost || r.URL.Path != "/tasks" || json.NewDecoder(r.Body).Decode(&tEvent) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		received = append(received, tEvent)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("failed to create pipe: %v", err)
+	}
+	stdin := os.Stdin
+	os.Stdin = reader
+	defer func() { os.Stdin = stdin }()
+	writer.WriteString(`[{"name": "web", "namespace": "shop", "image": "nginx", "cpu": 0.5, "memory": 1024, "cmd": ["echo", "hello"]}]`)
+	writer.Close()
+
+	if err := startTask(server.URL, "-", false); err != nil {
+		t.Fatalf("failed to start task: %v", err)
+	}
+	if len(received) != 1 {
+		t.Fatalf("expected 1 submitted task, got %d", len(received))
+	}
+	submitted := received[0].Task
+	if submitted.Name != "web" || submitted.Image != "nginx" || submitted.Cpu != 0.5 || submitted.Memory != 1024 {
+		t.Errorf("unexpected submitted task %+v", submitted)
+	}
+	if len(submitted.Cmd) != 2 || submitted.Cmd[0] != "echo" || submitted.Cmd[1] != "hello" {
+		t.Errorf("expected the task command to be submitted, got %v", submitted.Cmd)
+	}
+}
+
+// Manager double listing tasks filtered by labels and recording the tasks actions
+type labeledStub struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	actions []string
+}
+
+// Create a manager double holding the given tasks, the actions on the rejected task fail
+func newLabeledStub(t *testing.T, tasks []task.Task, rejected uuid.UUID) *labeledStub {
+	t.Helper()
+	stub := &labeledStub{}
+	record := func(w http.ResponseWriter, r *http.Request, status int) {
+		if chi.URLParam(r, "taskId") == rejected.String() {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		stub.mu.Lock()
+		stub.actions = append(stub.actions, r.Method+" "+chi.URLParam(r, "taskId"))
+		stub.mu.Unlock()
+		w.WriteHeader(status)
+	}
+	router := chi.NewRouter()
+	router.Get("/tasks", func(w http.ResponseWriter, r *http.Request) {
+		selector, err := task.ParseLabelSelector(r.URL.Query()["label"])
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		matching := []task.Task{}
+		for _, tk := range tasks {
+			if tk.MatchLabels(selector) {
+				matching = append(matching, tk)
+			}
+		}
+		json.NewEncoder(w).Encode(manager.TaskPage{Items: matching, Total: len(matching)})
+	})
+	router.Delete("/tasks/{taskId}", func(w http.ResponseWriter, r *http.Request) {
+		record(w, r, http.StatusNoContent)
+	})
+	router.Post("/tasks/{taskId}/restart", func(w http.ResponseWriter, r *http.Request) {
+		record(w, r, http.StatusNoContent)
+	})
+	stub.Server = httptest.NewServer(router)
+	t.Cleanup(stub.Close)
+	return stub
+}
+
+func (s *labeledStub) recordedActions() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	actions := append([]string(nil), s.actions...)
+	sort.Strings(actions)
+	return actions
+}
+
+func TestLabeledTasksActionSelectsMatchingTasks(t *testing.T) {
+	web1 := task.Task{Id: uuid.New(), Name: "web-1", Labels: map[string]string{"app": "web", "tier": "front"}}
+	web2 := task.Task{Id: uuid.New(), Name: "web-2", Labels: map[string]string{"app": "web"}}
+	db := task.Task{Id: uuid.New(), Name: "db", Labels: map[string]string{"app": "db"}}
+	stub := newLabeledStub(t, []task.Task{web1, web2, db}, uuid.Nil)
+
+	if err := runLabeledTasksAction(stub.URL, []string{"app=web"}, stopTask); err != nil {
+		t.Fatalf("failed to stop labeled tasks: %v", err)
+	}
+	if err := runLabeledTasksAction(stub.URL, []string{"app=web", "tier=front"}, restartTask); err != nil {
+		t.Fatalf("failed to restart labeled tasks: %v", err)
+	}
+
+	expected := []string{"DELETE " + web1.Id.String(), "DELETE " + web2.Id.String(), "POST " + web1.Id.String()}
+	sort.Strings(expected)
+	actions := stub.recordedActions()
+	if fmt.Sprint(actions) != fmt.Sprint(expected) {
+		t.Errorf("expected actions %v, got %v", expected, actions)
+	}
+}
+
+func TestLabeledTasksActionReportsFailures(t *testing.T) {
+	web1 := task.Task{Id: uuid.New(), Name: "web-1", Labels: map[string]string{"app": "web"}}
+	web2 := task.Task{Id: uuid.New(), Name: "web-2", Labels: map[string]string{"app": "web"}}
+	stub := newLabeledStub(t, []task.Task{web1, web2}, web1.Id)
+
+	if err := runLabeledTasksAction(stub.URL, []string{"app=web"}, restartTask); err == nil {
+		t.Error("expected the failed restart to be reported")
+	}
+	if actions := stub.recordedActions(); len(actions) != 1 || actions[0] != "POST "+web2.Id.String() {
+		t.Errorf("expected the other task to be restarted anyway, got actions %v", actions)
+	}
+}
+
+func TestUpdateRestartPolicySendsProvidedFields(t *testing.T) {
+	var received map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPatch || json.NewDecoder(r.Body).Decode(&received) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	maxRestarts := 0
+	if err := updateRestartPolicy(server.URL, uuid.New(), manager.RestartPolicyRequest{MaxRestarts: &maxRestarts}); err != nil {
+		t.Fatalf("failed to update restart policy: %v", err)
+	}
+	if received["MaxRestarts"] != 0.0 || received["RestartPolicy"] != nil {
+		t.Errorf("expected only the max restarts to be set, got %v", received)
+	}
+}
+
+// Create a manager double answering every request with the given status, content type and body
+func newErrorStub(t *testing.T, status int, contentType string, body string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(status)
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestRequestsReportManagerErrorMessage(t *testing.T) {
+	body, _ := json.Marshal(manager.ErrResponse{HTTPStatusCode: http.StatusBadRequest, Message: "invalid task: missing image"})
+	server := newErrorStub(t, http.StatusBadRequest, "application/json; charset=utf-8", string(body))
+	tasksFile := filepath.Join(t.TempDir(), "tasks.json")
+	os.WriteFile(tasksFile, []byte(`[{"name": "web", "namespace": "shop", "image": "nginx"}]`), 0600)
+
+	err := startTask(server.URL, tasksFile, false)
+	if err == nil || !strings.Contains(err.Error(), "invalid task: missing image") {
+		t.Errorf("expected the start error to carry the manager message, got %v", err)
+	}
+	err = stopTask(server.URL, uuid.New())
+	if err == nil || !strings.Contains(err.Error(), "invalid task: missing image") {
+		t.Errorf("expected the stop error to carry the manager message, got %v", err)
+	}
+}
+
+func TestRequestsReportStatusWithoutStructuredError(t *testing.T) {
+	server := newErrorStub(t, http.StatusBadGateway, "text/html", "<html>bad gateway</html>")
+
+	err := stopTask(server.URL, uuid.New())
+	if err == nil || !strings.Contains(err.Error(), "502") || strings.Contains(err.Error(), "html") {
+		t.Errorf("expected the error to only report the status code, got %v", err)
+	}
+}
+
+func TestPrintLogsReconnectsAfterLastLine(t *testing.T) {
+	written := time.Now().UTC()
+	var queries []neturl.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.Query())
+		if len(queries) > 1 {
+			// End the test once the stream was opened again
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(logger.Entry{Time: written, Line: "started\n"})
+	}))
+	defer server.Close()
+
+	err := printLogs(server.URL+"/logs", time.Time{}, true)
+	if !errors.Is(err, errLogsRejected) {
+		t.Fatalf("expected the logs to end with the rejected request, got %v", err)
+	}
+	if len(queries) != 2 {
+		t.Fatalf("expected the interrupted stream to be opened again, got %d requests", len(queries))
+	}
+	if queries[0].Get("follow") != "true" || queries[0].Has("since") {
+		t.Errorf("expected the first request to follow without since, got %v", queries[0])
+	}
+	if since := queries[1].Get("since"); since != written.Format(time.RFC3339Nano) {
+		t.Errorf("expected the stream to resume after the last line at %v, got since %q", written, since)
+	}
+}
+
+func TestStreamLogsSendsSince(t *testing.T) {
+	since := time.Now().Add(-10 * time.Minute).UTC()
+	lines := []logger.Entry{{Time: since.Add(time.Minute), Line: "a\n"}, {Time: since.Add(2 * time.Minute), Line: "b\n"}}
+	var query neturl.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		for _, line := range lines {
+			json.NewEncoder(w).Encode(line)
+		}
+	}))
+	defer server.Close()
+
+	last, err := streamLogs(server.URL, since, false)
+	if err != nil {
+		t.Fatalf("failed to stream logs: %v", err)
+	}
+	if query.Get("since") != since.Format(time.RFC3339Nano) || query.Has("follow") {
+		t.Errorf("expected only the since parameter, got %v", query)
+	}
+	if !last.Equal(lines[1].Time) {
+		t.Errorf("expected the time of the last line %v, got %v", lines[1].Time, last)
+	}
+}
+
+func TestPrintTaskLogsRequestsFollow(t *testing.T) {
+	taskId := uuid.New()
+	var requested string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = r.URL.String()
+		fmt.Fprint(w, "hello\n")
+	}))
+	defer server.Close()
+
+	if err := printTaskLogs(server.URL, taskId, true); err != nil {
+		t.Fatalf("failed to print task logs: %v", err)
+	}
+	if expected := fmt.Sprintf("/tasks/%v/logs?follow=true", taskId); requested != expected {
+		t.Errorf("expected request %s, got %s", expected, requested)
+	}
+}
+
+func TestGetTaskRequestsSingleTask(t *testing.T) {
+	taskId := uuid.New()
+	var requested string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = r.URL.Path
+		json.NewEncoder(w).Encode(task.Task{Id: taskId, Name: "web"})
+	}))
+	defer server.Close()
+
+	if err := getTask(server.URL, taskId); err != nil {
+		t.Fatalf("failed to get task: %v", err)
+	}
+	if expected := fmt.Sprintf("/tasks/%v", taskId); requested != expected {
+		t.Errorf("expected request %s, got %s", expected, requested)
+	}
+}
+
+func TestGetTaskReportsMissingTask(t *testing.T) {
+	body, _ := json.Marshal(manager.ErrResponse{HTTPStatusCode: http.StatusNotFound, Message: "task not found"})
+	server := newErrorStub(t, http.StatusNotFound, "application/json", string(body))
+
+	err := getTask(server.URL, uuid.New())
+	if err == nil || !strings.Contains(err.Error(), "task not found") {
+		t.Errorf("expected the missing task to be reported, got %v", err)
+	}
+}
+
+func TestRequestsReportAllViolations(t *testing.T) {
+	body, _ := json.Marshal(manager.ErrResponse{
+		HTTPStatusCode: http.StatusBadRequest,
+		Message:        "invalid task",
+		Violations:     []task.Violation{{Field: "Image", Message: "image is required"}, {Field: "Cpu", Message: "must be positive"}},
+	})
+	server := newErrorStub(t, http.StatusBadRequest, "application/json", string(body))
+	tasksFile := filepath.Join(t.TempDir(), "tasks.json")
+	os.WriteFile(tasksFile, []byte(`[{"name": "web", "namespace": "shop", "image": "nginx"}]`), 0600)
+
+	err := startTask(server.URL, tasksFile, false)
+	if err == nil || !strings.Contains(err.Error(), "Image: image is required") || !strings.Contains(err.Error(), "Cpu: must be positive") {
+		t.Errorf("expected every violation to be reported, got %v", err)
+	}
+}
+
+func TestTasksUrlEncodesFilter(t *testing.T) {
+	base := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	tests := []struct {
+		filter   taskFilter
+		expected string
+	}{
+		{taskFilter{}, "http://manager/tasks"},
+		{taskFilter{Namespace: "shop"}, "http://manager/tasks?namespace=shop"},
+		{taskFilter{StartedAfter: base}, "http://manager/tasks?startedAfter=2024-01-02T15%3A04%3A05Z"},
+		{taskFilter{Labels: []string{"app=web"}, FinishedBefore: base}, "http://manager/tasks?finishedBefore=2024-01-02T15%3A04%3A05Z&label=app%3Dweb"},
+	}
+	for _, test := range tests {
+		if url := tasksUrl("http://manager", test.filter); url != test.expected {
+			t.Errorf("expected the URL %s, got %s", test.expected, url)
+		}
+	}
+}
+
+func TestGetTasksFromManagerPagesThroughResults(t *testing.T) {
+	tasks := make([]task.Task, 2*tasksPageSize+1)
+	for i := range tasks {
+		tasks[i] = task.Task{Id: uuid.New(), Name: fmt.Sprintf("task-%d", i)}
+	}
+	var offsets []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		offsets = append(offsets, query.Get("offset"))
+		if query.Get("namespace") != "shop" || query.Get("limit") != fmt.Sprint(tasksPageSize) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var offset int
+		fmt.Sscan(query.Get("offset"), &offset)
+		end := min(offset+tasksPageSize, len(tasks))
+		json.NewEncoder(w).Encode(manager.TaskPage{Items: tasks[offset:end], Total: len(tasks), Limit: tasksPageSize, Offset: offset})
+	}))
+	defer server.Close()
+
+	received, err := getTasksFromManager(server.URL, taskFilter{Namespace: "shop"})
+	if err != nil {
+		t.Fatalf("failed to get tasks: %v", err)
+	}
+	if len(received) != len(tasks) || received[len(tasks)-1].Id != tasks[len(tasks)-1].Id {
+		t.Errorf("expected the %d tasks to be received, got %d", len(tasks), len(received))
+	}
+	if fmt.Sprint(offsets) != "[0 100 200]" {
+		t.Errorf("expected 3 pages to be requested, got offsets %v", offsets)
+	}
+}
+
+func TestShutdownClusterIsIdempotent(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/admin/shutdown" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		// The repeated requests get the result of the first shutdown
+		requests++
+		json.NewEncoder(w).Encode(manager.ShutdownResponse{StoppedTasks: 2})
+	}))
+	defer server.Close()
+
+	for i := 0; i < 2; i++ {
+		if err := shutdownCluster(server.URL); err != nil {
+			t.Errorf("expected shutdown request %d to succeed, got %v", i, err)
+		}
+	}
+	if requests != 2 {
+		t.Errorf("expected 2 shutdown requests, got %d", requests)
+	}
+}
+
+func TestTaskInputCarriesStopSignal(t *testing.T) {
+	input := taskInput{Name: "web", Namespace: "default", Image: "nginx", StopSignal: "SIGINT"}
+	tk, err := input.toTask()
+	if err != nil {
+		t.Fatalf("failed to convert task input: %v", err)
+	}
+	if tk.StopSignal != "SIGINT" {
+		t.Errorf("expected the stop signal to be SIGINT, got %q", tk.StopSignal)
+	}
+
+	input.StopSignal = "SIGFOO"
+	if _, err := input.toTask(); err == nil || !strings.Contains(err.Error(), "StopSignal") {
+		t.Errorf("expected the unknown stop signal to be rejected, got %v", err)
+	}
+}
+
+func TestRemoveNodeSendsDeletion(t *testing.T) {
+	var received string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Method + " " + r.URL.EscapedPath()
+		json.NewEncoder(w).Encode(worker.DrainResponse{Node: "worker/1", MigratedTasks: 2})
+	}))
+	defer server.Close()
+
+	if err := removeNode(server.URL, "worker/1"); err != nil {
+		t.Fatalf("failed to remove node: %v", err)
+	}
+	if expected := "DELETE /nodes/worker%2F1"; received != expected {
+		t.Errorf("expected the request %q, got %q", expected, received)
+	}
+
+	body, _ := json.Marshal(manager.ErrResponse{HTTPStatusCode: http.StatusConflict, Message: "no node can run task"})
+	conflict := newErrorStub(t, http.StatusConflict, "application/json", string(body))
+	if err := removeNode(conflict.URL, "worker1"); err == nil || !strings.Contains(err.Error(), "no node can run task") {
+		t.Errorf("expected the conflict to be reported, got %v", err)
+	}
+}
+
+func TestRebuildAssignmentsSendsRequest(t *testing.T) {
+	var received string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Method + " " + r.URL.Path
+		json.NewEncoder(w).Encode(manager.RebuildResponse{Workers: 2, Unreachable: []string{}, Corrections: []manager.AssignmentCorrection{}})
+	}))
+	defer server.Close()
+
+	if err := rebuildAssignments(server.URL); err != nil {
+		t.Fatalf("failed to rebuild assignments: %v", err)
+	}
+	if expected := "POST /admin/rebuild-assignments"; received != expected {
+		t.Errorf("expected the request %q, got %q", expected, received)
+	}
+
+	body, _ := json.Marshal(manager.ErrResponse{HTTPStatusCode: http.StatusInternalServerError, Message: "failed to rebuild tasks assignments"})
+	failure := newErrorStub(t, http.StatusInternalServerError, "application/json", string(body))
+	if err := rebuildAssignments(failure.URL); err == nil || !strings.Contains(err.Error(), "failed to rebuild") {
+		t.Errorf("expected the failure to be reported, got %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+)
+
+// Prefix of the keys documenting the following field in the task template, ignored when the task file is read
+const templateCommentPrefix = "// "
+
+// Example value and description of a task definition field in the task template
+type templateField struct {
+	Example     any
+	Description string
+	Minimal     bool // The field is part of the minimal template
+}
+
+// Documentation of the task definition fields, by taskInput field name
+//
+// The template is built from the taskInput struct, a field missing here is still written with its zero value
+var templateFields = map[string]templateField{
+	"Name":             {"web", "Name of the task, unique in its namespace", true},
+	"Namespace":        {"shop", "Project the task belongs to", true},
+	"Hostname":         {"web", "Hostname of the container, the task name when empty", false},
+	"ContainerId":      {"", "Id of an existing container to adopt instead of creating a new one", false},
+	"Image":            {"nginx:1.25", "Image of the container", true},
+	"PullPolicy":       {"IfNotPresent", `Image pull policy: "Always", "IfNotPresent" or "Never"`, false},
+	"RegistryAuth":     {nil, "Credentials of the private registry of the image, the manager ones when omitted", false},
+	"Cpu":              {0.5, "Number of CPUs requested, the manager default when 0", true},
+	"Memory":           {268435456, "Memory requested in bytes, the manager default when 0", true},
+	"Disk":             {1073741824, "Disk requested in bytes, the manager default when 0", false},
+	"CpusetCpus":       {"", `CPUs the container is pinned to (e.g. "0-3" or "0,2")`, false},
+	"ReadonlyRootfs":   {false, "Mount the container root filesystem as read only", false},
+	"Tmpfs":            {map[string]string{"/tmp": "rw,size=64m"}, "Tmpfs mounts with their options, by container path", false},
+	"Volumes":          {[]string{"/srv/web:/usr/share/nginx/html:ro"}, `Bind mounts and named volumes, in the "docker run -v" syntax`, false},
+	"Env":              {[]string{"LOG_LEVEL=info"}, "Environment variables of the container, in the KEY=value format", false},
+	"Cmd":              {[]string{}, "Command run by the container, the image default command when empty", false},
+	"ExposedPorts":     {[]string{"80/tcp"}, "Ports exposed by the container", true},
+	"PortBindings":     {map[string]string{"80/tcp": "8080"}, "Host ports bound to the container ports, a random host port when empty", false},
+	"RestartPolicy":    {"on-failure", `Restart policy of the task: "always", "unless-stopped", "on-failure" or empty for none`, false},
+	"StopSignal":       {"SIGTERM", "Signal sent to stop the container, the image default when empty", false},
+	"StopTimeout":      {10, "Seconds given to the container to exit after the stop signal before it is killed", false},
+	"HealthCheck":      {healthCheckInput{Cmd: []string{"curl", "-f", "http://localhost/"}, Interval: "30s", Retries: 3}, "Check of the container health, the image one when omitted", false},
+	"CaptureOutput":    {false, "Keep the container output once it exits", false},
+	"MaxLogSize":       {"10m", "Maximum size of the container log file before it is rotated", false},
+	"MaxLogFiles":      {3, "Maximum number of container log files kept", false},
+	"Priority":         {0, "Priority of the task, the higher ones are scheduled first", false},
+	"Labels":           {map[string]string{"app": "web"}, "Labels of the task, used to select it in the list, stop and restart commands", false},
+	"AffinityTaskId":   {nil, "Id of a task to run this task on the same node as", false},
+	"AffinityRequired": {false, "Fail the scheduling when the affinity can't be honored instead of ignoring it", false},
+	"PreferredNode":    {"", "Node to use if it can run the task, only a hint for the scheduler", false},
+	"NodeSelector":     {map[string]string{}, "Labels the node running the task must have", false},
+	"AntiAffinity":     {[]string{}, "Names of the tasks of the same namespace which must not run on the same node", false},
+	"MaxRestarts":      {5, "Maximum number of restarts of the failed task, the manager default when 0", false},
+	"Replicas":         {0, "Number of identical tasks to run, named \"<name>-<index>\", a single task when 0", false},
+}
+
+// Write a commented template of a task file, with all the task definition fields or only the minimal ones
+//
+// The file is written to stdout when the path is empty
+func initTaskFile(filePath string, full bool) error {
+	template, err := taskTemplate(full)
+	if err != nil {
+		return err
+	}
+	var out io.Writer = os.Stdout
+	if filePath != "" {
+		file, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+	if _, err := out.Write(template); err != nil {
+		return err
+	}
+	if filePath != "" {
+		fmt.Printf("[OK] task template written to %s\n", filePath)
+	}
+	return nil
+}
+
+// Build the json template of a task file, from the fields of the taskInput struct
+//
+// Every field is preceded by a key documenting it, unknown keys are ignored when the file is read
+func taskTemplate(full bool) ([]byte, error) {
+	var buffer bytes.Buffer
+	buffer.WriteString("[\n  {")
+	inputType := reflect.TypeOf(taskInput{})
+	first := true
+	for i := 0; i < inputType.NumField(); i++ {
+		field := inputType.Field(i)
+		doc := templateFields[field.Name]
+		if !full && !doc.Minimal {
+			continue
+		}
+		example := doc.Example
+		if example == nil {
+			example = reflect.Zero(field.Type).Interface()
+		}
+		value, err := json.MarshalIndent(example, "    ", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode the %s field example: %w", field.Name, err)
+		}
+		if !first {
+			buffer.WriteString(",")
+		}
+		first = false
+		if doc.Description != "" {
+			description, _ := json.Marshal(doc.Description)
+			fmt.Fprintf(&buffer, "\n    %q: %s,", templateCommentPrefix+field.Name, description)
+		}
+		fmt.Fprintf(&buffer, "\n    %q: %s", field.Name, value)
+	}
+	buffer.WriteString("\n  }\n]\n")
+	return buffer.Bytes(), nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Decode a task template, failing the test if it isn't a valid task definition
+func decodeTemplate(t *testing.T, template []byte) taskInput {
+	t.Helper()
+	var inputs []taskInput
+	if err := json.Unmarshal(template, &inputs); err != nil {
+		t.Fatalf("failed to decode template: %v\n%s", err, template)
+	}
+	if len(inputs) != 1 {
+		t.Fatalf("expected a single task in the template, got %d", len(inputs))
+	}
+	newTask, err := inputs[0].toTask()
+	if err != nil {
+		t.Fatalf("failed to convert the template task: %v", err)
+	}
+	if err := newTask.Validate(); err != nil {
+		t.Fatalf("expected the template task to be valid, got %v", err)
+	}
+	return inputs[0]
+}
+
+func TestTaskTemplateIsValidTask(t *testing.T) {
+	for _, full := range []bool{true, false} {
+		template, err := taskTemplate(full)
+		if err != nil {
+			t.Fatalf("failed to build template: %v", err)
+		}
+		input := decodeTemplate(t, template)
+		if input.Name != "web" || input.Image != "nginx:1.25" {
+			t.Errorf("expected the example values to be decoded, got %+v", input)
+		}
+		if !strings.Contains(string(template), `"// Image": `) {
+			t.Errorf("expected the fields to be documented, got:\n%s", template)
+		}
+		if hasVolumes := strings.Contains(string(template), `"Volumes"`); hasVolumes != full {
+			t.Errorf("expected the optional fields only in the full template, got:\n%s", template)
+		}
+	}
+}
+
+func TestTemplateFieldsMatchTaskInput(t *testing.T) {
+	inputType := reflect.TypeOf(taskInput{})
+	for i := 0; i < inputType.NumField(); i++ {
+		name := inputType.Field(i).Name
+		if _, found := templateFields[name]; !found {
+			t.Errorf("task input field %s isn't documented in the template", name)
+		}
+	}
+	for name := range templateFields {
+		if _, found := inputType.FieldByName(name); !found {
+			t.Errorf("documented template field %s isn't a task input field", name)
+		}
+	}
+}
+
+func TestInitTaskFileWritesNewFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "task.json")
+	if err := initTaskFile(path, false); err != nil {
+		t.Fatalf("failed to write template: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read template: %v", err)
+	}
+	decodeTemplate(t, data)
+
+	// An existing file is never overwritten
+	if err := initTaskFile(path, true); err == nil {
+		t.Error("expected the existing file to be kept")
+	}
+}
@@ -1,21 +1,36 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/rs/zerolog/log"
 	"github.com/urfave/cli/v2"
 
+	"orchestrator/httpapi"
 	"orchestrator/logger"
 	"orchestrator/manager"
+	"orchestrator/node"
+	"orchestrator/scheduler"
+	"orchestrator/task"
 )
 
+// Maximum duration of the graceful shutdown
+const shutdownTimeout = 30 * time.Second
+
 func main() {
 	app := &cli.App{
 		Name:  "containers orchestration manager",
 		Usage: "start the manager process and API",
-		Flags: []cli.Flag{
+		Flags: append([]cli.Flag{
 			&cli.IntFlag{
 				Name:    "port",
 				Aliases: []string{"p"},
@@ -25,11 +40,11 @@ func main() {
 			&cli.StringFlag{
 				Name:     "storeType",
 				Aliases:  []string{"st"},
-				Usage:    `store type to use for tasks, allowed values: "memory", "persisted"`,
+				Usage:    `store type to use for tasks, allowed values: "memory", "persisted", "sqlite"`,
 				Required: true,
 				Action: func(ctx *cli.Context, v string) error {
-					if v != "memory" && v != "persisted" {
-						return errors.New(`invalid storeType, allowed values: "memory", "persisted"`)
+					if v != "memory" && v != "persisted" && v != "sqlite" {
+						return errors.New(`invalid storeType, allowed values: "memory", "persisted", "sqlite"`)
 					}
 					return nil
 				},
@@ -37,11 +52,11 @@ func main() {
 			&cli.StringFlag{
 				Name:     "schedulerType",
 				Aliases:  []string{"sct"},
-				Usage:    `scheduler type to select a worker for new tasks, allowed values: "roundrobin", "epvm"`,
+				Usage:    `scheduler type to select a worker for new tasks, allowed values: "roundrobin", "epvm", "random", "binpacking"`,
 				Required: true,
 				Action: func(ctx *cli.Context, v string) error {
-					if v != "roundrobin" && v != "epvm" {
-						return errors.New(`invalid schedulerType, allowed values: "roundrobin", "epvm"`)
+					if v != "roundrobin" && v != "epvm" && v != "random" && v != "binpacking" {
+						return errors.New(`invalid schedulerType, allowed values: "roundrobin", "epvm", "random", "binpacking"`)
 					}
 					return nil
 				},
@@ -52,6 +67,193 @@ func main() {
 				Usage:    "address of container orchestration worker(s) API to manage",
 				Required: true,
 			},
+			&cli.Float64Flag{
+				Name:  "headroom",
+				Usage: "minimum percentage of free cpu, memory and disk to preserve on worker nodes when scheduling",
+				Value: 5,
+				Action: func(ctx *cli.Context, v float64) error {
+					if v < 0 || v >= 100 {
+						return errors.New("invalid headroom, allowed values: [0, 100)")
+					}
+					return nil
+				},
+			},
+			&cli.Float64Flag{
+				Name:  "epvmCpuWeight",
+				Usage: "factor of the cpu cost in the epvm scheduler score, raise it on cpu-bound clusters",
+				Value: scheduler.DefaultEpvmWeight,
+				Action: func(ctx *cli.Context, v float64) error {
+					if v <= 0 {
+						return errors.New("invalid epvmCpuWeight, must be strictly positive")
+					}
+					return nil
+				},
+			},
+			&cli.Float64Flag{
+				Name:  "epvmMemoryWeight",
+				Usage: "factor of the memory cost in the epvm scheduler score, raise it on memory-bound clusters",
+				Value: scheduler.DefaultEpvmWeight,
+				Action: func(ctx *cli.Context, v float64) error {
+					if v <= 0 {
+						return errors.New("invalid epvmMemoryWeight, must be strictly positive")
+					}
+					return nil
+				},
+			},
+			&cli.IntFlag{
+				Name:  "workerMetricsPort",
+				Usage: "port of the workers metrics route, defaults to their API port",
+			},
+			&cli.DurationFlag{
+				Name:  "eventRetention",
+				Usage: "age after which stored task events are deleted, the latest event of each task is kept, 0 to disable",
+				Value: 7 * 24 * time.Hour,
+			},
+			&cli.StringSliceFlag{
+				Name:  "nodeLimits",
+				Usage: `hard resource limits of a worker node, format: "address;cpu=2;memory=4194304;disk=107374182400" (memory in KB, disk in bytes)`,
+			},
+			&cli.StringSliceFlag{
+				Name:  "nodeLabels",
+				Usage: `labels of a worker node matched by the tasks node selector, format: "address;zone=eu-west;disk=ssd"`,
+			},
+			&cli.StringFlag{
+				Name:    "registryCredentials",
+				Usage:   `credentials of the private registries by host, in the {"registry.example.com": {"Username": "user", "Password": "secret"}} JSON format, set it through the environment to keep it out of the process list`,
+				EnvVars: []string{"ORCHESTRATOR_REGISTRY_CREDENTIALS"},
+			},
+			&cli.StringFlag{
+				Name:  "dataDir",
+				Usage: "directory where the cluster snapshots are written",
+				Value: ".",
+			},
+			&cli.StringSliceFlag{
+				Name:  "restore",
+				Usage: `backup of a persisted store restored before the manager starts, format: "tasks=path/to/manager_tasks.db", the store being "tasks", "events" or "groups"`,
+			},
+			&cli.DurationFlag{
+				Name:  "snapshotInterval",
+				Usage: "interval between cluster state snapshots, 0 to disable",
+			},
+			&cli.DurationFlag{
+				Name:  "reconcileInterval",
+				Usage: "interval between tasks assignments rebuilds from the tasks reported by the workers, 0 to disable",
+			},
+			&cli.IntFlag{
+				Name:  "snapshotRetention",
+				Usage: "number of snapshot files to keep, 0 to keep all of them",
+				Value: 24,
+				Action: func(ctx *cli.Context, v int) error {
+					if v < 0 {
+						return errors.New("invalid snapshotRetention, must be positive")
+					}
+					return nil
+				},
+			},
+			&cli.Float64Flag{
+				Name:  "defaultCpu",
+				Usage: "number of cpus requested by tasks which don't specify it, 0 for no limit",
+				Action: func(ctx *cli.Context, v float64) error {
+					if v < 0 {
+						return errors.New("invalid defaultCpu, must be positive")
+					}
+					return nil
+				},
+			},
+			&cli.Int64Flag{
+				Name:  "defaultMemory",
+				Usage: "memory in bytes requested by tasks which don't specify it, 0 for no limit",
+				Action: func(ctx *cli.Context, v int64) error {
+					if v < 0 {
+						return errors.New("invalid defaultMemory, must be positive")
+					}
+					return nil
+				},
+			},
+			&cli.Int64Flag{
+				Name:  "defaultDisk",
+				Usage: "disk in bytes requested by tasks which don't specify it, 0 for no limit",
+				Action: func(ctx *cli.Context, v int64) error {
+					if v < 0 {
+						return errors.New("invalid defaultDisk, must be positive")
+					}
+					return nil
+				},
+			},
+			&cli.BoolFlag{
+				Name:  "purgeStoppedTasks",
+				Usage: "delete the stopped tasks from the store instead of keeping them as completed",
+			},
+			&cli.IntFlag{
+				Name:  "maxRestartsPerInterval",
+				Usage: "maximum number of failed tasks restarted per restartRateInterval, the others are delayed, 0 for no limit",
+				Action: func(ctx *cli.Context, v int) error {
+					if v < 0 {
+						return errors.New("invalid maxRestartsPerInterval, must be positive")
+					}
+					return nil
+				},
+			},
+			&cli.DurationFlag{
+				Name:  "restartRateInterval",
+				Usage: "interval of the failed tasks restarts rate limit",
+				Value: time.Minute,
+			},
+			&cli.IntFlag{
+				Name:  "maxRestarts",
+				Usage: "maximum number of restarts of a failed task, unless the task defines its own limit",
+				Value: manager.DefaultMaxRestarts,
+				Action: func(ctx *cli.Context, v int) error {
+					if v < 0 {
+						return errors.New("invalid maxRestarts, must be positive")
+					}
+					return nil
+				},
+			},
+			&cli.DurationFlag{
+				Name:  "healthInterval",
+				Usage: "interval between failed tasks health checks",
+				Value: manager.DefaultLoopInterval,
+				Action: func(ctx *cli.Context, v time.Duration) error {
+					if v <= 0 {
+						return errors.New("invalid healthInterval, must be strictly positive")
+					}
+					return nil
+				},
+			},
+			&cli.DurationFlag{
+				Name:  "updateInterval",
+				Usage: "interval between tasks state retrievals from the workers",
+				Value: manager.DefaultLoopInterval,
+				Action: func(ctx *cli.Context, v time.Duration) error {
+					if v <= 0 {
+						return errors.New("invalid updateInterval, must be strictly positive")
+					}
+					return nil
+				},
+			},
+			&cli.DurationFlag{
+				Name:  "statsInterval",
+				Usage: "interval between worker nodes stats retrievals, nodes whose stats are 3 intervals old receive no task",
+				Value: manager.DefaultLoopInterval,
+				Action: func(ctx *cli.Context, v time.Duration) error {
+					if v <= 0 {
+						return errors.New("invalid statsInterval, must be strictly positive")
+					}
+					return nil
+				},
+			},
+			&cli.DurationFlag{
+				Name:  "statsTimeout",
+				Usage: "maximum duration of a worker node stats retrieval, independent of the API timeouts",
+				Value: node.DefaultStatsTimeout,
+				Action: func(ctx *cli.Context, v time.Duration) error {
+					if v <= 0 {
+						return errors.New("invalid statsTimeout, must be strictly positive")
+					}
+					return nil
+				},
+			},
 			&cli.StringFlag{
 				Name:  "logLevel",
 				Usage: `log level to use, allowed values: "debug", "info", "error"`,
@@ -63,10 +265,58 @@ func main() {
 					return nil
 				},
 			},
-		},
+		}, httpapi.ServerFlags()...),
 		Action: func(ctx *cli.Context) error {
-			logger.Setup(ctx.String("logLevel"), "manager")
-			startManager(ctx.Int("port"), ctx.String("storeType"), ctx.String("schedulerType"), ctx.StringSlice("worker"))
+			if err := logger.Setup(ctx.String("logLevel"), "manager"); err != nil {
+				return err
+			}
+			nodeLimits, err := parseNodeLimits(ctx.StringSlice("nodeLimits"))
+			if err != nil {
+				return err
+			}
+			nodeLabels, err := parseNodeLabels(ctx.StringSlice("nodeLabels"))
+			if err != nil {
+				return err
+			}
+			registryCredentials, err := parseRegistryCredentials(ctx.String("registryCredentials"))
+			if err != nil {
+				return err
+			}
+			config := manager.Config{
+				Headroom:               ctx.Float64("headroom"),
+				EpvmCpuWeight:          ctx.Float64("epvmCpuWeight"),
+				EpvmMemoryWeight:       ctx.Float64("epvmMemoryWeight"),
+				WorkerMetricsPort:      ctx.Int("workerMetricsPort"),
+				EventRetention:         ctx.Duration("eventRetention"),
+				NodeLimits:             nodeLimits,
+				NodeLabels:             nodeLabels,
+				RegistryCredentials:    registryCredentials,
+				DataDir:                ctx.String("dataDir"),
+				SnapshotInterval:       ctx.Duration("snapshotInterval"),
+				ReconcileInterval:      ctx.Duration("reconcileInterval"),
+				SnapshotRetention:      ctx.Int("snapshotRetention"),
+				DefaultCpu:             ctx.Float64("defaultCpu"),
+				DefaultMemory:          ctx.Int64("defaultMemory"),
+				DefaultDisk:            ctx.Int64("defaultDisk"),
+				PurgeStoppedTasks:      ctx.Bool("purgeStoppedTasks"),
+				MaxRestartsPerInterval: ctx.Int("maxRestartsPerInterval"),
+				RestartRateInterval:    ctx.Duration("restartRateInterval"),
+				MaxRestarts:            ctx.Int("maxRestarts"),
+				HealthCheckInterval:    ctx.Duration("healthInterval"),
+				UpdateInterval:         ctx.Duration("updateInterval"),
+				StatsInterval:          ctx.Duration("statsInterval"),
+				StatsTimeout:           ctx.Duration("statsTimeout"),
+			}
+			if ctx.IsSet("restore") {
+				if ctx.String("storeType") != "persisted" {
+					return errors.New(`restore is only available with the "persisted" storeType`)
+				}
+				if err := restoreStores(ctx.StringSlice("restore")); err != nil {
+					return err
+				}
+			}
+			server := httpapi.ServerConfigFromFlags(ctx)
+			startManager(ctx.Int("port"), ctx.String("storeType"), ctx.String("schedulerType"), ctx.StringSlice("worker"), config, server)
 			return nil
 		},
 	}
@@ -76,28 +326,130 @@ func main() {
 	}
 }
 
-func startManager(port int, storeType string, schedulerType string, workers []string) {
-	m, err := manager.New(workers, schedulerType, storeType)
+func startManager(port int, storeType string, schedulerType string, workers []string, config manager.Config, server httpapi.ServerConfig) {
+	m, err := manager.New(workers, schedulerType, storeType, config)
 	if err != nil {
 		log.Err(err).Msg("manager creation failed")
 		return
 	}
 
-	defer func() {
-		if err := m.Close(); err != nil {
-			log.Err(err).Msg("failed to stop manager")
-		}
-	}()
-
 	// Launch backgound routines
-	go m.ProcessTasks()
-	go m.UpdateTasks()
-	go m.CheckTasksHealth()
-	go m.CheckNodesStats()
+	m.Start()
 
 	// Run API
 	host := "127.0.0.1"
 	log.Info().Msgf("Manager API listening on %s:%d", host, port)
-	api := manager.Api{Address: host, Port: port, Manager: m}
-	api.StartRouter()
+	api := &manager.Api{Address: host, Port: port, Manager: m, Server: server}
+	apiDone := make(chan struct{})
+	go func() {
+		api.StartRouter()
+		close(apiDone)
+	}()
+
+	// Block until the process is asked to stop, then stop the API, the background routines and the stores in order
+	waitForShutdown(apiDone)
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := m.Shutdown(ctx, api); err != nil {
+		log.Err(err).Msg("failed to stop manager")
+	}
+}
+
+// Parse worker nodes limits in the "address;cpu=2;memory=4194304;disk=107374182400" format
+func parseNodeLimits(specs []string) (map[string]manager.NodeLimits, error) {
+	nodeLimits := make(map[string]manager.NodeLimits, len(specs))
+	for _, spec := range specs {
+		parts := strings.Split(spec, ";")
+		limits := manager.NodeLimits{}
+		for _, part := range parts[1:] {
+			key, value, found := strings.Cut(part, "=")
+			if !found {
+				return nil, fmt.Errorf("invalid node limit %q, expected format: key=value", part)
+			}
+			var err error
+			switch key {
+			case "cpu":
+				limits.MaxCpu, err = strconv.ParseFloat(value, 64)
+			case "memory":
+				limits.MaxMemory, err = strconv.ParseInt(value, 10, 64)
+			case "disk":
+				limits.MaxDisk, err = strconv.ParseInt(value, 10, 64)
+			default:
+				return nil, fmt.Errorf(`invalid node limit %q, allowed values: "cpu", "memory", "disk"`, key)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s limit value %q: %w", key, value, err)
+			}
+		}
+		nodeLimits[parts[0]] = limits
+	}
+	return nodeLimits, nil
+}
+
+// Parse worker nodes labels in the "address;zone=eu-west;disk=ssd" format
+func parseNodeLabels(specs []string) (map[string]map[string]string, error) {
+	nodeLabels := make(map[string]map[string]string, len(specs))
+	for _, spec := range specs {
+		parts := strings.Split(spec, ";")
+		labels, err := task.ParseLabelSelector(parts[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid labels of node %s: %w", parts[0], err)
+		}
+		nodeLabels[parts[0]] = labels
+	}
+	return nodeLabels, nil
+}
+
+// Parse the private registries credentials by host, in the {"host": {"Username": "user", "Password": "secret"}} format
+//
+// The parsing errors don't include the value, since it contains the credentials
+func parseRegistryCredentials(spec string) (map[string]task.RegistryAuth, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	var credentials map[string]task.RegistryAuth
+	if err := json.Unmarshal([]byte(spec), &credentials); err != nil {
+		return nil, errors.New(`invalid registryCredentials, expected format: {"host": {"Username": "user", "Password": "secret"}}`)
+	}
+	for host, auth := range credentials {
+		if err := task.ValidateRegistryAuth(&auth); err != nil {
+			return nil, fmt.Errorf("invalid credentials of registry %s: %w", host, err)
+		}
+	}
+	return credentials, nil
+}
+
+// Wait for a termination signal or for the API server to stop
+func waitForShutdown(apiDone <-chan struct{}) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	select {
+	case s := <-sig:
+		log.Info().Str("signal", s.String()).Msg("shutdown signal received, stopping manager")
+	case <-apiDone:
+		log.Info().Msg("api server stopped, stopping manager")
+	}
+}
+
+// Restore the persisted stores from their backups, given in the "store=path" format
+func restoreStores(backups []string) error {
+	for _, backup := range backups {
+		name, path, found := strings.Cut(backup, "=")
+		if !found || name == "" || path == "" {
+			return fmt.Errorf(`invalid restore %q, expected format: "tasks=path/to/manager_tasks.db"`, backup)
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("failed to open backup of store %s: %w", name, err)
+		}
+		err = manager.RestoreStore(name, file)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("failed to restore store %s: %w", name, err)
+		}
+		log.Info().Str("store", name).Str("backup", path).Msg("store restored")
+	}
+	return nil
 }
@@ -1,22 +1,34 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/rs/zerolog/log"
 	"github.com/urfave/cli/v2"
 
+	"orchestrator/httpapi"
 	"orchestrator/logger"
+	"orchestrator/stats"
+	"orchestrator/task"
 	"orchestrator/worker"
 )
 
+// Maximum duration of the graceful shutdown
+const shutdownTimeout = 30 * time.Second
+
 func main() {
 	app := &cli.App{
 		Name:  "containers orchestration worker",
 		Usage: "start the worker process and API",
-		Flags: []cli.Flag{
+		Flags: append([]cli.Flag{
 			&cli.StringFlag{
 				Name:     "name",
 				Aliases:  []string{"n"},
@@ -29,18 +41,131 @@ func main() {
 				Usage:   "port to serve the API on",
 				Value:   8080,
 			},
+			&cli.IntFlag{
+				Name:  "metricsPort",
+				Usage: "port to serve the metrics route on, defaults to the API port",
+			},
 			&cli.StringFlag{
 				Name:     "storeType",
 				Aliases:  []string{"st"},
-				Usage:    `store type to use for tasks, allowed values: "memory", "persisted"`,
+				Usage:    `store type to use for tasks, allowed values: "memory", "persisted", "sqlite"`,
 				Required: true,
 				Action: func(ctx *cli.Context, v string) error {
-					if v != "memory" && v != "persisted" {
-						return errors.New(`invalid storeType, allowed values: "memory", "persisted"`)
+					if v != "memory" && v != "persisted" && v != "sqlite" {
+						return errors.New(`invalid storeType, allowed values: "memory", "persisted", "sqlite"`)
+					}
+					return nil
+				},
+			},
+			&cli.StringFlag{
+				Name:  "dataDir",
+				Usage: "directory where the worker files are written",
+				Value: ".",
+			},
+			&cli.Int64Flag{
+				Name:  "maxOutputSize",
+				Usage: "maximum size in bytes of a captured task output",
+				Value: worker.DefaultMaxOutputSize,
+			},
+			&cli.StringFlag{
+				Name:  "containerPrefix",
+				Usage: "prefix of the created containers names, followed by the task id",
+				Value: task.DefaultContainerPrefix,
+			},
+			&cli.DurationFlag{
+				Name:  "startTimeout",
+				Usage: "maximum duration of a task start, including the image pull, 0 to disable",
+				Value: worker.DefaultStartTimeout,
+				Action: func(ctx *cli.Context, v time.Duration) error {
+					if v < 0 {
+						return errors.New("invalid startTimeout, must be positive")
+					}
+					return nil
+				},
+			},
+			&cli.DurationFlag{
+				Name:  "dockerTimeout",
+				Usage: "maximum duration of a container stop or inspection request to the Docker daemon, 0 to disable",
+				Value: worker.DefaultDockerTimeout,
+				Action: func(ctx *cli.Context, v time.Duration) error {
+					if v < 0 {
+						return errors.New("invalid dockerTimeout, must be positive")
+					}
+					return nil
+				},
+			},
+			&cli.IntFlag{
+				Name:  "maxConcurrent",
+				Usage: "maximum number of tasks started or stopped at the same time, defaults to the number of CPUs",
+				Action: func(ctx *cli.Context, v int) error {
+					if v < 1 {
+						return errors.New("invalid maxConcurrent, must be at least 1")
 					}
 					return nil
 				},
 			},
+			&cli.StringFlag{
+				Name:  "manager",
+				Usage: "address of the manager API, asked to migrate the worker tasks when receiving SIGTERM",
+			},
+			&cli.StringFlag{
+				Name:  "nodeAddress",
+				Usage: "address of this worker as registered on the manager, defaults to 127.0.0.1:<port>",
+			},
+			&cli.DurationFlag{
+				Name:  "drainTimeout",
+				Usage: "maximum duration waited for the manager to acknowledge the worker drain",
+				Value: worker.DefaultDrainTimeout,
+			},
+			&cli.StringFlag{
+				Name:  "dockerHost",
+				Usage: "address of the Docker daemon running the containers, defaults to the DOCKER_HOST environment variable",
+			},
+			&cli.StringFlag{
+				Name:  "dockerTLS",
+				Usage: "directory of the ca.pem, cert.pem and key.pem files used to connect to the Docker daemon with TLS",
+			},
+			&cli.DurationFlag{
+				Name:  "updateInterval",
+				Usage: "interval between tasks state updates from their containers",
+				Value: worker.DefaultLoopInterval,
+				Action: func(ctx *cli.Context, v time.Duration) error {
+					if v <= 0 {
+						return errors.New("invalid updateInterval, must be strictly positive")
+					}
+					return nil
+				},
+			},
+			&cli.DurationFlag{
+				Name:  "statsInterval",
+				Usage: "interval between node stats collections",
+				Value: worker.DefaultLoopInterval,
+				Action: func(ctx *cli.Context, v time.Duration) error {
+					if v <= 0 {
+						return errors.New("invalid statsInterval, must be strictly positive")
+					}
+					return nil
+				},
+			},
+			&cli.StringFlag{
+				Name:  "statsCollector",
+				Usage: `source of the node stats, allowed values: "procfs" (Linux only), "gopsutil" (cross-platform)`,
+				Value: stats.DefaultCollector,
+				Action: func(ctx *cli.Context, v string) error {
+					if _, err := stats.NewCollector(v); err != nil {
+						return errors.New(`invalid statsCollector, allowed values: "procfs", "gopsutil"`)
+					}
+					return nil
+				},
+			},
+			&cli.StringFlag{
+				Name:  "capacity",
+				Usage: `resources available to the tasks, reported instead of the machine ones, in the "cpu=2,mem=4194304,disk=107374182400" format (memory in KB, disk in bytes)`,
+				Action: func(ctx *cli.Context, v string) error {
+					_, err := parseCapacity(v)
+					return err
+				},
+			},
 			&cli.StringFlag{
 				Name:  "logLevel",
 				Usage: `log level to use, allowed values: "debug", "info", "error"`,
@@ -52,11 +177,54 @@ func main() {
 					return nil
 				},
 			},
-		},
+		}, httpapi.ServerFlags()...),
 		Action: func(ctx *cli.Context) error {
 			name := ctx.String("name")
-			logger.Setup(ctx.String("logLevel"), fmt.Sprintf("worker-%s", name))
-			startWorker(name, ctx.Int("port"), ctx.String("storeType"))
+			if err := logger.Setup(ctx.String("logLevel"), fmt.Sprintf("worker-%s", name)); err != nil {
+				return err
+			}
+			drain := worker.DrainConfig{
+				ManagerAddress: ctx.String("manager"),
+				NodeAddress:    ctx.String("nodeAddress"),
+				Timeout:        ctx.Duration("drainTimeout"),
+			}
+			if drain.NodeAddress == "" {
+				drain.NodeAddress = fmt.Sprintf("127.0.0.1:%d", ctx.Int("port"))
+			}
+			docker := task.DockerConfig{
+				Host:    ctx.String("dockerHost"),
+				TLSPath: ctx.String("dockerTLS"),
+			}
+			server := httpapi.ServerConfigFromFlags(ctx)
+			collector, err := stats.NewCollector(ctx.String("statsCollector"))
+			if err != nil {
+				return err
+			}
+			if ctx.IsSet("capacity") {
+				capacity, err := parseCapacity(ctx.String("capacity"))
+				if err != nil {
+					return err
+				}
+				collector = stats.WithCapacity(collector, capacity)
+			}
+			startWorker(workerOptions{
+				Name:            name,
+				Port:            ctx.Int("port"),
+				MetricsPort:     ctx.Int("metricsPort"),
+				StoreType:       ctx.String("storeType"),
+				DataDir:         ctx.String("dataDir"),
+				MaxOutputSize:   ctx.Int64("maxOutputSize"),
+				ContainerPrefix: ctx.String("containerPrefix"),
+				StartTimeout:    ctx.Duration("startTimeout"),
+				DockerTimeout:   ctx.Duration("dockerTimeout"),
+				MaxConcurrent:   ctx.Int("maxConcurrent"),
+				UpdateInterval:  ctx.Duration("updateInterval"),
+				StatsInterval:   ctx.Duration("statsInterval"),
+				Collector:       collector,
+				Drain:           drain,
+				Docker:          docker,
+				Server:          server,
+			})
 			return nil
 		},
 	}
@@ -66,27 +234,115 @@ func main() {
 	}
 }
 
-func startWorker(name string, port int, storeType string) {
-	w, err := worker.New(name, storeType)
+// Settings of the worker process
+type workerOptions struct {
+	Name            string               // Name of the worker, also used for its store file
+	Port            int                  // Port of the worker API
+	MetricsPort     int                  // Port of the metrics route, served on the API port when 0 or equal to it
+	StoreType       string               // Type of the tasks store: "memory", "persisted" or "sqlite"
+	DataDir         string               // Directory of the store files and captured tasks outputs
+	MaxOutputSize   int64                // Maximum size in bytes of a task output file
+	ContainerPrefix string               // Prefix of the names of the containers created by the worker
+	StartTimeout    time.Duration        // Maximum duration of a task start, including the image pull, 0 to disable
+	DockerTimeout   time.Duration        // Maximum duration of a container stop or inspection, 0 to disable
+	MaxConcurrent   int                  // Maximum number of tasks started or stopped at the same time, the worker default when 0
+	UpdateInterval  time.Duration        // Interval between tasks state updates
+	StatsInterval   time.Duration        // Interval between stats collections
+	Collector       stats.StatsCollector // Source of the node stats
+	Drain           worker.DrainConfig   // Settings of the drain run when the process receives SIGTERM
+	Docker          task.DockerConfig    // Settings of the Docker daemon connection
+	Server          httpapi.ServerConfig // Settings of the API server
+}
+
+// Create and start the worker with the given options, then serve its API until the process is asked to stop
+func startWorker(options workerOptions) {
+	w, err := worker.New(options.Name, options.StoreType, options.DataDir, options.Docker)
 	if err != nil {
 		log.Err(err).Msg("worker creation failed")
 		return
 	}
-
-	defer func() {
-		if err := w.Close(); err != nil {
-			log.Err(err).Msg("failed to stop worker")
-		}
-	}()
+	w.MaxOutputSize = options.MaxOutputSize
+	w.ContainerPrefix = options.ContainerPrefix
+	w.StartTimeout = options.StartTimeout
+	w.DockerTimeout = options.DockerTimeout
+	if options.MaxConcurrent != 0 {
+		w.MaxConcurrent = options.MaxConcurrent
+	}
+	w.UpdateInterval = options.UpdateInterval
+	w.StatsInterval = options.StatsInterval
+	w.StatsCollector = options.Collector
 
 	// Launch backgound routines
-	go w.RunTasks()
-	go w.CollectStats()
-	go w.UpdateTasks()
+	w.Start()
 
 	// Run API
 	host := "127.0.0.1"
-	log.Info().Msgf("Worker %s API listening on %s:%d", name, host, port)
-	api := worker.Api{Address: host, Port: port, Worker: w}
-	api.StartRouter()
+	log.Info().Msgf("Worker %s API listening on %s:%d", options.Name, host, options.Port)
+	if options.MetricsPort != 0 && options.MetricsPort != options.Port {
+		log.Info().Msgf("Worker %s metrics API listening on %s:%d", options.Name, host, options.MetricsPort)
+	}
+	api := &worker.Api{Address: host, Port: options.Port, MetricsPort: options.MetricsPort, Worker: w, Server: options.Server}
+	apiDone := make(chan struct{})
+	go func() {
+		api.StartRouter()
+		close(apiDone)
+	}()
+
+	// Block until the process is asked to stop, then stop the API, the background routines and the stores in order
+	if waitForShutdown(apiDone) == syscall.SIGTERM && options.Drain.ManagerAddress != "" {
+		// The node is drained by an external system, let the manager move the tasks before exiting
+		if err := w.Drain(options.Drain); err != nil {
+			log.Err(err).Msg("worker drain failed")
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := w.Shutdown(ctx, api); err != nil {
+		log.Err(err).Msg("failed to stop worker")
+	}
+}
+
+// Wait for a termination signal or for the API server to stop, the received signal is returned
+func waitForShutdown(apiDone <-chan struct{}) os.Signal {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	select {
+	case s := <-sig:
+		log.Info().Str("signal", s.String()).Msg("shutdown signal received, stopping worker")
+		return s
+	case <-apiDone:
+		log.Info().Msg("api server stopped, stopping worker")
+		return nil
+	}
+}
+
+// Parse the worker capacity in the "cpu=2,mem=4194304,disk=107374182400" format, the omitted resources aren't overridden
+func parseCapacity(spec string) (stats.Capacity, error) {
+	capacity := stats.Capacity{}
+	for _, part := range strings.Split(spec, ",") {
+		key, value, found := strings.Cut(part, "=")
+		if !found {
+			return capacity, fmt.Errorf("invalid capacity %q, expected format: key=value", part)
+		}
+		var err error
+		switch key {
+		case "cpu":
+			capacity.Cpu, err = strconv.ParseFloat(value, 64)
+			if err == nil && capacity.Cpu <= 0 {
+				err = errors.New("must be strictly positive")
+			}
+		case "mem":
+			capacity.MemoryKb, err = strconv.ParseUint(value, 10, 64)
+		case "disk":
+			capacity.DiskBytes, err = strconv.ParseUint(value, 10, 64)
+		default:
+			return capacity, fmt.Errorf(`invalid capacity %q, allowed values: "cpu", "mem", "disk"`, key)
+		}
+		if err != nil {
+			return capacity, fmt.Errorf("invalid %s capacity value %q: %w", key, value, err)
+		}
+	}
+	return capacity, nil
 }
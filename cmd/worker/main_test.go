@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestWaitForShutdownReturnsSigterm(t *testing.T) {
+	// Keep the signal from terminating the test process before the wait registers its handler
+	guard := make(chan os.Signal, 1)
+	signal.Notify(guard, syscall.SIGTERM)
+	defer signal.Stop(guard)
+
+	received := make(chan os.Signal, 1)
+	go func() {
+		received <- waitForShutdown(make(chan struct{}))
+	}()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+			t.Fatalf("failed to send signal: %v", err)
+		}
+		select {
+		case s := <-received:
+			if s != syscall.SIGTERM {
+				t.Fatalf("expected SIGTERM to be returned, got %v", s)
+			}
+			return
+		case <-time.After(20 * time.Millisecond):
+		case <-timeout:
+			t.Fatal("timed out waiting for the shutdown signal")
+		}
+	}
+}
+
+func TestWaitForShutdownReturnsWhenApiStops(t *testing.T) {
+	apiDone := make(chan struct{})
+	close(apiDone)
+	if s := waitForShutdown(apiDone); s != nil {
+		t.Errorf("expected no signal when the api stopped, got %v", s)
+	}
+}
+
+func TestParseCapacity(t *testing.T) {
+	capacity, err := parseCapacity("cpu=1.5,mem=4194304,disk=107374182400")
+	if err != nil {
+		t.Fatalf("failed to parse capacity: %v", err)
+	}
+	if capacity.Cpu != 1.5 || capacity.MemoryKb != 4194304 || capacity.DiskBytes != 107374182400 {
+		t.Errorf("unexpected capacity %+v", capacity)
+	}
+	if capacity, err := parseCapacity("mem=1024"); err != nil || capacity.Cpu != 0 || capacity.MemoryKb != 1024 {
+		t.Errorf("expected only the memory to be overridden, got %+v and %v", capacity, err)
+	}
+
+	for _, spec := range []string{"cpu", "cpu=0", "cpu=abc", "mem=-1", "gpu=1"} {
+		if _, err := parseCapacity(spec); err == nil {
+			t.Errorf("expected the capacity %q to be rejected", spec)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package httpapi
+
+import (
+	"errors"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// Command line flags of the API server limits, read with ServerConfigFromFlags
+func ServerFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.DurationFlag{
+			Name:   "readTimeout",
+			Usage:  "maximum duration to read an API request, including its body, 0 for no limit",
+			Value:  DefaultReadTimeout,
+			Action: positiveDuration("readTimeout"),
+		},
+		&cli.DurationFlag{
+			Name:   "writeTimeout",
+			Usage:  "maximum duration to write an API response, streamed responses aren't limited, 0 for no limit",
+			Value:  DefaultWriteTimeout,
+			Action: positiveDuration("writeTimeout"),
+		},
+		&cli.DurationFlag{
+			Name:   "idleTimeout",
+			Usage:  "maximum duration an API keep-alive connection waits for the next request, 0 to use readTimeout",
+			Value:  DefaultIdleTimeout,
+			Action: positiveDuration("idleTimeout"),
+		},
+		&cli.Int64Flag{
+			Name:  "maxBodySize",
+			Usage: "maximum size in bytes of an API request body, 0 for no limit",
+			Value: DefaultMaxBodySize,
+			Action: func(ctx *cli.Context, v int64) error {
+				if v < 0 {
+					return errors.New("invalid maxBodySize, must be positive")
+				}
+				return nil
+			},
+		},
+	}
+}
+
+// Read the API server limits from the flags defined by ServerFlags
+func ServerConfigFromFlags(ctx *cli.Context) ServerConfig {
+	return ServerConfig{
+		ReadTimeout:  ctx.Duration("readTimeout"),
+		WriteTimeout: ctx.Duration("writeTimeout"),
+		IdleTimeout:  ctx.Duration("idleTimeout"),
+		MaxBodySize:  ctx.Int64("maxBodySize"),
+	}
+}
+
+func positiveDuration(name string) func(ctx *cli.Context, v time.Duration) error {
+	return func(ctx *cli.Context, v time.Duration) error {
+		if v < 0 {
+			return errors.New("invalid " + name + ", must be positive")
+		}
+		return nil
+	}
+}
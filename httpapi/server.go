@@ -0,0 +1,40 @@
+package httpapi
+
+import (
+	"net/http"
+	"time"
+)
+
+// Default limits of the API HTTP servers
+const (
+	DefaultReadTimeout  = 30 * time.Second
+	DefaultWriteTimeout = time.Minute
+	DefaultIdleTimeout  = 2 * time.Minute
+	DefaultMaxBodySize  = 1 << 20
+)
+
+// Limits of an API HTTP server, protecting it from slow clients and oversized requests
+type ServerConfig struct {
+	ReadTimeout  time.Duration // Maximum duration to read a request, including its body, 0 for no limit
+	WriteTimeout time.Duration // Maximum duration to write a response, streamed responses aren't limited, 0 for no limit
+	IdleTimeout  time.Duration // Maximum duration a keep-alive connection waits for the next request, 0 to use the read timeout
+	MaxBodySize  int64         // Maximum size in bytes of a request body, 0 for no limit
+}
+
+// Create an HTTP server applying the configured limits to the requests of the handler
+func NewServer(addr string, handler http.Handler, config ServerConfig) *http.Server {
+	if config.MaxBodySize > 0 {
+		next := handler
+		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Body = http.MaxBytesReader(w, r.Body, config.MaxBodySize)
+			next.ServeHTTP(w, r)
+		})
+	}
+	return &http.Server{
+		Addr:         addr,
+		Handler:      handler,
+		ReadTimeout:  config.ReadTimeout,
+		WriteTimeout: config.WriteTimeout,
+		IdleTimeout:  config.IdleTimeout,
+	}
+}
@@ -0,0 +1,75 @@
+package httpapi
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Serve the handler with the given limits on a local port, the server address is returned
+func startServer(t *testing.T, handler http.Handler, config ServerConfig) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	server := NewServer(listener.Addr().String(), handler, config)
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+	return listener.Addr().String()
+}
+
+func TestServerRejectsOversizedBody(t *testing.T) {
+	addr := startServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var maxBytesErr *http.MaxBytesError
+		if _, err := io.ReadAll(r.Body); errors.As(err, &maxBytesErr) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}), ServerConfig{MaxBodySize: 16})
+
+	for body, expected := range map[string]int{
+		"small":                          http.StatusOK,
+		strings.Repeat("oversized", 100): http.StatusRequestEntityTooLarge,
+	} {
+		response, err := http.Post("http://"+addr, "text/plain", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		response.Body.Close()
+		if response.StatusCode != expected {
+			t.Errorf("expected status %d for a %d bytes body, got %d", expected, len(body), response.StatusCode)
+		}
+	}
+}
+
+func TestServerClosesSlowClientConnection(t *testing.T) {
+	addr := startServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}), ServerConfig{ReadTimeout: 100 * time.Millisecond})
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+	// The request headers are never completed
+	if _, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: orchestrator\r\n")); err != nil {
+		t.Fatalf("failed to write request: %v", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	response, err := io.ReadAll(conn)
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		t.Fatal("the connection of the slow client wasn't closed by the server")
+	}
+	if strings.Contains(string(response), "200 OK") {
+		t.Errorf("expected the incomplete request to be rejected, got %q", response)
+	}
+}
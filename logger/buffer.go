@@ -0,0 +1,122 @@
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Default number of log lines kept in memory
+const DefaultBufferSize = 1000
+
+// Log line written by the process
+type Entry struct {
+	Time time.Time
+	Line string
+}
+
+// Writer keeping the most recent log lines in memory, to be served over HTTP
+type Buffer struct {
+	mu      sync.Mutex
+	entries []Entry
+	start   int           // Index of the oldest entry once the buffer is full
+	size    int           // Maximum number of entries
+	written chan struct{} // Closed and replaced on each write to wake up followers
+}
+
+// Create a buffer keeping the given number of log lines
+func NewBuffer(size int) *Buffer {
+	return &Buffer{
+		entries: make([]Entry, 0, size),
+		size:    size,
+		written: make(chan struct{}),
+	}
+}
+
+// Store a log line, evicting the oldest one when the buffer is full
+func (b *Buffer) Write(p []byte) (int, error) {
+	entry := Entry{Time: time.Now().UTC(), Line: string(p)}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.entries) < b.size {
+		b.entries = append(b.entries, entry)
+	} else {
+		b.entries[b.start] = entry
+		b.start = (b.start + 1) % b.size
+	}
+	close(b.written)
+	b.written = make(chan struct{})
+	return len(p), nil
+}
+
+// Get the stored entries written after the given time, along with a channel closed on the next write
+func (b *Buffer) Since(since time.Time) ([]Entry, <-chan struct{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var entries []Entry
+	for i := range b.entries {
+		e := b.entries[(b.start+i)%len(b.entries)]
+		if e.Time.After(since) {
+			entries = append(entries, e)
+		}
+	}
+	return entries, b.written
+}
+
+// Serve the buffered log lines as JSON lines
+//
+// The "since" query parameter only returns the lines written after the given RFC 3339 time, the "follow"
+// query parameter keeps the response open to send the new lines until the client disconnects
+func (b *Buffer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var since time.Time
+	if value := r.URL.Query().Get("since"); value != "" {
+		var err error
+		since, err = time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "invalid since parameter %q, expected an RFC 3339 time", value)
+			return
+		}
+	}
+	follow := r.URL.Query().Get("follow") == "true"
+
+	flusher, ok := w.(http.Flusher)
+	if follow && !ok {
+		log.Error().Msg("response writer doesn't support streaming")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if follow {
+		// The logs are followed as long as the client reads them, whatever the server write timeout
+		http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	for {
+		entries, written := b.Since(since)
+		for _, e := range entries {
+			if err := encoder.Encode(e); err != nil {
+				return
+			}
+			since = e.Time
+		}
+		if !follow {
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-written:
+		}
+	}
+}
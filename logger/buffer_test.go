@@ -0,0 +1,100 @@
+package logger
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// Get the lines of the given entries
+func lines(entries []Entry) []string {
+	var result []string
+	for _, e := range entries {
+		result = append(result, e.Line)
+	}
+	return result
+}
+
+func TestBufferEvictsOldestLines(t *testing.T) {
+	b := NewBuffer(2)
+	for _, line := range []string{"first", "second", "third"} {
+		b.Write([]byte(line))
+	}
+
+	entries, _ := b.Since(time.Time{})
+	if got := lines(entries); len(got) != 2 || got[0] != "second" || got[1] != "third" {
+		t.Errorf("expected the 2 most recent lines in order, got %v", got)
+	}
+}
+
+func TestServeLinesWrittenSince(t *testing.T) {
+	b := NewBuffer(DefaultBufferSize)
+	b.Write([]byte("old\n"))
+	entries, _ := b.Since(time.Time{})
+	b.Write([]byte("new\n"))
+
+	rec := httptest.NewRecorder()
+	query := url.Values{"since": {entries[0].Time.Format(time.RFC3339Nano)}}
+	b.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/logs?"+query.Encode(), nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	var served Entry
+	if err := json.NewDecoder(rec.Body).Decode(&served); err != nil {
+		t.Fatalf("failed to decode served line: %v", err)
+	}
+	if served.Line != "new\n" || rec.Body.Len() != 0 {
+		t.Errorf("expected only the line written after the since time, got %q and %q", served.Line, rec.Body.String())
+	}
+}
+
+func TestServeRejectsInvalidSince(t *testing.T) {
+	rec := httptest.NewRecorder()
+	NewBuffer(1).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/logs?since=yesterday", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestServeFollowsNewLines(t *testing.T) {
+	b := NewBuffer(DefaultBufferSize)
+	b.Write([]byte("before\n"))
+	server := httptest.NewServer(b)
+	defer server.Close()
+
+	response, err := http.Get(server.URL + "?follow=true")
+	if err != nil {
+		t.Fatalf("failed to request logs: %v", err)
+	}
+	defer response.Body.Close()
+	scanner := bufio.NewScanner(response.Body)
+	received := make(chan string)
+	go func() {
+		defer close(received)
+		for scanner.Scan() {
+			var e Entry
+			json.Unmarshal(scanner.Bytes(), &e)
+			received <- e.Line
+		}
+	}()
+
+	expected := []string{"before\n", "after\n"}
+	for i, line := range expected {
+		if i == 1 {
+			b.Write([]byte(line))
+		}
+		select {
+		case got := <-received:
+			if got != line {
+				t.Errorf("expected line %q, got %q", line, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for line %q", line)
+		}
+	}
+}
@@ -1,12 +1,19 @@
 package logger
 
 import (
+	"fmt"
+	"io"
+	"os"
+
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
 
+// Most recent log lines of the process, served by the logs API routes
+var Recent = NewBuffer(DefaultBufferSize)
+
 // Helper function to set the global minimum logging level and add a 'service' field to all log messages
-func Setup(minimumLogLevel, serviceName string) {
+func Setup(minimumLogLevel, serviceName string) error {
 	var level zerolog.Level
 	switch minimumLogLevel {
 	case "debug":
@@ -16,10 +23,11 @@ func Setup(minimumLogLevel, serviceName string) {
 	case "error":
 		level = zerolog.ErrorLevel
 	default:
-		level = zerolog.InfoLevel
+		return fmt.Errorf("unsupported log level: %s", minimumLogLevel)
 	}
 	zerolog.SetGlobalLevel(level)
 
-	// Identify application with logger property
-	log.Logger = log.With().Str("service", "manager").Logger()
+	// Identify application with logger property, and keep the recent lines in memory
+	log.Logger = log.Output(io.MultiWriter(os.Stderr, Recent)).With().Str("service", serviceName).Logger()
+	return nil
 }
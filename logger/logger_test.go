@@ -0,0 +1,55 @@
+package logger
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// Restore the global logger and level once the test ends
+func restoreGlobalLogger(t *testing.T) {
+	logger, level := log.Logger, zerolog.GlobalLevel()
+	t.Cleanup(func() {
+		log.Logger = logger
+		zerolog.SetGlobalLevel(level)
+	})
+}
+
+func TestSetupTagsLinesWithServiceName(t *testing.T) {
+	restoreGlobalLogger(t)
+	start := time.Now()
+	if err := Setup("info", "worker-a"); err != nil {
+		t.Fatalf("failed to set up logger: %v", err)
+	}
+	log.Info().Msg("started")
+
+	entries, _ := Recent.Since(start)
+	if len(entries) == 0 {
+		t.Fatal("expected the line to be kept in the recent logs")
+	}
+	var line struct {
+		Service string
+		Message string
+	}
+	if err := json.Unmarshal([]byte(entries[len(entries)-1].Line), &line); err != nil {
+		t.Fatalf("failed to decode log line: %v", err)
+	}
+	if line.Service != "worker-a" || line.Message != "started" {
+		t.Errorf("expected the line to be tagged with the service name, got %+v", line)
+	}
+}
+
+func TestSetupRejectsUnknownLevel(t *testing.T) {
+	restoreGlobalLogger(t)
+	if err := Setup("verbose", "manager"); err == nil {
+		t.Error("expected the unknown log level to be rejected")
+	}
+	for _, level := range []string{"debug", "info", "error"} {
+		if err := Setup(level, "manager"); err != nil {
+			t.Errorf("expected log level %q to be accepted, got %v", level, err)
+		}
+	}
+}
@@ -1,8 +1,13 @@
 package manager
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"orchestrator/httpapi"
+	"orchestrator/logger"
+	"sync"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/rs/zerolog/log"
@@ -14,24 +19,89 @@ type Api struct {
 	Port    int
 	Manager *Manager
 	Router  *chi.Mux
+	Server  httpapi.ServerConfig // Timeouts and body size limit of the HTTP server
+
+	mu       sync.Mutex
+	server   *http.Server
+	shutdown *clusterShutdown // Set once a cluster shutdown was requested, guarded by mu
+}
+
+// Cluster shutdown requested through the API, shared by the repeated requests
+type clusterShutdown struct {
+	done   chan struct{} // Closed once all the tasks stops are done
+	result ShutdownResponse
 }
 
-// Start the manager API server
+// Start the manager API server, it returns once the server is stopped
 func (a *Api) StartRouter() {
 	a.initRouter()
-	if err := http.ListenAndServe(fmt.Sprintf("%s:%d", a.Address, a.Port), a.Router); err != nil {
+	a.mu.Lock()
+	a.server = httpapi.NewServer(fmt.Sprintf("%s:%d", a.Address, a.Port), a.Router, a.Server)
+	server := a.server
+	a.mu.Unlock()
+
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Err(err).Msg("api server error")
 	}
 }
 
+// Stop accepting requests and wait for the in-flight ones until the context is done
+func (a *Api) Shutdown(ctx context.Context) error {
+	a.mu.Lock()
+	server := a.server
+	a.mu.Unlock()
+	if server == nil {
+		return nil
+	}
+	return server.Shutdown(ctx)
+}
+
 func (a *Api) initRouter() {
 	a.Router = chi.NewRouter()
 	a.Router.Route("/tasks", func(r chi.Router) {
 		r.Post("/", a.startTaskHandler)
 		r.Delete("/{taskId}", a.stopTaskHandler)
 		r.Get("/", a.getTasksHandler)
+		r.Get("/{taskId}", a.getTaskHandler)
+		r.Post("/{taskId}/restart", a.restartTaskHandler)
+		r.Put("/{name}/scale", a.scaleTaskHandler)
+		r.Patch("/{taskId}/restart-policy", a.updateRestartPolicyHandler)
+		r.Get("/{taskId}/events", a.streamTaskEventsHandler)
+		r.Get("/{taskId}/logs", a.getTaskLogsHandler)
+		r.Get("/{taskId}/stats", a.getTaskStatsHandler)
+	})
+	a.Router.Route("/groups", func(r chi.Router) {
+		r.Post("/", a.deployGroupHandler)
+		r.Get("/", a.getGroupsHandler)
+		r.Get("/{groupId}", a.getGroupHandler)
+		r.Delete("/{groupId}", a.stopGroupHandler)
 	})
 	a.Router.Route("/nodes", func(r chi.Router) {
 		r.Get("/", a.getNodesHandler)
+		r.Get("/{nodeName}/logs", a.getNodeLogsHandler)
+		r.Post("/{nodeName}/drain", a.drainNodeHandler)
+		r.Delete("/{nodeName}", a.removeNodeHandler)
+	})
+	a.Router.Method(http.MethodGet, "/logs", logger.Recent)
+	a.Router.Route("/events", func(r chi.Router) {
+		r.Get("/", a.getEventsHandler)
+	})
+	a.Router.Route("/snapshots", func(r chi.Router) {
+		r.Post("/", a.writeSnapshotHandler)
+	})
+	a.Router.Route("/metrics", func(r chi.Router) {
+		r.Get("/", a.getMetricsHandler)
+		r.Method(http.MethodGet, "/prometheus", a.Manager.Prometheus.Handler())
+	})
+	a.Router.Route("/admin", func(r chi.Router) {
+		r.Post("/shutdown", a.shutdownHandler)
+		r.Post("/rebuild-assignments", a.rebuildAssignmentsHandler)
+		r.Get("/snapshot", a.getStoreSnapshotHandler)
+		r.Post("/replay-events", a.replayEventsHandler)
+	})
+	a.Router.Get("/health", a.healthHandler)
+	a.Router.Get("/ready", a.readyHandler)
+	a.Router.Route("/debug", func(r chi.Router) {
+		r.Get("/stats", a.getDebugStatsHandler)
 	})
 }
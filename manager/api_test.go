@@ -0,0 +1,165 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"orchestrator/task"
+)
+
+// Get a port which is free at the time of the call
+func freePort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to find a free port: %v", err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func TestApiStartsAndShutsDownCleanly(t *testing.T) {
+	m := newTestManager(t)
+	api := &Api{Address: "127.0.0.1", Port: freePort(t), Manager: m}
+	stopped := make(chan struct{})
+	go func() {
+		api.StartRouter()
+		close(stopped)
+	}()
+
+	url := fmt.Sprintf("http://127.0.0.1:%d/tasks", api.Port)
+	waitFor(t, "the api server to listen", func() bool {
+		resp, err := http.Get(url)
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusOK
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := api.Shutdown(ctx); err != nil {
+		t.Fatalf("failed to shut down the api server: %v", err)
+	}
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the api server to stop")
+	}
+	if _, err := http.Get(url); err == nil {
+		t.Error("expected the api server to refuse requests once shut down")
+	}
+}
+
+func TestLoopsReturnWhenContextCancelled(t *testing.T) {
+	m := newTestManager(t)
+	m.Config.EventRetention = time.Hour
+	m.Config.SnapshotInterval = time.Hour
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan struct{})
+	loops := []func(context.Context){m.ProcessTasks, m.UpdateTasks, m.CheckTasksHealth, m.CheckNodesStats, m.CleanupEvents, m.SnapshotState}
+	for _, loop := range loops {
+		go func(loop func(context.Context)) {
+			loop(ctx)
+			done <- struct{}{}
+		}(loop)
+	}
+	cancel()
+	for range loops {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the loops to return")
+		}
+	}
+}
+
+func TestRouterWiresEveryRoute(t *testing.T) {
+	fw, removed := newFakeWorker(t), newFakeWorker(t)
+	m := newTestManager(t, fw, removed)
+	m.Config.DataDir = t.TempDir()
+	api := newTestApi(m)
+	running := task.Task{Id: uuid.New(), Name: "web", Namespace: "default", Image: "nginx", State: task.Running}
+	m.TaskDb.Put(running.Id, running)
+	m.assignTask(running.Id, fw.addr())
+	completed := task.Task{Id: uuid.New(), Name: "batch", Namespace: "default", Image: "busybox", State: task.Completed}
+	m.TaskDb.Put(completed.Id, completed)
+	group := task.TaskGroup{Id: uuid.New(), Name: "shop", State: task.GroupDeployed}
+	m.GroupDb.Put(group.Id, group)
+	event := newTaskEvent("web")
+	m.EventDb.Put(event.Id, event)
+	unknownId := uuid.New()
+
+	routes := []struct {
+		method  string
+		pattern string // Route registered on the router
+		url     string
+		body    any
+		status  int
+		content string // Part of the response body written by the route handler
+	}{
+		{"POST", "/tasks/", "/tasks", map[string]any{}, http.StatusBadRequest, "invalid task"},
+		{"GET", "/tasks/", "/tasks", nil, http.StatusOK, `"Name":"batch"`},
+		{"GET", "/tasks/{taskId}", "/tasks/" + running.Id.String(), nil, http.StatusOK, `"Name":"web"`},
+		{"POST", "/tasks/{taskId}/restart", "/tasks/" + completed.Id.String() + "/restart", nil, http.StatusConflict, "can't be restarted"},
+		{"PUT", "/tasks/{name}/scale", "/tasks/web/scale?namespace=default", map[string]any{"Replicas": 2}, http.StatusNotFound, "no replicated task"},
+		{"PATCH", "/tasks/{taskId}/restart-policy", "/tasks/" + running.Id.String() + "/restart-policy", map[string]any{"RestartPolicy": "bogus"}, http.StatusBadRequest, "invalid restart policy"},
+		{"GET", "/tasks/{taskId}/events", "/tasks/" + completed.Id.String() + "/events", nil, http.StatusOK, "data: {"},
+		{"GET", "/tasks/{taskId}/logs", "/tasks/" + running.Id.String() + "/logs", nil, http.StatusOK, "logs of " + running.Id.String()},
+		{"GET", "/tasks/{taskId}/stats", "/tasks/" + running.Id.String() + "/stats", nil, http.StatusOK, `"CpuPercent"`},
+		{"DELETE", "/tasks/{taskId}", "/tasks/" + unknownId.String(), nil, http.StatusNotFound, "task " + unknownId.String() + " not found"},
+		{"POST", "/groups/", "/groups", map[string]any{"Name": "empty"}, http.StatusBadRequest, "invalid group"},
+		{"GET", "/groups/", "/groups", nil, http.StatusOK, `"Name":"shop"`},
+		{"GET", "/groups/{groupId}", "/groups/" + group.Id.String(), nil, http.StatusOK, `"Name":"shop"`},
+		{"DELETE", "/groups/{groupId}", "/groups/" + unknownId.String(), nil, http.StatusNotFound, "group " + unknownId.String() + " not found"},
+		{"GET", "/nodes/", "/nodes", nil, http.StatusOK, `"Role":"worker"`},
+		{"GET", "/nodes/{nodeName}/logs", "/nodes/" + fw.addr() + "/logs", nil, http.StatusOK, ""},
+		{"POST", "/nodes/{nodeName}/drain", "/nodes/unknown/drain", nil, http.StatusNotFound, "node unknown not found"},
+		{"DELETE", "/nodes/{nodeName}", "/nodes/" + removed.addr(), nil, http.StatusOK, `"MigratedTasks":0`},
+		{"GET", "/logs", "/logs?since=yesterday", nil, http.StatusBadRequest, "invalid since parameter"},
+		{"GET", "/events/", "/events", nil, http.StatusOK, event.Id.String()},
+		{"POST", "/snapshots/", "/snapshots", nil, http.StatusCreated, `"Path"`},
+		{"GET", "/metrics/", "/metrics", nil, http.StatusOK, "scheduler_decision_duration"},
+		{"GET", "/metrics/prometheus", "/metrics/prometheus", nil, http.StatusOK, "# HELP"},
+		{"POST", "/admin/rebuild-assignments", "/admin/rebuild-assignments", nil, http.StatusOK, `"Unreachable"`},
+		{"GET", "/admin/snapshot", "/admin/snapshot?store=unknown", nil, http.StatusBadRequest, "unknown store"},
+		{"POST", "/admin/replay-events", "/admin/replay-events", nil, http.StatusOK, `"Restored"`},
+		{"GET", "/health", "/health", nil, http.StatusOK, `"Status":"ok"`},
+		{"GET", "/ready", "/ready", nil, http.StatusOK, `"Status":"ready"`},
+		{"GET", "/debug/stats", "/debug/stats", nil, http.StatusOK, `"Goroutines"`},
+		// The cluster shutdown closes the manager, it is requested last
+		{"POST", "/admin/shutdown", "/admin/shutdown", nil, http.StatusOK, `"StoppedTasks"`},
+	}
+
+	// Every registered route is expected, so that the new ones are added to the table
+	expected := make(map[string]bool, len(routes))
+	for _, r := range routes {
+		expected[r.method+" "+r.pattern] = true
+	}
+	err := chi.Walk(api.Router, func(method string, pattern string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		if !expected[method+" "+pattern] {
+			t.Errorf("route %s %s is missing from the expected routes", method, pattern)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to walk routes: %v", err)
+	}
+
+	for _, r := range routes {
+		rec := api.serve(t, r.method, r.url, r.body)
+		if rec.Code != r.status || !strings.Contains(rec.Body.String(), r.content) {
+			t.Errorf("expected %s %s to respond %d with %q, got %d: %s", r.method, r.url, r.status, r.content, rec.Code, rec.Body.String())
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"orchestrator/node"
+	"orchestrator/store"
+	"orchestrator/task"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// Assignment of a task to a worker, persisted so that the manager still knows the workers of the tasks once restarted
+type Assignment struct {
+	TaskId uuid.UUID
+	Worker string
+}
+
+// Get the worker the given task is assigned to
+func (m *Manager) taskWorker(taskId uuid.UUID) (string, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	worker, found := m.TaskWorkerMap[taskId]
+	return worker, found
+}
+
+// Get a copy of the ids of the tasks assigned to the given worker
+func (m *Manager) workerTaskIds(worker string) []uuid.UUID {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]uuid.UUID(nil), m.WorkerTaskMap[worker]...)
+}
+
+// Assign a task to a worker
+func (m *Manager) assignTask(taskId uuid.UUID, worker string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.addAssignment(taskId, worker)
+}
+
+// Record the assignment of a task to a worker, the caller must hold the lock
+func (m *Manager) addAssignment(taskId uuid.UUID, worker string) {
+	m.WorkerTaskMap[worker] = append(m.WorkerTaskMap[worker], taskId)
+	m.TaskWorkerMap[taskId] = worker
+	if err := m.AssignmentDb.Put(taskId, Assignment{TaskId: taskId, Worker: worker}); err != nil {
+		log.Err(err).Str("task-id", taskId.String()).Str("worker", worker).Msg("failed to store task assignment")
+	}
+}
+
+// Remove the assignment of a task to its worker
+func (m *Manager) unassignTask(taskId uuid.UUID) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.removeAssignment(taskId)
+}
+
+// Remove the assignment of a task to its worker, the caller must hold the lock
+func (m *Manager) removeAssignment(taskId uuid.UUID) {
+	worker, found := m.TaskWorkerMap[taskId]
+	if !found {
+		return
+	}
+	delete(m.TaskWorkerMap, taskId)
+	m.deleteStoredAssignment(taskId)
+
+	workerTasks := m.WorkerTaskMap[worker]
+	for i, id := range workerTasks {
+		if id == taskId {
+			m.WorkerTaskMap[worker] = append(workerTasks[:i], workerTasks[i+1:]...)
+			break
+		}
+	}
+	if len(m.WorkerTaskMap[worker]) == 0 && m.findWorkerNode(worker) == nil {
+		delete(m.WorkerTaskMap, worker)
+	}
+}
+
+// Delete the persisted assignment of a task
+func (m *Manager) deleteStoredAssignment(taskId uuid.UUID) {
+	if err := m.AssignmentDb.Delete(taskId); err != nil && !errors.Is(err, store.ErrKeyNotFound) {
+		log.Err(err).Str("task-id", taskId.String()).Msg("failed to delete task assignment")
+	}
+}
+
+// Load the persisted tasks assignments, the active tasks being reserved on their worker node
+//
+// The assignments of the tasks which are no longer stored are deleted
+func (m *Manager) restoreAssignments() error {
+	assignments, err := m.AssignmentDb.List()
+	if err != nil {
+		return fmt.Errorf("failed to list tasks assignments: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, a := range assignments {
+		t, err := m.TaskDb.Get(a.TaskId)
+		if errors.Is(err, store.ErrKeyNotFound) {
+			m.deleteStoredAssignment(a.TaskId)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get task %v: %w", a.TaskId, err)
+		}
+		m.WorkerTaskMap[a.Worker] = append(m.WorkerTaskMap[a.Worker], a.TaskId)
+		m.TaskWorkerMap[a.TaskId] = a.Worker
+		if n := m.findWorkerNode(a.Worker); n != nil && t.State != task.Completed {
+			n.TaskCount++
+			n.CpuReserved += t.Cpu
+		}
+	}
+	if len(assignments) != 0 {
+		log.Info().Int("count", len(m.TaskWorkerMap)).Msg("restored tasks assignments")
+	}
+	return nil
+}
+
+// Update the number of tasks and the CPUs reserved on a worker node, nothing is done if the node was removed
+func (m *Manager) reserveOnNode(name string, tasks int, cpu float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := m.findWorkerNode(name)
+	if n == nil {
+		return
+	}
+	n.TaskCount += tasks
+	n.CpuReserved += cpu
+}
+
+// Get a copy of the registered worker nodes list
+func (m *Manager) nodes() []*node.Node {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]*node.Node(nil), m.WorkerNodes...)
+}
+
+// Get a copy of the registered workers addresses
+func (m *Manager) workers() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]string(nil), m.Workers...)
+}
+
+// Get the registered worker node with the given name, nil if it doesn't exist
+func (m *Manager) getWorkerNode(name string) *node.Node {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.findWorkerNode(name)
+}
+
+// Get a copy of the worker node with the given name if it can receive tasks, nil if it doesn't exist, is draining
+// or offline
+//
+// The copy can be handed to the scheduler, which refreshes the stats of the nodes it scores
+func (m *Manager) availableWorkerNode(name string) *node.Node {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	n := m.findWorkerNode(name)
+	if n == nil || n.Draining || n.Status == node.Offline {
+		return nil
+	}
+	nodeCopy := *n
+	return &nodeCopy
+}
+
+// Check if the worker node with the given name is draining
+func (m *Manager) isDraining(name string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	n := m.findWorkerNode(name)
+	return n != nil && n.Draining
+}
+
+// Find a worker node by name, the caller must hold the lock
+func (m *Manager) findWorkerNode(name string) *node.Node {
+	for _, n := range m.WorkerNodes {
+		if n.Name == name {
+			return n
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package manager
+
+import (
+	"os"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"orchestrator/task"
+)
+
+// Run the test from a temporary directory, where the persisted stores write their files
+func chdirTemp(t *testing.T) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("failed to get working directory: %v", err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatalf("failed to change directory: %v", err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func newPersistedManager(t *testing.T, fw *fakeWorker) *Manager {
+	t.Helper()
+	m, err := New([]string{fw.addr()}, "roundrobin", "persisted", Config{MaxRestarts: DefaultMaxRestarts})
+	if err != nil {
+		t.Fatalf("failed to create manager: %v", err)
+	}
+	return m
+}
+
+func TestAssignmentsRestoredOnRestart(t *testing.T) {
+	chdirTemp(t)
+	fw := newFakeWorker(t)
+	m := newPersistedManager(t, fw)
+	running := task.Task{Id: uuid.New(), Name: "web", Image: "nginx", State: task.Running, Cpu: 0.5}
+	purged := task.Task{Id: uuid.New(), Name: "db", Image: "postgres", State: task.Running}
+	for _, tk := range []task.Task{running, purged} {
+		m.TaskDb.Put(tk.Id, tk)
+		m.assignTask(tk.Id, fw.addr())
+	}
+	// The task record is removed without its assignment, as if the manager stopped in between
+	m.TaskDb.Delete(purged.Id)
+	if err := m.Close(); err != nil {
+		t.Fatalf("failed to close manager: %v", err)
+	}
+
+	restarted := newPersistedManager(t, fw)
+	defer restarted.Close()
+	if worker, found := restarted.taskWorker(running.Id); !found || worker != fw.addr() {
+		t.Errorf("expected the task to be assigned to %s, got %q", fw.addr(), worker)
+	}
+	if ids := restarted.workerTaskIds(fw.addr()); len(ids) != 1 || ids[0] != running.Id {
+		t.Errorf("expected only the stored task on the worker, got %v", ids)
+	}
+	if _, err := restarted.AssignmentDb.Get(purged.Id); err == nil {
+		t.Error("expected the assignment of the missing task to be deleted")
+	}
+	n := restarted.WorkerNodes[0]
+	if n.TaskCount != 1 || n.CpuReserved != 0.5 {
+		t.Errorf("expected the task to be reserved on its node, got %d tasks and %v CPUs", n.TaskCount, n.CpuReserved)
+	}
+}
+
+func TestUnassignedTaskNotRestored(t *testing.T) {
+	chdirTemp(t)
+	fw := newFakeWorker(t)
+	m := newPersistedManager(t, fw)
+	tk := task.Task{Id: uuid.New(), Name: "web", Image: "nginx", State: task.Running}
+	m.TaskDb.Put(tk.Id, tk)
+	m.assignTask(tk.Id, fw.addr())
+	m.unassignTask(tk.Id)
+	m.Close()
+
+	restarted := newPersistedManager(t, fw)
+	defer restarted.Close()
+	if _, found := restarted.taskWorker(tk.Id); found {
+		t.Error("expected the removed assignment not to be restored")
+	}
+}
@@ -0,0 +1,109 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"orchestrator/store"
+	"orchestrator/task"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// Result of the tasks restoration from the events log
+type ReplayResponse struct {
+	Events   int         // Number of events read
+	Restored []uuid.UUID // Tasks missing from the store which were restored from their latest event, or submitted again
+}
+
+// Get the stored task events ordered by timestamp, all of them or only the ones of a task when its id isn't nil
+//
+// Events with the same timestamp are ordered by id, so that the order is stable between calls
+func (m *Manager) TaskEvents(taskId uuid.UUID) ([]task.TaskEvent, error) {
+	events := []task.TaskEvent{}
+	err := m.EventDb.ForEach(func(e task.TaskEvent) error {
+		if taskId == uuid.Nil || e.Task.Id == taskId {
+			events = append(events, e)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read task events from store: %w", err)
+	}
+	sort.Slice(events, func(i, j int) bool {
+		if !events[i].Timestamp.Equal(events[j].Timestamp) {
+			return events[i].Timestamp.Before(events[j].Timestamp)
+		}
+		return events[i].Id.String() < events[j].Id.String()
+	})
+	return events, nil
+}
+
+// Restore the tasks missing from the store from the events log, for disaster recovery
+//
+// Each missing task is rebuilt from its latest event. The completed tasks are stored as is, unless the stopped tasks
+// are purged from the store, the other ones are submitted again so that they are placed on a node, a task whose
+// name is used by another active task is left out. The stored tasks are left untouched since they are more recent than the events, their actual state being
+// reported by the workers
+func (m *Manager) ReplayEvents() (ReplayResponse, error) {
+	response := ReplayResponse{Restored: []uuid.UUID{}}
+	events, err := m.TaskEvents(uuid.Nil)
+	if err != nil {
+		return response, err
+	}
+	response.Events = len(events)
+
+	latest := make(map[uuid.UUID]task.TaskEvent)
+	for _, e := range events {
+		latest[e.Task.Id] = e
+	}
+	completed := make(map[uuid.UUID]task.Task)
+	var active []task.TaskEvent
+	for taskId, e := range latest {
+		_, err := m.TaskDb.Get(taskId)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, store.ErrKeyNotFound) {
+			return response, fmt.Errorf("failed to get task %v: %w", taskId, err)
+		}
+		t := e.Task
+		if e.State == task.Completed {
+			if m.Config.PurgeStoppedTasks {
+				// The task was deleted from the store once stopped
+				continue
+			}
+			t.State = task.Completed
+			completed[taskId] = t
+			continue
+		}
+		t.State = task.Pending
+		active = append(active, task.TaskEvent{
+			Id:        uuid.New(),
+			State:     task.Scheduled,
+			Timestamp: time.Now().UTC(),
+			Task:      t,
+		})
+	}
+
+	if err := m.TaskDb.PutBatch(completed); err != nil {
+		return response, fmt.Errorf("failed to store restored tasks: %w", err)
+	}
+	for taskId := range completed {
+		response.Restored = append(response.Restored, taskId)
+	}
+	for _, tEvent := range active {
+		if err := m.SubmitTask(tEvent); err != nil {
+			if errors.Is(err, ErrNameConflict) || errors.Is(err, ErrTaskSubmitted) {
+				log.Warn().Err(err).Str("task-id", tEvent.Task.Id.String()).Msg("task not restored from events log")
+				continue
+			}
+			return response, fmt.Errorf("failed to submit restored task %v: %w", tEvent.Task.Id, err)
+		}
+		response.Restored = append(response.Restored, tEvent.Task.Id)
+	}
+	log.Info().Int("events", response.Events).Int("restored", len(response.Restored)).Msg("tasks restored from events log")
+	return response, nil
+}
@@ -0,0 +1,198 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"orchestrator/store"
+	"orchestrator/task"
+)
+
+// Store an event of the given task, which happened the given duration ago
+func storeEvent(t *testing.T, m *Manager, taskId uuid.UUID, age time.Duration) task.TaskEvent {
+	t.Helper()
+	e := task.TaskEvent{
+		Id:        uuid.New(),
+		State:     task.Scheduled,
+		Timestamp: time.Now().Add(-age),
+		Task:      task.Task{Id: taskId},
+	}
+	if err := m.EventDb.Put(e.Id, e); err != nil {
+		t.Fatalf("failed to store event: %v", err)
+	}
+	return e
+}
+
+func TestCleanupEventsPurgesExpiredEvents(t *testing.T) {
+	m := newTestManager(t)
+	m.Config.EventRetention = 50 * time.Millisecond
+
+	// Events of a deleted task, the latest one is kept even if expired
+	deletedTask := uuid.New()
+	storeEvent(t, m, deletedTask, time.Hour)
+	deletedLatest := storeEvent(t, m, deletedTask, time.Minute)
+	// Events of a running task
+	runningTask := uuid.New()
+	storeEvent(t, m, runningTask, time.Hour)
+	runningLatest := storeEvent(t, m, runningTask, 0)
+
+	m.cleanupEvents()
+
+	events, err := m.EventDb.List()
+	if err != nil {
+		t.Fatalf("failed to list events: %v", err)
+	}
+	kept := make(map[uuid.UUID]bool)
+	for _, e := range events {
+		kept[e.Id] = true
+	}
+	if len(events) != 2 || !kept[deletedLatest.Id] || !kept[runningLatest.Id] {
+		t.Errorf("expected only the latest event of each task to remain, got %d events", len(events))
+	}
+
+	// The recent event expires with the retention
+	time.Sleep(100 * time.Millisecond)
+	m.cleanupEvents()
+	if count, _ := m.EventDb.Count(); count != 2 {
+		t.Errorf("expected the latest event of each task to be kept once expired, got %d events", count)
+	}
+}
+
+func TestCleanupEventsKeepsRecentEvents(t *testing.T) {
+	m := newTestManager(t)
+	m.Config.EventRetention = time.Hour
+	taskId := uuid.New()
+	for i := 0; i < 3; i++ {
+		storeEvent(t, m, taskId, time.Duration(i)*time.Minute)
+	}
+
+	m.cleanupEvents()
+
+	if count, _ := m.EventDb.Count(); count != 3 {
+		t.Errorf("expected the 3 recent events to remain, got %d", count)
+	}
+}
+
+// Store a start and a stop event for each of the given tasks, a second apart
+func storeEvents(t *testing.T, m *Manager, tasks ...task.Task) {
+	t.Helper()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, eventState := range []task.State{task.Scheduled, task.Completed} {
+		for _, tk := range tasks {
+			e := task.TaskEvent{Id: uuid.New(), State: eventState, Timestamp: start.Add(time.Duration(i) * time.Second), Task: tk}
+			if err := m.EventDb.Put(e.Id, e); err != nil {
+				t.Fatalf("failed to store event: %v", err)
+			}
+		}
+	}
+}
+
+func getEvents(t *testing.T, api *Api, url string) []task.TaskEvent {
+	t.Helper()
+	rec := api.serve(t, http.MethodGet, url, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body)
+	}
+	var events []task.TaskEvent
+	if err := json.NewDecoder(rec.Body).Decode(&events); err != nil {
+		t.Fatalf("failed to decode events: %v", err)
+	}
+	return events
+}
+
+func TestGetEvents(t *testing.T) {
+	m := newTestManager(t)
+	api := newTestApi(m)
+	web, db := newTaskEvent("web").Task, newTaskEvent("db").Task
+	storeEvents(t, m, web, db)
+
+	events := getEvents(t, api, "/events")
+	if len(events) != 4 {
+		t.Fatalf("expected 4 events, got %d", len(events))
+	}
+	for i := 1; i < len(events); i++ {
+		if events[i].Timestamp.Before(events[i-1].Timestamp) {
+			t.Errorf("events aren't ordered by timestamp")
+		}
+	}
+
+	events = getEvents(t, api, "/events?taskId="+web.Id.String())
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events of task web, got %d", len(events))
+	}
+	if events[0].Task.Id != web.Id || events[0].State != task.Scheduled || events[1].State != task.Completed {
+		t.Errorf("unexpected events of task web: %+v", events)
+	}
+
+	if rec := api.serve(t, http.MethodGet, "/events?taskId=web", nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for an invalid task id, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestReplayEventsSchedulesActiveTasks(t *testing.T) {
+	fw := newFakeWorker(t)
+	m := newTestManager(t, fw)
+	api := newTestApi(m)
+
+	stopped := newTaskEvent("batch").Task
+	storeEvents(t, m, stopped)
+	running := newTaskEvent("web")
+	running.Timestamp = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	m.EventDb.Put(running.Id, running)
+
+	rec := api.serve(t, http.MethodPost, "/admin/replay-events", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected replay status %d: %s", rec.Code, rec.Body)
+	}
+	var response ReplayResponse
+	if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+		t.Fatalf("failed to decode replay response: %v", err)
+	}
+	if response.Events != 3 || len(response.Restored) != 2 {
+		t.Fatalf("expected 2 tasks restored from 3 events, got %+v", response)
+	}
+
+	if restored, err := m.TaskDb.Get(stopped.Id); err != nil || restored.State != task.Completed {
+		t.Errorf("expected the stopped task to be restored as completed, got %v (%v)", restored.State, err)
+	}
+	if m.Pending.Len() != 1 {
+		t.Fatalf("expected the active task to be queued, got %d queued events", m.Pending.Len())
+	}
+	queued, _ := m.Pending.Pop()
+	m.sendWork(queued)
+	events := fw.receivedEvents()
+	if len(events) != 1 || events[0].Task.Id != running.Task.Id {
+		t.Fatalf("expected the active task to be started again, got %d started tasks", len(events))
+	}
+	if worker, found := m.taskWorker(running.Task.Id); !found || worker != fw.addr() {
+		t.Errorf("expected the active task to be assigned to the worker, got %q", worker)
+	}
+}
+
+func TestReplayEventsSkipsPurgedTasks(t *testing.T) {
+	fw := newFakeWorker(t)
+	m := newTestManager(t, fw)
+	m.Config.PurgeStoppedTasks = true
+	tk := storeAssignedTask(t, m, fw.addr())
+	m.AddTask(task.TaskEvent{Id: uuid.New(), State: task.Completed, Timestamp: time.Now().UTC(), Task: tk})
+	processPending(m)
+	if _, err := m.TaskDb.Get(tk.Id); !errors.Is(err, store.ErrKeyNotFound) {
+		t.Fatalf("expected the stopped task to be purged, got %v", err)
+	}
+
+	response, err := m.ReplayEvents()
+	if err != nil {
+		t.Fatalf("failed to replay events: %v", err)
+	}
+	if response.Events != 1 || len(response.Restored) != 0 {
+		t.Errorf("expected the purged task not to be restored from its stop event, got %+v", response)
+	}
+	if _, err := m.TaskDb.Get(tk.Id); !errors.Is(err, store.ErrKeyNotFound) {
+		t.Errorf("expected the purged task to stay deleted, got %v", err)
+	}
+}
@@ -0,0 +1,177 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"orchestrator/task"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// Task group representation returned by the API, with the current state of its tasks
+type GroupResponse struct {
+	task.TaskGroup
+	Tasks []task.Task
+}
+
+var ErrGroupRolledBack = errors.New("group deployment rolled back")
+
+// Schedule all the given tasks together
+//
+// The tasks are directly submitted to the workers instead of going through the pending queue. Their ids and names
+// are first held as the ones of the queued submissions, the group is rejected when one of them is already used. If
+// one of the tasks can't be scheduled, the tasks already started are stopped and the group is marked as rolled back
+func (m *Manager) DeployGroup(name string, tasks []task.Task) (task.TaskGroup, error) {
+	tEvents := make([]task.TaskEvent, 0, len(tasks))
+	for _, t := range tasks {
+		tEvents = append(tEvents, task.TaskEvent{
+			Id:        uuid.New(),
+			State:     task.Scheduled,
+			Timestamp: time.Now().UTC(),
+			Task:      t,
+		})
+	}
+	m.submitMu.Lock()
+	err := m.reserveSubmissions(tEvents)
+	m.submitMu.Unlock()
+	if err != nil {
+		return task.TaskGroup{}, err
+	}
+	// The scheduled tasks are assigned before their submission is released, so that they can't be submitted again
+	defer func() {
+		for _, tEvent := range tEvents {
+			m.releaseSubmission(tEvent)
+		}
+	}()
+
+	group := task.TaskGroup{
+		Id:      uuid.New(),
+		Name:    name,
+		TaskIds: make([]uuid.UUID, 0, len(tasks)),
+		State:   task.GroupDeploying,
+	}
+	for _, t := range tasks {
+		group.TaskIds = append(group.TaskIds, t.Id)
+	}
+	if err := m.GroupDb.Put(group.Id, group); err != nil {
+		return group, fmt.Errorf("failed to store group: %w", err)
+	}
+
+	groupLogger := log.With().
+		Str("group-id", group.Id.String()).
+		Logger()
+
+	started := make([]uuid.UUID, 0, len(tasks))
+	for _, tEvent := range tEvents {
+		t := tEvent.Task
+		if err := m.EventDb.Put(tEvent.Id, tEvent.WithoutRegistryAuth()); err != nil {
+			groupLogger.Err(err).Msg("failed to store group task event")
+		}
+
+		if err := m.scheduleTask(tEvent); err != nil {
+			groupLogger.Err(err).Str("task-id", t.Id.String()).Msg("failed to schedule group task, rolling back")
+			m.failGroupTask(t)
+			m.rollbackGroup(started)
+
+			group.State = task.GroupRolledBack
+			group.Error = fmt.Sprintf("task %s: %v", t.Name, err)
+			if err := m.GroupDb.Put(group.Id, group); err != nil {
+				groupLogger.Err(err).Msg("failed to update group")
+			}
+			return group, fmt.Errorf("%w, %s", ErrGroupRolledBack, group.Error)
+		}
+		started = append(started, t.Id)
+	}
+
+	group.State = task.GroupDeployed
+	if err := m.GroupDb.Put(group.Id, group); err != nil {
+		return group, fmt.Errorf("failed to update group: %w", err)
+	}
+	groupLogger.Info().Int("tasks", len(started)).Msg("group deployed")
+	return group, nil
+}
+
+// Request the stop of all the tasks of a group
+func (m *Manager) StopGroup(groupId uuid.UUID) (task.TaskGroup, error) {
+	group, err := m.GroupDb.Get(groupId)
+	if err != nil {
+		return group, err
+	}
+
+	for _, taskId := range group.TaskIds {
+		t, err := m.TaskDb.Get(taskId)
+		if err != nil {
+			log.Err(err).Str("task-id", taskId.String()).Msg("failed to retrieve group task from store")
+			continue
+		}
+		if !task.ValidStateTransition(t.State, task.Completed) {
+			continue
+		}
+		t.State = task.Completed
+		m.AddTask(task.TaskEvent{
+			Id:        uuid.New(),
+			State:     task.Completed,
+			Timestamp: time.Now().UTC(),
+			Task:      t,
+		})
+	}
+
+	group.State = task.GroupStopped
+	if err := m.GroupDb.Put(group.Id, group); err != nil {
+		return group, fmt.Errorf("failed to update group: %w", err)
+	}
+	return group, nil
+}
+
+// Retrieve a group along with its tasks
+func (m *Manager) GetGroup(groupId uuid.UUID) (GroupResponse, error) {
+	group, err := m.GroupDb.Get(groupId)
+	if err != nil {
+		return GroupResponse{}, err
+	}
+
+	response := GroupResponse{
+		TaskGroup: group,
+		Tasks:     make([]task.Task, 0, len(group.TaskIds)),
+	}
+	for _, taskId := range group.TaskIds {
+		t, err := m.TaskDb.Get(taskId)
+		if err != nil {
+			// The task may not have been stored if the deployment was rolled back before reaching it
+			continue
+		}
+		response.Tasks = append(response.Tasks, t)
+	}
+	return response, nil
+}
+
+// Stop the tasks started before a group deployment failure
+//
+// The tasks were only just sent to their workers, which may not know them yet: their stops are retried in the
+// background until the workers confirm them
+func (m *Manager) rollbackGroup(started []uuid.UUID) {
+	for _, taskId := range started {
+		worker, found := m.taskWorker(taskId)
+		if !found {
+			continue
+		}
+		go m.stopStartingTask(taskId, worker)
+	}
+}
+
+// Release the task that couldn't be scheduled and mark it as failed if it was stored
+func (m *Manager) failGroupTask(t task.Task) {
+	m.unassignTask(t.Id)
+
+	stored, err := m.TaskDb.Get(t.Id)
+	if err != nil {
+		return
+	}
+	stored.State = task.Failed
+	stored.FinishTime = time.Now().UTC()
+	if err := m.TaskDb.Put(stored.Id, stored); err != nil {
+		log.Err(err).Str("task-id", t.Id.String()).Msg("failed to update task")
+	}
+}
@@ -0,0 +1,125 @@
+package manager
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"orchestrator/task"
+)
+
+func TestDeployGroupRollbackStopsStartingTasks(t *testing.T) {
+	fw := newFakeWorker(t)
+	fw.startStatus = func(tEvent task.TaskEvent) int {
+		if tEvent.Task.Name == "db" {
+			return http.StatusInternalServerError
+		}
+		return http.StatusCreated
+	}
+	// The worker doesn't know the started task yet when the first stop is requested
+	fw.stopFailures = []int{http.StatusNotFound}
+	m := newTestManager(t, fw)
+
+	web := newTaskEvent("web").Task
+	db := newTaskEvent("db").Task
+	cache := newTaskEvent("cache").Task
+	group, err := m.DeployGroup("shop", []task.Task{web, db, cache})
+	if err == nil {
+		t.Fatal("expected the group deployment to fail")
+	}
+	if group.State != task.GroupRolledBack {
+		t.Errorf("expected group state %v, got %v", task.GroupRolledBack, group.State)
+	}
+	if _, found := m.TaskWorkerMap[db.Id]; found {
+		t.Error("expected the task which failed to start to be unassigned")
+	}
+	if stored, _ := m.TaskDb.Get(db.Id); stored.State != task.Failed {
+		t.Errorf("expected the task which failed to start to be failed, got state %v", stored.State)
+	}
+	if _, err := m.TaskDb.Get(cache.Id); err == nil {
+		t.Error("expected the task after the failure not to be scheduled")
+	}
+
+	waitFor(t, "the rolled back task stop", func() bool {
+		return slices.Contains(fw.receivedStops(), web.Id)
+	})
+	waitFor(t, "the rolled back task completion", func() bool {
+		stored, err := m.TaskDb.Get(web.Id)
+		return err == nil && stored.State == task.Completed
+	})
+}
+
+func TestDeployGroup(t *testing.T) {
+	fw := newFakeWorker(t)
+	m := newTestManager(t, fw)
+
+	group, err := m.DeployGroup("shop", []task.Task{newTaskEvent("web").Task, newTaskEvent("db").Task})
+	if err != nil {
+		t.Fatalf("failed to deploy group: %v", err)
+	}
+	if group.State != task.GroupDeployed {
+		t.Errorf("expected group state %v, got %v", task.GroupDeployed, group.State)
+	}
+	if events := fw.receivedEvents(); len(events) != 2 {
+		t.Errorf("expected the 2 tasks to be sent to the worker, got %d", len(events))
+	}
+	if count := m.WorkerNodes[0].TaskCount; count != 2 {
+		t.Errorf("expected the node task count to be 2, got %d", count)
+	}
+}
+
+func TestDeployGroupRejectsSubmittedTasks(t *testing.T) {
+	fw := newFakeWorker(t)
+	m := newTestManager(t, fw)
+	queued := newTaskEvent("web")
+	if err := m.SubmitTask(queued); err != nil {
+		t.Fatalf("failed to submit task: %v", err)
+	}
+	assigned := storeAssignedTask(t, m, fw.addr())
+
+	for _, tasks := range [][]task.Task{
+		{newTaskEvent("db").Task, queued.Task},
+		{newTaskEvent("db").Task, assigned},
+	} {
+		if _, err := m.DeployGroup("shop", tasks); !errors.Is(err, ErrTaskSubmitted) {
+			t.Errorf("expected the group with an already submitted task to be rejected, got %v", err)
+		}
+	}
+	// A group task named as a queued task is rejected as well
+	renamed := newTaskEvent("web").Task
+	if _, err := m.DeployGroup("shop", []task.Task{renamed}); !errors.Is(err, ErrNameConflict) {
+		t.Errorf("expected the group with a conflicting task name to be rejected, got %v", err)
+	}
+
+	if events := fw.receivedEvents(); len(events) != 0 {
+		t.Errorf("expected no group task to be started, got %v", events)
+	}
+	if ids := m.workerTaskIds(fw.addr()); len(ids) != 1 || ids[0] != assigned.Id {
+		t.Errorf("expected only the assigned task on the worker, got %v", ids)
+	}
+	if groups, _ := m.GroupDb.List(); len(groups) != 0 {
+		t.Errorf("expected the rejected groups not to be stored, got %v", groups)
+	}
+	// The ids held by the rejected groups are released
+	if err := m.SubmitTask(task.TaskEvent{Id: uuid.New(), State: task.Scheduled, Task: newTaskEvent("db").Task}); err != nil {
+		t.Errorf("expected a new task to be submitted, got %v", err)
+	}
+}
+
+func TestDeployGroupReleasesSubmissions(t *testing.T) {
+	m := newTestManager(t, newFakeWorker(t))
+	web := newTaskEvent("web").Task
+	if _, err := m.DeployGroup("shop", []task.Task{web}); err != nil {
+		t.Fatalf("failed to deploy group: %v", err)
+	}
+	if submitted := m.submittedTasks(); len(submitted) != 0 {
+		t.Errorf("expected the group tasks submissions to be released, got %v", submitted)
+	}
+	// The deployed task is assigned, it can't be submitted again
+	if err := m.SubmitTask(task.TaskEvent{Id: uuid.New(), State: task.Scheduled, Task: web}); !errors.Is(err, ErrTaskSubmitted) {
+		t.Errorf("expected the deployed task submission to be rejected, got %v", err)
+	}
+}
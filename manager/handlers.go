@@ -1,12 +1,19 @@
 package manager
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	neturl "net/url"
 	"orchestrator/store"
 	"orchestrator/task"
+	"orchestrator/worker"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -14,44 +21,155 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
+// Content type of newline delimited JSON, one value per line
+const ndjsonContentType = "application/x-ndjson"
+
 type ErrResponse struct {
 	HTTPStatusCode int
 	Message        string
+	Violations     []task.Violation // Problems found on the submitted specification, when it is invalid
 }
 
-func (a *Api) startTaskHandler(w http.ResponseWriter, r *http.Request) {
-	data := json.NewDecoder(r.Body)
+// Write an error response with a JSON body describing the error
+func writeErrResponse(w http.ResponseWriter, statusCode int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(ErrResponse{
+		Message:        message,
+		HTTPStatusCode: statusCode,
+	})
+}
+
+// Write a bad request response listing the problems found on an invalid specification
+func writeValidationError(w http.ResponseWriter, message string, err error) {
+	response := ErrResponse{
+		Message:        fmt.Sprintf("%s: %v", message, err),
+		HTTPStatusCode: http.StatusBadRequest,
+	}
+	var validationErr *task.ValidationError
+	if errors.As(err, &validationErr) {
+		response.Violations = validationErr.Violations
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(response)
+}
+
+// Restart settings update request, only the provided fields are changed
+type RestartPolicyRequest struct {
+	RestartPolicy *string
+	MaxRestarts   *int
+}
 
-	tEvent := task.TaskEvent{}
-	err := data.Decode(&tEvent)
+// Replicated task scaling request
+type ScaleRequest struct {
+	Replicas int
+}
+
+// Task group deployment request, the tasks are scheduled together or not at all
+type GroupRequest struct {
+	Name          string
+	Tasks         []task.Task
+	RegistryAuths map[uuid.UUID]task.RegistryAuth // Credentials of the tasks images registries by task id, never stored
+}
+
+// Written snapshot file information
+type SnapshotResponse struct {
+	Path string
+}
+
+// Cluster shutdown result
+type ShutdownResponse struct {
+	StoppedTasks   int
+	RemainingTasks int // Tasks whose stop wasn't confirmed before the shutdown timeout
+}
+
+// Liveness or readiness probe result
+type HealthResponse struct {
+	Status string
+}
+
+// Process diagnostics information
+type DebugStats struct {
+	Goroutines    int
+	PendingTasks  int
+	StoredTasks   int
+	StoredEvents  int
+	UptimeSeconds float64
+}
+
+func (a *Api) startTaskHandler(w http.ResponseWriter, r *http.Request) {
+	tEvent, err := worker.DecodeRequest[task.TaskEvent](w, r)
 	if err != nil {
-		log.Err(err).Msg("start task handler error: failed to unmarshall request body")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(ErrResponse{
-			Message:        fmt.Sprintf("error unmarshalling request body: %v", err),
-			HTTPStatusCode: http.StatusBadRequest,
-		})
 		return
 	}
+	tEvent.ReceiveRegistryAuth()
+	if err := tEvent.Task.Validate(); err != nil {
+		log.Err(err).Msg("start task handler error: invalid task")
+		writeValidationError(w, "invalid task", err)
+		return
+	}
+	if err := ValidateSubmission(tEvent); err != nil {
+		log.Debug().Err(err).Str("task-id", tEvent.Task.Id.String()).Msg("task submission rejected")
+		writeErrResponse(w, http.StatusConflict, err.Error())
+		return
+	}
+	replicas := ExpandReplicas(tEvent)
+	for i := range replicas {
+		if err := a.Manager.CheckTaskName(replicas[i].Task); err != nil {
+			writeTaskNameError(w, err)
+			return
+		}
+		if err := a.Manager.CheckHostPorts(replicas[i].Task); err != nil {
+			log.Debug().Err(err).Str("task-id", replicas[i].Task.Id.String()).Msg("task submission rejected")
+			writeErrResponse(w, http.StatusConflict, err.Error())
+			return
+		}
+		a.Manager.ApplyDefaultResources(&replicas[i].Task)
+	}
 
-	a.Manager.AddTask(tEvent)
-	log.Info().Str("task-id", tEvent.Task.Id.String()).Msg("task queued for creation")
+	// The replicas are queued together, none of them is scheduled when one is rejected
+	if err := a.Manager.SubmitTasks(replicas); err != nil {
+		if !errors.Is(err, ErrTaskSubmitted) {
+			writeTaskNameError(w, err)
+			return
+		}
+		log.Debug().Err(err).Str("task-id", tEvent.Task.Id.String()).Msg("task submission rejected")
+		writeErrResponse(w, http.StatusConflict, err.Error())
+		return
+	}
+	for _, replica := range replicas {
+		log.Info().Str("task-id", replica.Task.Id.String()).Msg("task queued for creation")
+	}
+	// The first replica keeps the id of the submitted task
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(tEvent.Task)
+	json.NewEncoder(w).Encode(replicas[0].Task)
+}
+
+// Write the response of a failed task name uniqueness check
+func writeTaskNameError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrNameConflict) {
+		log.Debug().Err(err).Msg("task name conflict")
+		writeErrResponse(w, http.StatusConflict, err.Error())
+	} else {
+		log.Err(err).Msg("failed to check task name")
+		writeErrResponse(w, http.StatusInternalServerError, "failed to check task name")
+	}
 }
 
 func (a *Api) stopTaskHandler(w http.ResponseWriter, r *http.Request) {
 	taskId := chi.URLParam(r, "taskId")
 	if taskId == "" {
 		log.Debug().Msg("taskId parameter is missing")
-		w.WriteHeader(http.StatusBadRequest)
+		writeErrResponse(w, http.StatusBadRequest, "task id is missing")
 		return
 	}
 
 	taskUuid, err := uuid.Parse(taskId)
 	if err != nil {
 		log.Debug().Msg("taskId parameter isn't a valid uuid")
-		w.WriteHeader(http.StatusBadRequest)
+		writeErrResponse(w, http.StatusBadRequest, fmt.Sprintf("invalid task id %q", taskId))
 		return
 	}
 
@@ -59,10 +177,10 @@ func (a *Api) stopTaskHandler(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		if errors.Is(err, store.ErrKeyNotFound) {
 			log.Debug().Str("task-id", taskUuid.String()).Msg("task not found in store")
-			w.WriteHeader(http.StatusNotFound)
+			writeErrResponse(w, http.StatusNotFound, fmt.Sprintf("task %v not found", taskUuid))
 		} else {
 			log.Err(err).Str("task-id", taskUuid.String()).Msg("failed to retrieve task from store")
-			w.WriteHeader(http.StatusInternalServerError)
+			writeErrResponse(w, http.StatusInternalServerError, "failed to retrieve task")
 		}
 		return
 	}
@@ -81,13 +199,846 @@ func (a *Api) stopTaskHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (a *Api) getTasksHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseTaskFilter(r.URL.Query())
+	if err != nil {
+		log.Debug().Err(err).Msg("invalid tasks filter query parameter")
+		writeErrResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), ndjsonContentType) {
+		a.streamTasks(w, filter)
+		return
+	}
+
+	paged, limit, offset, err := parsePagination(r.URL.Query())
+	if err != nil {
+		log.Debug().Err(err).Msg("invalid pagination query parameter")
+		writeErrResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	tasks := []task.Task{}
+	for _, t := range a.Manager.GetTasks() {
+		if filter.match(t) {
+			tasks = append(tasks, t)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if !paged {
+		json.NewEncoder(w).Encode(tasks)
+		return
+	}
+	json.NewEncoder(w).Encode(newTaskPage(tasks, limit, offset))
+}
+
+// Page of the tasks list, returned when the "limit" or "offset" query parameter is set
+type TaskPage struct {
+	Items  []task.Task
+	Total  int // Number of tasks matching the filter, across all pages
+	Limit  int
+	Offset int
+}
+
+// Get the page of the given tasks, which are sorted by id so that the pages are stable
+//
+// A limit of 0 returns all the tasks following the offset
+func newTaskPage(tasks []task.Task, limit int, offset int) TaskPage {
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].Id.String() < tasks[j].Id.String()
+	})
+	page := TaskPage{Items: []task.Task{}, Total: len(tasks), Limit: limit, Offset: offset}
+	if offset >= len(tasks) {
+		return page
+	}
+	end := len(tasks)
+	if limit > 0 && offset+limit < end {
+		end = offset + limit
+	}
+	page.Items = tasks[offset:end]
+	return page
+}
+
+// Parse the "limit" and "offset" query parameters, the boolean is false when none of them is set
+func parsePagination(query neturl.Values) (bool, int, int, error) {
+	if !query.Has("limit") && !query.Has("offset") {
+		return false, 0, 0, nil
+	}
+	limit, offset := 0, 0
+	var err error
+	if value := query.Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 0 {
+			return false, 0, 0, fmt.Errorf("invalid limit parameter %q, expected a positive number", value)
+		}
+	}
+	if value := query.Get("offset"); value != "" {
+		if offset, err = strconv.Atoi(value); err != nil || offset < 0 {
+			return false, 0, 0, fmt.Errorf("invalid offset parameter %q, expected a positive number", value)
+		}
+	}
+	return true, limit, offset, nil
+}
+
+func (a *Api) getTaskHandler(w http.ResponseWriter, r *http.Request) {
+	taskId := chi.URLParam(r, "taskId")
+	taskUuid, err := uuid.Parse(taskId)
+	if err != nil {
+		log.Debug().Msg("taskId parameter isn't a valid uuid")
+		writeErrResponse(w, http.StatusBadRequest, fmt.Sprintf("invalid task id %q", taskId))
+		return
+	}
+
+	t, err := a.Manager.TaskDb.Get(taskUuid)
+	if err != nil {
+		if errors.Is(err, store.ErrKeyNotFound) {
+			log.Debug().Str("task-id", taskUuid.String()).Msg("task not found in store")
+			writeErrResponse(w, http.StatusNotFound, fmt.Sprintf("task %v not found", taskUuid))
+		} else {
+			log.Err(err).Str("task-id", taskUuid.String()).Msg("failed to retrieve task from store")
+			writeErrResponse(w, http.StatusInternalServerError, "failed to retrieve task")
+		}
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(a.Manager.GetTasks())
+	json.NewEncoder(w).Encode(t)
+}
+
+// Criteria of the listed tasks, the zero value matches all the tasks
+type taskFilter struct {
+	namespace      string
+	selector       map[string]string
+	startedAfter   time.Time
+	finishedBefore time.Time
+}
+
+// Parse the tasks filter from the "namespace", "label", "startedAfter" and "finishedBefore" query parameters
+//
+// The time bounds are RFC 3339 times, either of them may be omitted to get an open-ended window
+func parseTaskFilter(query neturl.Values) (taskFilter, error) {
+	selector, err := task.ParseLabelSelector(query["label"])
+	if err != nil {
+		return taskFilter{}, err
+	}
+	filter := taskFilter{namespace: query.Get("namespace"), selector: selector}
+	if value := query.Get("startedAfter"); value != "" {
+		if filter.startedAfter, err = time.Parse(time.RFC3339Nano, value); err != nil {
+			return taskFilter{}, fmt.Errorf("invalid startedAfter parameter %q, expected an RFC 3339 time", value)
+		}
+	}
+	if value := query.Get("finishedBefore"); value != "" {
+		if filter.finishedBefore, err = time.Parse(time.RFC3339Nano, value); err != nil {
+			return taskFilter{}, fmt.Errorf("invalid finishedBefore parameter %q, expected an RFC 3339 time", value)
+		}
+	}
+	return filter, nil
+}
+
+// Check if a task belongs to the namespace, matches the labels selector and is within the time window
+//
+// When a finish bound is set, the tasks which didn't finish yet don't match
+func (f taskFilter) match(t task.Task) bool {
+	if f.namespace != "" && t.Namespace != f.namespace {
+		return false
+	}
+	if !f.startedAfter.IsZero() && !t.StartTime.After(f.startedAfter) {
+		return false
+	}
+	if !f.finishedBefore.IsZero() && (t.FinishTime.IsZero() || !t.FinishTime.Before(f.finishedBefore)) {
+		return false
+	}
+	return t.MatchLabels(f.selector)
+}
+
+// Write the stored tasks matching the filter as JSON lines while iterating the store,
+// without loading them all in memory
+func (a *Api) streamTasks(w http.ResponseWriter, filter taskFilter) {
+	w.Header().Set("Content-Type", ndjsonContentType)
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	err := a.Manager.TaskDb.ForEach(func(t task.Task) error {
+		if !filter.match(t) {
+			return nil
+		}
+		return encoder.Encode(t)
+	})
+	if err != nil {
+		log.Err(err).Msg("failed to stream tasks")
+	}
+}
+
+func (a *Api) restartTaskHandler(w http.ResponseWriter, r *http.Request) {
+	taskId := chi.URLParam(r, "taskId")
+	taskUuid, err := uuid.Parse(taskId)
+	if err != nil {
+		log.Debug().Msg("taskId parameter isn't a valid uuid")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	t, err := a.Manager.TaskDb.Get(taskUuid)
+	if err != nil {
+		if errors.Is(err, store.ErrKeyNotFound) {
+			log.Debug().Str("task-id", taskUuid.String()).Msg("task not found in store")
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			log.Err(err).Str("task-id", taskUuid.String()).Msg("failed to retrieve task from store")
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+	if !task.ValidStateTransition(t.State, task.Scheduled) {
+		log.Debug().Str("task-id", taskUuid.String()).Msg("task can't be restarted from its current state")
+		writeErrResponse(w, http.StatusConflict, fmt.Sprintf("task can't be restarted from the %v state", t.State))
+		return
+	}
+
+	a.Manager.AddTask(task.TaskEvent{
+		Id:        uuid.New(),
+		State:     task.Scheduled,
+		Timestamp: time.Now().UTC(),
+		Task:      t,
+	})
+
+	log.Info().Str("task-id", t.Id.String()).Msg("task restart request queued")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *Api) updateRestartPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	taskId := chi.URLParam(r, "taskId")
+	taskUuid, err := uuid.Parse(taskId)
+	if err != nil {
+		log.Debug().Msg("taskId parameter isn't a valid uuid")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	request, err := worker.DecodeRequest[RestartPolicyRequest](w, r)
+	if err != nil {
+		return
+	}
+	if err := request.validate(); err != nil {
+		writeErrResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	t, err := a.Manager.UpdateRestartPolicy(taskUuid, request.RestartPolicy, request.MaxRestarts)
+	if err != nil {
+		if errors.Is(err, store.ErrKeyNotFound) {
+			log.Debug().Str("task-id", taskUuid.String()).Msg("task not found in store")
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			log.Err(err).Str("task-id", taskUuid.String()).Msg("failed to update task restart policy")
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	log.Info().Str("task-id", t.Id.String()).Msg("task restart policy updated")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(t)
+}
+
+func (r *RestartPolicyRequest) validate() error {
+	if r.RestartPolicy != nil {
+		if err := task.ValidateRestartPolicy(*r.RestartPolicy); err != nil {
+			return err
+		}
+	}
+	if r.MaxRestarts != nil && *r.MaxRestarts < 0 {
+		return fmt.Errorf("invalid max restarts %d: must be positive", *r.MaxRestarts)
+	}
+	return nil
+}
+
+func (a *Api) scaleTaskHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	namespace := r.URL.Query().Get("namespace")
+	if namespace == "" {
+		writeErrResponse(w, http.StatusBadRequest, "namespace query parameter is required")
+		return
+	}
+
+	request, err := worker.DecodeRequest[ScaleRequest](w, r)
+	if err != nil {
+		return
+	}
+	if request.Replicas < 0 {
+		writeErrResponse(w, http.StatusBadRequest, fmt.Sprintf("invalid replicas %d: must be positive", request.Replicas))
+		return
+	}
+
+	response, err := a.Manager.ScaleTask(namespace, name, request.Replicas)
+	switch {
+	case errors.Is(err, ErrNotReplicated):
+		log.Debug().Err(err).Msg("scale task handler error")
+		writeErrResponse(w, http.StatusNotFound, err.Error())
+		return
+	case errors.Is(err, ErrPortsUnavailable):
+		log.Debug().Err(err).Msg("scale task handler error")
+		writeErrResponse(w, http.StatusConflict, err.Error())
+		return
+	case err != nil:
+		log.Err(err).Str("name", name).Msg("failed to scale task")
+		writeErrResponse(w, http.StatusInternalServerError, "failed to scale task")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
 }
 
 func (a *Api) getNodesHandler(w http.ResponseWriter, r *http.Request) {
+	nodes := a.Manager.nodeResponses()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(nodes)
+}
+
+func (a *Api) drainNodeHandler(w http.ResponseWriter, r *http.Request) {
+	nodeName := chi.URLParam(r, "nodeName")
+	migrated, err := a.Manager.DrainNode(nodeName)
+	if err != nil {
+		if errors.Is(err, ErrNodeNotFound) {
+			writeErrResponse(w, http.StatusNotFound, fmt.Sprintf("node %s not found", nodeName))
+		} else {
+			log.Err(err).Str("node", nodeName).Msg("failed to drain node")
+			writeErrResponse(w, http.StatusInternalServerError, "failed to drain node")
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(worker.DrainResponse{
+		Node:          nodeName,
+		MigratedTasks: migrated,
+	})
+}
+
+// Drain a worker node then remove it from the cluster
+func (a *Api) removeNodeHandler(w http.ResponseWriter, r *http.Request) {
+	nodeName := chi.URLParam(r, "nodeName")
+	migrated, err := a.Manager.RemoveNode(nodeName)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrNodeNotFound):
+			writeErrResponse(w, http.StatusNotFound, fmt.Sprintf("node %s not found", nodeName))
+		case errors.Is(err, ErrNodeHasTasks):
+			writeErrResponse(w, http.StatusConflict, err.Error())
+		default:
+			log.Err(err).Str("node", nodeName).Msg("failed to remove node")
+			writeErrResponse(w, http.StatusInternalServerError, "failed to remove node")
+		}
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(a.Manager.WorkerNodes)
+	json.NewEncoder(w).Encode(worker.DrainResponse{
+		Node:          nodeName,
+		MigratedTasks: migrated,
+	})
+}
+
+// Stop all the tasks of the cluster then shut down the manager API
+//
+// The manager API is shut down even when some stops couldn't be confirmed in time. The repeated requests wait for
+// the shutdown in progress and get its result
+func (a *Api) shutdownHandler(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	shutdown := a.shutdown
+	requested := shutdown == nil
+	if requested {
+		shutdown = &clusterShutdown{done: make(chan struct{})}
+		a.shutdown = shutdown
+	}
+	a.mu.Unlock()
+
+	// The stops can be confirmed after the server write timeout, the response is written once they are all done
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	if requested {
+		log.Info().Msg("cluster shutdown requested, stopping all tasks")
+		// The stops go on when the client goes away, the repeated requests wait for them
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), clusterShutdownTimeout)
+		stopped, remaining, err := a.Manager.StopAllTasks(ctx)
+		cancel()
+		if err != nil {
+			log.Err(err).Int("remaining-tasks", remaining).Msg("failed to stop all tasks")
+		}
+		shutdown.result = ShutdownResponse{
+			StoppedTasks:   stopped,
+			RemainingTasks: remaining,
+		}
+		close(shutdown.done)
+	} else {
+		log.Debug().Msg("cluster shutdown already in progress, waiting for it")
+		select {
+		case <-shutdown.done:
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(shutdown.result)
+
+	if requested {
+		// The shutdown waits for the in-flight requests, including this one, it must not block the handler
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), clusterShutdownTimeout)
+			defer cancel()
+			if err := a.Shutdown(ctx); err != nil {
+				log.Err(err).Msg("api server shutdown failed")
+			}
+		}()
+	}
+}
+
+// Rebuild the tasks assignments from the tasks reported by the workers, and report the corrections made
+func (a *Api) rebuildAssignmentsHandler(w http.ResponseWriter, r *http.Request) {
+	result, err := a.Manager.RebuildAssignments()
+	if err != nil {
+		log.Err(err).Msg("failed to rebuild tasks assignments")
+		writeErrResponse(w, http.StatusInternalServerError, "failed to rebuild tasks assignments")
+		return
+	}
+
+	log.Info().Int("corrections", len(result.Corrections)).Msg("tasks assignments rebuilt")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(result)
+}
+
+// List the stored task events ordered by timestamp, only the ones of a task with "taskId=<id>"
+func (a *Api) getEventsHandler(w http.ResponseWriter, r *http.Request) {
+	var taskId uuid.UUID
+	if value := r.URL.Query().Get("taskId"); value != "" {
+		var err error
+		if taskId, err = uuid.Parse(value); err != nil {
+			writeErrResponse(w, http.StatusBadRequest, fmt.Sprintf("invalid task id %q", value))
+			return
+		}
+	}
+
+	events, err := a.Manager.TaskEvents(taskId)
+	if err != nil {
+		log.Err(err).Msg("failed to get task events")
+		writeErrResponse(w, http.StatusInternalServerError, "failed to retrieve task events")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(events)
+}
+
+func (a *Api) replayEventsHandler(w http.ResponseWriter, r *http.Request) {
+	result, err := a.Manager.ReplayEvents()
+	if err != nil {
+		log.Err(err).Msg("failed to replay task events")
+		writeErrResponse(w, http.StatusInternalServerError, "failed to replay task events")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(result)
+}
+
+// Stream a backup of a store database, the tasks one unless another is given with "store=events" or "store=groups"
+func (a *Api) getStoreSnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("store")
+	if name == "" {
+		name = "tasks"
+	}
+
+	snapshotter, err := a.Manager.StoreSnapshotter(name)
+	switch {
+	case errors.Is(err, ErrUnknownStore):
+		writeErrResponse(w, http.StatusBadRequest, err.Error())
+		return
+	case errors.Is(err, store.ErrSnapshotUnsupported):
+		writeErrResponse(w, http.StatusNotImplemented, err.Error())
+		return
+	}
+
+	// The database is streamed as long as the client reads it, whatever the server write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("manager_%s.db", name)))
+	if err := snapshotter.Snapshot(w); err != nil {
+		// The response status is already sent, the client gets a truncated file
+		log.Err(err).Str("store", name).Msg("failed to write store snapshot")
+	}
+}
+
+// Stream the process logs of a worker node
+func (a *Api) getNodeLogsHandler(w http.ResponseWriter, r *http.Request) {
+	nodeName := chi.URLParam(r, "nodeName")
+	wNode := a.Manager.getWorkerNode(nodeName)
+	if wNode == nil {
+		writeErrResponse(w, http.StatusNotFound, fmt.Sprintf("node %s not found", nodeName))
+		return
+	}
+
+	url := fmt.Sprintf("%s/logs?%s", wNode.Api, r.URL.RawQuery)
+	proxyStream(w, r, url, wNode.Name)
+}
+
+// Stream the logs of a task container from the worker running it
+func (a *Api) getTaskLogsHandler(w http.ResponseWriter, r *http.Request) {
+	taskId := chi.URLParam(r, "taskId")
+	taskUuid, err := uuid.Parse(taskId)
+	if err != nil {
+		log.Debug().Msg("taskId parameter isn't a valid uuid")
+		writeErrResponse(w, http.StatusBadRequest, fmt.Sprintf("invalid task id %q", taskId))
+		return
+	}
+
+	worker, _ := a.Manager.taskWorker(taskUuid)
+	wNode := a.Manager.getWorkerNode(worker)
+	if wNode == nil {
+		writeErrResponse(w, http.StatusNotFound, fmt.Sprintf("task %v isn't assigned to a node", taskUuid))
+		return
+	}
+
+	url := fmt.Sprintf("%s/tasks/%v/logs?%s", wNode.Api, taskUuid, r.URL.RawQuery)
+	proxyStream(w, r, url, wNode.Name)
+}
+
+// Get the resources usage of a task container from the worker running it
+func (a *Api) getTaskStatsHandler(w http.ResponseWriter, r *http.Request) {
+	taskId := chi.URLParam(r, "taskId")
+	taskUuid, err := uuid.Parse(taskId)
+	if err != nil {
+		log.Debug().Msg("taskId parameter isn't a valid uuid")
+		writeErrResponse(w, http.StatusBadRequest, fmt.Sprintf("invalid task id %q", taskId))
+		return
+	}
+
+	worker, _ := a.Manager.taskWorker(taskUuid)
+	wNode := a.Manager.getWorkerNode(worker)
+	if wNode == nil {
+		writeErrResponse(w, http.StatusNotFound, fmt.Sprintf("task %v isn't assigned to a node", taskUuid))
+		return
+	}
+
+	url := fmt.Sprintf("%s/tasks/%v/stats", wNode.Api, taskUuid)
+	proxyStream(w, r, url, wNode.Name)
+}
+
+// Forward a GET request to a worker node, the response is streamed back as it is received
+func proxyStream(w http.ResponseWriter, r *http.Request, url string, nodeName string) {
+	request, err := http.NewRequestWithContext(r.Context(), http.MethodGet, url, nil)
+	if err != nil {
+		log.Err(err).Str("node", nodeName).Msg("failed to create node request")
+		writeErrResponse(w, http.StatusInternalServerError, "failed to create node request")
+		return
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		log.Err(err).Str("node", nodeName).Msg("failed to send node request")
+		writeErrResponse(w, http.StatusBadGateway, fmt.Sprintf("node %s is unreachable", nodeName))
+		return
+	}
+	defer response.Body.Close()
+
+	// The node response is streamed as long as the client reads it, whatever the server write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", response.Header.Get("Content-Type"))
+	w.WriteHeader(response.StatusCode)
+	flusher, _ := w.(http.Flusher)
+	buffer := make([]byte, 32*1024)
+	for {
+		n, err := response.Body.Read(buffer)
+		if n > 0 {
+			if _, err := w.Write(buffer[:n]); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (a *Api) getMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(a.Manager.Metrics.Report())
+}
+
+func (a *Api) writeSnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	path, err := a.Manager.WriteSnapshot()
+	if err != nil {
+		log.Err(err).Msg("failed to write cluster snapshot")
+		writeErrResponse(w, http.StatusInternalServerError, "failed to write snapshot")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(SnapshotResponse{Path: path})
+}
+
+func (a *Api) healthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(HealthResponse{Status: "ok"})
+}
+
+func (a *Api) readyHandler(w http.ResponseWriter, r *http.Request) {
+	if err := a.Manager.Ready(); err != nil {
+		log.Debug().Err(err).Msg("manager isn't ready")
+		writeErrResponse(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(HealthResponse{Status: "ready"})
+}
+
+func (a *Api) getDebugStatsHandler(w http.ResponseWriter, r *http.Request) {
+	taskCount, err := a.Manager.TaskDb.Count()
+	if err != nil {
+		log.Err(err).Msg("failed to count stored tasks")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	eventCount, err := a.Manager.EventDb.Count()
+	if err != nil {
+		log.Err(err).Msg("failed to count stored task events")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(DebugStats{
+		Goroutines:    runtime.NumGoroutine(),
+		PendingTasks:  a.Manager.Pending.Len(),
+		StoredTasks:   taskCount,
+		StoredEvents:  eventCount,
+		UptimeSeconds: time.Since(a.Manager.StartTime).Seconds(),
+	})
+}
+
+// Stream the state changes of a task as server-sent events, until the task reaches a final state
+//
+// A failed task which will be restarted isn't in a final state, its stream goes on. The stream is fed by the tasks
+// store changes, so that the transitions written in a quick succession are all sent
+func (a *Api) streamTaskEventsHandler(w http.ResponseWriter, r *http.Request) {
+	taskId := chi.URLParam(r, "taskId")
+	taskUuid, err := uuid.Parse(taskId)
+	if err != nil {
+		log.Debug().Msg("taskId parameter isn't a valid uuid")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		log.Error().Msg("response writer doesn't support streaming")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Subscribe before reading the task, so that no change written in between is missed
+	var changes <-chan store.ChangeEvent[uuid.UUID, task.Task]
+	var current task.Task
+	var stored bool
+	subscribe := func() error {
+		var err error
+		if changes, err = a.Manager.WatchTasks(r.Context()); err != nil {
+			return err
+		}
+		// The task may not be stored yet if it is still in the pending queue
+		current, err = a.Manager.TaskDb.Get(taskUuid)
+		stored = err == nil
+		if errors.Is(err, store.ErrKeyNotFound) {
+			return nil
+		}
+		return err
+	}
+	if err := subscribe(); err != nil {
+		log.Err(err).Str("task-id", taskUuid.String()).Msg("failed to watch task")
+		writeErrResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// The events are streamed until the task completes, whatever the server write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	var lastState *task.State
+	for {
+		if stored && (lastState == nil || *lastState != current.State) {
+			state := current.State
+			lastState = &state
+			data, err := json.Marshal(task.TaskEvent{
+				Id:        uuid.New(),
+				State:     current.State,
+				Timestamp: time.Now().UTC(),
+				Task:      current,
+			})
+			if err != nil {
+				log.Err(err).Str("task-id", taskUuid.String()).Msg("failed to marshal task event")
+				return
+			}
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			flusher.Flush()
+
+			if current.State == task.Completed || (current.State == task.Failed && !a.Manager.canRestart(current)) {
+				return
+			}
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case change, open := <-changes:
+			if !open {
+				if r.Context().Err() != nil {
+					return
+				}
+				// The stream fell behind the store changes, the task is read again from a new subscription
+				if err := subscribe(); err != nil {
+					log.Err(err).Str("task-id", taskUuid.String()).Msg("failed to watch task")
+					return
+				}
+				continue
+			}
+			if change.Key != taskUuid {
+				continue
+			}
+			if change.Type == store.ChangeDelete {
+				// The task was purged, it won't change anymore
+				return
+			}
+			current, stored = change.Value, true
+		}
+	}
+}
+
+func (a *Api) deployGroupHandler(w http.ResponseWriter, r *http.Request) {
+	request, err := worker.DecodeRequest[GroupRequest](w, r)
+	if err != nil {
+		return
+	}
+	for i := range request.Tasks {
+		if auth, found := request.RegistryAuths[request.Tasks[i].Id]; found {
+			request.Tasks[i].RegistryAuth = &auth
+		}
+	}
+	if err := task.ValidateGroup(request.Tasks); err != nil {
+		log.Err(err).Msg("deploy group handler error: invalid group")
+		writeValidationError(w, "invalid group", err)
+		return
+	}
+	for i := range request.Tasks {
+		if err := a.Manager.CheckTaskName(request.Tasks[i]); err != nil {
+			writeTaskNameError(w, err)
+			return
+		}
+		if err := a.Manager.CheckHostPorts(request.Tasks[i]); err != nil {
+			log.Debug().Err(err).Str("task-id", request.Tasks[i].Id.String()).Msg("group deployment rejected")
+			writeErrResponse(w, http.StatusConflict, err.Error())
+			return
+		}
+		a.Manager.ApplyDefaultResources(&request.Tasks[i])
+	}
+
+	group, err := a.Manager.DeployGroup(request.Name, request.Tasks)
+	switch {
+	case errors.Is(err, ErrTaskSubmitted), errors.Is(err, ErrNameConflict):
+		log.Debug().Err(err).Msg("group deployment rejected")
+		writeErrResponse(w, http.StatusConflict, err.Error())
+		return
+	case errors.Is(err, ErrGroupRolledBack):
+		log.Err(err).Str("group-id", group.Id.String()).Msg("group deployment failed")
+		writeErrResponse(w, http.StatusConflict, err.Error())
+		return
+	case err != nil:
+		log.Err(err).Str("group-id", group.Id.String()).Msg("group deployment failed")
+		writeErrResponse(w, http.StatusInternalServerError, "failed to deploy group")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(group)
+}
+
+func (a *Api) getGroupsHandler(w http.ResponseWriter, r *http.Request) {
+	groups, err := a.Manager.GroupDb.List()
+	if err != nil {
+		log.Err(err).Msg("failed to get groups from store")
+		writeErrResponse(w, http.StatusInternalServerError, "failed to retrieve groups")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(groups)
+}
+
+func (a *Api) getGroupHandler(w http.ResponseWriter, r *http.Request) {
+	groupId := chi.URLParam(r, "groupId")
+	groupUuid, err := uuid.Parse(groupId)
+	if err != nil {
+		log.Debug().Msg("groupId parameter isn't a valid uuid")
+		writeErrResponse(w, http.StatusBadRequest, fmt.Sprintf("invalid group id %q", groupId))
+		return
+	}
+
+	group, err := a.Manager.GetGroup(groupUuid)
+	if err != nil {
+		writeGroupLookupError(w, groupUuid, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(group)
+}
+
+func (a *Api) stopGroupHandler(w http.ResponseWriter, r *http.Request) {
+	groupId := chi.URLParam(r, "groupId")
+	groupUuid, err := uuid.Parse(groupId)
+	if err != nil {
+		log.Debug().Msg("groupId parameter isn't a valid uuid")
+		writeErrResponse(w, http.StatusBadRequest, fmt.Sprintf("invalid group id %q", groupId))
+		return
+	}
+
+	if _, err := a.Manager.StopGroup(groupUuid); err != nil {
+		writeGroupLookupError(w, groupUuid, err)
+		return
+	}
+
+	log.Info().Str("group-id", groupUuid.String()).Msg("group stop requests queued")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeGroupLookupError(w http.ResponseWriter, groupId uuid.UUID, err error) {
+	if errors.Is(err, store.ErrKeyNotFound) {
+		log.Debug().Str("group-id", groupId.String()).Msg("group not found in store")
+		writeErrResponse(w, http.StatusNotFound, fmt.Sprintf("group %v not found", groupId))
+	} else {
+		log.Err(err).Str("group-id", groupId.String()).Msg("failed to retrieve group")
+		writeErrResponse(w, http.StatusInternalServerError, "failed to retrieve group")
+	}
 }
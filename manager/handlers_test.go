@@ -0,0 +1,727 @@
+package manager
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"orchestrator/httpapi"
+	"orchestrator/store"
+	"orchestrator/task"
+	"orchestrator/worker"
+)
+
+// Read the next event of a task events stream, failing the test if it doesn't come in time
+func nextStreamEvent(t *testing.T, events <-chan task.TaskEvent) task.TaskEvent {
+	t.Helper()
+	select {
+	case tEvent, ok := <-events:
+		if !ok {
+			t.Fatal("task events stream ended")
+		}
+		return tEvent
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a task event")
+	}
+	return task.TaskEvent{}
+}
+
+func TestStreamTaskEventsFollowsRestart(t *testing.T) {
+	m := newTestManager(t)
+	server := httptest.NewServer(newTestApi(m).Router)
+	defer server.Close()
+
+	tk := task.Task{Id: uuid.New(), Name: "web", Image: "nginx", State: task.Failed, RestartCount: 1}
+	m.TaskDb.Put(tk.Id, tk)
+
+	response, err := http.Get(fmt.Sprintf("%s/tasks/%v/events", server.URL, tk.Id))
+	if err != nil {
+		t.Fatalf("failed to get task events: %v", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status %d", response.StatusCode)
+	}
+	events := make(chan task.TaskEvent)
+	go func() {
+		defer close(events)
+		scanner := bufio.NewScanner(response.Body)
+		for scanner.Scan() {
+			if data, found := strings.CutPrefix(scanner.Text(), "data: "); found {
+				var tEvent task.TaskEvent
+				json.Unmarshal([]byte(data), &tEvent)
+				events <- tEvent
+			}
+		}
+	}()
+
+	// The failed task is restarted, its stream goes on until it completes
+	for _, state := range []task.State{task.Failed, task.Scheduled, task.Running, task.Completed} {
+		tk.State = state
+		m.TaskDb.Put(tk.Id, tk)
+		if tEvent := nextStreamEvent(t, events); tEvent.State != state {
+			t.Fatalf("expected a %v event, got %v", state, tEvent.State)
+		}
+	}
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Error("expected the stream to end once the task completed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("timed out waiting for the end of the stream")
+	}
+}
+
+func TestStreamTaskEventsMissesNoTransition(t *testing.T) {
+	m := newTestManager(t)
+	server := httptest.NewServer(newTestApi(m).Router)
+	defer server.Close()
+
+	tk := task.Task{Id: uuid.New(), Name: "web", Image: "nginx", State: task.Running}
+	m.TaskDb.Put(tk.Id, tk)
+	response, err := http.Get(fmt.Sprintf("%s/tasks/%v/events", server.URL, tk.Id))
+	if err != nil {
+		t.Fatalf("failed to get task events: %v", err)
+	}
+	defer response.Body.Close()
+
+	// The transitions are written without waiting for the stream to read them
+	states := []task.State{task.Failed, task.Scheduled, task.Running}
+	for _, state := range states {
+		tk.State = state
+		m.TaskDb.Put(tk.Id, tk)
+	}
+	m.TaskDb.Delete(tk.Id)
+
+	var received []task.State
+	scanner := bufio.NewScanner(response.Body)
+	for scanner.Scan() {
+		if data, found := strings.CutPrefix(scanner.Text(), "data: "); found {
+			var tEvent task.TaskEvent
+			json.Unmarshal([]byte(data), &tEvent)
+			received = append(received, tEvent.State)
+		}
+	}
+	if !slices.Equal(received, append([]task.State{task.Running}, states...)) {
+		t.Errorf("expected every transition to be streamed until the task is purged, got %v", received)
+	}
+}
+
+func TestStreamTaskEventsEndsWhenFailedTaskCantRestart(t *testing.T) {
+	m := newTestManager(t)
+	api := newTestApi(m)
+
+	tk := task.Task{Id: uuid.New(), Name: "web", Image: "nginx", State: task.Failed, RestartCount: DefaultMaxRestarts}
+	m.TaskDb.Put(tk.Id, tk)
+
+	rec := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		api.Router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/tasks/%v/events", tk.Id), nil))
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the stream to end for a task which can't be restarted")
+	}
+	if count := strings.Count(rec.Body.String(), "data: "); count != 1 {
+		t.Errorf("expected a single event, got %d", count)
+	}
+}
+
+// Get the tasks listed by the API, as JSON lines when streamed or else as an array, sorted by id
+func listedTasks(t *testing.T, api *Api, stream bool) []task.Task {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/tasks", nil)
+	if stream {
+		req.Header.Set("Accept", ndjsonContentType)
+	}
+	rec := httptest.NewRecorder()
+	api.Router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+
+	var tasks []task.Task
+	if stream {
+		if contentType := rec.Header().Get("Content-Type"); contentType != ndjsonContentType {
+			t.Errorf("expected content type %s, got %s", ndjsonContentType, contentType)
+		}
+		decoder := json.NewDecoder(rec.Body)
+		for decoder.More() {
+			var tk task.Task
+			if err := decoder.Decode(&tk); err != nil {
+				t.Fatalf("failed to decode streamed task: %v", err)
+			}
+			tasks = append(tasks, tk)
+		}
+	} else if err := json.NewDecoder(rec.Body).Decode(&tasks); err != nil {
+		t.Fatalf("failed to decode tasks: %v", err)
+	}
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].Id.String() < tasks[j].Id.String() })
+	return tasks
+}
+
+func TestGetTasksStreamMatchesArray(t *testing.T) {
+	m := newTestManager(t)
+	for i := 0; i < 5; i++ {
+		tk := task.Task{Id: uuid.New(), Name: fmt.Sprintf("web-%d", i), State: task.Running}
+		if err := m.TaskDb.Put(tk.Id, tk); err != nil {
+			t.Fatalf("failed to store task: %v", err)
+		}
+	}
+	api := newTestApi(m)
+
+	array := listedTasks(t, api, false)
+	streamed := listedTasks(t, api, true)
+	if len(array) != 5 {
+		t.Fatalf("expected 5 listed tasks, got %d", len(array))
+	}
+	if !reflect.DeepEqual(array, streamed) {
+		t.Errorf("streamed tasks differ from the listed ones:\n%v\n%v", streamed, array)
+	}
+}
+
+func TestGetDebugStats(t *testing.T) {
+	m := newTestManager(t)
+	tk := task.Task{Id: uuid.New(), Name: "web", State: task.Running}
+	if err := m.TaskDb.Put(tk.Id, tk); err != nil {
+		t.Fatalf("failed to store task: %v", err)
+	}
+	m.AddTask(task.TaskEvent{Id: uuid.New(), State: task.Scheduled, Task: task.Task{Id: uuid.New()}})
+	api := newTestApi(m)
+
+	rec := httptest.NewRecorder()
+	api.Router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/stats", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	var fields map[string]float64
+	if err := json.NewDecoder(rec.Body).Decode(&fields); err != nil {
+		t.Fatalf("failed to decode debug stats: %v", err)
+	}
+	for _, name := range []string{"Goroutines", "PendingTasks", "StoredTasks", "StoredEvents", "UptimeSeconds"} {
+		if _, found := fields[name]; !found {
+			t.Errorf("expected the %s field in the debug stats", name)
+		}
+	}
+	if fields["Goroutines"] < 1 || fields["PendingTasks"] != 1 || fields["StoredTasks"] != 1 || fields["UptimeSeconds"] < 0 {
+		t.Errorf("unexpected debug stats values: %v", fields)
+	}
+}
+
+func TestGetTasksFiltersByLabels(t *testing.T) {
+	m := newTestManager(t)
+	labeled := []map[string]string{{"app": "web", "tier": "front"}, {"app": "web"}, {"app": "db"}, nil}
+	for i, labels := range labeled {
+		tk := task.Task{Id: uuid.New(), Name: fmt.Sprintf("task-%d", i), State: task.Running, Labels: labels}
+		if err := m.TaskDb.Put(tk.Id, tk); err != nil {
+			t.Fatalf("failed to store task: %v", err)
+		}
+	}
+	api := newTestApi(m)
+
+	for _, stream := range []bool{false, true} {
+		req := httptest.NewRequest(http.MethodGet, "/tasks?label=app=web", nil)
+		if stream {
+			req.Header.Set("Accept", ndjsonContentType)
+		}
+		rec := httptest.NewRecorder()
+		api.Router.ServeHTTP(rec, req)
+		if lines := strings.Count(rec.Body.String(), `"Name":"task-`); lines != 2 {
+			t.Errorf("expected 2 tasks labeled app=web (streamed: %v), got %d", stream, lines)
+		}
+	}
+
+	// All the selectors must match
+	for url, expected := range map[string]int{
+		"/tasks?label=app=web&label=tier=front": 1,
+		"/tasks?label=app=web&label=tier=back":  0,
+		"/tasks?label=app=cache":                0,
+	} {
+		rec := httptest.NewRecorder()
+		api.Router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("expected status %d for %s, got %d", http.StatusOK, url, rec.Code)
+		}
+		if matched := strings.Count(rec.Body.String(), `"Name":"task-`); matched != expected {
+			t.Errorf("expected %d tasks listed by %s, got %d", expected, url, matched)
+		}
+	}
+
+	for _, url := range []string{"/tasks?label=app", "/tasks?label=app=web&label=app=db"} {
+		rec := httptest.NewRecorder()
+		api.Router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("expected status %d for the invalid selector %s, got %d", http.StatusBadRequest, url, rec.Code)
+		}
+	}
+}
+
+// Send a restart settings update of the given task and return the response status
+func patchRestartPolicy(t *testing.T, api *Api, taskId uuid.UUID, body string) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPatch, "/tasks/"+taskId.String()+"/restart-policy", strings.NewReader(body))
+	api.Router.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestUpdateRestartPolicyAppliesOnNextFailure(t *testing.T) {
+	fw := newFakeWorker(t)
+	m := newTestManager(t, fw)
+	tk := storeAssignedTask(t, m, fw.addr())
+	tk.State = task.Failed
+	tk.RestartCount = 1
+	if err := m.TaskDb.Put(tk.Id, tk); err != nil {
+		t.Fatalf("failed to store task: %v", err)
+	}
+	api := newTestApi(m)
+
+	// Lowering the limit stops the crash loop
+	if status := patchRestartPolicy(t, api, tk.Id, `{"MaxRestarts": 1}`); status != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, status)
+	}
+	m.checkTasksHealth()
+	if events := fw.receivedEvents(); len(events) != 0 {
+		t.Fatalf("expected the task not to be restarted past its new limit, got %d restarts", len(events))
+	}
+
+	if status := patchRestartPolicy(t, api, tk.Id, `{"RestartPolicy": "on-failure", "MaxRestarts": 5}`); status != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, status)
+	}
+	m.checkTasksHealth()
+	events := fw.receivedEvents()
+	if len(events) != 1 {
+		t.Fatalf("expected the task to be restarted under its new limit, got %d restarts", len(events))
+	}
+	if restarted := events[0].Task; restarted.RestartPolicy != "on-failure" || restarted.MaxRestarts != 5 {
+		t.Errorf("expected the restarted task to carry the new restart settings, got %q and %d", restarted.RestartPolicy, restarted.MaxRestarts)
+	}
+}
+
+func TestUpdateRestartPolicyRejectsInvalidSettings(t *testing.T) {
+	m := newTestManager(t)
+	tk := task.Task{Id: uuid.New(), Name: "web", State: task.Running}
+	if err := m.TaskDb.Put(tk.Id, tk); err != nil {
+		t.Fatalf("failed to store task: %v", err)
+	}
+	api := newTestApi(m)
+
+	for _, body := range []string{`{"RestartPolicy": "sometimes"}`, `{"MaxRestarts": -1}`} {
+		if status := patchRestartPolicy(t, api, tk.Id, body); status != http.StatusBadRequest {
+			t.Errorf("expected status %d for %s, got %d", http.StatusBadRequest, body, status)
+		}
+	}
+	if status := patchRestartPolicy(t, api, uuid.New(), `{"MaxRestarts": 1}`); status != http.StatusNotFound {
+		t.Errorf("expected status %d for an unknown task, got %d", http.StatusNotFound, status)
+	}
+}
+
+func TestStopTaskErrorsAreStructured(t *testing.T) {
+	api := newTestApi(newTestManager(t))
+
+	for path, status := range map[string]int{
+		"/tasks/not-a-uuid":          http.StatusBadRequest,
+		"/tasks/" + uuid.NewString(): http.StatusNotFound,
+	} {
+		rec := httptest.NewRecorder()
+		api.Router.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, path, nil))
+		if rec.Code != status {
+			t.Errorf("%s: expected status %d, got %d", path, status, rec.Code)
+		}
+		if contentType := rec.Header().Get("Content-Type"); contentType != "application/json" {
+			t.Errorf("%s: expected a JSON error, got content type %q", path, contentType)
+		}
+		var errResponse ErrResponse
+		if err := json.NewDecoder(rec.Body).Decode(&errResponse); err != nil || errResponse.Message == "" || errResponse.HTTPStatusCode != status {
+			t.Errorf("%s: expected an error message, got %+v (%v)", path, errResponse, err)
+		}
+	}
+}
+
+func TestGetTasksFiltersByNamespace(t *testing.T) {
+	m := newTestManager(t)
+	api := newTestApi(m)
+	shopWeb := task.Task{Id: uuid.New(), Name: "web", Namespace: "shop", State: task.Running}
+	blogWeb := task.Task{Id: uuid.New(), Name: "web", Namespace: "blog", State: task.Running}
+	m.TaskDb.Put(shopWeb.Id, shopWeb)
+	m.TaskDb.Put(blogWeb.Id, blogWeb)
+
+	for _, accept := range []string{"application/json", ndjsonContentType} {
+		req := httptest.NewRequest(http.MethodGet, "/tasks?namespace=shop", nil)
+		req.Header.Set("Accept", accept)
+		rec := httptest.NewRecorder()
+		api.Router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+		}
+		if body := rec.Body.String(); !strings.Contains(body, shopWeb.Id.String()) || strings.Contains(body, blogWeb.Id.String()) {
+			t.Errorf("expected only the task of the shop namespace to be listed as %s, got %s", accept, body)
+		}
+	}
+}
+
+func TestGetNodeLogsForwardedToWorker(t *testing.T) {
+	fw := newFakeWorker(t)
+	api := newTestApi(newTestManager(t, fw))
+
+	rec := api.serve(t, http.MethodGet, fmt.Sprintf("/nodes/%s/logs?since=2024-01-01T00:00:00Z", fw.addr()), nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	if body := strings.TrimSpace(rec.Body.String()); body != "since=2024-01-01T00:00:00Z" {
+		t.Errorf("expected the query to be forwarded to the worker, got %q", body)
+	}
+	if contentType := rec.Header().Get("Content-Type"); contentType != "application/x-ndjson" {
+		t.Errorf("expected the worker content type, got %q", contentType)
+	}
+
+	if rec := api.serve(t, http.MethodGet, "/nodes/unknown/logs", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("expected status %d for an unknown node, got %d", http.StatusNotFound, rec.Code)
+	}
+}
+
+func TestStartTaskAppliesDefaultResources(t *testing.T) {
+	m := newTestManager(t)
+	m.Config.DefaultCpu = 0.5
+	m.Config.DefaultMemory = 64 * 1024 * 1024
+	m.Config.DefaultDisk = 1024 * 1024 * 1024
+	api := newTestApi(m)
+
+	tEvent := newTaskEvent("web")
+	tEvent.Task.Memory = 32 * 1024 * 1024
+	if rec := api.serve(t, http.MethodPost, "/tasks", tEvent); rec.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d", http.StatusCreated, rec.Code)
+	}
+
+	queued, _ := m.Pending.Pop()
+	if queued.Task.Cpu != 0.5 || queued.Task.Disk != 1024*1024*1024 {
+		t.Errorf("expected the default cpu and disk to be requested, got %v and %d", queued.Task.Cpu, queued.Task.Disk)
+	}
+	if queued.Task.Memory != 32*1024*1024 {
+		t.Errorf("expected the memory set on the task to take precedence, got %d", queued.Task.Memory)
+	}
+}
+
+func TestStartTaskRejectsTerminalStates(t *testing.T) {
+	m := newTestManager(t)
+	api := newTestApi(m)
+
+	for _, state := range []task.State{task.Completed, task.Failed} {
+		tEvent := newTaskEvent("web")
+		tEvent.Task.State = state
+		if rec := api.serve(t, http.MethodPost, "/tasks", tEvent); rec.Code != http.StatusConflict {
+			t.Errorf("expected status %d for a task in state %v, got %d", http.StatusConflict, state, rec.Code)
+		}
+	}
+	tEvent := newTaskEvent("web")
+	tEvent.State = task.Completed
+	if rec := api.serve(t, http.MethodPost, "/tasks", tEvent); rec.Code != http.StatusConflict {
+		t.Errorf("expected status %d for an invalid state transition, got %d", http.StatusConflict, rec.Code)
+	}
+	if m.Pending.Len() != 0 {
+		t.Errorf("expected the rejected tasks not to be queued, got %d queued events", m.Pending.Len())
+	}
+}
+
+func TestGetTaskLogsForwardedToAssignedWorker(t *testing.T) {
+	fw := newFakeWorker(t)
+	m := newTestManager(t, fw)
+	api := newTestApi(m)
+	tk := storeAssignedTask(t, m, fw.addr())
+
+	rec := api.serve(t, http.MethodGet, fmt.Sprintf("/tasks/%v/logs?follow=true", tk.Id), nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	if expected := fmt.Sprintf("logs of %v follow=true\n", tk.Id); rec.Body.String() != expected {
+		t.Errorf("expected the worker logs %q, got %q", expected, rec.Body.String())
+	}
+
+	if rec := api.serve(t, http.MethodGet, fmt.Sprintf("/tasks/%v/logs", uuid.New()), nil); rec.Code != http.StatusNotFound {
+		t.Errorf("expected status %d for an unassigned task, got %d", http.StatusNotFound, rec.Code)
+	}
+}
+
+func TestGetTaskStatsForwardedToAssignedWorker(t *testing.T) {
+	fw := newFakeWorker(t)
+	m := newTestManager(t, fw)
+	api := newTestApi(m)
+	tk := storeAssignedTask(t, m, fw.addr())
+
+	rec := api.serve(t, http.MethodGet, fmt.Sprintf("/tasks/%v/stats", tk.Id), nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	var got worker.TaskStats
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("failed to decode stats: %v", err)
+	}
+	if got.TaskId != tk.Id || got.ContainerId != "container-1" || got.CpuPercent != 12.5 {
+		t.Errorf("expected the worker stats of task %v, got %+v", tk.Id, got)
+	}
+
+	if rec := api.serve(t, http.MethodGet, fmt.Sprintf("/tasks/%v/stats", uuid.New()), nil); rec.Code != http.StatusNotFound {
+		t.Errorf("expected status %d for an unassigned task, got %d", http.StatusNotFound, rec.Code)
+	}
+}
+
+func TestGetTask(t *testing.T) {
+	m := newTestManager(t)
+	tk := task.Task{Id: uuid.New(), Name: "web", Namespace: "default", Image: "nginx", State: task.Running}
+	if err := m.TaskDb.Put(tk.Id, tk); err != nil {
+		t.Fatalf("failed to store task: %v", err)
+	}
+	api := newTestApi(m)
+
+	rec := api.serve(t, http.MethodGet, fmt.Sprintf("/tasks/%v", tk.Id), nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	got := task.Task{}
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("failed to decode task: %v", err)
+	}
+	if got.Id != tk.Id || got.Name != tk.Name {
+		t.Errorf("expected task %v, got %+v", tk.Id, got)
+	}
+
+	if rec := api.serve(t, http.MethodGet, fmt.Sprintf("/tasks/%v", uuid.New()), nil); rec.Code != http.StatusNotFound {
+		t.Errorf("expected status %d for an unknown task, got %d", http.StatusNotFound, rec.Code)
+	}
+	if rec := api.serve(t, http.MethodGet, "/tasks/not-a-uuid", nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for a malformed id, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestStartTaskRejectsUnknownFieldsAndOversizedBodies(t *testing.T) {
+	api := newTestApi(newTestManager(t))
+	handler := httpapi.NewServer("", api.Router, httpapi.ServerConfig{MaxBodySize: 1 << 10}).Handler
+	bodies := map[string]struct {
+		body   string
+		status int
+	}{
+		"unknown field": {`{"Task": {"Name": "web", "Namespace": "default", "Image": "nginx", "Imgae": "nginx"}}`, http.StatusBadRequest},
+		"oversized":     {`{"Task": {"Name": "` + strings.Repeat("a", 1<<10) + `"}}`, http.StatusRequestEntityTooLarge},
+	}
+	for name, c := range bodies {
+		t.Run(name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/tasks", strings.NewReader(c.body)))
+			if rec.Code != c.status {
+				t.Errorf("expected status %d, got %d", c.status, rec.Code)
+			}
+			if api.Manager.Pending.Len() != 0 {
+				t.Errorf("expected the task not to be queued")
+			}
+		})
+	}
+}
+
+func TestStartTaskReportsAllViolations(t *testing.T) {
+	api := newTestApi(newTestManager(t))
+	tEvent := newTaskEvent("web")
+	tEvent.Task.Image = ""
+	tEvent.Task.Cpu = -1
+	tEvent.Task.MaxRestarts = -1
+
+	rec := api.serve(t, http.MethodPost, "/tasks", tEvent)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+	e := ErrResponse{}
+	if err := json.NewDecoder(rec.Body).Decode(&e); err != nil {
+		t.Fatalf("failed to decode error response: %v", err)
+	}
+	fields := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		fields[i] = v.Field
+	}
+	if expected := []string{"Image", "Cpu", "MaxRestarts"}; !reflect.DeepEqual(fields, expected) {
+		t.Errorf("expected violations on %v, got %v", expected, fields)
+	}
+}
+
+func TestTaskFilterTimeWindow(t *testing.T) {
+	base := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+	early := task.Task{StartTime: base.Add(-2 * time.Hour), FinishTime: base.Add(-time.Hour)}
+	late := task.Task{StartTime: base.Add(time.Hour), FinishTime: base.Add(2 * time.Hour)}
+	running := task.Task{StartTime: base.Add(time.Hour)}
+
+	tests := []struct {
+		name     string
+		filter   taskFilter
+		expected []bool // Match of the early, late and running tasks
+	}{
+		{"no bounds", taskFilter{}, []bool{true, true, true}},
+		{"started after only", taskFilter{startedAfter: base}, []bool{false, true, true}},
+		{"finished before only", taskFilter{finishedBefore: base}, []bool{true, false, false}},
+		{"closed window", taskFilter{startedAfter: base, finishedBefore: base.Add(3 * time.Hour)}, []bool{false, true, false}},
+		{"empty window", taskFilter{startedAfter: base, finishedBefore: base}, []bool{false, false, false}},
+	}
+	for _, test := range tests {
+		for i, tk := range []task.Task{early, late, running} {
+			if matched := test.filter.match(tk); matched != test.expected[i] {
+				t.Errorf("%s: expected the match of task %d to be %v, got %v", test.name, i, test.expected[i], matched)
+			}
+		}
+	}
+}
+
+func TestGetTasksFiltersByTimeWindow(t *testing.T) {
+	m := newTestManager(t)
+	api := newTestApi(m)
+	base := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+	shopOld := task.Task{Id: uuid.New(), Name: "old", Namespace: "shop", State: task.Completed, StartTime: base.Add(-2 * time.Hour), FinishTime: base.Add(-time.Hour)}
+	shopNew := task.Task{Id: uuid.New(), Name: "new", Namespace: "shop", State: task.Completed, StartTime: base.Add(time.Hour), FinishTime: base.Add(2 * time.Hour)}
+	blogNew := task.Task{Id: uuid.New(), Name: "new", Namespace: "blog", State: task.Completed, StartTime: base.Add(time.Hour), FinishTime: base.Add(2 * time.Hour)}
+	for _, tk := range []task.Task{shopOld, shopNew, blogNew} {
+		m.TaskDb.Put(tk.Id, tk)
+	}
+
+	for _, stream := range []bool{false, true} {
+		req := httptest.NewRequest(http.MethodGet, "/tasks?namespace=shop&startedAfter=2024-01-02T12:00:00Z", nil)
+		if stream {
+			req.Header.Set("Accept", ndjsonContentType)
+		}
+		rec := httptest.NewRecorder()
+		api.Router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+		}
+		body := rec.Body.String()
+		if !strings.Contains(body, shopNew.Id.String()) || strings.Contains(body, shopOld.Id.String()) || strings.Contains(body, blogNew.Id.String()) {
+			t.Errorf("expected only the recent task of the shop namespace to be listed (streamed: %v), got %s", stream, body)
+		}
+	}
+
+	for _, query := range []string{"startedAfter=yesterday", "finishedBefore=2024-01-02"} {
+		rec := api.serve(t, http.MethodGet, "/tasks?"+query, nil)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("expected status %d for %s, got %d", http.StatusBadRequest, query, rec.Code)
+		}
+	}
+}
+
+func TestGetTasksPagination(t *testing.T) {
+	m := newTestManager(t)
+	api := newTestApi(m)
+	for i := 0; i < 5; i++ {
+		tk := task.Task{Id: uuid.New(), Name: fmt.Sprintf("task-%d", i), State: task.Running}
+		m.TaskDb.Put(tk.Id, tk)
+	}
+
+	tests := []struct {
+		query string
+		count int
+	}{
+		{"offset=0", 5},
+		{"limit=2", 2},
+		{"limit=2&offset=4", 1},
+		{"limit=2&offset=5", 0},
+		{"offset=10", 0},
+	}
+	seen := make(map[uuid.UUID]bool)
+	for _, test := range tests {
+		rec := api.serve(t, http.MethodGet, "/tasks?"+test.query, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status %d for %s, got %d", http.StatusOK, test.query, rec.Code)
+		}
+		var page TaskPage
+		if err := json.NewDecoder(rec.Body).Decode(&page); err != nil {
+			t.Fatalf("failed to decode page for %s: %v", test.query, err)
+		}
+		if len(page.Items) != test.count || page.Total != 5 {
+			t.Errorf("expected %d of 5 tasks for %s, got %d of %d", test.count, test.query, len(page.Items), page.Total)
+		}
+		if test.query == "limit=2" || test.query == "limit=2&offset=4" {
+			for _, tk := range page.Items {
+				seen[tk.Id] = true
+			}
+		}
+	}
+	// The pages are stable, so consecutive pages don't overlap
+	if len(seen) != 3 {
+		t.Errorf("expected the pages to hold distinct tasks, got %d distinct tasks", len(seen))
+	}
+
+	for _, query := range []string{"offset=-1", "limit=-1", "limit=ten"} {
+		if rec := api.serve(t, http.MethodGet, "/tasks?"+query, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("expected status %d for %s, got %d", http.StatusBadRequest, query, rec.Code)
+		}
+	}
+
+	// Without pagination parameters, the tasks are listed as an array
+	if tasks := listedTasks(t, api, false); len(tasks) != 5 {
+		t.Errorf("expected the 5 tasks to be listed, got %d", len(tasks))
+	}
+}
+
+func TestStartTaskRejectsDuplicateSubmission(t *testing.T) {
+	m := newTestManager(t)
+	api := newTestApi(m)
+	tEvent := newTaskEvent("web")
+
+	if rec := api.serve(t, http.MethodPost, "/tasks", tEvent); rec.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d", http.StatusCreated, rec.Code)
+	}
+	tEvent.Id = uuid.New()
+	if rec := api.serve(t, http.MethodPost, "/tasks", tEvent); rec.Code != http.StatusConflict {
+		t.Errorf("expected status %d for the retried submission, got %d", http.StatusConflict, rec.Code)
+	}
+	if m.Pending.Len() != 1 {
+		t.Errorf("expected a single queued submission, got %d", m.Pending.Len())
+	}
+}
+
+// Store double whose values can't be counted, as when its database is unreachable
+type unreachableStore[TKey comparable, TVal any] struct {
+	store.Store[TKey, TVal]
+}
+
+func (s unreachableStore[TKey, TVal]) Count() (int, error) {
+	return 0, errors.New("database unreachable")
+}
+
+func TestHealthAndReadiness(t *testing.T) {
+	fw := newFakeWorker(t)
+	m := newTestManager(t, fw)
+	api := newTestApi(m)
+	for _, url := range []string{"/health", "/ready"} {
+		if rec := api.serve(t, http.MethodGet, url, nil); rec.Code != http.StatusOK {
+			t.Errorf("expected status %d for %s, got %d", http.StatusOK, url, rec.Code)
+		}
+	}
+
+	takeOffline(m, fw)
+	if rec := api.serve(t, http.MethodGet, "/ready", nil); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected the manager without online node not to be ready, got status %d", rec.Code)
+	}
+}
+
+func TestNotReadyWhenStoreUnreachable(t *testing.T) {
+	m := newTestManager(t, newFakeWorker(t))
+	m.TaskDb = unreachableStore[uuid.UUID, task.Task]{m.TaskDb}
+	api := newTestApi(m)
+
+	if rec := api.serve(t, http.MethodGet, "/ready", nil); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d, got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+	if rec := api.serve(t, http.MethodGet, "/health", nil); rec.Code != http.StatusOK {
+		t.Errorf("expected the manager to stay alive, got status %d", rec.Code)
+	}
+}
@@ -0,0 +1,120 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"orchestrator/node"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// Number of consecutive failed exchanges with a worker node after which it is considered offline
+const nodeOfflineThreshold = 3
+
+// Number of stats retrieval intervals after which the stats of a worker node are too old to schedule tasks on it
+const staleStatsIntervals = 3
+
+var ErrNoOnlineNode = errors.New("no worker node online")
+
+// Check if the manager can schedule tasks, an error is returned when its tasks store is unreachable or when no
+// worker node is online
+func (m *Manager) Ready() error {
+	if _, err := m.TaskDb.Count(); err != nil {
+		return fmt.Errorf("tasks store unreachable: %w", err)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, wNode := range m.WorkerNodes {
+		if wNode.Status == node.Online {
+			return nil
+		}
+	}
+	return ErrNoOnlineNode
+}
+
+// Record a successful exchange with a worker node, an offline node is back online
+func (m *Manager) recordHeartbeat(worker string) {
+	m.mu.Lock()
+	wNode := m.findWorkerNode(worker)
+	if wNode == nil {
+		m.mu.Unlock()
+		return
+	}
+	wasOffline := wNode.Status == node.Offline
+	wNode.Status = node.Online
+	wNode.LastHeartbeat = time.Now().UTC()
+	wNode.FailedChecks = 0
+	m.mu.Unlock()
+
+	if wasOffline {
+		log.Info().Str("node", worker).Msg("node is back online")
+	}
+}
+
+// Record a failed exchange with a worker node
+//
+// Once the node reaches the offline threshold, its tasks are moved to other nodes
+func (m *Manager) recordFailedCheck(worker string) {
+	m.mu.Lock()
+	wNode := m.findWorkerNode(worker)
+	if wNode == nil {
+		m.mu.Unlock()
+		return
+	}
+	wNode.FailedChecks++
+	lastHeartbeat := wNode.LastHeartbeat
+	goesOffline := wNode.Status != node.Offline && wNode.FailedChecks >= nodeOfflineThreshold
+	if goesOffline {
+		wNode.Status = node.Offline
+		wNode.TaskCount = 0
+		wNode.CpuReserved = 0
+	}
+	m.mu.Unlock()
+
+	if goesOffline {
+		rescheduled := m.rescheduleTasksFrom(worker)
+		log.Warn().
+			Str("node", worker).
+			Time("last-heartbeat", lastHeartbeat).
+			Int("rescheduled-tasks", rescheduled).
+			Msg("node is offline")
+	}
+}
+
+// Queue the active tasks assigned to the given worker to be scheduled on other nodes, the tasks which won't
+// run again are released from the worker
+//
+// The number of tasks queued for rescheduling is returned
+func (m *Manager) rescheduleTasksFrom(worker string) int {
+	rescheduled := 0
+	for _, taskId := range m.workerTaskIds(worker) {
+		t, err := m.TaskDb.Get(taskId)
+		if err != nil {
+			log.Err(err).Str("task-id", taskId.String()).Msg("failed to retrieve task from store")
+			continue
+		}
+		if err := m.migrateTask(t); err != nil {
+			// The task won't run again, it no longer needs a node
+			log.Debug().Err(err).Str("task-id", taskId.String()).Msg("task not rescheduled")
+			m.unassignTask(taskId)
+			continue
+		}
+		rescheduled++
+	}
+	return rescheduled
+}
+
+// Stop the container of a task still running on a worker it was moved away from
+func (m *Manager) stopStaleTask(taskId uuid.UUID, worker string) {
+	taskLogger := log.With().
+		Str("task-id", taskId.String()).
+		Str("worker", worker).
+		Logger()
+	if _, err := sendStopRequest(fmt.Sprintf("http://%s/tasks/%v", worker, taskId)); err != nil {
+		taskLogger.Err(err).Msg("failed to stop the container of a moved task")
+		return
+	}
+	taskLogger.Info().Msg("stopped the container of a task moved to another node")
+}
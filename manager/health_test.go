@@ -0,0 +1,92 @@
+package manager
+
+import (
+	"testing"
+
+	"orchestrator/node"
+	"orchestrator/task"
+)
+
+// Fail the stats updates of the given worker until it reaches the offline threshold
+func takeOffline(m *Manager, fw *fakeWorker) {
+	fw.Close()
+	for i := 0; i < nodeOfflineThreshold; i++ {
+		m.updateNodesStats()
+	}
+}
+
+func TestOfflineNodeTasksRescheduledOnOtherNode(t *testing.T) {
+	crashed := newLoadedWorker(t, 1000000)
+	healthy := newLoadedWorker(t, 1000000)
+	m := newTestManager(t, crashed, healthy)
+	m.updateNodesStats()
+	tk := storeAssignedTask(t, m, crashed.addr())
+
+	takeOffline(m, crashed)
+
+	wNode := m.getWorkerNode(crashed.addr())
+	if wNode.Status != node.Offline {
+		t.Fatalf("expected the unreachable node to be offline, got %v", wNode.Status)
+	}
+	if m.Pending.Len() != 1 {
+		t.Fatalf("expected the task of the offline node to be queued, got %d queued events", m.Pending.Len())
+	}
+	tEvent, _ := m.Pending.Pop()
+	m.sendWork(tEvent)
+	if worker, _ := m.taskWorker(tk.Id); worker != healthy.addr() {
+		t.Errorf("expected the task to be moved to %s, got %s", healthy.addr(), worker)
+	}
+	if events := healthy.receivedEvents(); len(events) != 1 || events[0].Task.Id != tk.Id {
+		t.Errorf("expected the task to be started on the healthy node, got %v", events)
+	}
+}
+
+func TestExhaustedFailedTaskStaysFailedWhenNodeOffline(t *testing.T) {
+	crashed := newLoadedWorker(t, 1000000)
+	m := newTestManager(t, crashed, newLoadedWorker(t, 1000000))
+	tk := storeAssignedTask(t, m, crashed.addr())
+	tk.State = task.Failed
+	tk.RestartCount = DefaultMaxRestarts
+	if err := m.TaskDb.Put(tk.Id, tk); err != nil {
+		t.Fatalf("failed to store task: %v", err)
+	}
+
+	takeOffline(m, crashed)
+
+	if m.Pending.Len() != 0 {
+		t.Errorf("expected the failed task not to be rescheduled, got %d queued events", m.Pending.Len())
+	}
+	if stored, _ := m.TaskDb.Get(tk.Id); stored.State != task.Failed {
+		t.Errorf("expected the task to stay failed, got state %v", stored.State)
+	}
+	if _, assigned := m.taskWorker(tk.Id); assigned {
+		t.Error("expected the failed task to be released from the offline node")
+	}
+}
+
+func TestMigrateTaskRefusesTasksWhichWontRunAgain(t *testing.T) {
+	m := newTestManager(t)
+	completed := task.Task{State: task.Completed}
+	exhausted := task.Task{State: task.Failed, RestartCount: 2, MaxRestarts: 2}
+	for _, tk := range []task.Task{completed, exhausted} {
+		if err := m.migrateTask(tk); err == nil {
+			t.Errorf("expected the %v task not to be migrated", tk.State)
+		}
+	}
+	if err := m.migrateTask(task.Task{State: task.Failed, RestartCount: 1, MaxRestarts: 2}); err != nil {
+		t.Errorf("expected the restartable failed task to be migrated, got %v", err)
+	}
+}
+
+func TestNodeBackOnlineAfterHeartbeat(t *testing.T) {
+	fw := newLoadedWorker(t, 1000000)
+	m := newTestManager(t, fw)
+	m.recordFailedCheck(fw.addr())
+	m.recordFailedCheck(fw.addr())
+	m.updateNodesStats()
+
+	wNode := m.getWorkerNode(fw.addr())
+	if wNode.Status != node.Online || wNode.FailedChecks != 0 || wNode.LastHeartbeat.IsZero() {
+		t.Errorf("expected the heartbeat to reset the node health, got %+v", wNode)
+	}
+}
@@ -0,0 +1,261 @@
+package manager
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+
+	"orchestrator/stats"
+	"orchestrator/store"
+	"orchestrator/task"
+	"orchestrator/worker"
+)
+
+func TestMain(m *testing.M) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+	os.Exit(m.Run())
+}
+
+// Store double whose writes block until released, recording when it is closed
+type blockingStore[TKey comparable, TVal any] struct {
+	store.Store[TKey, TVal]
+
+	entered chan struct{} // Receives a value when a write starts
+	release chan struct{} // The writes block until it is closed
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func newBlockingStore[TKey comparable, TVal any](inner store.Store[TKey, TVal]) *blockingStore[TKey, TVal] {
+	return &blockingStore[TKey, TVal]{
+		Store:   inner,
+		entered: make(chan struct{}, 1),
+		release: make(chan struct{}),
+	}
+}
+
+func (s *blockingStore[TKey, TVal]) Put(key TKey, value TVal) error {
+	select {
+	case s.entered <- struct{}{}:
+	default:
+	}
+	<-s.release
+	return s.Store.Put(key, value)
+}
+
+func (s *blockingStore[TKey, TVal]) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	return s.Store.Close()
+}
+
+func (s *blockingStore[TKey, TVal]) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// Worker API double recording the requests it receives
+type fakeWorker struct {
+	*httptest.Server
+
+	mu           sync.Mutex
+	events       []task.TaskEvent
+	stops        []uuid.UUID
+	startStatus  func(tEvent task.TaskEvent) int // Status of the task start responses, 201 when nil
+	stopFailures []int                           // Status codes of the next deletion requests, answered before the deletions are accepted
+	stats        *stats.Stats                    // Stats served to the nodes updates, unavailable when nil
+	statsQueries int                             // Number of stats requests received
+	tasks        []task.Task                     // Tasks reported by the tasks listing
+}
+
+func newFakeWorker(t *testing.T) *fakeWorker {
+	t.Helper()
+	fw := &fakeWorker{}
+	router := chi.NewRouter()
+	router.Post("/tasks", func(w http.ResponseWriter, r *http.Request) {
+		var tEvent task.TaskEvent
+		if err := json.NewDecoder(r.Body).Decode(&tEvent); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fw.mu.Lock()
+		status := http.StatusCreated
+		if fw.startStatus != nil {
+			status = fw.startStatus(tEvent)
+		}
+		if status == http.StatusCreated {
+			fw.events = append(fw.events, tEvent)
+		}
+		fw.mu.Unlock()
+		w.WriteHeader(status)
+		if status != http.StatusCreated {
+			json.NewEncoder(w).Encode(worker.ErrResponse{HTTPStatusCode: status, Message: "start failed"})
+			return
+		}
+		json.NewEncoder(w).Encode(tEvent.Task)
+	})
+	router.Get("/tasks", func(w http.ResponseWriter, r *http.Request) {
+		fw.mu.Lock()
+		defer fw.mu.Unlock()
+		tasks := fw.tasks
+		if tasks == nil {
+			tasks = []task.Task{}
+		}
+		json.NewEncoder(w).Encode(tasks)
+	})
+	router.Delete("/tasks/{taskId}", func(w http.ResponseWriter, r *http.Request) {
+		fw.mu.Lock()
+		defer fw.mu.Unlock()
+		if len(fw.stopFailures) != 0 {
+			w.WriteHeader(fw.stopFailures[0])
+			fw.stopFailures = fw.stopFailures[1:]
+			return
+		}
+		fw.stops = append(fw.stops, uuid.MustParse(chi.URLParam(r, "taskId")))
+		w.WriteHeader(http.StatusNoContent)
+	})
+	router.Get("/tasks/{taskId}/logs", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "logs of %s %s\n", chi.URLParam(r, "taskId"), r.URL.RawQuery)
+	})
+	router.Get("/tasks/{taskId}/stats", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(worker.TaskStats{TaskId: uuid.MustParse(chi.URLParam(r, "taskId")), ContainerId: "container-1", CpuPercent: 12.5})
+	})
+	router.Get("/logs", func(w http.ResponseWriter, r *http.Request) {
+		// Echo the query so that the forwarded parameters can be checked
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		fmt.Fprintf(w, "%s\n", r.URL.RawQuery)
+	})
+	router.Get("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		fw.mu.Lock()
+		defer fw.mu.Unlock()
+		fw.statsQueries++
+		if fw.stats == nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(fw.stats)
+	})
+	fw.Server = httptest.NewServer(router)
+	t.Cleanup(fw.Close)
+	return fw
+}
+
+// Get the address of the worker, as registered on the manager
+func (fw *fakeWorker) addr() string {
+	return strings.TrimPrefix(fw.URL, "http://")
+}
+
+func (fw *fakeWorker) receivedEvents() []task.TaskEvent {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	return append([]task.TaskEvent(nil), fw.events...)
+}
+
+func (fw *fakeWorker) receivedStops() []uuid.UUID {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	return append([]uuid.UUID(nil), fw.stops...)
+}
+
+// Create a manager using the memory stores and the given workers
+func newTestManager(t *testing.T, workers ...*fakeWorker) *Manager {
+	t.Helper()
+	addrs := make([]string, len(workers))
+	for i, fw := range workers {
+		addrs[i] = fw.addr()
+	}
+	m, err := New(addrs, "roundrobin", "memory", Config{MaxRestarts: DefaultMaxRestarts})
+	if err != nil {
+		t.Fatalf("failed to create manager: %v", err)
+	}
+	// The fake workers don't serve stats unless asked to, their nodes are considered up to date
+	for _, n := range m.WorkerNodes {
+		n.LastStatsUpdate = time.Now().UTC()
+	}
+	t.Cleanup(func() { m.Close() })
+	return m
+}
+
+// Create the API of the manager, with its router initialized
+func newTestApi(m *Manager) *Api {
+	api := &Api{Manager: m}
+	api.initRouter()
+	return api
+}
+
+// Send a request to the API router, the body is encoded as JSON when it isn't nil
+func (a *Api) serve(t *testing.T, method string, url string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	var reader *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("failed to encode request body: %v", err)
+		}
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req := httptest.NewRequest(method, url, reader)
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	a.Router.ServeHTTP(rec, req)
+	return rec
+}
+
+// Create a scheduling event of a new task with the given name, in the default namespace
+func newTaskEvent(name string) task.TaskEvent {
+	return task.TaskEvent{
+		Id:        uuid.New(),
+		State:     task.Scheduled,
+		Timestamp: time.Now().UTC(),
+		Task: task.Task{
+			Id:        uuid.New(),
+			Name:      name,
+			Namespace: "default",
+			Image:     "nginx",
+			State:     task.Pending,
+		},
+	}
+}
+
+// Wait until the condition is true, failing the test after a few seconds
+func waitFor(t *testing.T, description string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", description)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Get the number of stats requests received
+func (fw *fakeWorker) statsRequests() int {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	return fw.statsQueries
+}
+
+// Send the queued tasks events to the workers, as the tasks processing loop does
+func processPending(m *Manager) {
+	for m.Pending.Len() != 0 {
+		tEvent, _ := m.Pending.Pop()
+		m.sendWork(tEvent)
+	}
+}
@@ -2,12 +2,20 @@ package manager
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math/rand"
+	"net"
 	"net/http"
+	"sort"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 
 	"orchestrator/node"
@@ -17,23 +25,118 @@ import (
 	"orchestrator/worker"
 )
 
+// Default maximum number of restarts of a failed task without its own limit
+const DefaultMaxRestarts = 3
+
+// Default interval of the tasks health checks, tasks state and nodes stats retrieval loops
+const DefaultLoopInterval = 10 * time.Second
+
+const (
+	stopTaskMaxAttempts   = 3           // Maximum number of task deletion requests sent to a worker
+	startingStopAttempts  = 6           // Maximum number of deletion requests of a task just sent to a worker, which may not be stored yet
+	stopTaskRetryDelay    = time.Second // Delay before the first task deletion retry, doubled after each attempt
+	eventsCleanupInterval = time.Minute // Interval between expired task events cleanups
+	schedulingRetryDelay  = time.Second // Delay before enqueuing again a task which couldn't be placed, doubled after each attempt
+	schedulingMaxDelay    = time.Minute // Maximum delay between two placement attempts of a task
+	tasksListCacheTtl     = time.Second // Duration the persisted tasks list is kept in memory, it is dropped on each task write
+)
+
+var (
+	errWorkerUnreachable = errors.New("worker unreachable")
+	errSubmissionFailed  = errors.New("task submission to worker failed")
+	errTaskNotFound      = errors.New("task not found on worker")
+	errNoCandidate       = errors.New("no available candidates")
+	errTaskNotRunnable   = errors.New("task is completed or can't be restarted")
+	ErrNameConflict      = errors.New("name already used in namespace")
+	ErrTerminalState     = errors.New("task is in a terminal state")
+	ErrInvalidTransition = errors.New("invalid state transition")
+	ErrTaskSubmitted     = errors.New("task already submitted")
+	ErrWatchDisabled     = errors.New("tasks store doesn't support watching")
+)
+
 // Manager sends requests of task creation or deletion to workers
 // and keeps track of sent tasks with their state
 type Manager struct {
-	Pending       chan task.TaskEvent
+	Pending       *TaskQueue
 	TaskDb        store.Store[uuid.UUID, task.Task]
 	EventDb       store.Store[uuid.UUID, task.TaskEvent]
-	Workers       []string
-	WorkerNodes   []*node.Node
-	WorkerTaskMap map[string][]uuid.UUID
-	TaskWorkerMap map[uuid.UUID]string
+	GroupDb       store.Store[uuid.UUID, task.TaskGroup]
+	AssignmentDb  store.Store[uuid.UUID, Assignment] // Persisted copy of TaskWorkerMap, written along with it
+	Workers       []string                           // Guarded by mu
+	WorkerNodes   []*node.Node                       // Guarded by mu, along with the nodes reservation counters and draining flag
+	WorkerTaskMap map[string][]uuid.UUID             // Guarded by mu
+	TaskWorkerMap map[uuid.UUID]string               // Guarded by mu
 	Scheduler     scheduler.Scheduler
+	Metrics       *Metrics
+	Prometheus    *PrometheusMetrics
+	StartTime     time.Time
+	Config        Config
+
+	mu                 sync.RWMutex      // Protects the tasks assignments and the worker nodes
+	schedulerMu        sync.Mutex        // Serializes the scheduler decisions, the schedulers aren't safe for concurrent use
+	schedulingAttempts map[uuid.UUID]int // Failed placement attempts of the tasks waiting for capacity
+	restartLimiter     rateLimiter       // Cap of the failed tasks restarts issued by the health checks
+	snapshotMu         sync.Mutex        // Serializes the snapshots writing and pruning
+	submissions        sync.Map          // Queued *submission of each new task by task id, so that a task isn't started twice
+	submitMu           sync.Mutex        // Serializes the submissions, so that a task name is checked and reserved at once
+	scaleMu            sync.Mutex        // Serializes the replicas scaling, so that the replica indexes aren't used twice
+	registryAuths      sync.Map          // Registry credentials supplied with the tasks by task id, kept in memory only
+	stops              sync.Map          // Stop in progress of each task by task id, its channel is closed once it is done
+
+	loopsCtx context.Context    // Context of the background loops, cancelled to stop them
+	stop     context.CancelFunc // Cancels the background loops context
+	loops    sync.WaitGroup     // Running background loops
+}
+
+// Submission of a new task waiting to be placed on a node
+type submission struct {
+	eventId uuid.UUID
+	task    task.Task
 }
 
-// Create a new manager with a collection of workers, a scheduler type and a data store type
+// Manager tuning options
+type Config struct {
+	Headroom               float64                      // Minimum percentage of free CPU, memory and disk to preserve on nodes when scheduling
+	EpvmCpuWeight          float64                      // Factor of the CPU cost in the EPVM scheduler score, scheduler.DefaultEpvmWeight when 0
+	EpvmMemoryWeight       float64                      // Factor of the memory cost in the EPVM scheduler score, scheduler.DefaultEpvmWeight when 0
+	WorkerMetricsPort      int                          // Port of the workers metrics route, when it isn't served on their main API port
+	EventRetention         time.Duration                // Age after which stored task events are deleted, 0 to keep them forever
+	NodeLimits             map[string]NodeLimits        // Hard resource limits of worker nodes, by worker address
+	NodeLabels             map[string]map[string]string // Labels of worker nodes matched by the tasks node selector, by worker address
+	RegistryCredentials    map[string]task.RegistryAuth // Credentials of the private registries by host, sent to the workers along with the tasks
+	DataDir                string                       // Directory where the cluster snapshots are written
+	SnapshotInterval       time.Duration                // Interval between cluster snapshots, 0 to disable the periodic snapshots
+	SnapshotRetention      int                          // Number of snapshot files to keep, 0 to keep all of them
+	ReconcileInterval      time.Duration                // Interval between tasks assignments rebuilds from the workers, 0 to disable the periodic reconciliation
+	DefaultCpu             float64                      // CPUs requested by tasks which don't specify it
+	DefaultMemory          int64                        // Memory in bytes requested by tasks which don't specify it
+	DefaultDisk            int64                        // Disk in bytes requested by tasks which don't specify it
+	PurgeStoppedTasks      bool                         // Delete the tasks from the store once their container is stopped
+	MaxRestartsPerInterval int                          // Maximum number of failed tasks restarts per interval, 0 for no limit
+	RestartRateInterval    time.Duration                // Interval of the restarts rate limit
+	MaxRestarts            int                          // Maximum number of restarts of a failed task without its own limit
+	HealthCheckInterval    time.Duration                // Interval between tasks health checks, DefaultLoopInterval when 0
+	UpdateInterval         time.Duration                // Interval between tasks state retrievals from the workers, DefaultLoopInterval when 0
+	StatsInterval          time.Duration                // Interval between worker nodes stats retrievals, DefaultLoopInterval when 0
+	StatsTimeout           time.Duration                // Maximum duration of a worker node stats retrieval, node.DefaultStatsTimeout when 0
+}
+
+// Hard resource limits of a worker node, used by the scheduler whatever the stats reported by the worker
+type NodeLimits struct {
+	MaxCpu    float64 // Number of CPUs reservable by tasks
+	MaxMemory int64   // Memory capacity in KB
+	MaxDisk   int64   // Disk capacity in bytes
+}
+
+// Create a new manager with a collection of workers, a scheduler type, a data store type and tuning options
 //
 // The Close method should be called when the manager is no longer used
-func New(workers []string, schedulerType string, storeType string) (*Manager, error) {
+func New(workers []string, schedulerType string, storeType string, config Config) (*Manager, error) {
+	for _, interval := range []*time.Duration{&config.HealthCheckInterval, &config.UpdateInterval, &config.StatsInterval} {
+		if *interval <= 0 {
+			*interval = DefaultLoopInterval
+		}
+	}
 	workerTaskMap := make(map[string][]uuid.UUID)
 	nodes := make([]*node.Node, len(workers))
 	for i, worker := range workers {
@@ -41,59 +144,210 @@ func New(workers []string, schedulerType string, storeType string) (*Manager, er
 
 		nodeApi := fmt.Sprintf("http://%s", worker)
 		newNode := node.NewNode(worker, nodeApi, "worker")
+		newNode.StatsTimeout = config.StatsTimeout
+		if config.WorkerMetricsPort != 0 {
+			host, _, err := net.SplitHostPort(worker)
+			if err != nil {
+				return nil, fmt.Errorf("invalid worker address %s: %w", worker, err)
+			}
+			newNode.MetricsApi = fmt.Sprintf("http://%s", net.JoinHostPort(host, strconv.Itoa(config.WorkerMetricsPort)))
+		}
+		if limits, found := config.NodeLimits[worker]; found {
+			newNode.MaxCpu = limits.MaxCpu
+			newNode.MaxMemory = limits.MaxMemory
+			newNode.MaxDisk = limits.MaxDisk
+		}
+		newNode.Labels = config.NodeLabels[worker]
 		nodes[i] = &newNode
 	}
+	for worker := range config.NodeLimits {
+		if _, found := workerTaskMap[worker]; !found {
+			return nil, fmt.Errorf("limits are defined for unknown worker %s", worker)
+		}
+	}
+	for worker := range config.NodeLabels {
+		if _, found := workerTaskMap[worker]; !found {
+			return nil, fmt.Errorf("labels are defined for unknown worker %s", worker)
+		}
+	}
 
 	var sched scheduler.Scheduler
 	switch schedulerType {
 	case "roundrobin":
 		sched = &scheduler.RoundRobin{}
 	case "epvm":
-		sched = &scheduler.Epvm{}
+		sched = &scheduler.Epvm{
+			Headroom:     config.Headroom,
+			CpuWeight:    config.EpvmCpuWeight,
+			MemoryWeight: config.EpvmMemoryWeight,
+		}
+	case "binpacking":
+		sched = &scheduler.BinPacking{}
+	case "random":
+		sched = scheduler.NewRandom(rand.NewSource(time.Now().UnixNano()))
 	default:
 		return nil, fmt.Errorf("unsupported scheduler type: %s", schedulerType)
 	}
 
 	var taskDb store.Store[uuid.UUID, task.Task]
 	var taskEventDb store.Store[uuid.UUID, task.TaskEvent]
+	var groupDb store.Store[uuid.UUID, task.TaskGroup]
+	var assignmentDb store.Store[uuid.UUID, Assignment]
 	switch storeType {
 	case "memory":
 		taskDb = store.NewMemoryStore[uuid.UUID, task.Task]()
 		taskEventDb = store.NewMemoryStore[uuid.UUID, task.TaskEvent]()
+		groupDb = store.NewMemoryStore[uuid.UUID, task.TaskGroup]()
+		assignmentDb = store.NewMemoryStore[uuid.UUID, Assignment]()
 	case "persisted":
-		var err error
-		taskDb, err = store.NewPersistedStore[uuid.UUID, task.Task]("manager_tasks.db", 0600, "tasks")
+		tasksStore, err := store.NewPersistedStore[uuid.UUID, task.Task](tasksDbFile, 0600, "tasks")
+		if err != nil {
+			return nil, err
+		}
+		if err := tasksStore.SetSchema(task.SchemaVersion, task.Migrations); err != nil {
+			tasksStore.Close()
+			return nil, err
+		}
+		assignmentDb, err = store.NewPersistedStoreFromDb[uuid.UUID, Assignment](tasksStore.Db, "assignments")
 		if err != nil {
+			tasksStore.Close()
 			return nil, err
 		}
-		taskEventDb, err = store.NewPersistedStore[uuid.UUID, task.TaskEvent]("manager_task_events.db", 0600, "taskEvents")
+		// The tasks list is read by every API listing, avoid reading the whole bucket each time
+		taskDb = store.NewCachedStore(tasksStore, tasksListCacheTtl)
+		taskEventDb, err = store.NewPersistedStore[uuid.UUID, task.TaskEvent](eventsDbFile, 0600, "taskEvents")
 		if err != nil {
 			return nil, err
 		}
+		groupDb, err = store.NewPersistedStore[uuid.UUID, task.TaskGroup](groupsDbFile, 0600, "groups")
+		if err != nil {
+			return nil, err
+		}
+	case "sqlite":
+		tasksStore, err := store.NewSqliteStore[uuid.UUID, task.Task]("manager.sqlite", "tasks")
+		if err != nil {
+			return nil, err
+		}
+		taskDb = store.NewCachedStore[uuid.UUID, task.Task](tasksStore, tasksListCacheTtl)
+		assignmentDb, err = store.NewSqliteStoreFromDb[uuid.UUID, Assignment](tasksStore.Db, "assignments")
+		if err != nil {
+			tasksStore.Close()
+			return nil, err
+		}
+		taskEventDb, err = store.NewSqliteStoreFromDb[uuid.UUID, task.TaskEvent](tasksStore.Db, "taskEvents")
+		if err != nil {
+			tasksStore.Close()
+			return nil, err
+		}
+		groupDb, err = store.NewSqliteStoreFromDb[uuid.UUID, task.TaskGroup](tasksStore.Db, "groups")
+		if err != nil {
+			tasksStore.Close()
+			return nil, err
+		}
 	default:
 		return nil, fmt.Errorf("unsupported store type: %s", storeType)
 	}
+	if _, ok := taskDb.(store.Watcher[uuid.UUID, task.Task]); !ok {
+		// The tasks changes are streamed to the API clients whatever the store type
+		taskDb = store.NewWatchedStore(taskDb)
+	}
 
-	return &Manager{
-		Pending:       make(chan task.TaskEvent, 10),
+	ctx, stop := context.WithCancel(context.Background())
+	m := &Manager{
+		Pending:       NewTaskQueue(),
 		Workers:       workers,
 		WorkerNodes:   nodes,
 		TaskDb:        taskDb,
 		EventDb:       taskEventDb,
+		GroupDb:       groupDb,
+		AssignmentDb:  assignmentDb,
 		WorkerTaskMap: workerTaskMap,
 		TaskWorkerMap: make(map[uuid.UUID]string),
 		Scheduler:     sched,
-	}, nil
+		Metrics:       &Metrics{},
+		StartTime:     time.Now().UTC(),
+		Config:        config,
+		loopsCtx:      ctx,
+		stop:          stop,
+
+		schedulingAttempts: make(map[uuid.UUID]int),
+		restartLimiter:     rateLimiter{limit: config.MaxRestartsPerInterval, interval: config.RestartRateInterval},
+	}
+	if err := m.restoreAssignments(); err != nil {
+		m.Close()
+		return nil, err
+	}
+	m.Prometheus = newPrometheusMetrics(m)
+	return m, nil
+}
+
+// Subscribe to the tasks changes written to the store, until the context is done
+func (m *Manager) WatchTasks(ctx context.Context) (<-chan store.ChangeEvent[uuid.UUID, task.Task], error) {
+	watcher, ok := m.TaskDb.(store.Watcher[uuid.UUID, task.Task])
+	if !ok {
+		return nil, ErrWatchDisabled
+	}
+	return watcher.Watch(ctx)
+}
+
+// Start the background loops: tasks processing, tasks state and health monitoring, nodes stats retrieval
+// and expired events cleanup
+//
+// The loops run until Shutdown is called
+func (m *Manager) Start() {
+	for _, loop := range []func(context.Context){m.ProcessTasks, m.UpdateTasks, m.CheckTasksHealth, m.CheckNodesStats, m.CleanupEvents, m.SnapshotState, m.ReconcileTasks} {
+		m.loops.Add(1)
+		go func(loop func(context.Context)) {
+			defer m.loops.Done()
+			loop(m.loopsCtx)
+		}(loop)
+	}
+}
+
+// Stop the manager in order: the API server stops accepting requests, the background loops are drained,
+// then the stores are closed
+//
+// The API server is optional. The context bounds the wait for the in-flight requests and the background loops,
+// the stores are left open if the loops didn't return in time
+func (m *Manager) Shutdown(ctx context.Context, api *Api) error {
+	if api != nil {
+		if err := api.Shutdown(ctx); err != nil {
+			return fmt.Errorf("failed to shut down api server: %w", err)
+		}
+	}
+
+	m.stop()
+	loopsDone := make(chan struct{})
+	go func() {
+		m.loops.Wait()
+		close(loopsDone)
+	}()
+	select {
+	case <-loopsDone:
+	case <-ctx.Done():
+		return fmt.Errorf("background loops didn't stop: %w", ctx.Err())
+	}
+
+	return m.Close()
 }
 
 // Cleanup the manager's resources
 func (m *Manager) Close() error {
+	m.Pending.Close()
 	err1 := m.TaskDb.Close()
 	err2 := m.EventDb.Close()
+	err3 := m.GroupDb.Close()
+	err4 := m.AssignmentDb.Close()
 	if err1 != nil {
 		return err1
 	}
-	return err2
+	if err2 != nil {
+		return err2
+	}
+	if err3 != nil {
+		return err3
+	}
+	return err4
 }
 
 // Retrieve all stored tasks
@@ -106,21 +360,131 @@ func (m *Manager) GetTasks() []task.Task {
 	return tasks
 }
 
+// Check that no active task of the namespace uses the name of the given task, including the submitted tasks
+// waiting to be placed on a node
+//
+// Names of completed tasks and of failed tasks which won't be restarted can be reused
+func (m *Manager) CheckTaskName(t task.Task) error {
+	for _, submitted := range m.submittedTasks() {
+		if submitted.Id != t.Id && submitted.Namespace == t.Namespace && submitted.Name == t.Name {
+			return fmt.Errorf("%w: submitted task %v is named %q in namespace %q", ErrNameConflict, submitted.Id, t.Name, t.Namespace)
+		}
+	}
+	return m.TaskDb.ForEach(func(existing task.Task) error {
+		if existing.Id == t.Id || existing.Namespace != t.Namespace || existing.Name != t.Name {
+			return nil
+		}
+		if !m.isRunnable(existing) {
+			return nil
+		}
+		return fmt.Errorf("%w: task %v is named %q in namespace %q", ErrNameConflict, existing.Id, t.Name, t.Namespace)
+	})
+}
+
+// Add a new task to the pending queue, unless a task with the same id is already queued or assigned to a worker
+//
+// ErrTaskSubmitted is returned for a duplicate submission, such as a client retrying its request. The task id
+// is held until the task is placed on a node or its scheduling is abandoned. The task name is checked along
+// with the id, an error wrapping ErrNameConflict is returned when an active task already uses it
+func (m *Manager) SubmitTask(tEvent task.TaskEvent) error {
+	return m.SubmitTasks([]task.TaskEvent{tEvent})
+}
+
+// Add new tasks to the pending queue, either all of them or none
+//
+// Each task is checked as by SubmitTask, no task is queued when one of them is rejected
+func (m *Manager) SubmitTasks(tEvents []task.TaskEvent) error {
+	m.submitMu.Lock()
+	defer m.submitMu.Unlock()
+	if err := m.reserveSubmissions(tEvents); err != nil {
+		return err
+	}
+	for _, tEvent := range tEvents {
+		m.AddTask(tEvent)
+	}
+	return nil
+}
+
+// Hold the ids and the names of submitted tasks, either all of them or none, the caller must hold the submissions
+// lock
+func (m *Manager) reserveSubmissions(tEvents []task.TaskEvent) error {
+	for i, tEvent := range tEvents {
+		if err := m.reserveSubmission(tEvent); err != nil {
+			for _, reserved := range tEvents[:i] {
+				m.releaseSubmission(reserved)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Hold the id and the name of a submitted task, the caller must hold the submissions lock
+func (m *Manager) reserveSubmission(tEvent task.TaskEvent) error {
+	if _, loaded := m.submissions.LoadOrStore(tEvent.Task.Id, &submission{eventId: tEvent.Id, task: tEvent.Task}); loaded {
+		return fmt.Errorf("%w: task %v is waiting to be scheduled", ErrTaskSubmitted, tEvent.Task.Id)
+	}
+	// Checked once the id is held, the assignment of a previous submission is done before its release
+	if worker, found := m.taskWorker(tEvent.Task.Id); found {
+		m.releaseSubmission(tEvent)
+		return fmt.Errorf("%w: task %v is assigned to worker %s", ErrTaskSubmitted, tEvent.Task.Id, worker)
+	}
+	if err := m.CheckTaskName(tEvent.Task); err != nil {
+		m.releaseSubmission(tEvent)
+		return err
+	}
+	return nil
+}
+
+// Release the task id held by a submission, unless it is held by another submission of the same task
+func (m *Manager) releaseSubmission(tEvent task.TaskEvent) {
+	held, found := m.submissions.Load(tEvent.Task.Id)
+	if found && held.(*submission).eventId == tEvent.Id {
+		m.submissions.CompareAndDelete(tEvent.Task.Id, held)
+	}
+}
+
+// Get the tasks of the submissions waiting to be placed on a node
+func (m *Manager) submittedTasks() []task.Task {
+	tasks := []task.Task{}
+	m.submissions.Range(func(_, held any) bool {
+		tasks = append(tasks, held.(*submission).task)
+		return true
+	})
+	return tasks
+}
+
+// Set the default resources requests on a submitted task which doesn't specify them
+//
+// Values set on the task always take precedence over the manager defaults
+func (m *Manager) ApplyDefaultResources(t *task.Task) {
+	if t.Cpu == 0 {
+		t.Cpu = m.Config.DefaultCpu
+	}
+	if t.Memory == 0 {
+		t.Memory = m.Config.DefaultMemory
+	}
+	if t.Disk == 0 {
+		t.Disk = m.Config.DefaultDisk
+	}
+}
+
 // Add a task to the pending queue
 func (m *Manager) AddTask(tEvent task.TaskEvent) {
-	// Run inside a goroutine to avoid blocking API call if chan is full
-	go func() {
-		m.Pending <- tEvent
-	}()
+	m.Pending.Push(tEvent)
 }
 
-// Start the pending tasks execution loop
-func (m *Manager) ProcessTasks() {
+// Start the pending tasks execution loop, tasks with the highest priority are processed first
+//
+// It returns once the context is cancelled
+func (m *Manager) ProcessTasks(ctx context.Context) {
 	log.Debug().Msg("starting queued tasks processing")
+	stop := context.AfterFunc(ctx, m.Pending.Close)
+	defer stop()
 	for {
-		t, ok := <-m.Pending
+		t, ok := m.Pending.Pop()
 		if !ok {
-			log.Debug().Msg("tasks channel closed, stop processing")
+			log.Debug().Msg("tasks queue closed, stop processing")
 			return
 		}
 
@@ -128,40 +492,65 @@ func (m *Manager) ProcessTasks() {
 	}
 }
 
-// Start the task health monitoring execution loop
-func (m *Manager) CheckTasksHealth() {
-	for {
+// Start the task health monitoring execution loop, it returns once the context is cancelled
+func (m *Manager) CheckTasksHealth(ctx context.Context) {
+	runEvery(ctx, m.Config.HealthCheckInterval, func() {
 		log.Debug().Msg("checking tasks health")
 		m.checkTasksHealth()
 		log.Debug().Msg("tasks health check completed")
-		time.Sleep(10 * time.Second)
-	}
+	})
 }
 
-// Start the task state monitoring execution loop
-func (m *Manager) UpdateTasks() {
-	for {
+// Start the task state monitoring execution loop, it returns once the context is cancelled
+func (m *Manager) UpdateTasks(ctx context.Context) {
+	runEvery(ctx, m.Config.UpdateInterval, func() {
 		log.Debug().Msg("checking for workers' tasks update")
 		m.updateTasks()
 		log.Debug().Msg("tasks update completed")
-		time.Sleep(10 * time.Second)
-	}
+	})
 }
 
-// Start the worker nodes stats retrieval execution loop
-func (m *Manager) CheckNodesStats() {
-	for {
+// Start the worker nodes stats retrieval execution loop, it returns once the context is cancelled
+func (m *Manager) CheckNodesStats(ctx context.Context) {
+	runEvery(ctx, m.Config.StatsInterval, func() {
 		log.Debug().Msg("checking nodes stats")
 		m.updateNodesStats()
 		log.Debug().Msg("nodes stats retrieval completed")
-		time.Sleep(10 * time.Second)
+	})
+}
+
+// Start the expired task events cleanup loop, it returns once the context is cancelled
+//
+// It returns immediately if events retention is disabled
+func (m *Manager) CleanupEvents(ctx context.Context) {
+	if m.Config.EventRetention <= 0 {
+		return
+	}
+	runEvery(ctx, eventsCleanupInterval, func() {
+		log.Debug().Msg("cleaning up expired task events")
+		m.cleanupEvents()
+		log.Debug().Msg("task events cleanup completed")
+	})
+}
+
+// Run the function right away then at each interval, until the context is cancelled
+func runEvery(ctx context.Context, interval time.Duration, fn func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		fn()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
 	}
 }
 
 // Process the next pending task,
 // send the action to the most adequate worker
 func (m *Manager) sendWork(tEvent task.TaskEvent) {
-	if err := m.EventDb.Put(tEvent.Id, tEvent); err != nil {
+	if err := m.EventDb.Put(tEvent.Id, tEvent.WithoutRegistryAuth()); err != nil {
 		log.Err(err).Msg("failed to store dequeued task event")
 	}
 
@@ -170,111 +559,179 @@ func (m *Manager) sendWork(tEvent task.TaskEvent) {
 		Logger()
 	taskLogger.Debug().Msg("starting task processing")
 
+	// The submission is released once processed, unless it is queued again to be retried
+	requeued := false
+	defer func() {
+		if !requeued {
+			m.releaseSubmission(tEvent)
+		}
+	}()
+
 	// Try to find if the task is already managed by a specific worker
-	taskWorker, found := m.TaskWorkerMap[tEvent.Id]
+	taskWorker, found := m.taskWorker(tEvent.Task.Id)
 	if found {
 		persistedTask, err := m.TaskDb.Get(tEvent.Task.Id)
 		if err != nil {
 			taskLogger.Err(err).Msg("failed to retrieve task from store")
 			return
 		}
-		if tEvent.State != task.Completed {
+		if tEvent.State != task.Completed && tEvent.State != task.Scheduled {
 			taskLogger.Error().
 				Str("target-state", fmt.Sprintf("%v", tEvent.State)).
-				Msg("invalid request: can't request other state transition than 'completed' for an existing task")
+				Msg("invalid request: can't request other state transition than 'scheduled' or 'completed' for an existing task")
 			return
 		}
-		if task.ValidStateTransition(persistedTask.State, tEvent.State) {
-			m.stopTask(tEvent.Task.Id, taskWorker)
-		} else {
+		if !task.ValidStateTransition(persistedTask.State, tEvent.State) {
 			taskLogger.Error().
 				Str("initial-state", fmt.Sprintf("%v", persistedTask.State)).
-				Msg("invalid request: forbidden state transition to 'completed'")
+				Str("target-state", fmt.Sprintf("%v", tEvent.State)).
+				Msg("invalid request: forbidden state transition")
+			return
+		}
+		if tEvent.State == task.Completed {
+			// The stop retries don't hold the tasks queue
+			m.requestStop(tEvent.Task.Id, taskWorker, false)
+		} else {
+			m.restartTask(persistedTask)
 		}
 		return
 	}
+	if m.skipUnassigned(tEvent) {
+		return
+	}
+
+	err := m.scheduleTask(tEvent)
+	switch {
+	case err == nil:
+		delete(m.schedulingAttempts, tEvent.Task.Id)
+		m.Prometheus.tasksScheduled.Inc()
+	case errors.Is(err, errWorkerUnreachable), errors.Is(err, errSubmissionFailed):
+		taskLogger.Err(err).Msg("failed to schedule task")
+		requeued = true
+		m.AddTask(tEvent) // Try again
+	case errors.Is(err, errNoCandidate):
+		requeued = true
+		m.retryScheduling(tEvent, err)
+	default:
+		taskLogger.Err(err).Msg("failed to schedule task")
+	}
+}
+
+// Enqueue again a task which couldn't be placed on a node, after a delay increasing with each attempt
+//
+// The task will be placed once capacity frees up
+func (m *Manager) retryScheduling(tEvent task.TaskEvent, err error) {
+	attempts := m.schedulingAttempts[tEvent.Task.Id] + 1
+	m.schedulingAttempts[tEvent.Task.Id] = attempts
+
+	delay := schedulingRetryDelay
+	for i := 1; i < attempts && delay < schedulingMaxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, schedulingMaxDelay)
 
+	log.Err(err).
+		Str("task-id", tEvent.Task.Id.String()).
+		Int("attempt", attempts).
+		Dur("retry-delay", delay).
+		Msg("failed to schedule task, retrying later")
+	time.AfterFunc(delay, func() {
+		m.AddTask(tEvent)
+	})
+}
+
+// Select a worker to execute a new task and submit the task to it
+//
+// When errWorkerUnreachable or errSubmissionFailed is returned, the task is left unassigned so that it can be
+// submitted again
+func (m *Manager) scheduleTask(tEvent task.TaskEvent) (err error) {
 	wNode, err := m.selectWorker(tEvent.Task)
+	m.Prometheus.observeSchedulerDecision(err)
 	if err != nil {
-		taskLogger.Err(err).Msg("failed to select a worker to execute task")
-		return
+		return fmt.Errorf("failed to select a worker to execute task: %w", err)
 	}
 
-	m.WorkerTaskMap[wNode.Name] = append(m.WorkerTaskMap[wNode.Name], tEvent.Task.Id)
-	m.TaskWorkerMap[tEvent.Task.Id] = wNode.Name
+	if tEvent.Task.RegistryAuth != nil {
+		m.registryAuths.Store(tEvent.Task.Id, *tEvent.Task.RegistryAuth)
+	}
+	tEvent.RegistryAuth = m.registryAuth(tEvent.Task)
+	tEvent.Task.RegistryAuth = nil
+
+	m.assignTask(tEvent.Task.Id, wNode.Name)
+	defer func() {
+		if err != nil {
+			m.unassignTask(tEvent.Task.Id)
+		}
+	}()
 	if err = m.TaskDb.Put(tEvent.Task.Id, tEvent.Task); err != nil {
-		taskLogger.Err(err).Msg("failed to store task")
-		return
+		return fmt.Errorf("%w: failed to store task: %v", errSubmissionFailed, err)
 	}
 
 	jsonTaskEvent, err := json.Marshal(tEvent)
 	if err != nil {
-		taskLogger.Err(err).
-			Interface("task-event", tEvent).
-			Msg("failed to marshal task event")
-		return
+		return fmt.Errorf("failed to marshal task event: %w", err)
 	}
 
 	url := fmt.Sprintf("%s/tasks", wNode.Api)
 	response, err := http.Post(url, "application/json", bytes.NewBuffer(jsonTaskEvent))
 	if err != nil {
-		taskLogger.Err(err).
-			Str("node", wNode.Name).
-			Str("url", url).
-			Msg("failed to send post request")
-		m.AddTask(tEvent) // Try again
-		return
+		return fmt.Errorf("%w: node %s, url %s: %v", errWorkerUnreachable, wNode.Name, url, err)
 	}
 	defer response.Body.Close()
 
 	decoder := json.NewDecoder(response.Body)
 	if response.StatusCode != http.StatusCreated {
 		e := worker.ErrResponse{}
-		err = decoder.Decode(&e)
-		if err != nil {
-			taskLogger.Err(err).Msg("failed to decode error message")
-			return
+		if decodeErr := decoder.Decode(&e); decodeErr != nil {
+			return fmt.Errorf("%w: received an unexpected response code from worker %s: %d", errSubmissionFailed, wNode.Name, response.StatusCode)
 		}
-		taskLogger.Error().
-			Int("status-code", response.StatusCode).
-			Str("node", wNode.Name).
-			Str("url", url).
-			Str("message", e.Message).
-			Msg("received an unexpected response code from worker")
-		return
+		return fmt.Errorf("%w: received an unexpected response code from worker %s: %d, message: %s", errSubmissionFailed, wNode.Name, response.StatusCode, e.Message)
 	}
 
 	t := task.Task{}
 	err = decoder.Decode(&t)
 	if err != nil {
-		taskLogger.Err(err).Msg("error decoding task reponse")
-	} else {
-		wNode.TaskCount++
+		return fmt.Errorf("%w: error decoding task reponse: %v", errSubmissionFailed, err)
 	}
+
+	m.reserveOnNode(wNode.Name, 1, tEvent.Task.Cpu)
+	return nil
 }
 
 // Update machine stats for all registered worker nodes
 func (m *Manager) updateNodesStats() {
-	for _, node := range m.WorkerNodes {
-		err := node.UpdateStats()
+	for _, node := range m.nodes() {
+		// Retrieved without the lock, the node name and API aren't written once the node is registered
+		nodeStats, err := node.FetchStats(context.Background())
 		if err != nil {
 			log.Err(err).Str("node", node.Name).Msg("failed to update node stats")
+			m.recordFailedCheck(node.Name)
+			continue
 		}
+		m.mu.Lock()
+		node.ApplyStats(nodeStats)
+		m.mu.Unlock()
+		m.recordHeartbeat(node.Name)
 	}
 }
 
 // Retrieve and update tasks state from workers
 func (m *Manager) updateTasks() {
-	for _, worker := range m.Workers {
+	for _, worker := range m.workers() {
 		workerLogger := log.Logger.
 			With().
 			Str("worker", worker).
 			Logger()
+		if m.isDraining(worker) {
+			// The tasks of a drained worker were moved, its reports are outdated
+			continue
+		}
 		workerLogger.Debug().Msg("checking worker for task updates")
-		url := fmt.Sprintf("http://%s/tasks", worker)
+		url := fmt.Sprintf("http://%s/tasks?live=true", worker)
 		response, err := http.Get(url)
 		if err != nil {
 			workerLogger.Err(err).Msg("failed to send get request")
+			m.recordFailedCheck(worker)
 			continue
 		}
 		defer response.Body.Close()
@@ -282,8 +739,10 @@ func (m *Manager) updateTasks() {
 			workerLogger.Error().
 				Int("status-code", response.StatusCode).
 				Msg("received an unexpected response code from worker")
+			m.recordFailedCheck(worker)
 			continue
 		}
+		m.recordHeartbeat(worker)
 
 		decoder := json.NewDecoder(response.Body)
 		var tasks []*task.Task
@@ -294,54 +753,224 @@ func (m *Manager) updateTasks() {
 		}
 
 		for _, t := range tasks {
+			if assigned, found := m.taskWorker(t.Id); found && assigned != worker {
+				// The task was moved to another node while this worker was unreachable
+				if t.State == task.Running {
+					m.stopStaleTask(t.Id, worker)
+				}
+				continue
+			}
 			m.updateTask(t)
 		}
 	}
 }
 
-// Request container stop for the given task
-func (m *Manager) stopTask(taskId uuid.UUID, worker string) {
-	var wNode *node.Node
-	for _, n := range m.WorkerNodes {
-		if n.Name == worker {
-			wNode = n
-			break
+// Handle the events of a task which isn't assigned to a worker, the returned boolean is true when there is nothing to schedule
+//
+// A task stopped while waiting to be scheduled again has no container left to stop: it is marked as stopped,
+// and its queued start events are dropped. A new task already completed or failed is rejected
+func (m *Manager) skipUnassigned(tEvent task.TaskEvent) bool {
+	t, err := m.TaskDb.Get(tEvent.Task.Id)
+	if err != nil {
+		// New task, never stored yet
+		if err := ValidateSubmission(tEvent); err != nil {
+			log.Error().Err(err).Str("task-id", tEvent.Task.Id.String()).Msg("task submission rejected, it won't be scheduled")
+			return true
 		}
+		return false
 	}
+	taskLogger := log.With().Str("task-id", t.Id.String()).Logger()
+	switch {
+	case tEvent.State == task.Completed && t.State != task.Completed:
+		t.State = task.Completed
+		t.FinishTime = time.Now().UTC()
+		if err := m.TaskDb.Put(t.Id, t); err != nil {
+			taskLogger.Err(err).Msg("failed to update task")
+		}
+		delete(m.schedulingAttempts, t.Id)
+		taskLogger.Info().Msg("task stopped before being scheduled")
+		return true
+	case t.State == task.Completed:
+		taskLogger.Debug().Msg("ignoring event of a task stopped before being scheduled")
+		return true
+	default:
+		return false
+	}
+}
 
+// Verify that a submitted task can be scheduled: it must not be completed or failed already,
+// and the event state must be reachable from the task state
+func ValidateSubmission(tEvent task.TaskEvent) error {
+	if tEvent.Task.State == task.Completed || tEvent.Task.State == task.Failed {
+		return fmt.Errorf("%w: %v", ErrTerminalState, tEvent.Task.State)
+	}
+	if !task.ValidStateTransition(tEvent.Task.State, tEvent.State) {
+		return fmt.Errorf("%w: from %v to %v", ErrInvalidTransition, tEvent.Task.State, tEvent.State)
+	}
+	return nil
+}
+
+// Request container stop for the given task and wait until the stop is confirmed or given up
+//
+// Transient failures are retried with an exponential backoff. The worker node task count
+// is only decremented once the worker confirmed the deletion, calling this method for an
+// already stopped task is a no-op
+func (m *Manager) stopTask(taskId uuid.UUID, worker string) {
+	<-m.requestStop(taskId, worker, false)
+}
+
+// Request container stop for a task which was just sent to its worker
+//
+// The worker only knows the task once its start is dequeued, the deletion request is retried in the background
+// until the worker found the task
+func (m *Manager) stopStartingTask(taskId uuid.UUID, worker string) {
+	m.requestStop(taskId, worker, true)
+}
+
+// Deletion of a task container, whose requests are retried while they fail transiently
+type stopRequest struct {
+	task        task.Task
+	node        string
+	url         string
+	starting    bool          // The worker may not know the task yet, its not found responses are retried
+	maxAttempts int           // Maximum number of deletion requests sent to the worker
+	done        chan struct{} // Closed once the stop is confirmed or given up
+	logger      zerolog.Logger
+}
+
+// Request container stop for the given task, retrying the requests failing because the worker doesn't know the
+// task yet when starting is set
+//
+// The first deletion request is sent before returning, the retries are scheduled in the background so that the
+// tasks queue isn't held by an unreachable worker. The returned channel is closed once the stop is confirmed or
+// given up, the stop already in progress for the task is returned instead of sending other requests
+func (m *Manager) requestStop(taskId uuid.UUID, worker string, starting bool) <-chan struct{} {
+	done := make(chan struct{})
+	if inProgress, found := m.stops.LoadOrStore(taskId, done); found {
+		return inProgress.(chan struct{})
+	}
 	taskLogger := log.Logger.
 		With().
 		Str("task-id", taskId.String()).
 		Str("worker", worker).
 		Logger()
+
+	t, err := m.TaskDb.Get(taskId)
+	if err != nil {
+		taskLogger.Err(err).Msg("failed to retrieve task from store")
+		m.endStop(taskId, done)
+		return done
+	}
+	if t.State == task.Completed {
+		taskLogger.Debug().Msg("task is already stopped")
+		m.endStop(taskId, done)
+		return done
+	}
+
+	wNode := m.getWorkerNode(worker)
 	if wNode == nil {
-		taskLogger.Error().Msg("couldn't find worker node")
+		// The worker was removed, there is no container left to stop
+		taskLogger.Warn().Msg("couldn't find worker node, marking orphaned task as stopped")
+		m.unassignTask(taskId)
+		t.State = task.Completed
+		t.FinishTime = time.Now().UTC()
+		if err := m.TaskDb.Put(t.Id, t); err != nil {
+			taskLogger.Err(err).Msg("failed to update task")
+		}
+		m.endStop(taskId, done)
+		return done
+	}
+
+	request := &stopRequest{
+		task:        t,
+		node:        wNode.Name,
+		url:         fmt.Sprintf("http://%s/tasks/%v", worker, taskId),
+		starting:    starting,
+		maxAttempts: stopTaskMaxAttempts,
+		done:        done,
+		logger:      taskLogger,
+	}
+	if starting {
+		request.maxAttempts = startingStopAttempts
+	}
+	m.attemptStop(request, 1, stopTaskRetryDelay)
+	return done
+}
+
+// Send a deletion request of the task, the next attempt is scheduled after the given delay when it fails transiently
+func (m *Manager) attemptStop(request *stopRequest, attempt int, delay time.Duration) {
+	retry, err := sendStopRequest(request.url)
+	if err != nil {
+		retry = retry || (request.starting && errors.Is(err, errTaskNotFound))
+		if !retry || attempt >= request.maxAttempts {
+			request.logger.Err(err).Int("attempt", attempt).Msg("task deletion request failed")
+			m.endStop(request.task.Id, request.done)
+			return
+		}
+		request.logger.Err(err).Int("attempt", attempt).Dur("retry-delay", delay).Msg("task deletion request failed, retrying")
+		time.AfterFunc(delay, func() {
+			m.attemptStop(request, attempt+1, delay*2)
+		})
+		return
+	}
+
+	m.completeStop(request)
+	m.endStop(request.task.Id, request.done)
+}
+
+// Release the resources of a task whose stop was confirmed by its worker
+func (m *Manager) completeStop(request *stopRequest) {
+	t := request.task
+	m.reserveOnNode(request.node, -1, -t.Cpu)
+	m.registryAuths.Delete(t.Id)
+	m.Prometheus.tasksStopped.Inc()
+
+	if m.Config.PurgeStoppedTasks {
+		m.unassignTask(t.Id)
+		if err := m.TaskDb.Delete(t.Id); err != nil && !errors.Is(err, store.ErrKeyNotFound) {
+			request.logger.Err(err).Msg("failed to delete stopped task")
+		}
+		request.logger.Info().Msg("task has been scheduled to stop and was purged")
 		return
 	}
 
-	url := fmt.Sprintf("http://%s/tasks/%v", worker, taskId)
+	// Mark the task as stopped to prevent any later stop request from being sent again
+	t.State = task.Completed
+	if err := m.TaskDb.Put(t.Id, t); err != nil {
+		request.logger.Err(err).Msg("failed to update task")
+	}
+	request.logger.Info().Msg("task has been scheduled to stop")
+}
+
+// Mark the stop of a task as done, a later stop sends new requests
+func (m *Manager) endStop(taskId uuid.UUID, done chan struct{}) {
+	m.stops.Delete(taskId)
+	close(done)
+}
+
+// Send a task deletion request to a worker
+//
+// When an error is returned, the boolean indicates if the failure is transient and the request may be retried
+func sendStopRequest(url string) (bool, error) {
 	request, err := http.NewRequest(http.MethodDelete, url, nil)
 	if err != nil {
-		taskLogger.Err(err).Msg("error creating task deletion request")
-		return
+		return false, fmt.Errorf("error creating task deletion request: %w", err)
 	}
 
 	client := http.Client{}
 	response, err := client.Do(request)
 	if err != nil {
-		taskLogger.Err(err).Msg("task deletion request sending failed")
-		return
+		return true, fmt.Errorf("task deletion request sending failed: %w", err)
 	}
 	defer response.Body.Close()
+	if response.StatusCode == http.StatusNotFound {
+		return false, errTaskNotFound
+	}
 	if response.StatusCode != http.StatusNoContent {
-		taskLogger.Error().
-			Int("status-code", response.StatusCode).
-			Msg("received an unexpected response code from worker")
-		return
+		return response.StatusCode >= http.StatusInternalServerError,
+			fmt.Errorf("received an unexpected response code from worker: %d", response.StatusCode)
 	}
-
-	wNode.TaskCount--
-	taskLogger.Info().Msg("task has been scheduled to stop")
+	return false, nil
 }
 
 // Update stored task with new informations
@@ -352,15 +981,36 @@ func (m *Manager) updateTask(t *task.Task) {
 		Logger()
 
 	dbTask, err := m.TaskDb.Get(t.Id)
+	if errors.Is(err, store.ErrKeyNotFound) {
+		// Stopped tasks may have been purged while the worker still reports them
+		taskLogger.Debug().Msg("ignoring update of unknown task")
+		return
+	}
 	if err != nil {
 		taskLogger.Err(err).Msg("failed to retrieve task from store")
 		return
 	}
 
-	dbTask.State = t.State
+	if task.ValidStateTransition(dbTask.State, t.State) {
+		dbTask.State = t.State
+	} else {
+		taskLogger.Debug().
+			Str("current-state", fmt.Sprintf("%v", dbTask.State)).
+			Str("reported-state", fmt.Sprintf("%v", t.State)).
+			Msg("ignoring invalid state transition reported by worker")
+	}
 	dbTask.StartTime = t.StartTime
 	dbTask.FinishTime = t.FinishTime
+	dbTask.Error = t.Error
 	dbTask.ContainerId = t.ContainerId
+	if t.RestartCount > dbTask.RestartCount {
+		// Restarts done by docker are only known by the worker
+		dbTask.RestartCount = t.RestartCount
+	}
+	if dbTask.Image == "" {
+		// The image of an adopted container is discovered by the worker
+		dbTask.Image = t.Image
+	}
 
 	if err := m.TaskDb.Put(t.Id, dbTask); err != nil {
 		taskLogger.Err(err).Msg("failed to update task")
@@ -372,15 +1022,45 @@ func (m *Manager) updateTask(t *task.Task) {
 
 // Check if tasks are in failed state and try to restart them
 func (m *Manager) checkTasksHealth() {
-	tasks := m.GetTasks()
-	for _, t := range tasks {
-		if t.RestartCount >= 3 {
-			continue
+	var failed []task.Task
+	for _, t := range m.GetTasks() {
+		if t.State == task.Failed && m.canRestart(t) {
+			failed = append(failed, t)
 		}
+	}
 
-		if t.State == task.Failed {
-			m.restartTask(t)
+	// Restart the tasks which failed first, the others wait for the next checks when restarts are rate limited
+	sort.Slice(failed, func(i, j int) bool {
+		return failed[i].FinishTime.Before(failed[j].FinishTime)
+	})
+	for i, t := range failed {
+		if !m.restartLimiter.allow(time.Now()) {
+			log.Warn().Int("delayed-restarts", len(failed)-i).Msg("restart rate limit reached, delaying failed tasks restarts")
+			return
 		}
+		m.restartTask(t)
+	}
+}
+
+// Check if the given task didn't reach its maximum number of restarts, its own limit or the default one
+func (m *Manager) canRestart(t task.Task) bool {
+	maxRestarts := m.Config.MaxRestarts
+	if t.MaxRestarts != 0 {
+		maxRestarts = t.MaxRestarts
+	}
+	return t.RestartCount < maxRestarts
+}
+
+// Check if the given task runs or will run again, a failed task is runnable until it reached its maximum
+// number of restarts
+func (m *Manager) isRunnable(t task.Task) bool {
+	switch t.State {
+	case task.Completed:
+		return false
+	case task.Failed:
+		return m.canRestart(t)
+	default:
+		return true
 	}
 }
 
@@ -391,6 +1071,14 @@ func (m *Manager) restartTask(t task.Task) {
 		Str("task-id", t.Id.String()).
 		Logger()
 
+	workerAddr, _ := m.taskWorker(t.Id)
+	if m.availableWorkerNode(workerAddr) == nil {
+		// The worker was removed or is shutting down, the task must be scheduled on another node
+		taskLogger.Warn().Str("worker", workerAddr).Msg("worker node is unavailable, rescheduling task")
+		m.rescheduleTask(t)
+		return
+	}
+
 	// Update task in store
 	t.State = task.Scheduled
 	t.RestartCount++
@@ -400,20 +1088,18 @@ func (m *Manager) restartTask(t task.Task) {
 	}
 
 	tEvent := task.TaskEvent{
-		Id:        uuid.New(),
-		State:     task.Running,
-		Timestamp: time.Now(),
-		Task:      t,
+		Id:           uuid.New(),
+		State:        task.Running,
+		Timestamp:    time.Now(),
+		Task:         t,
+		RegistryAuth: m.registryAuth(t),
 	}
 	data, err := json.Marshal(tEvent)
 	if err != nil {
-		taskLogger.Err(err).
-			Interface("task-event", tEvent).
-			Msg("unable to marshal task object")
+		taskLogger.Err(err).Msg("unable to marshal task object")
 		return
 	}
 
-	workerAddr := m.TaskWorkerMap[t.Id]
 	url := fmt.Sprintf("http://%s/tasks", workerAddr)
 	response, err := http.Post(url, "application/json", bytes.NewBuffer(data))
 	if err != nil {
@@ -445,15 +1131,160 @@ func (m *Manager) restartTask(t task.Task) {
 		taskLogger.Err(err).Msg("error decoding worker response")
 		return
 	}
+	m.Prometheus.tasksRestarted.Inc()
+}
+
+// Change the restart settings of a task, they are applied on its next restart
+func (m *Manager) UpdateRestartPolicy(taskId uuid.UUID, restartPolicy *string, maxRestarts *int) (task.Task, error) {
+	t, err := m.TaskDb.Get(taskId)
+	if err != nil {
+		return task.Task{}, err
+	}
+
+	if restartPolicy != nil {
+		t.RestartPolicy = *restartPolicy
+	}
+	if maxRestarts != nil {
+		t.MaxRestarts = *maxRestarts
+	}
+
+	if err := m.TaskDb.Put(t.Id, t); err != nil {
+		return task.Task{}, err
+	}
+	return t, nil
+}
+
+// Get the credentials of the task image registry: the ones supplied with the task, or the manager ones for the
+// registry
+//
+// The credentials are only sent to the worker, they are never stored
+func (m *Manager) registryAuth(t task.Task) *task.RegistryAuth {
+	if t.RegistryAuth != nil {
+		return t.RegistryAuth
+	}
+	if auth, found := m.registryAuths.Load(t.Id); found {
+		taskAuth := auth.(task.RegistryAuth)
+		return &taskAuth
+	}
+	if t.Image == "" || len(m.Config.RegistryCredentials) == 0 {
+		return nil
+	}
+	host, err := task.RegistryHost(t.Image)
+	if err != nil {
+		return nil
+	}
+	if auth, found := m.Config.RegistryCredentials[host]; found {
+		return &auth
+	}
+	return nil
+}
+
+// Release the task from its worker and queue it to be scheduled on a new worker
+func (m *Manager) rescheduleTask(t task.Task) {
+	t.RestartCount++
+	m.requeueTask(t)
+}
+
+// Queue the task to be scheduled again, on a node selected without considering its current one
+//
+// Completed tasks and failed tasks which reached their maximum number of restarts aren't moved
+func (m *Manager) migrateTask(t task.Task) error {
+	if !m.isRunnable(t) {
+		return fmt.Errorf("%w: task %v is %v after %d restarts", errTaskNotRunnable, t.Id, t.State, t.RestartCount)
+	}
+	m.requeueTask(t)
+	return nil
+}
+
+// Release the task from its worker and queue it to be scheduled again
+func (m *Manager) requeueTask(t task.Task) {
+	m.unassignTask(t.Id)
+
+	t.State = task.Scheduled
+	t.ContainerId = ""
+	m.AddTask(task.TaskEvent{
+		Id:        uuid.New(),
+		State:     task.Scheduled,
+		Timestamp: time.Now().UTC(),
+		Task:      t,
+	})
+}
+
+// Delete the task events older than the retention duration, the latest event of each task is always kept
+func (m *Manager) cleanupEvents() {
+	cutoff := time.Now().Add(-m.Config.EventRetention)
+	latest := make(map[uuid.UUID]task.TaskEvent)
+	var expired []task.TaskEvent
+	err := m.EventDb.ForEach(func(e task.TaskEvent) error {
+		if previous, found := latest[e.Task.Id]; !found || e.Timestamp.After(previous.Timestamp) {
+			latest[e.Task.Id] = e
+		}
+		if e.Timestamp.Before(cutoff) {
+			expired = append(expired, e)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Err(err).Msg("failed to read task events from store")
+		return
+	}
+
+	deleted := 0
+	for _, e := range expired {
+		if latest[e.Task.Id].Id == e.Id {
+			continue
+		}
+		if err := m.EventDb.Delete(e.Id); err != nil && !errors.Is(err, store.ErrKeyNotFound) {
+			log.Err(err).Str("event-id", e.Id.String()).Msg("failed to delete task event")
+			continue
+		}
+		deleted++
+	}
+	if deleted != 0 {
+		log.Info().Int("count", deleted).Msg("deleted expired task events")
+	}
 }
 
 // Select the most adequate worker to execute the given task
 //
 // The result of this operation depends on the configured scheduler
 func (m *Manager) selectWorker(t task.Task) (*node.Node, error) {
-	selectedNode := m.Scheduler.SelectNode(t, m.WorkerNodes)
+	start := time.Now()
+	defer func() {
+		m.Metrics.ObserveSchedulerDecision(time.Since(start))
+	}()
+
+	if t.IsAdoption() {
+		// The adopted container already runs on its node, its resources are in use whatever the scheduler decides
+		adoptionNode := m.availableWorkerNode(t.PreferredNode)
+		if adoptionNode == nil {
+			return nil, fmt.Errorf("node %s of the adopted container is unavailable", t.PreferredNode)
+		}
+		return adoptionNode, nil
+	}
+
+	m.schedulerMu.Lock()
+	defer m.schedulerMu.Unlock()
+
+	if t.AffinityTaskId != uuid.Nil {
+		// Try to colocate the task with its companion
+		companionWorker, _ := m.taskWorker(t.AffinityTaskId)
+		if companionNode := m.availableWorkerNode(companionWorker); companionNode != nil {
+			companionNodes := m.withoutPortConflicts(t, m.withoutAntiAffinity(t, []*node.Node{companionNode}))
+			if selectedNode := m.Scheduler.SelectNode(t, companionNodes); selectedNode != nil {
+				return selectedNode, nil
+			}
+		}
+		if t.AffinityRequired {
+			m.Metrics.ObserveSchedulingFailure()
+			return nil, fmt.Errorf("%w: node of companion task %v can't run task %v", errNoCandidate, t.AffinityTaskId, t.Id)
+		}
+	}
+
+	selectedNode := m.Scheduler.SelectNode(t, m.withoutPortConflicts(t, m.withoutAntiAffinity(t, m.schedulableNodes())))
 	if selectedNode == nil {
-		return nil, fmt.Errorf("no available candidates match resource request for task %v", t.Id)
+		m.Metrics.ObserveSchedulingFailure()
+		return nil, fmt.Errorf("%w match resource request for task %v", errNoCandidate, t.Id)
 	}
 	return selectedNode, nil
 }
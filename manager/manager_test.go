@@ -0,0 +1,1075 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/c9s/goprocinfo/linux"
+	"github.com/google/uuid"
+
+	"orchestrator/scheduler"
+	"orchestrator/stats"
+	"orchestrator/store"
+	"orchestrator/task"
+)
+
+func TestShutdownClosesStoresOnceLoopsReturned(t *testing.T) {
+	m, err := New(nil, "roundrobin", "memory", Config{})
+	if err != nil {
+		t.Fatalf("failed to create manager: %v", err)
+	}
+	events := newBlockingStore(m.EventDb)
+	m.EventDb = events
+	m.Start()
+
+	// The tasks processing loop blocks while storing the event
+	m.AddTask(task.TaskEvent{Id: uuid.New(), State: task.Scheduled, Task: task.Task{Id: uuid.New(), Name: "web"}})
+	select {
+	case <-events.entered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the task event processing")
+	}
+
+	shutdownErr := make(chan error, 1)
+	go func() {
+		shutdownErr <- m.Shutdown(context.Background(), nil)
+	}()
+	select {
+	case err := <-shutdownErr:
+		t.Fatalf("shutdown returned while a loop was running: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if events.isClosed() {
+		t.Fatal("store closed while a loop was running")
+	}
+
+	close(events.release)
+	select {
+	case err := <-shutdownErr:
+		if err != nil {
+			t.Fatalf("shutdown failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the shutdown")
+	}
+	if !events.isClosed() {
+		t.Error("store not closed by the shutdown")
+	}
+}
+
+func TestShutdownLeavesStoresOpenWhenLoopsDontReturn(t *testing.T) {
+	m, err := New(nil, "roundrobin", "memory", Config{})
+	if err != nil {
+		t.Fatalf("failed to create manager: %v", err)
+	}
+	events := newBlockingStore(m.EventDb)
+	m.EventDb = events
+	m.Start()
+	defer close(events.release)
+
+	m.AddTask(task.TaskEvent{Id: uuid.New(), State: task.Scheduled, Task: task.Task{Id: uuid.New(), Name: "web"}})
+	select {
+	case <-events.entered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the task event processing")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := m.Shutdown(ctx, nil); err == nil {
+		t.Fatal("expected the shutdown to fail while a loop was running")
+	}
+	if events.isClosed() {
+		t.Error("store closed while a loop was running")
+	}
+}
+
+// Store a running task assigned to the given worker, counted in the worker node tasks
+func storeAssignedTask(t *testing.T, m *Manager, worker string) task.Task {
+	t.Helper()
+	tk := task.Task{Id: uuid.New(), Name: "web", Image: "nginx", State: task.Running}
+	if err := m.TaskDb.Put(tk.Id, tk); err != nil {
+		t.Fatalf("failed to store task: %v", err)
+	}
+	m.WorkerTaskMap[worker] = append(m.WorkerTaskMap[worker], tk.Id)
+	m.TaskWorkerMap[tk.Id] = worker
+	m.WorkerNodes[0].TaskCount++
+	return tk
+}
+
+func TestStopTaskRetriesTransientFailures(t *testing.T) {
+	fw := newFakeWorker(t)
+	fw.stopFailures = []int{http.StatusServiceUnavailable}
+	m := newTestManager(t, fw)
+	tk := storeAssignedTask(t, m, fw.addr())
+
+	m.stopTask(tk.Id, fw.addr())
+
+	if stops := fw.receivedStops(); len(stops) != 1 || stops[0] != tk.Id {
+		t.Fatalf("expected the task stop to be accepted after a retry, got stops %v", stops)
+	}
+	if count := m.WorkerNodes[0].TaskCount; count != 0 {
+		t.Errorf("expected the node task count to be 0, got %d", count)
+	}
+	if stored, _ := m.TaskDb.Get(tk.Id); stored.State != task.Completed {
+		t.Errorf("expected the task to be completed, got state %v", stored.State)
+	}
+}
+
+func TestStopRetriesDontHoldTasksQueue(t *testing.T) {
+	fw := newFakeWorker(t)
+	fw.stopFailures = []int{http.StatusServiceUnavailable}
+	m := newTestManager(t, fw)
+	stopped := storeAssignedTask(t, m, fw.addr())
+	m.AddTask(task.TaskEvent{Id: uuid.New(), State: task.Completed, Timestamp: time.Now().UTC(), Task: stopped})
+	started := newTaskEvent("api")
+	m.AddTask(started)
+
+	begin := time.Now()
+	processPending(m)
+	if elapsed := time.Since(begin); elapsed >= stopTaskRetryDelay {
+		t.Errorf("expected the queued start not to wait for the stop retry, the queue took %v", elapsed)
+	}
+	if events := fw.receivedEvents(); len(events) != 1 || events[0].Task.Id != started.Task.Id {
+		t.Errorf("expected the queued task to be started, got %v", events)
+	}
+
+	// The stop is retried in the background
+	waitFor(t, "task stop retry", func() bool {
+		stored, _ := m.TaskDb.Get(stopped.Id)
+		return stored.State == task.Completed
+	})
+	if stops := fw.receivedStops(); len(stops) != 1 || stops[0] != stopped.Id {
+		t.Errorf("expected the task stop to be accepted after a retry, got stops %v", stops)
+	}
+}
+
+func TestConcurrentStopsShareRequests(t *testing.T) {
+	fw := newFakeWorker(t)
+	fw.stopFailures = []int{http.StatusServiceUnavailable}
+	m := newTestManager(t, fw)
+	tk := storeAssignedTask(t, m, fw.addr())
+
+	// The second stop is requested while the first one waits for its retry
+	first := m.requestStop(tk.Id, fw.addr(), false)
+	m.stopTask(tk.Id, fw.addr())
+	<-first
+
+	if stops := fw.receivedStops(); len(stops) != 1 {
+		t.Errorf("expected a single accepted deletion request, got %v", stops)
+	}
+	if count := m.WorkerNodes[0].TaskCount; count != 0 {
+		t.Errorf("expected the node task count to be 0, got %d", count)
+	}
+}
+
+func TestStopTaskKeepsCountOnFailure(t *testing.T) {
+	fw := newFakeWorker(t)
+	fw.stopFailures = []int{http.StatusNotFound}
+	m := newTestManager(t, fw)
+	tk := storeAssignedTask(t, m, fw.addr())
+
+	m.stopTask(tk.Id, fw.addr())
+
+	if stops := fw.receivedStops(); len(stops) != 0 {
+		t.Fatalf("expected a client error not to be retried, got stops %v", stops)
+	}
+	if count := m.WorkerNodes[0].TaskCount; count != 1 {
+		t.Errorf("expected the node task count to be 1, got %d", count)
+	}
+	if stored, _ := m.TaskDb.Get(tk.Id); stored.State != task.Running {
+		t.Errorf("expected the task to be running, got state %v", stored.State)
+	}
+}
+
+func TestStopTaskTwiceDecrementsOnce(t *testing.T) {
+	fw := newFakeWorker(t)
+	m := newTestManager(t, fw)
+	tk := storeAssignedTask(t, m, fw.addr())
+
+	m.stopTask(tk.Id, fw.addr())
+	m.stopTask(tk.Id, fw.addr())
+
+	if stops := fw.receivedStops(); len(stops) != 1 {
+		t.Errorf("expected a single deletion request, got %d", len(stops))
+	}
+	if count := m.WorkerNodes[0].TaskCount; count != 0 {
+		t.Errorf("expected the node task count to be 0, got %d", count)
+	}
+}
+
+func TestSelectWorkerRecordsDecisionDuration(t *testing.T) {
+	m := newTestManager(t, newFakeWorker(t))
+
+	for i := 0; i < 2; i++ {
+		if _, err := m.selectWorker(task.Task{Id: uuid.New()}); err != nil {
+			t.Fatalf("failed to select a worker: %v", err)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	newTestApi(m).Router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	var report MetricsReport
+	if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+		t.Fatalf("failed to decode metrics: %v", err)
+	}
+	if report.SchedulerDecisionDuration.Count != 2 {
+		t.Errorf("expected 2 recorded scheduler decisions, got %d", report.SchedulerDecisionDuration.Count)
+	}
+}
+
+func TestNodeStatsRetrievedFromWorkerMetricsPort(t *testing.T) {
+	metrics := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/metrics" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(stats.Stats{
+			MemoryStats: &linux.MemInfo{MemTotal: 2000, MemAvailable: 500},
+			DiskStats:   &linux.Disk{All: 1000, Used: 100},
+		})
+	}))
+	defer metrics.Close()
+	metricsPort := metrics.Listener.Addr().(*net.TCPAddr).Port
+
+	// Nothing listens on the worker main port, the stats must come from the metrics port
+	m, err := New([]string{"127.0.0.1:1"}, "roundrobin", "memory", Config{WorkerMetricsPort: metricsPort})
+	if err != nil {
+		t.Fatalf("failed to create manager: %v", err)
+	}
+	defer m.Close()
+
+	n := m.WorkerNodes[0]
+	if expected := fmt.Sprintf("http://127.0.0.1:%d", metricsPort); n.MetricsApi != expected {
+		t.Errorf("expected metrics API %s, got %s", expected, n.MetricsApi)
+	}
+	if err := n.UpdateStats(context.Background()); err != nil {
+		t.Fatalf("failed to update node stats: %v", err)
+	}
+	if n.Memory != 2000 || n.MemoryAllocated != 1500 {
+		t.Errorf("expected the stats of the metrics port, got memory %d and allocated %d", n.Memory, n.MemoryAllocated)
+	}
+}
+
+// Store a task of the given state assigned to a worker which isn't registered anymore
+func storeOrphanedTask(t *testing.T, m *Manager, state task.State) task.Task {
+	t.Helper()
+	tk := task.Task{Id: uuid.New(), Name: "web", Image: "nginx", State: state, ContainerId: "container-1"}
+	if err := m.TaskDb.Put(tk.Id, tk); err != nil {
+		t.Fatalf("failed to store task: %v", err)
+	}
+	m.WorkerTaskMap["removed:5556"] = []uuid.UUID{tk.Id}
+	m.TaskWorkerMap[tk.Id] = "removed:5556"
+	return tk
+}
+
+func TestRestartTaskReschedulesWhenWorkerRemoved(t *testing.T) {
+	m := newTestManager(t, newFakeWorker(t))
+	tk := storeOrphanedTask(t, m, task.Failed)
+
+	m.restartTask(tk)
+
+	if _, found := m.TaskWorkerMap[tk.Id]; found {
+		t.Error("expected the task to be unassigned from the removed worker")
+	}
+	if _, found := m.WorkerTaskMap["removed:5556"]; found {
+		t.Error("expected the removed worker entry to be cleaned")
+	}
+	if m.Pending.Len() != 1 {
+		t.Fatalf("expected the task to be queued for scheduling, got %d queued events", m.Pending.Len())
+	}
+	tEvent, _ := m.Pending.Pop()
+	if tEvent.Task.Id != tk.Id || tEvent.State != task.Scheduled || tEvent.Task.ContainerId != "" {
+		t.Errorf("expected a scheduling event of the task without container, got %+v", tEvent)
+	}
+}
+
+func TestStopTaskReleasesTaskWhenWorkerRemoved(t *testing.T) {
+	m := newTestManager(t, newFakeWorker(t))
+	tk := storeOrphanedTask(t, m, task.Running)
+
+	m.stopTask(tk.Id, "removed:5556")
+
+	if _, found := m.TaskWorkerMap[tk.Id]; found {
+		t.Error("expected the task to be unassigned from the removed worker")
+	}
+	if stored, _ := m.TaskDb.Get(tk.Id); stored.State != task.Completed {
+		t.Errorf("expected the task to be completed, got state %v", stored.State)
+	}
+}
+
+func TestSelectWorkerColocatesAffineTask(t *testing.T) {
+	workers := []*fakeWorker{newFakeWorker(t), newFakeWorker(t), newFakeWorker(t)}
+	m := newTestManager(t, workers...)
+	companion := uuid.New()
+	m.TaskWorkerMap[companion] = workers[1].addr()
+
+	for _, required := range []bool{false, true} {
+		for i := 0; i < 3; i++ {
+			wNode, err := m.selectWorker(task.Task{Id: uuid.New(), AffinityTaskId: companion, AffinityRequired: required})
+			if err != nil {
+				t.Fatalf("failed to select a worker: %v", err)
+			}
+			if wNode.Name != workers[1].addr() {
+				t.Errorf("expected the task to land on the companion node %s, got %s", workers[1].addr(), wNode.Name)
+			}
+		}
+	}
+}
+
+func TestSelectWorkerWithoutCompanionNode(t *testing.T) {
+	m := newTestManager(t, newFakeWorker(t))
+	unknown := uuid.New()
+
+	if _, err := m.selectWorker(task.Task{Id: uuid.New(), AffinityTaskId: unknown, AffinityRequired: true}); err == nil {
+		t.Error("expected the required affinity to fail without companion node")
+	}
+	if _, err := m.selectWorker(task.Task{Id: uuid.New(), AffinityTaskId: unknown}); err != nil {
+		t.Errorf("expected the preferred affinity to fall back on another node, got %v", err)
+	}
+}
+
+func TestSendWorkRequeuesRejectedTask(t *testing.T) {
+	fw := newFakeWorker(t)
+	rejections := 1
+	fw.startStatus = func(tEvent task.TaskEvent) int {
+		if rejections > 0 {
+			rejections--
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusCreated
+	}
+	m := newTestManager(t, fw)
+	tEvent := newTaskEvent("web")
+
+	m.sendWork(tEvent)
+
+	if _, found := m.TaskWorkerMap[tEvent.Task.Id]; found {
+		t.Error("expected the rejected task to be unassigned")
+	}
+	if ids := m.WorkerTaskMap[fw.addr()]; len(ids) != 0 {
+		t.Errorf("expected no task assigned to the worker, got %v", ids)
+	}
+	if count := m.WorkerNodes[0].TaskCount; count != 0 {
+		t.Errorf("expected the node task count to be 0, got %d", count)
+	}
+	if m.Pending.Len() != 1 {
+		t.Fatalf("expected the rejected task to be queued again, got %d queued events", m.Pending.Len())
+	}
+
+	requeued, _ := m.Pending.Pop()
+	m.sendWork(requeued)
+	if worker := m.TaskWorkerMap[tEvent.Task.Id]; worker != fw.addr() {
+		t.Errorf("expected the task to be assigned to the worker once accepted, got %q", worker)
+	}
+	if count := m.WorkerNodes[0].TaskCount; count != 1 {
+		t.Errorf("expected the node task count to be 1, got %d", count)
+	}
+}
+
+func TestSendWorkRetriesUnplacedTaskLater(t *testing.T) {
+	m := newTestManager(t)
+	tEvent := newTaskEvent("web")
+
+	m.sendWork(tEvent)
+
+	if failures := m.Metrics.Report().SchedulingFailures; failures != 1 {
+		t.Errorf("expected 1 scheduling failure, got %d", failures)
+	}
+	if attempts := m.schedulingAttempts[tEvent.Task.Id]; attempts != 1 {
+		t.Errorf("expected 1 placement attempt, got %d", attempts)
+	}
+	if m.Pending.Len() != 0 {
+		t.Error("expected the unplaced task not to be queued again before the retry delay")
+	}
+	waitFor(t, "the unplaced task to be queued again", func() bool {
+		return m.Pending.Len() == 1
+	})
+}
+
+func TestSendWorkResetsPlacementAttemptsOnSuccess(t *testing.T) {
+	fw := newFakeWorker(t)
+	m := newTestManager(t, fw)
+	tEvent := newTaskEvent("web")
+	m.schedulingAttempts[tEvent.Task.Id] = 3
+
+	m.sendWork(tEvent)
+
+	if _, found := m.schedulingAttempts[tEvent.Task.Id]; found {
+		t.Error("expected the placement attempts to be forgotten once the task is placed")
+	}
+	if worker := m.TaskWorkerMap[tEvent.Task.Id]; worker != fw.addr() {
+		t.Errorf("expected the task to be assigned to the worker, got %q", worker)
+	}
+}
+
+func TestSubmitTaskReservesName(t *testing.T) {
+	m := newTestManager(t)
+	api := newTestApi(m)
+
+	const submitters = 10
+	codes := make(chan int, submitters)
+	var wg sync.WaitGroup
+	for i := 0; i < submitters; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- api.serve(t, http.MethodPost, "/tasks", newTaskEvent("web")).Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	created := 0
+	for code := range codes {
+		switch code {
+		case http.StatusCreated:
+			created++
+		case http.StatusConflict:
+		default:
+			t.Errorf("unexpected start status %d", code)
+		}
+	}
+	if created != 1 {
+		t.Fatalf("expected a single task named web to be submitted, got %d", created)
+	}
+
+	// The name is still reserved by the queued submission
+	if rec := api.serve(t, http.MethodPost, "/tasks", newTaskEvent("web")); rec.Code != http.StatusConflict {
+		t.Errorf("expected status %d for a name used by a queued task, got %d", http.StatusConflict, rec.Code)
+	}
+	if rec := api.serve(t, http.MethodPost, "/tasks", newTaskEvent("api")); rec.Code != http.StatusCreated {
+		t.Errorf("expected status %d for an unused name, got %d", http.StatusCreated, rec.Code)
+	}
+}
+
+func TestSubmittedNameReleasedOnceTaskStored(t *testing.T) {
+	fw := newFakeWorker(t)
+	m := newTestManager(t, fw)
+	tEvent := newTaskEvent("web")
+	if err := m.SubmitTask(tEvent); err != nil {
+		t.Fatalf("failed to submit task: %v", err)
+	}
+
+	queued, _ := m.Pending.Pop()
+	m.sendWork(queued)
+
+	if submitted := m.submittedTasks(); len(submitted) != 0 {
+		t.Errorf("expected the stored task not to be held as a submission, got %v", submitted)
+	}
+	if err := m.CheckTaskName(newTaskEvent("web").Task); !errors.Is(err, ErrNameConflict) {
+		t.Errorf("expected the stored task to keep holding its name, got %v", err)
+	}
+}
+
+func TestNamespacesScopeTaskNames(t *testing.T) {
+	m := newTestManager(t)
+	api := newTestApi(m)
+
+	web := newTaskEvent("web")
+	web.Task.Namespace = "shop"
+	if rec := api.serve(t, http.MethodPost, "/tasks", web); rec.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d", http.StatusCreated, rec.Code)
+	}
+	other := newTaskEvent("web")
+	other.Task.Namespace = "blog"
+	if rec := api.serve(t, http.MethodPost, "/tasks", other); rec.Code != http.StatusCreated {
+		t.Errorf("expected the name to be usable in another namespace, got status %d", rec.Code)
+	}
+
+	completed := task.Task{Id: uuid.New(), Name: "api", Namespace: "shop", State: task.Completed}
+	m.TaskDb.Put(completed.Id, completed)
+	reused := newTaskEvent("api")
+	reused.Task.Namespace = "shop"
+	if err := m.CheckTaskName(reused.Task); err != nil {
+		t.Errorf("expected the name of a completed task to be reusable, got %v", err)
+	}
+}
+
+// Create a worker double reporting the given memory usage, in kB
+func newLoadedWorker(t *testing.T, memUsed uint64) *fakeWorker {
+	t.Helper()
+	fw := newFakeWorker(t)
+	fw.stats = &stats.Stats{
+		MemoryStats: &linux.MemInfo{MemTotal: 4000000, MemAvailable: 4000000 - memUsed},
+		DiskStats:   &linux.Disk{All: 1000000, Used: 100000, Free: 900000},
+		CpuStats:    &linux.CPUStat{User: 20, Idle: 80},
+	}
+	return fw
+}
+
+func TestSelectWorkerWithEachScheduler(t *testing.T) {
+	busy := newLoadedWorker(t, 3000000)
+	idle := newLoadedWorker(t, 500000)
+
+	t.Run("roundrobin", func(t *testing.T) {
+		m := newTestManager(t, busy, idle)
+		m.Scheduler = &scheduler.RoundRobin{}
+		selected := make(map[string]bool)
+		for i := 0; i < 2; i++ {
+			wNode, err := m.selectWorker(task.Task{Id: uuid.New()})
+			if err != nil {
+				t.Fatalf("failed to select a worker: %v", err)
+			}
+			selected[wNode.Name] = true
+		}
+		if !selected[busy.addr()] || !selected[idle.addr()] {
+			t.Errorf("expected the workers to be selected in turn, got %v", selected)
+		}
+	})
+	t.Run("epvm", func(t *testing.T) {
+		m := newTestManager(t, busy, idle)
+		m.Scheduler = &scheduler.Epvm{}
+		wNode, err := m.selectWorker(task.Task{Id: uuid.New(), Memory: 1000})
+		if err != nil {
+			t.Fatalf("failed to select a worker: %v", err)
+		}
+		if wNode.Name != idle.addr() {
+			t.Errorf("expected the least loaded worker %s to be selected, got %s", idle.addr(), wNode.Name)
+		}
+	})
+	t.Run("random", func(t *testing.T) {
+		m, err := New([]string{busy.addr(), idle.addr()}, "random", "memory", Config{})
+		if err != nil {
+			t.Fatalf("failed to create manager: %v", err)
+		}
+		defer m.Close()
+		m.updateNodesStats()
+		if _, err := m.selectWorker(task.Task{Id: uuid.New()}); err != nil {
+			t.Fatalf("failed to select a worker: %v", err)
+		}
+	})
+	t.Run("no candidate", func(t *testing.T) {
+		for _, sched := range []scheduler.Scheduler{&scheduler.RoundRobin{}, &scheduler.Epvm{}, scheduler.NewRandom(rand.NewSource(1)), &scheduler.BinPacking{}} {
+			m := newTestManager(t, busy, idle)
+			m.Scheduler = sched
+			for _, n := range m.WorkerNodes {
+				n.MaxCpu = 1
+			}
+			_, err := m.selectWorker(task.Task{Id: uuid.New(), Cpu: 2})
+			if !errors.Is(err, errNoCandidate) {
+				t.Errorf("expected no candidate with scheduler %T, got %v", sched, err)
+			}
+		}
+	})
+}
+
+func TestStopTaskPurgesStoppedTask(t *testing.T) {
+	fw := newFakeWorker(t)
+	m := newTestManager(t, fw)
+	m.Config.PurgeStoppedTasks = true
+	tk := storeAssignedTask(t, m, fw.addr())
+
+	m.stopTask(tk.Id, fw.addr())
+
+	if _, err := m.TaskDb.Get(tk.Id); !errors.Is(err, store.ErrKeyNotFound) {
+		t.Errorf("expected the stopped task to be deleted, got %v", err)
+	}
+	if _, found := m.TaskWorkerMap[tk.Id]; found {
+		t.Error("expected the stopped task to be unassigned")
+	}
+	if count := m.WorkerNodes[0].TaskCount; count != 0 {
+		t.Errorf("expected the node task count to be 0, got %d", count)
+	}
+}
+
+func TestSelectWorkerPlacesAdoptionOnContainerNode(t *testing.T) {
+	first, second := newFakeWorker(t), newFakeWorker(t)
+	m := newTestManager(t, first, second)
+	adoption := task.Task{Id: uuid.New(), Name: "legacy", Namespace: "default", ContainerId: "legacy-1", PreferredNode: second.addr()}
+
+	for i := 0; i < 2; i++ {
+		wNode, err := m.selectWorker(adoption)
+		if err != nil {
+			t.Fatalf("failed to select a worker: %v", err)
+		}
+		if wNode.Name != second.addr() {
+			t.Errorf("expected the node running the container to be selected, got %s", wNode.Name)
+		}
+	}
+
+	adoption.PreferredNode = "unknown:5556"
+	if _, err := m.selectWorker(adoption); err == nil {
+		t.Error("expected the adoption on an unknown node to fail")
+	}
+}
+
+func TestUpdateTaskKeepsHighestRestartCount(t *testing.T) {
+	m := newTestManager(t)
+	tk := task.Task{Id: uuid.New(), Name: "web", State: task.Running, RestartCount: 1}
+	m.TaskDb.Put(tk.Id, tk)
+
+	reported := tk
+	reported.RestartCount = 3
+	m.updateTask(&reported)
+	if stored, _ := m.TaskDb.Get(tk.Id); stored.RestartCount != 3 {
+		t.Errorf("expected the restarts done by docker to be reported, got restart count %d", stored.RestartCount)
+	}
+
+	reported.RestartCount = 0
+	m.updateTask(&reported)
+	if stored, _ := m.TaskDb.Get(tk.Id); stored.RestartCount != 3 {
+		t.Errorf("expected a lower reported count to be ignored, got restart count %d", stored.RestartCount)
+	}
+}
+
+func TestFailedTasksRestartsSpreadAcrossIntervals(t *testing.T) {
+	fw := newFakeWorker(t)
+	m := newTestManager(t, fw)
+	m.restartLimiter = rateLimiter{limit: 10, interval: time.Hour}
+	failedAt := time.Now().UTC().Add(-time.Hour)
+	for i := 0; i < 100; i++ {
+		tk := storeAssignedTask(t, m, fw.addr())
+		tk.State = task.Failed
+		tk.FinishTime = failedAt.Add(time.Duration(i) * time.Second)
+		if err := m.TaskDb.Put(tk.Id, tk); err != nil {
+			t.Fatalf("failed to store task: %v", err)
+		}
+	}
+
+	for interval := 1; interval <= 10; interval++ {
+		m.checkTasksHealth()
+		if restarted := len(fw.receivedEvents()); restarted != interval*10 {
+			t.Fatalf("interval %d: expected %d restarts, got %d", interval, interval*10, restarted)
+		}
+		// A second check within the interval doesn't restart more tasks
+		m.checkTasksHealth()
+		if restarted := len(fw.receivedEvents()); restarted != interval*10 {
+			t.Fatalf("interval %d: expected the restarts to be delayed, got %d restarts", interval, restarted)
+		}
+		m.restartLimiter.windowStart = m.restartLimiter.windowStart.Add(-time.Hour)
+	}
+
+	// The tasks which failed first are restarted first
+	events := fw.receivedEvents()
+	for i := 1; i < len(events); i++ {
+		if events[i].Task.FinishTime.Before(events[i-1].Task.FinishTime) {
+			t.Fatalf("expected the restarts in failure order, restart %d failed before restart %d", i, i-1)
+		}
+	}
+}
+
+// Run with -race: the API handlers, the tasks processing and the health checks share the tasks assignments
+func TestConcurrentTasksSubmissionAndListing(t *testing.T) {
+	fw := newFakeWorker(t)
+	m := newTestManager(t, fw)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			tEvent, _ := m.Pending.Pop()
+			m.sendWork(tEvent)
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			m.AddTask(newTaskEvent(fmt.Sprintf("web-%d", i)))
+		}(i)
+		go func() {
+			defer wg.Done()
+			m.GetTasks()
+			m.nodeResponses()
+			m.checkTasksHealth()
+		}()
+	}
+	wg.Wait()
+
+	if tasks := m.GetTasks(); len(tasks) != 20 {
+		t.Fatalf("expected 20 stored tasks, got %d", len(tasks))
+	}
+	if count := len(m.workerTaskIds(fw.addr())); count != 20 {
+		t.Errorf("expected 20 tasks assigned to the worker, got %d", count)
+	}
+}
+
+func TestFailedTaskStopsRestartingAtConfiguredLimit(t *testing.T) {
+	fw := newFakeWorker(t)
+	m := newTestManager(t, fw)
+	m.Config.MaxRestarts = 1
+	tk := storeAssignedTask(t, m, fw.addr())
+	tk.State = task.Failed
+	if err := m.TaskDb.Put(tk.Id, tk); err != nil {
+		t.Fatalf("failed to store task: %v", err)
+	}
+
+	m.checkTasksHealth()
+	stored, _ := m.TaskDb.Get(tk.Id)
+	if stored.RestartCount != 1 {
+		t.Fatalf("expected the failed task to be restarted once, got %d restarts", stored.RestartCount)
+	}
+
+	// The task fails again, it reached the configured limit
+	stored.State = task.Failed
+	m.TaskDb.Put(stored.Id, stored)
+	m.checkTasksHealth()
+	if restarts := len(fw.receivedEvents()); restarts != 1 {
+		t.Errorf("expected no restart beyond the configured limit, got %d restarts", restarts)
+	}
+
+	// The task limit takes precedence over the configured one
+	stored.MaxRestarts = 2
+	m.TaskDb.Put(stored.Id, stored)
+	m.checkTasksHealth()
+	if restarts := len(fw.receivedEvents()); restarts != 2 {
+		t.Errorf("expected the task limit to allow a second restart, got %d restarts", restarts)
+	}
+}
+
+func TestSelectWorkerAvoidsAntiAffineTasks(t *testing.T) {
+	workers := []*fakeWorker{newFakeWorker(t), newFakeWorker(t)}
+	m := newTestManager(t, workers...)
+	m.Scheduler = &scheduler.RoundRobin{}
+	storeAssignedTask(t, m, workers[0].addr())
+
+	for i := 0; i < 3; i++ {
+		wNode, err := m.selectWorker(task.Task{Id: uuid.New(), Name: "replica", AntiAffinity: []string{"web"}})
+		if err != nil {
+			t.Fatalf("failed to select a worker: %v", err)
+		}
+		if wNode.Name != workers[1].addr() {
+			t.Errorf("expected the task to avoid the node running web, got %s", wNode.Name)
+		}
+	}
+
+	// Tasks of other namespaces aren't concerned
+	if _, err := m.selectWorker(task.Task{Id: uuid.New(), Namespace: "other", AntiAffinity: []string{"web"}}); err != nil {
+		t.Errorf("expected the anti-affinity to be scoped to the namespace, got %v", err)
+	}
+
+	storeAssignedTask(t, m, workers[1].addr())
+	if _, err := m.selectWorker(task.Task{Id: uuid.New(), AntiAffinity: []string{"web"}}); !errors.Is(err, errNoCandidate) {
+		t.Errorf("expected no candidate when every node runs an anti-affine task, got %v", err)
+	}
+}
+
+func TestSelectWorkerAvoidsBoundHostPorts(t *testing.T) {
+	workers := []*fakeWorker{newFakeWorker(t), newFakeWorker(t)}
+	m := newTestManager(t, workers...)
+	m.Scheduler = &scheduler.RoundRobin{}
+	bound := storeAssignedTask(t, m, workers[0].addr())
+	bound.PortBindings = map[string]string{"80/tcp": "8080"}
+	m.TaskDb.Put(bound.Id, bound)
+
+	for i := 0; i < 3; i++ {
+		wNode, err := m.selectWorker(task.Task{Id: uuid.New(), Name: "proxy", PortBindings: map[string]string{"8000/tcp": "8080"}})
+		if err != nil {
+			t.Fatalf("failed to select a worker: %v", err)
+		}
+		if wNode.Name != workers[1].addr() {
+			t.Errorf("expected the task to avoid the node binding the port, got %s", wNode.Name)
+		}
+	}
+
+	// The same port number on another protocol doesn't conflict
+	if _, err := m.selectWorker(task.Task{Id: uuid.New(), PortBindings: map[string]string{"53/udp": "8080"}}); err != nil {
+		t.Errorf("expected the protocols to be distinguished, got %v", err)
+	}
+}
+
+func TestSubmissionRejectedWhenHostPortsBoundEverywhere(t *testing.T) {
+	fw := newFakeWorker(t)
+	m := newTestManager(t, fw)
+	api := newTestApi(m)
+	bound := storeAssignedTask(t, m, fw.addr())
+	bound.PortBindings = map[string]string{"80/tcp": "8080"}
+	m.TaskDb.Put(bound.Id, bound)
+
+	tEvent := newTaskEvent("proxy")
+	tEvent.Task.PortBindings = map[string]string{"8000/tcp": "8080"}
+	if rec := api.serve(t, http.MethodPost, "/tasks", tEvent); rec.Code != http.StatusConflict {
+		t.Errorf("expected status %d, got %d", http.StatusConflict, rec.Code)
+	}
+	if m.Pending.Len() != 0 {
+		t.Errorf("expected the rejected task not to be queued, got %d queued events", m.Pending.Len())
+	}
+
+	// Once the bound task is completed, its ports are free again
+	bound.State = task.Completed
+	m.TaskDb.Put(bound.Id, bound)
+	if rec := api.serve(t, http.MethodPost, "/tasks", tEvent); rec.Code != http.StatusCreated {
+		t.Errorf("expected status %d, got %d", http.StatusCreated, rec.Code)
+	}
+}
+
+func TestSendWorkStopsUnassignedTask(t *testing.T) {
+	fw := newFakeWorker(t)
+	m := newTestManager(t, fw)
+	tk := task.Task{Id: uuid.New(), Name: "web", Namespace: "default", Image: "nginx", State: task.Scheduled}
+	if err := m.TaskDb.Put(tk.Id, tk); err != nil {
+		t.Fatalf("failed to store task: %v", err)
+	}
+
+	m.sendWork(task.TaskEvent{Id: uuid.New(), State: task.Completed, Task: tk})
+	stored, err := m.TaskDb.Get(tk.Id)
+	if err != nil {
+		t.Fatalf("failed to get task: %v", err)
+	}
+	if stored.State != task.Completed || stored.FinishTime.IsZero() {
+		t.Errorf("expected the unassigned task to be stopped, got state %v", stored.State)
+	}
+
+	// A start racing with the stop doesn't bring the task back
+	m.sendWork(task.TaskEvent{Id: uuid.New(), State: task.Scheduled, Task: tk})
+	if _, found := m.TaskWorkerMap[tk.Id]; found {
+		t.Error("expected the stopped task not to be scheduled")
+	}
+	if len(fw.receivedEvents()) != 0 || len(fw.receivedStops()) != 0 {
+		t.Errorf("expected the worker not to be contacted, got %d events and %d stops", len(fw.receivedEvents()), len(fw.receivedStops()))
+	}
+}
+
+func TestCheckNodesStatsRunsAtConfiguredInterval(t *testing.T) {
+	fw := newFakeWorker(t)
+	m := newTestManager(t, fw)
+	m.Config.StatsInterval = time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		m.CheckNodesStats(ctx)
+		close(done)
+	}()
+	deadline := time.Now().Add(2 * time.Second)
+	for fw.statsRequests() < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the stats to be retrieved several times, got %d retrievals", fw.statsRequests())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected the stats loop to return once the context is cancelled")
+	}
+}
+
+func TestNewDefaultsLoopIntervals(t *testing.T) {
+	m, err := New([]string{"localhost:5556"}, "roundrobin", "memory", Config{UpdateInterval: time.Second})
+	if err != nil {
+		t.Fatalf("failed to create manager: %v", err)
+	}
+	defer m.Close()
+
+	if m.Config.HealthCheckInterval != DefaultLoopInterval || m.Config.StatsInterval != DefaultLoopInterval {
+		t.Errorf("expected the unset intervals to default to %v, got %v and %v", DefaultLoopInterval, m.Config.HealthCheckInterval, m.Config.StatsInterval)
+	}
+	if m.Config.UpdateInterval != time.Second {
+		t.Errorf("expected the configured update interval to be kept, got %v", m.Config.UpdateInterval)
+	}
+}
+
+func TestSendWorkSkipsNewTasksInTerminalState(t *testing.T) {
+	fw := newFakeWorker(t)
+	m := newTestManager(t, fw)
+
+	for _, state := range []task.State{task.Completed, task.Failed} {
+		tEvent := newTaskEvent("web")
+		tEvent.Task.State = state
+		m.sendWork(tEvent)
+		if _, err := m.TaskDb.Get(tEvent.Task.Id); err == nil {
+			t.Errorf("expected the task in state %v not to be stored", state)
+		}
+	}
+	if events := fw.receivedEvents(); len(events) != 0 {
+		t.Errorf("expected no task to be sent to the worker, got %d events", len(events))
+	}
+}
+
+func TestValidateSubmission(t *testing.T) {
+	cases := []struct {
+		taskState  task.State
+		eventState task.State
+		err        error
+	}{
+		{task.Pending, task.Scheduled, nil},
+		{task.Scheduled, task.Scheduled, nil},
+		{task.Completed, task.Scheduled, ErrTerminalState},
+		{task.Failed, task.Scheduled, ErrTerminalState},
+		{task.Pending, task.Running, ErrInvalidTransition},
+	}
+	for _, c := range cases {
+		tEvent := task.TaskEvent{State: c.eventState, Task: task.Task{State: c.taskState}}
+		if err := ValidateSubmission(tEvent); !errors.Is(err, c.err) {
+			t.Errorf("task %v with event %v: expected error %v, got %v", c.taskState, c.eventState, c.err, err)
+		}
+	}
+}
+
+func TestStatsTimeoutAppliedToNodes(t *testing.T) {
+	m, err := New([]string{"localhost:5556"}, "roundrobin", "memory", Config{StatsTimeout: 2 * time.Second})
+	if err != nil {
+		t.Fatalf("failed to create manager: %v", err)
+	}
+	defer m.Close()
+
+	if timeout := m.WorkerNodes[0].StatsTimeout; timeout != 2*time.Second {
+		t.Errorf("expected the node stats timeout to be 2s, got %v", timeout)
+	}
+}
+
+// Run with -race: the duplicate submissions are concurrent
+func TestDuplicateSubmissionRejected(t *testing.T) {
+	fw := newFakeWorker(t)
+	m := newTestManager(t, fw)
+	tEvent := newTaskEvent("web")
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	accepted := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			retry := tEvent
+			retry.Id = uuid.New()
+			err := m.SubmitTask(retry)
+			if err != nil && !errors.Is(err, ErrTaskSubmitted) {
+				t.Errorf("expected ErrTaskSubmitted for a duplicate submission, got %v", err)
+			}
+			if err == nil {
+				mu.Lock()
+				accepted++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if accepted != 1 || m.Pending.Len() != 1 {
+		t.Fatalf("expected a single submission to be queued, got %d accepted and %d queued", accepted, m.Pending.Len())
+	}
+
+	queued, _ := m.Pending.Pop()
+	m.sendWork(queued)
+	if err := m.SubmitTask(tEvent); !errors.Is(err, ErrTaskSubmitted) {
+		t.Errorf("expected the submission of an assigned task to be rejected, got %v", err)
+	}
+	if events := fw.receivedEvents(); len(events) != 1 {
+		t.Errorf("expected the task to be sent once to the worker, got %d events", len(events))
+	}
+}
+
+func TestSubmissionHeldUntilScheduled(t *testing.T) {
+	fw := newFakeWorker(t)
+	fw.startStatus = func(tEvent task.TaskEvent) int { return http.StatusServiceUnavailable }
+	m := newTestManager(t, fw)
+	tEvent := newTaskEvent("web")
+	if err := m.SubmitTask(tEvent); err != nil {
+		t.Fatalf("failed to submit task: %v", err)
+	}
+
+	// The failed start is queued again, the submission is kept
+	queued, _ := m.Pending.Pop()
+	m.sendWork(queued)
+	if err := m.SubmitTask(tEvent); !errors.Is(err, ErrTaskSubmitted) {
+		t.Errorf("expected the submission to be held while the task is retried, got %v", err)
+	}
+
+	// A task which won't be scheduled releases its submission
+	rejected := newTaskEvent("db")
+	rejected.Task.State = task.Failed
+	if err := m.SubmitTask(rejected); err != nil {
+		t.Fatalf("failed to submit task: %v", err)
+	}
+	m.sendWork(rejected)
+	for _, submitted := range m.submittedTasks() {
+		if submitted.Id == rejected.Task.Id {
+			t.Error("expected the abandoned submission to be released")
+		}
+	}
+}
+
+func TestRegistryAuthIsNeverStored(t *testing.T) {
+	fw := newFakeWorker(t)
+	m := newTestManager(t, fw)
+	api := newTestApi(m)
+
+	tEvent := newTaskEvent("web")
+	tEvent.RegistryAuth = &task.RegistryAuth{Username: "user", Password: "s3cr3t"}
+	if rec := api.serve(t, http.MethodPost, "/tasks", tEvent); rec.Code != http.StatusCreated {
+		t.Fatalf("unexpected start status %d: %s", rec.Code, rec.Body)
+	}
+	queued, _ := m.Pending.Pop()
+	m.sendWork(queued)
+
+	events := fw.receivedEvents()
+	if len(events) != 1 {
+		t.Fatalf("expected 1 event sent to the worker, got %d", len(events))
+	}
+	if events[0].RegistryAuth == nil || events[0].RegistryAuth.Password != "s3cr3t" {
+		t.Fatalf("expected the credentials to be sent to the worker, got %v", events[0].RegistryAuth)
+	}
+
+	stored, err := m.TaskDb.Get(tEvent.Task.Id)
+	if err != nil {
+		t.Fatalf("failed to get stored task: %v", err)
+	}
+	if stored.RegistryAuth != nil {
+		t.Error("credentials stored with the task")
+	}
+	storedEvents, err := m.EventDb.List()
+	if err != nil {
+		t.Fatalf("failed to list stored events: %v", err)
+	}
+	for _, e := range storedEvents {
+		if e.RegistryAuth != nil || e.Task.RegistryAuth != nil {
+			t.Errorf("credentials stored with event %v", e.Id)
+		}
+	}
+	for _, url := range []string{"/tasks", "/tasks/" + tEvent.Task.Id.String()} {
+		rec := api.serve(t, http.MethodGet, url, nil)
+		if strings.Contains(rec.Body.String(), "s3cr3t") {
+			t.Errorf("credentials returned by GET %s", url)
+		}
+	}
+
+	// The credentials supplied with the task are still used when it is restarted
+	stored.State = task.Failed
+	m.restartTask(stored)
+	events = fw.receivedEvents()
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events sent to the worker, got %d", len(events))
+	}
+	if events[1].RegistryAuth == nil || events[1].RegistryAuth.Password != "s3cr3t" {
+		t.Errorf("expected the credentials to be sent with the restart, got %v", events[1].RegistryAuth)
+	}
+}
+
+func TestRegistryCredentialsInjectedByHost(t *testing.T) {
+	fw := newFakeWorker(t)
+	m := newTestManager(t, fw)
+	m.Config.RegistryCredentials = map[string]task.RegistryAuth{"registry.example.com": {Username: "manager", Password: "secret"}}
+
+	private := newTaskEvent("private")
+	private.Task.Image = "registry.example.com/shop/web:1.0"
+	public := newTaskEvent("public")
+	for _, tEvent := range []task.TaskEvent{private, public} {
+		m.sendWork(tEvent)
+	}
+
+	events := fw.receivedEvents()
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events sent to the worker, got %d", len(events))
+	}
+	if auth := events[0].RegistryAuth; auth == nil || auth.Username != "manager" {
+		t.Errorf("expected the registry credentials to be sent with the private image task, got %v", auth)
+	}
+	if auth := events[1].RegistryAuth; auth != nil {
+		t.Errorf("expected no credentials for the Docker Hub image task, got %v", auth)
+	}
+}
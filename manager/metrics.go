@@ -0,0 +1,57 @@
+package manager
+
+import (
+	"sync"
+	"time"
+)
+
+// Summary of observed durations
+type DurationSummary struct {
+	Count       int     `json:"count"`
+	SumSeconds  float64 `json:"sum_seconds"`
+	MaxSeconds  float64 `json:"max_seconds"`
+	LastSeconds float64 `json:"last_seconds"`
+}
+
+func (d *DurationSummary) observe(duration time.Duration) {
+	seconds := duration.Seconds()
+	d.Count++
+	d.SumSeconds += seconds
+	d.LastSeconds = seconds
+	if seconds > d.MaxSeconds {
+		d.MaxSeconds = seconds
+	}
+}
+
+// Manager operational metrics, exposed on the metrics API route
+type MetricsReport struct {
+	SchedulerDecisionDuration DurationSummary `json:"scheduler_decision_duration"`
+	SchedulingFailures        int             `json:"scheduling_failures"`
+}
+
+// Thread-safe collector of the manager operational metrics
+type Metrics struct {
+	mu     sync.Mutex
+	report MetricsReport
+}
+
+// Record the time taken by the scheduler to select a worker node
+func (m *Metrics) ObserveSchedulerDecision(duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.report.SchedulerDecisionDuration.observe(duration)
+}
+
+// Count a task which couldn't be placed on any node
+func (m *Metrics) ObserveSchedulingFailure() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.report.SchedulingFailures++
+}
+
+// Get a copy of the current metrics values
+func (m *Metrics) Report() MetricsReport {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.report
+}
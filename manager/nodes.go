@@ -0,0 +1,309 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"orchestrator/node"
+	"orchestrator/task"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	ErrNodeNotFound     = errors.New("node not found")
+	ErrNodeHasTasks     = errors.New("node tasks can't be moved to another node")
+	ErrPortsUnavailable = errors.New("host ports unavailable")
+)
+
+// Resources requested by the active tasks assigned to a node
+type NodeReservation struct {
+	Tasks  int
+	Cpu    float64
+	Memory int64
+	Disk   int64
+}
+
+// Worker node representation returned by the API, with its capacity, usage and reservations
+//
+// Memory values are expressed in KB and disk values in bytes, as reported by the worker
+type NodeResponse struct {
+	Name              string
+	Api               string
+	Role              string
+	TaskCount         int
+	MemoryTotal       int64
+	MemoryUsed        int64
+	MemoryFree        int64
+	MemoryUsedPercent float64
+	DiskTotal         int64
+	DiskUsed          int64
+	DiskFree          int64
+	DiskUsedPercent   float64
+	CpuUsedPercent    float64
+	CpuLimit          float64 // CPUs reservable by tasks, 0 for no limit
+	Draining          bool
+	Labels            map[string]string
+	Status            node.Status
+	LastHeartbeat     time.Time
+	LastStatsUpdate   time.Time
+	Reserved          NodeReservation
+}
+
+func newNodeResponse(n *node.Node, reservation NodeReservation) NodeResponse {
+	response := NodeResponse{
+		Name:            n.Name,
+		Api:             n.Api,
+		Role:            n.Role,
+		TaskCount:       n.TaskCount,
+		MemoryTotal:     n.Memory,
+		MemoryUsed:      n.MemoryAllocated,
+		MemoryFree:      n.Memory - n.MemoryAllocated,
+		DiskTotal:       n.Disk,
+		DiskUsed:        n.DiskAllocated,
+		DiskFree:        n.Disk - n.DiskAllocated,
+		CpuLimit:        n.CpuLimit(),
+		Draining:        n.Draining,
+		Labels:          n.Labels,
+		Status:          n.Status,
+		LastHeartbeat:   n.LastHeartbeat,
+		LastStatsUpdate: n.LastStatsUpdate,
+		Reserved:        reservation,
+	}
+	if n.Memory != 0 {
+		response.MemoryUsedPercent = float64(n.MemoryAllocated) / float64(n.Memory) * 100
+	}
+	if n.Disk != 0 {
+		response.DiskUsedPercent = float64(n.DiskAllocated) / float64(n.Disk) * 100
+	}
+	if usage, err := n.Stats.CpuUsage(); err == nil {
+		response.CpuUsedPercent = usage * 100
+	}
+	return response
+}
+
+// Get the state of the registered worker nodes
+func (m *Manager) nodeResponses() []NodeResponse {
+	nodes := m.nodes()
+	responses := make([]NodeResponse, len(nodes))
+	for i, n := range nodes {
+		reservation := m.GetNodeReservation(n.Name)
+		m.mu.RLock()
+		responses[i] = newNodeResponse(n, reservation)
+		m.mu.RUnlock()
+	}
+	return responses
+}
+
+// Get the sum of the resources requested by the active tasks assigned to the given worker node
+func (m *Manager) GetNodeReservation(worker string) NodeReservation {
+	reservation := NodeReservation{}
+	for _, taskId := range m.workerTaskIds(worker) {
+		t, err := m.TaskDb.Get(taskId)
+		if err != nil || t.State == task.Completed || t.State == task.Failed {
+			continue
+		}
+		reservation.Tasks++
+		reservation.Cpu += t.Cpu
+		reservation.Memory += t.Memory
+		reservation.Disk += t.Disk
+	}
+	return reservation
+}
+
+// Stop scheduling tasks on a worker node and move its active tasks to other nodes
+//
+// The number of tasks queued for rescheduling is returned
+func (m *Manager) DrainNode(name string) (int, error) {
+	wNode := m.getWorkerNode(name)
+	if wNode == nil {
+		return 0, ErrNodeNotFound
+	}
+	m.mu.Lock()
+	wNode.Draining = true
+	m.mu.Unlock()
+
+	migrated := m.rescheduleTasksFrom(name)
+	m.mu.Lock()
+	wNode.TaskCount = 0
+	wNode.CpuReserved = 0
+	m.mu.Unlock()
+
+	log.Info().Str("node", name).Int("migrated-tasks", migrated).Msg("node drained")
+	return migrated, nil
+}
+
+// Drain a worker node then remove it from the cluster, the containers of its moved tasks are stopped
+//
+// ErrNodeHasTasks is returned, without draining the node, when no other node can receive one of its runnable tasks.
+// The number of tasks queued for rescheduling is returned
+func (m *Manager) RemoveNode(name string) (int, error) {
+	if m.getWorkerNode(name) == nil {
+		return 0, ErrNodeNotFound
+	}
+	taskIds, err := m.checkTasksMovable(name)
+	if err != nil {
+		return 0, err
+	}
+
+	migrated, err := m.DrainNode(name)
+	if err != nil {
+		return 0, err
+	}
+	for _, taskId := range taskIds {
+		m.stopStaleTask(taskId, name)
+	}
+
+	m.mu.Lock()
+	m.WorkerNodes = slices.DeleteFunc(slices.Clone(m.WorkerNodes), func(n *node.Node) bool { return n.Name == name })
+	m.Workers = slices.DeleteFunc(slices.Clone(m.Workers), func(worker string) bool { return worker == name })
+	for _, taskId := range m.WorkerTaskMap[name] {
+		delete(m.TaskWorkerMap, taskId)
+		m.deleteStoredAssignment(taskId)
+	}
+	delete(m.WorkerTaskMap, name)
+	m.mu.Unlock()
+
+	log.Info().Str("node", name).Int("migrated-tasks", migrated).Msg("node removed")
+	return migrated, nil
+}
+
+// Verify that every runnable task of a worker node can be placed on another node
+//
+// The ids of the runnable tasks are returned
+func (m *Manager) checkTasksMovable(name string) ([]uuid.UUID, error) {
+	var candidates []*node.Node
+	for _, n := range m.schedulableNodes() {
+		if n.Name != name {
+			candidates = append(candidates, n)
+		}
+	}
+
+	var taskIds []uuid.UUID
+	for _, taskId := range m.workerTaskIds(name) {
+		t, err := m.TaskDb.Get(taskId)
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve task %v: %w", taskId, err)
+		}
+		if !m.isRunnable(t) {
+			continue
+		}
+		movable := slices.ContainsFunc(m.withoutAntiAffinity(t, candidates), func(n *node.Node) bool {
+			return n.MatchSelector(t.NodeSelector)
+		})
+		if !movable {
+			return nil, fmt.Errorf("%w: no node can run task %v", ErrNodeHasTasks, t.Id)
+		}
+		taskIds = append(taskIds, t.Id)
+	}
+	return taskIds, nil
+}
+
+// Get a copy of the worker nodes which can receive new tasks, the nodes with stale stats are left out
+//
+// The copies can be handed to the scheduler, which refreshes the stats of the nodes it scores
+func (m *Manager) schedulableNodes() []*node.Node {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	nodes := make([]*node.Node, 0, len(m.WorkerNodes))
+	for _, n := range m.WorkerNodes {
+		if !n.Draining && n.Status != node.Offline && !n.StatsStale(staleStatsIntervals*m.Config.StatsInterval) {
+			nodeCopy := *n
+			nodes = append(nodes, &nodeCopy)
+		}
+	}
+	return nodes
+}
+
+// Get the nodes not running any of the tasks the given task must not be colocated with
+func (m *Manager) withoutAntiAffinity(t task.Task, nodes []*node.Node) []*node.Node {
+	if len(t.AntiAffinity) == 0 {
+		return nodes
+	}
+	var allowed []*node.Node
+	for _, n := range nodes {
+		if !m.runsAnyTask(n.Name, t.Namespace, t.AntiAffinity) {
+			allowed = append(allowed, n)
+		}
+	}
+	return allowed
+}
+
+// Get the nodes on which none of the host ports requested by the given task are bound by another active task
+func (m *Manager) withoutPortConflicts(t task.Task, nodes []*node.Node) []*node.Node {
+	ports := hostPorts(t)
+	if len(ports) == 0 {
+		return nodes
+	}
+	var allowed []*node.Node
+	for _, n := range nodes {
+		if !m.bindsAnyPort(n.Name, t.Id, ports) {
+			allowed = append(allowed, n)
+		}
+	}
+	return allowed
+}
+
+// Check if one of the active tasks assigned to the worker, other than the given one, binds one of the given host ports
+func (m *Manager) bindsAnyPort(worker string, taskId uuid.UUID, ports []string) bool {
+	for _, id := range m.workerTaskIds(worker) {
+		if id == taskId {
+			continue
+		}
+		t, err := m.TaskDb.Get(id)
+		if err != nil || t.State == task.Completed {
+			continue
+		}
+		for _, port := range hostPorts(t) {
+			if slices.Contains(ports, port) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Get the host ports bound by a task, in the "port/protocol" format, the ports left to Docker being ignored
+func hostPorts(t task.Task) []string {
+	var ports []string
+	for containerPort, hostPort := range t.PortBindings {
+		if hostPort == "" || hostPort == "0" {
+			continue
+		}
+		ports = append(ports, fmt.Sprintf("%s/%s", hostPort, nat.Port(containerPort).Proto()))
+	}
+	return ports
+}
+
+// Check that a node could bind the host ports requested by a task, an error wrapping ErrPortsUnavailable is returned
+// when all the schedulable nodes already bind one of them
+//
+// The task is accepted when no node is schedulable, it will be placed once a node is available
+func (m *Manager) CheckHostPorts(t task.Task) error {
+	if t.IsAdoption() {
+		return nil
+	}
+	nodes := m.schedulableNodes()
+	if len(nodes) != 0 && len(m.withoutPortConflicts(t, nodes)) == 0 {
+		return fmt.Errorf("%w: host ports %s are bound on every node", ErrPortsUnavailable, strings.Join(hostPorts(t), ", "))
+	}
+	return nil
+}
+
+// Check if one of the active tasks assigned to the worker has one of the given names in the namespace
+func (m *Manager) runsAnyTask(worker string, namespace string, names []string) bool {
+	for _, taskId := range m.workerTaskIds(worker) {
+		t, err := m.TaskDb.Get(taskId)
+		if err != nil || t.State == task.Completed {
+			continue
+		}
+		if t.Namespace == namespace && slices.Contains(names, t.Name) {
+			return true
+		}
+	}
+	return false
+}
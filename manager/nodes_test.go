@@ -0,0 +1,333 @@
+package manager
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/c9s/goprocinfo/linux"
+	"github.com/google/uuid"
+
+	"orchestrator/stats"
+	"orchestrator/task"
+	"orchestrator/worker"
+)
+
+func TestGetNodesComputesCapacityAndReservations(t *testing.T) {
+	fw := newFakeWorker(t)
+	m := newTestManager(t, fw)
+	n := m.WorkerNodes[0]
+	n.Memory = 4000
+	n.MemoryAllocated = 1000
+	n.Disk = 10000
+	n.DiskAllocated = 2500
+	n.Stats = stats.Stats{CpuStats: &linux.CPUStat{User: 30, Idle: 70}}
+
+	// Only the active tasks are reserved
+	for _, state := range []task.State{task.Running, task.Scheduled, task.Completed} {
+		tk := task.Task{Id: uuid.New(), State: state, Cpu: 0.5, Memory: 100, Disk: 200}
+		if err := m.TaskDb.Put(tk.Id, tk); err != nil {
+			t.Fatalf("failed to store task: %v", err)
+		}
+		m.WorkerTaskMap[fw.addr()] = append(m.WorkerTaskMap[fw.addr()], tk.Id)
+	}
+
+	rec := httptest.NewRecorder()
+	newTestApi(m).Router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/nodes", nil))
+	var nodes []NodeResponse
+	if err := json.NewDecoder(rec.Body).Decode(&nodes); err != nil {
+		t.Fatalf("failed to decode nodes: %v", err)
+	}
+	if len(nodes) != 1 {
+		t.Fatalf("expected 1 node, got %d", len(nodes))
+	}
+
+	got := nodes[0]
+	if got.MemoryTotal != 4000 || got.MemoryUsed != 1000 || got.MemoryFree != 3000 || got.MemoryUsedPercent != 25 {
+		t.Errorf("unexpected memory figures: %+v", got)
+	}
+	if got.DiskTotal != 10000 || got.DiskUsed != 2500 || got.DiskFree != 7500 || got.DiskUsedPercent != 25 {
+		t.Errorf("unexpected disk figures: %+v", got)
+	}
+	if got.CpuUsedPercent != 30 {
+		t.Errorf("expected 30%% of CPU used, got %f", got.CpuUsedPercent)
+	}
+	expected := NodeReservation{Tasks: 2, Cpu: 1, Memory: 200, Disk: 400}
+	if got.Reserved != expected {
+		t.Errorf("expected reservation %+v, got %+v", expected, got.Reserved)
+	}
+}
+
+func TestGetNodesWithoutStats(t *testing.T) {
+	m := newTestManager(t, newFakeWorker(t))
+
+	rec := httptest.NewRecorder()
+	newTestApi(m).Router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/nodes", nil))
+	var nodes []NodeResponse
+	if err := json.NewDecoder(rec.Body).Decode(&nodes); err != nil {
+		t.Fatalf("failed to decode nodes: %v", err)
+	}
+	if len(nodes) != 1 || nodes[0].MemoryUsedPercent != 0 || nodes[0].DiskUsedPercent != 0 || nodes[0].CpuUsedPercent != 0 {
+		t.Errorf("expected zero usage percentages for a node without stats, got %+v", nodes)
+	}
+}
+
+func TestNodeLimitsAppliedToRegisteredNodes(t *testing.T) {
+	fw := newFakeWorker(t)
+	limits := NodeLimits{MaxCpu: 2, MaxMemory: 1000, MaxDisk: 5000}
+	m, err := New([]string{fw.addr()}, "roundrobin", "memory", Config{NodeLimits: map[string]NodeLimits{fw.addr(): limits}})
+	if err != nil {
+		t.Fatalf("failed to create manager: %v", err)
+	}
+	defer m.Close()
+	if n := m.WorkerNodes[0]; n.MaxCpu != 2 || n.MaxMemory != 1000 || n.MaxDisk != 5000 {
+		t.Errorf("expected the node limits to be set, got %+v", n)
+	}
+
+	if _, err := New([]string{fw.addr()}, "roundrobin", "memory", Config{NodeLimits: map[string]NodeLimits{"unknown:5556": limits}}); err == nil {
+		t.Error("expected limits of an unknown worker to be rejected")
+	}
+}
+
+func TestDrainNodeMigratesActiveTasks(t *testing.T) {
+	fw := newFakeWorker(t)
+	m := newTestManager(t, fw)
+	running := storeAssignedTask(t, m, fw.addr())
+	completed := storeAssignedTask(t, m, fw.addr())
+	completed.State = task.Completed
+	if err := m.TaskDb.Put(completed.Id, completed); err != nil {
+		t.Fatalf("failed to store task: %v", err)
+	}
+
+	migrated, err := m.DrainNode(fw.addr())
+	if err != nil {
+		t.Fatalf("failed to drain node: %v", err)
+	}
+	if migrated != 1 {
+		t.Errorf("expected 1 migrated task, got %d", migrated)
+	}
+	if m.Pending.Len() != 1 {
+		t.Fatalf("expected the running task to be queued for rescheduling, got %d queued events", m.Pending.Len())
+	}
+	if tEvent, _ := m.Pending.Pop(); tEvent.Task.Id != running.Id || tEvent.Task.ContainerId != "" {
+		t.Errorf("expected the running task to be requeued without container, got %+v", tEvent.Task)
+	}
+	if _, assigned := m.TaskWorkerMap[running.Id]; assigned {
+		t.Error("expected the migrated task to be unassigned from the drained node")
+	}
+	if !m.WorkerNodes[0].Draining || len(m.schedulableNodes()) != 0 {
+		t.Error("expected the drained node to no longer be schedulable")
+	}
+}
+
+func TestDrainNodeHandler(t *testing.T) {
+	fw := newFakeWorker(t)
+	m := newTestManager(t, fw)
+	storeAssignedTask(t, m, fw.addr())
+	api := newTestApi(m)
+
+	rec := api.serve(t, http.MethodPost, "/nodes/"+fw.addr()+"/drain", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	ack := worker.DrainResponse{}
+	if err := json.NewDecoder(rec.Body).Decode(&ack); err != nil {
+		t.Fatalf("failed to decode drain response: %v", err)
+	}
+	if ack.Node != fw.addr() || ack.MigratedTasks != 1 {
+		t.Errorf("unexpected drain acknowledgment %+v", ack)
+	}
+
+	if rec := api.serve(t, http.MethodPost, "/nodes/unknown/drain", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("expected status %d for an unknown node, got %d", http.StatusNotFound, rec.Code)
+	}
+}
+
+// Run with -race: the stats polling, the scheduler and the nodes listing share the registered nodes
+func TestNodeStatsUpdatedWhileScheduling(t *testing.T) {
+	m := newTestManager(t, newLoadedWorker(t, 1000000), newLoadedWorker(t, 2000000))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			m.updateNodesStats()
+		}()
+		go func() {
+			defer wg.Done()
+			wNode, err := m.selectWorker(newTaskEvent("web").Task)
+			if err != nil {
+				t.Errorf("failed to select a worker: %v", err)
+				return
+			}
+			m.reserveOnNode(wNode.Name, 1, 0)
+		}()
+		go func() {
+			defer wg.Done()
+			m.nodeResponses()
+		}()
+	}
+	wg.Wait()
+
+	taskCount := 0
+	for _, n := range m.nodeResponses() {
+		taskCount += n.TaskCount
+	}
+	if taskCount != 3 {
+		t.Errorf("expected 3 tasks reserved on the registered nodes, got %d", taskCount)
+	}
+}
+
+func TestNodeWithStaleStatsNotSchedulable(t *testing.T) {
+	stale := newLoadedWorker(t, 500000)
+	fresh := newLoadedWorker(t, 500000)
+	m := newTestManager(t, stale, fresh)
+	m.WorkerNodes[0].LastStatsUpdate = time.Now().UTC().Add(-2 * staleStatsIntervals * m.Config.StatsInterval)
+
+	nodes := m.schedulableNodes()
+	if len(nodes) != 1 || nodes[0].Name != fresh.addr() {
+		t.Fatalf("expected only the node with fresh stats to be schedulable, got %d nodes", len(nodes))
+	}
+	for i := 0; i < 2; i++ {
+		wNode, err := m.selectWorker(task.Task{Id: uuid.New()})
+		if err != nil {
+			t.Fatalf("failed to select a worker: %v", err)
+		}
+		if wNode.Name != fresh.addr() {
+			t.Errorf("expected the node with stale stats to be skipped, got %s", wNode.Name)
+		}
+	}
+
+	// A failed stats retrieval doesn't refresh the node, a successful one does
+	stale.mu.Lock()
+	stale.stats = nil
+	stale.mu.Unlock()
+	m.updateNodesStats()
+	if len(m.schedulableNodes()) != 1 {
+		t.Error("expected the node to stay left out while its stats can't be retrieved")
+	}
+	stale.mu.Lock()
+	stale.stats = fresh.stats
+	stale.mu.Unlock()
+	m.updateNodesStats()
+	if len(m.schedulableNodes()) != 2 {
+		t.Error("expected the node to be schedulable again once its stats are retrieved")
+	}
+}
+
+func TestNodeWithoutStatsNotSchedulable(t *testing.T) {
+	fw := newFakeWorker(t)
+	m, err := New([]string{fw.addr()}, "roundrobin", "memory", Config{})
+	if err != nil {
+		t.Fatalf("failed to create manager: %v", err)
+	}
+	defer m.Close()
+
+	if _, err := m.selectWorker(task.Task{Id: uuid.New()}); err == nil {
+		t.Error("expected a node which never reported its stats not to receive tasks")
+	}
+}
+
+func TestRemoveNodeMovesItsTasks(t *testing.T) {
+	removed := newFakeWorker(t)
+	remaining := newFakeWorker(t)
+	m := newTestManager(t, removed, remaining)
+	tk := storeAssignedTask(t, m, removed.addr())
+	api := newTestApi(m)
+
+	rec := api.serve(t, http.MethodDelete, "/nodes/"+removed.addr(), nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	ack := worker.DrainResponse{}
+	if err := json.NewDecoder(rec.Body).Decode(&ack); err != nil {
+		t.Fatalf("failed to decode removal response: %v", err)
+	}
+	if ack.Node != removed.addr() || ack.MigratedTasks != 1 {
+		t.Errorf("unexpected removal acknowledgment %+v", ack)
+	}
+	if m.getWorkerNode(removed.addr()) != nil || len(m.workers()) != 1 {
+		t.Errorf("expected the node to be removed, got the workers %v", m.workers())
+	}
+	if stops := removed.receivedStops(); len(stops) != 1 || stops[0] != tk.Id {
+		t.Errorf("expected the task container to be stopped on the removed node, got stops %v", stops)
+	}
+
+	tEvent, ok := m.Pending.Pop()
+	if !ok {
+		t.Fatal("expected the task to be queued for rescheduling")
+	}
+	m.sendWork(tEvent)
+	if events := remaining.receivedEvents(); len(events) != 1 || events[0].Task.Id != tk.Id {
+		t.Fatalf("expected the task to be sent to the remaining node, got %d events", len(events))
+	}
+	if worker, _ := m.taskWorker(tk.Id); worker != remaining.addr() {
+		t.Errorf("expected the task to be assigned to the remaining node, got %q", worker)
+	}
+}
+
+func TestRemoveNodeWithUnmovableTask(t *testing.T) {
+	fw := newFakeWorker(t)
+	m := newTestManager(t, fw)
+	storeAssignedTask(t, m, fw.addr())
+	api := newTestApi(m)
+
+	if rec := api.serve(t, http.MethodDelete, "/nodes/"+fw.addr(), nil); rec.Code != http.StatusConflict {
+		t.Fatalf("expected status %d, got %d", http.StatusConflict, rec.Code)
+	}
+	n := m.getWorkerNode(fw.addr())
+	if n == nil || n.Draining {
+		t.Error("expected the node to be kept without being drained")
+	}
+	if len(fw.receivedStops()) != 0 || m.Pending.Len() != 0 {
+		t.Error("expected the task to be left on the node")
+	}
+
+	if rec := api.serve(t, http.MethodDelete, "/nodes/unknown", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("expected status %d for an unknown node, got %d", http.StatusNotFound, rec.Code)
+	}
+}
+
+func TestRemoveNodeIgnoresTasksWhichWontRunAgain(t *testing.T) {
+	fw := newFakeWorker(t)
+	m := newTestManager(t, fw)
+	for _, state := range []task.State{task.Completed, task.Failed} {
+		tk := storeAssignedTask(t, m, fw.addr())
+		tk.State = state
+		tk.RestartCount = DefaultMaxRestarts
+		if err := m.TaskDb.Put(tk.Id, tk); err != nil {
+			t.Fatalf("failed to store task: %v", err)
+		}
+	}
+
+	if _, err := m.RemoveNode(fw.addr()); err != nil {
+		t.Fatalf("expected the node to be removed, got %v", err)
+	}
+	if m.getWorkerNode(fw.addr()) != nil {
+		t.Error("expected the node to be removed")
+	}
+}
+
+func TestWorkerCapacityLimitsNodeCpu(t *testing.T) {
+	fw := newLoadedWorker(t, 500000)
+	fw.stats.CpuCapacity = 2
+	m := newTestManager(t, fw)
+	m.updateNodesStats()
+
+	rec := newTestApi(m).serve(t, http.MethodGet, "/nodes", nil)
+	var nodes []NodeResponse
+	if err := json.NewDecoder(rec.Body).Decode(&nodes); err != nil {
+		t.Fatalf("failed to decode nodes: %v", err)
+	}
+	if len(nodes) != 1 || nodes[0].CpuLimit != 2 {
+		t.Fatalf("expected the worker capacity to limit the node CPUs, got %+v", nodes)
+	}
+
+	if _, err := m.selectWorker(task.Task{Id: uuid.New(), Cpu: 3}); err == nil {
+		t.Error("expected a task requesting more CPUs than the worker capacity not to be placed")
+	}
+}
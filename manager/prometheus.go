@@ -0,0 +1,160 @@
+package manager
+
+import (
+	"net/http"
+	"orchestrator/task"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog/log"
+)
+
+const metricsNamespace = "orchestrator"
+
+// Manager metrics exposed in the Prometheus exposition format
+//
+// The counters are incremented by the manager, the gauges are computed from its state on each scrape
+type PrometheusMetrics struct {
+	registry           *prometheus.Registry
+	tasksScheduled     prometheus.Counter
+	tasksRestarted     prometheus.Counter
+	tasksStopped       prometheus.Counter
+	schedulerDecisions *prometheus.CounterVec
+	discrepancies      *prometheus.CounterVec
+}
+
+// Create the Prometheus metrics of the given manager, registered on a dedicated registry
+func newPrometheusMetrics(m *Manager) *PrometheusMetrics {
+	p := &PrometheusMetrics{
+		registry: prometheus.NewRegistry(),
+		tasksScheduled: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "tasks_scheduled_total",
+			Help:      "Number of tasks submitted to a worker node.",
+		}),
+		tasksRestarted: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "tasks_restarted_total",
+			Help:      "Number of tasks restarted on their worker node.",
+		}),
+		tasksStopped: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "tasks_stopped_total",
+			Help:      "Number of tasks stopped on their worker node.",
+		}),
+		schedulerDecisions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "scheduler_decisions_total",
+			Help:      "Number of worker node selections by the scheduler, by result.",
+		}, []string{"result"}),
+		discrepancies: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "assignment_discrepancies_total",
+			Help:      "Number of differences between the stored tasks assignments and the tasks reported by the workers, by kind.",
+		}, []string{"kind"}),
+	}
+	p.registry.MustRegister(
+		p.tasksScheduled,
+		p.tasksRestarted,
+		p.tasksStopped,
+		p.schedulerDecisions,
+		p.discrepancies,
+		newStateCollector(m),
+	)
+	for _, kind := range []string{"reassigned", "rescheduled", "unassigned", "unknown"} {
+		p.discrepancies.WithLabelValues(kind)
+	}
+	return p
+}
+
+// Count a worker node selection, successful or not
+func (p *PrometheusMetrics) observeSchedulerDecision(err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	p.schedulerDecisions.WithLabelValues(result).Inc()
+}
+
+// Count the discrepancies found by an assignments rebuild
+//
+// A reassigned task runs on another worker than the stored one, a rescheduled task is active but no worker runs it,
+// an unassigned task is no longer active nor reported, and an unknown task is reported by a worker but not stored
+func (p *PrometheusMetrics) observeRebuild(response RebuildResponse) {
+	for _, c := range response.Corrections {
+		switch {
+		case c.Worker != "":
+			p.discrepancies.WithLabelValues("reassigned").Inc()
+		case c.Rescheduled:
+			p.discrepancies.WithLabelValues("rescheduled").Inc()
+		default:
+			p.discrepancies.WithLabelValues("unassigned").Inc()
+		}
+	}
+	for _, ids := range response.Unknown {
+		p.discrepancies.WithLabelValues("unknown").Add(float64(len(ids)))
+	}
+}
+
+// Get the HTTP handler serving the metrics in the Prometheus exposition format
+func (p *PrometheusMetrics) Handler() http.Handler {
+	return promhttp.HandlerFor(p.registry, promhttp.HandlerOpts{})
+}
+
+// Collector of the gauges computed from the manager state
+type stateCollector struct {
+	manager       *Manager
+	tasksByState  *prometheus.Desc
+	pendingQueue  *prometheus.Desc
+	nodeTaskCount *prometheus.Desc
+}
+
+func newStateCollector(m *Manager) *stateCollector {
+	return &stateCollector{
+		manager: m,
+		tasksByState: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "", "tasks"),
+			"Number of stored tasks, by state.",
+			[]string{"state"}, nil,
+		),
+		pendingQueue: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "", "pending_queue_depth"),
+			"Number of task events waiting to be processed.",
+			nil, nil,
+		),
+		nodeTaskCount: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "", "node_tasks"),
+			"Number of tasks assigned to a worker node.",
+			[]string{"node"}, nil,
+		),
+	}
+}
+
+func (c *stateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.tasksByState
+	ch <- c.pendingQueue
+	ch <- c.nodeTaskCount
+}
+
+func (c *stateCollector) Collect(ch chan<- prometheus.Metric) {
+	counts := make(map[task.State]int)
+	for _, state := range []task.State{task.Pending, task.Scheduled, task.Running, task.Completed, task.Failed} {
+		counts[state] = 0
+	}
+	tasks, err := c.manager.TaskDb.List()
+	if err != nil {
+		log.Err(err).Msg("failed to list tasks for metrics collection")
+	}
+	for _, t := range tasks {
+		counts[t.State]++
+	}
+	for state, count := range counts {
+		ch <- prometheus.MustNewConstMetric(c.tasksByState, prometheus.GaugeValue, float64(count), state.String())
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.pendingQueue, prometheus.GaugeValue, float64(c.manager.Pending.Len()))
+
+	for _, n := range c.manager.nodeResponses() {
+		ch <- prometheus.MustNewConstMetric(c.nodeTaskCount, prometheus.GaugeValue, float64(n.TaskCount), n.Name)
+	}
+}
@@ -0,0 +1,47 @@
+package manager
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestPrometheusMetricsExposed(t *testing.T) {
+	fw := newFakeWorker(t)
+	m := newTestManager(t, fw)
+	api := newTestApi(m)
+
+	tEvent := newTaskEvent("web")
+	m.sendWork(tEvent)
+	m.stopTask(tEvent.Task.Id, fw.addr())
+	// No node can run this one
+	m.WorkerNodes[0].MaxCpu = 1
+	big := newTaskEvent("big")
+	big.Task.Cpu = 2
+	m.sendWork(big)
+
+	rec := api.serve(t, http.MethodGet, "/metrics/prometheus", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	if contentType := rec.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain") {
+		t.Errorf("expected the text exposition format, got %q", contentType)
+	}
+	body := rec.Body.String()
+	expected := []string{
+		"# TYPE orchestrator_tasks_scheduled_total counter",
+		"orchestrator_tasks_scheduled_total 1",
+		"orchestrator_tasks_stopped_total 1",
+		"orchestrator_tasks_restarted_total 0",
+		`orchestrator_scheduler_decisions_total{result="success"} 1`,
+		`orchestrator_scheduler_decisions_total{result="failure"} 1`,
+		`orchestrator_tasks{state="completed"} 1`,
+		"# TYPE orchestrator_pending_queue_depth gauge",
+		`orchestrator_node_tasks{node="` + fw.addr() + `"} 0`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(body, line) {
+			t.Errorf("expected the metrics to contain %q, got:\n%s", line, body)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package manager
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"orchestrator/task"
+)
+
+// Waiting time after which a queued task event gains one priority level
+const queueAgingInterval = 10 * time.Second
+
+type queueItem struct {
+	event task.TaskEvent
+	rank  float64 // Priority adjusted with the enqueue time, for aging
+	seq   uint64  // Insertion order, to dequeue events of equal rank in FIFO order
+}
+
+type queueHeap []*queueItem
+
+func (h queueHeap) Len() int { return len(h) }
+
+func (h queueHeap) Less(i, j int) bool {
+	if h[i].rank != h[j].rank {
+		return h[i].rank > h[j].rank
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h queueHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *queueHeap) Push(x any) { *h = append(*h, x.(*queueItem)) }
+
+func (h *queueHeap) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return item
+}
+
+// Get the rank of an event with the given priority enqueued at the given time
+//
+// Since all events age at the same pace, aging can be applied once at insertion:
+// an event enqueued one interval earlier ranks as if it had one more priority level
+func queueRank(priority int, enqueuedAt time.Time) float64 {
+	return float64(priority) - float64(enqueuedAt.UnixNano())/float64(queueAgingInterval)
+}
+
+// Unbounded priority queue of task events, the events with the highest task priority are dequeued first
+//
+// Waiting events gain one priority level every queueAgingInterval so that low priority tasks aren't starved.
+// Stop events bypass the start backlog: they are kept apart and dequeued first, in FIFO order
+type TaskQueue struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	items  queueHeap
+	stops  []task.TaskEvent
+	seq    uint64
+	closed bool
+}
+
+// Create an empty task events queue
+func NewTaskQueue() *TaskQueue {
+	q := &TaskQueue{}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// Add an event to the queue, this call never blocks
+//
+// A stop event supersedes the queued start events of the same task, which are dropped so that
+// the task isn't started once the stop is processed
+func (q *TaskQueue) Push(tEvent task.TaskEvent) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return
+	}
+	if tEvent.State == task.Completed {
+		q.dropStarts(tEvent.Task.Id)
+		q.stops = append(q.stops, tEvent)
+		q.cond.Signal()
+		return
+	}
+
+	heap.Push(&q.items, &queueItem{
+		event: tEvent,
+		rank:  queueRank(tEvent.Task.Priority, time.Now()),
+		seq:   q.seq,
+	})
+	q.seq++
+	q.cond.Signal()
+}
+
+// Remove the queued start events of the given task, the caller must hold the lock
+func (q *TaskQueue) dropStarts(taskId uuid.UUID) {
+	kept := q.items[:0]
+	for _, item := range q.items {
+		if item.event.Task.Id != taskId {
+			kept = append(kept, item)
+		}
+	}
+	for i := len(kept); i < len(q.items); i++ {
+		q.items[i] = nil
+	}
+	q.items = kept
+	heap.Init(&q.items)
+}
+
+// Remove and return the oldest stop event, or the highest ranked event when there is no stop,
+// blocking until one is available
+//
+// The boolean is false when the queue has been closed
+func (q *TaskQueue) Pop() (task.TaskEvent, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.items) == 0 && len(q.stops) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if q.closed {
+		return task.TaskEvent{}, false
+	}
+	if len(q.stops) != 0 {
+		tEvent := q.stops[0]
+		q.stops = q.stops[1:]
+		return tEvent, true
+	}
+	return heap.Pop(&q.items).(*queueItem).event, true
+}
+
+// Get the number of queued events
+func (q *TaskQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items) + len(q.stops)
+}
+
+// Close the queue, waking up the blocked consumers
+func (q *TaskQueue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.cond.Broadcast()
+}
@@ -0,0 +1,95 @@
+package manager
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"orchestrator/task"
+)
+
+func queuedEvent(name string, priority int) task.TaskEvent {
+	return task.TaskEvent{Task: task.Task{Name: name, Priority: priority}}
+}
+
+func TestTaskQueuePopsHighestPriorityFirst(t *testing.T) {
+	q := NewTaskQueue()
+	q.Push(queuedEvent("low-1", 0))
+	q.Push(queuedEvent("low-2", 0))
+	q.Push(queuedEvent("high", 5))
+	q.Push(queuedEvent("medium", 2))
+
+	expected := []string{"high", "medium", "low-1", "low-2"}
+	for _, name := range expected {
+		tEvent, ok := q.Pop()
+		if !ok {
+			t.Fatal("queue closed unexpectedly")
+		}
+		if tEvent.Task.Name != name {
+			t.Errorf("expected %s to be dequeued, got %s", name, tEvent.Task.Name)
+		}
+	}
+	if q.Len() != 0 {
+		t.Errorf("expected an empty queue, got %d events", q.Len())
+	}
+}
+
+func TestTaskQueueRankAgesWaitingEvents(t *testing.T) {
+	now := time.Now()
+	waiting := queueRank(0, now.Add(-3*queueAgingInterval))
+	fresh := queueRank(2, now)
+	if waiting <= fresh {
+		t.Errorf("expected an event waiting for 3 intervals to outrank a fresh one with 2 more priority levels")
+	}
+	if diff := queueRank(1, now) - queueRank(0, now.Add(-queueAgingInterval)); math.Abs(diff) > 1e-6 {
+		t.Errorf("expected one waiting interval to be worth one priority level, got a rank difference of %f", diff)
+	}
+}
+
+func TestTaskQueueCloseWakesUpConsumers(t *testing.T) {
+	q := NewTaskQueue()
+	popped := make(chan bool)
+	go func() {
+		_, ok := q.Pop()
+		popped <- ok
+	}()
+
+	q.Close()
+	select {
+	case ok := <-popped:
+		if ok {
+			t.Error("expected the pop to report the queue closed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the consumer to wake up")
+	}
+}
+
+func TestTaskQueuePopsStopsAheadOfStarts(t *testing.T) {
+	q := NewTaskQueue()
+	var starts []task.TaskEvent
+	for i := 0; i < 5; i++ {
+		tEvent := queuedEvent("web", 5)
+		tEvent.Task.Id = uuid.New()
+		starts = append(starts, tEvent)
+		q.Push(tEvent)
+	}
+	stop := task.TaskEvent{State: task.Completed, Task: starts[3].Task}
+	q.Push(stop)
+
+	if q.Len() != 5 {
+		t.Fatalf("expected the stop to replace the start of its task, got %d events", q.Len())
+	}
+	tEvent, _ := q.Pop()
+	if tEvent.State != task.Completed || tEvent.Task.Id != stop.Task.Id {
+		t.Fatalf("expected the stop to be dequeued ahead of the starts backlog, got %v for task %s", tEvent.State, tEvent.Task.Id)
+	}
+	for q.Len() != 0 {
+		tEvent, _ := q.Pop()
+		if tEvent.Task.Id == stop.Task.Id {
+			t.Error("expected the start of the stopped task to be dropped")
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package manager
+
+import "time"
+
+// Fixed window limiter capping the number of actions per interval
+//
+// It isn't safe for concurrent use
+type rateLimiter struct {
+	limit       int           // Maximum number of actions per interval, 0 for no limit
+	interval    time.Duration // Duration of a window
+	windowStart time.Time
+	count       int
+}
+
+// Check if an action can be done at the given time, and count it if it is allowed
+func (l *rateLimiter) allow(now time.Time) bool {
+	if l.limit <= 0 {
+		return true
+	}
+	if now.Sub(l.windowStart) >= l.interval {
+		l.windowStart = now
+		l.count = 0
+	}
+	if l.count >= l.limit {
+		return false
+	}
+	l.count++
+	return true
+}
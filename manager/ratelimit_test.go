@@ -0,0 +1,29 @@
+package manager
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiterCapsActionsPerInterval(t *testing.T) {
+	l := rateLimiter{limit: 2, interval: time.Minute}
+	start := time.Now()
+
+	for i, expected := range []bool{true, true, false} {
+		if allowed := l.allow(start.Add(time.Duration(i) * time.Second)); allowed != expected {
+			t.Errorf("action %d: expected allowed %v, got %v", i, expected, allowed)
+		}
+	}
+	if !l.allow(start.Add(time.Minute)) {
+		t.Error("expected the actions to be allowed again in the next interval")
+	}
+}
+
+func TestRateLimiterWithoutLimit(t *testing.T) {
+	l := rateLimiter{}
+	for i := 0; i < 100; i++ {
+		if !l.allow(time.Now()) {
+			t.Fatalf("expected action %d to be allowed without limit", i)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"orchestrator/task"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// Change of a task assignment made when rebuilding the assignments from the workers
+type AssignmentCorrection struct {
+	TaskId         uuid.UUID
+	PreviousWorker string // Empty when the task wasn't assigned
+	Worker         string // Empty when no worker reports the task
+	Rescheduled    bool   // The task can still run but no worker runs it, it is queued to be scheduled again
+}
+
+// Result of the tasks assignments rebuild
+type RebuildResponse struct {
+	Workers     int      // Number of workers whose tasks were retrieved
+	Unreachable []string // Workers which couldn't be queried, the assignments to them are kept
+	Corrections []AssignmentCorrection
+	Unknown     map[string][]uuid.UUID // Tasks reported by workers but absent from the store, by worker, they are left running
+}
+
+// Rebuild the tasks assignments from the tasks reported by the workers, which are authoritative
+//
+// A task reported by several workers is assigned to the one running it. The tasks assigned to a reachable worker
+// which doesn't report them are unassigned, and rescheduled if they can still run. The tasks being scheduled
+// are left untouched since the worker may not have stored them yet
+func (m *Manager) RebuildAssignments() (RebuildResponse, error) {
+	response := RebuildResponse{Unreachable: []string{}, Corrections: []AssignmentCorrection{}, Unknown: map[string][]uuid.UUID{}}
+	reported := make(map[uuid.UUID]task.Task)
+	reportedBy := make(map[uuid.UUID]string)
+	reachable := make(map[string]bool)
+	for _, worker := range m.workers() {
+		tasks, err := fetchWorkerTasks(worker)
+		if err != nil {
+			log.Err(err).Str("worker", worker).Msg("failed to retrieve worker tasks, its assignments are kept")
+			response.Unreachable = append(response.Unreachable, worker)
+			continue
+		}
+		reachable[worker] = true
+		response.Workers++
+		for _, t := range tasks {
+			if previous, found := reported[t.Id]; found && !isActive(t) && isActive(previous) {
+				continue
+			}
+			reported[t.Id] = t
+			reportedBy[t.Id] = worker
+		}
+	}
+
+	tasks, err := m.TaskDb.List()
+	if err != nil {
+		return response, fmt.Errorf("failed to list tasks: %w", err)
+	}
+
+	stored := make(map[uuid.UUID]bool, len(tasks))
+	for _, t := range tasks {
+		stored[t.Id] = true
+	}
+	for id, worker := range reportedBy {
+		if !stored[id] {
+			response.Unknown[worker] = append(response.Unknown[worker], id)
+		}
+	}
+
+	var rescheduled []task.Task
+	m.mu.Lock()
+	for _, t := range tasks {
+		current, assigned := m.TaskWorkerMap[t.Id]
+		worker, found := reportedBy[t.Id]
+		switch {
+		case found && current == worker:
+			continue
+		case !found && (!assigned || !reachable[current] || t.State == task.Scheduled):
+			continue
+		}
+
+		m.removeAssignment(t.Id)
+		if n := m.findWorkerNode(current); n != nil && assigned && t.State != task.Completed {
+			n.TaskCount--
+			n.CpuReserved -= t.Cpu
+		}
+		correction := AssignmentCorrection{TaskId: t.Id, PreviousWorker: current, Worker: worker}
+		if found {
+			m.addAssignment(t.Id, worker)
+			if n := m.findWorkerNode(worker); n != nil && t.State != task.Completed {
+				n.TaskCount++
+				n.CpuReserved += t.Cpu
+			}
+		} else if m.isRunnable(t) {
+			correction.Rescheduled = true
+			rescheduled = append(rescheduled, t)
+		}
+		response.Corrections = append(response.Corrections, correction)
+	}
+	m.mu.Unlock()
+
+	for _, t := range rescheduled {
+		m.migrateTask(t)
+	}
+	for _, c := range response.Corrections {
+		log.Warn().
+			Str("task-id", c.TaskId.String()).
+			Str("previous-worker", c.PreviousWorker).
+			Str("worker", c.Worker).
+			Bool("rescheduled", c.Rescheduled).
+			Msg("task assignment corrected")
+	}
+	for worker, ids := range response.Unknown {
+		for _, id := range ids {
+			log.Warn().Str("task-id", id.String()).Str("worker", worker).Msg("worker reports a task absent from the store")
+		}
+	}
+	m.Prometheus.observeRebuild(response)
+	return response, nil
+}
+
+// Check if a task reported by a worker has a container which is starting or running
+func isActive(t task.Task) bool {
+	return t.State == task.Scheduled || t.State == task.Running
+}
+
+// Get the tasks stored by a worker, its running tasks being checked against their container
+func fetchWorkerTasks(worker string) ([]task.Task, error) {
+	url := fmt.Sprintf("http://%s/tasks?live=true", worker)
+	response, err := http.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("%w: url %s: %v", errWorkerUnreachable, url, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("received an unexpected response code from worker %s: %d", worker, response.StatusCode)
+	}
+
+	var tasks []task.Task
+	if err := json.NewDecoder(response.Body).Decode(&tasks); err != nil {
+		return nil, fmt.Errorf("error decoding tasks response: %w", err)
+	}
+	return tasks, nil
+}
+
+// Periodically rebuild the tasks assignments from the workers, correcting the drift between the store and the workers
+func (m *Manager) ReconcileTasks(ctx context.Context) {
+	if m.Config.ReconcileInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(m.Config.ReconcileInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if _, err := m.RebuildAssignments(); err != nil {
+			log.Err(err).Msg("failed to reconcile tasks assignments")
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"orchestrator/task"
+)
+
+// Store a task in the given state, assigned to the given worker when it isn't empty
+func storeTaskOn(t *testing.T, m *Manager, worker string, state task.State) task.Task {
+	t.Helper()
+	tk := task.Task{Id: uuid.New(), Name: "web", Namespace: "default", Image: "nginx", State: state}
+	if err := m.TaskDb.Put(tk.Id, tk); err != nil {
+		t.Fatalf("failed to store task: %v", err)
+	}
+	if worker != "" {
+		m.addAssignment(tk.Id, worker)
+	}
+	return tk
+}
+
+func TestRebuildAssignmentsFromWorkers(t *testing.T) {
+	first := newFakeWorker(t)
+	second := newFakeWorker(t)
+	m := newTestManager(t, first, second)
+	moved := storeTaskOn(t, m, first.addr(), task.Running)
+	lost := storeTaskOn(t, m, first.addr(), task.Running)
+	unassigned := storeTaskOn(t, m, "", task.Running)
+	exhausted := storeTaskOn(t, m, first.addr(), task.Failed)
+	exhausted.RestartCount = DefaultMaxRestarts
+	m.TaskDb.Put(exhausted.Id, exhausted)
+	scheduled := storeTaskOn(t, m, first.addr(), task.Scheduled)
+	kept := storeTaskOn(t, m, second.addr(), task.Running)
+	first.tasks = []task.Task{unassigned}
+	second.tasks = []task.Task{moved, kept}
+
+	rec := newTestApi(m).serve(t, http.MethodPost, "/admin/rebuild-assignments", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	var result RebuildResponse
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+		t.Fatalf("failed to decode rebuild response: %v", err)
+	}
+	if result.Workers != 2 || len(result.Unreachable) != 0 {
+		t.Errorf("expected the 2 workers to be queried, got %+v", result)
+	}
+
+	corrections := make(map[uuid.UUID]AssignmentCorrection)
+	for _, c := range result.Corrections {
+		corrections[c.TaskId] = c
+	}
+	expected := map[uuid.UUID]AssignmentCorrection{
+		moved.Id:      {TaskId: moved.Id, PreviousWorker: first.addr(), Worker: second.addr()},
+		lost.Id:       {TaskId: lost.Id, PreviousWorker: first.addr(), Rescheduled: true},
+		unassigned.Id: {TaskId: unassigned.Id, Worker: first.addr()},
+		exhausted.Id:  {TaskId: exhausted.Id, PreviousWorker: first.addr()},
+	}
+	if len(corrections) != len(expected) {
+		t.Errorf("expected %d corrections, got %+v", len(expected), result.Corrections)
+	}
+	for id, c := range expected {
+		if corrections[id] != c {
+			t.Errorf("expected correction %+v, got %+v", c, corrections[id])
+		}
+	}
+
+	assignments := map[uuid.UUID]string{moved.Id: second.addr(), unassigned.Id: first.addr(), scheduled.Id: first.addr(), kept.Id: second.addr(), exhausted.Id: ""}
+	for id, expectedWorker := range assignments {
+		if worker, _ := m.taskWorker(id); worker != expectedWorker {
+			t.Errorf("expected task %v to be assigned to %q, got %q", id, expectedWorker, worker)
+		}
+	}
+
+	// Only the lost task which can still run is rescheduled
+	if queued := m.Pending.Len(); queued != 1 {
+		t.Fatalf("expected a single task to be queued for rescheduling, got %d", queued)
+	}
+	if tEvent, _ := m.Pending.Pop(); tEvent.Task.Id != lost.Id {
+		t.Errorf("expected the lost task to be rescheduled, got task %v", tEvent.Task.Id)
+	}
+	if stored, _ := m.TaskDb.Get(exhausted.Id); stored.State != task.Failed {
+		t.Errorf("expected the task with exhausted restarts to stay failed, got %v", stored.State)
+	}
+}
+
+func TestRebuildAssignmentsKeepsUnreachableWorkers(t *testing.T) {
+	fw := newFakeWorker(t)
+	m := newTestManager(t, fw)
+	tk := storeTaskOn(t, m, fw.addr(), task.Running)
+	fw.Close()
+
+	result, err := m.RebuildAssignments()
+	if err != nil {
+		t.Fatalf("failed to rebuild assignments: %v", err)
+	}
+	if len(result.Unreachable) != 1 || result.Unreachable[0] != fw.addr() || len(result.Corrections) != 0 {
+		t.Errorf("expected the unreachable worker to be reported without correction, got %+v", result)
+	}
+	if worker, _ := m.taskWorker(tk.Id); worker != fw.addr() {
+		t.Errorf("expected the assignment to the unreachable worker to be kept, got %q", worker)
+	}
+}
+
+func TestReconcileTasksCountsDiscrepancies(t *testing.T) {
+	fw := newFakeWorker(t)
+	m := newTestManager(t, fw)
+	m.Config.ReconcileInterval = 10 * time.Millisecond
+	lost := storeTaskOn(t, m, fw.addr(), task.Running)
+	orphan := task.Task{Id: uuid.New(), Name: "orphan", Namespace: "default", Image: "nginx", State: task.Running}
+	fw.mu.Lock()
+	fw.tasks = []task.Task{orphan}
+	fw.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m.ReconcileTasks(ctx)
+	}()
+	waitFor(t, "the lost task to be rescheduled", func() bool { return m.Pending.Len() != 0 })
+	cancel()
+	<-done
+
+	if tEvent, _ := m.Pending.Pop(); tEvent.Task.Id != lost.Id {
+		t.Errorf("expected the lost task to be rescheduled, got task %v", tEvent.Task.Id)
+	}
+	// The task unknown to the manager is only reported, it is left running on its worker
+	if _, err := m.TaskDb.Get(orphan.Id); err == nil {
+		t.Error("expected the unknown task not to be stored")
+	}
+	body := newTestApi(m).serve(t, http.MethodGet, "/metrics/prometheus", nil).Body.String()
+	for _, line := range []string{
+		`orchestrator_assignment_discrepancies_total{kind="rescheduled"} 1`,
+		`orchestrator_assignment_discrepancies_total{kind="reassigned"} 0`,
+	} {
+		if !strings.Contains(body, line) {
+			t.Errorf("expected the metrics to contain %q, got:\n%s", line, body)
+		}
+	}
+	if !strings.Contains(body, `orchestrator_assignment_discrepancies_total{kind="unknown"}`) {
+		t.Errorf("expected the unknown tasks to be counted, got:\n%s", body)
+	}
+}
+
+func TestRebuildReportsUnknownTasks(t *testing.T) {
+	fw := newFakeWorker(t)
+	m := newTestManager(t, fw)
+	orphan := task.Task{Id: uuid.New(), Name: "orphan", State: task.Running}
+	fw.tasks = []task.Task{orphan}
+
+	result, err := m.RebuildAssignments()
+	if err != nil {
+		t.Fatalf("failed to rebuild assignments: %v", err)
+	}
+	if ids := result.Unknown[fw.addr()]; len(ids) != 1 || ids[0] != orphan.Id {
+		t.Errorf("expected the task absent from the store to be reported, got %v", result.Unknown)
+	}
+	if len(fw.receivedStops()) != 0 {
+		t.Error("expected the unknown task to be left running")
+	}
+}
+
+func TestReconcileTasksDisabledWithoutInterval(t *testing.T) {
+	m := newTestManager(t, newFakeWorker(t))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m.ReconcileTasks(context.Background())
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected the reconciliation loop to return right away without interval")
+	}
+}
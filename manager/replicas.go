@@ -0,0 +1,216 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"orchestrator/task"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+var ErrNotReplicated = errors.New("no replicated task with this name")
+
+// Result of the scaling of a replicated task
+type ScaleResponse struct {
+	Replicas int         // Number of replicas once the started ones are scheduled and the stopped ones are removed
+	Started  []uuid.UUID // New replicas submitted for scheduling
+	Stopped  []uuid.UUID // Replicas whose stop was requested
+}
+
+// Get the name of a replica, from the name of the replicated task and the replica index starting at 1
+func replicaName(name string, index int) string {
+	return fmt.Sprintf("%s-%d", name, index)
+}
+
+// Get the index of a replica from its name, or 0 if the name isn't the one of a replica of the named task
+func replicaIndex(name string, replica string) int {
+	suffix, found := strings.CutPrefix(replica, name+"-")
+	if !found {
+		return 0
+	}
+	index, err := strconv.Atoi(suffix)
+	if err != nil || index < 1 {
+		return 0
+	}
+	return index
+}
+
+// Fan out the submission of a replicated task into the submissions of its replicas
+//
+// Each replica is a distinct task named "<name>-<index>", scheduled independently of the others. The first
+// replica keeps the ids of the submitted task and event, so that a retried submission is still detected.
+// The event is returned as is when the task isn't replicated
+func ExpandReplicas(tEvent task.TaskEvent) []task.TaskEvent {
+	if tEvent.Task.Replicas == 0 {
+		return []task.TaskEvent{tEvent}
+	}
+	events := make([]task.TaskEvent, tEvent.Task.Replicas)
+	for i := range events {
+		replica := tEvent
+		replica.Task.Name = replicaName(tEvent.Task.Name, i+1)
+		replica.Task.ReplicaOf = tEvent.Task.Name
+		if i > 0 {
+			replica.Id = uuid.New()
+			replica.Task.Id = uuid.New()
+		}
+		events[i] = replica
+	}
+	return events
+}
+
+// Change the number of running replicas of a replicated task
+//
+// Missing replicas are created from the specification of an existing one and submitted for scheduling,
+// the replicas with the highest indexes are stopped when there are too many of them. The replicas waiting
+// to be placed on a node and the failed ones which will be restarted are counted along with the running ones.
+// ErrNotReplicated is returned when there is no replica of the task to copy
+func (m *Manager) ScaleTask(namespace string, name string, replicas int) (ScaleResponse, error) {
+	m.scaleMu.Lock()
+	defer m.scaleMu.Unlock()
+
+	response := ScaleResponse{Replicas: replicas, Started: []uuid.UUID{}, Stopped: []uuid.UUID{}}
+	active := make(map[uuid.UUID]task.Task)
+	pending := make(map[uuid.UUID]bool)
+	var template *task.Task
+	for _, t := range m.submittedTasks() {
+		if t.Namespace == namespace && t.ReplicaOf == name {
+			active[t.Id] = t
+			pending[t.Id] = true
+		}
+	}
+	err := m.TaskDb.ForEach(func(t task.Task) error {
+		if t.Namespace != namespace || t.ReplicaOf != name {
+			return nil
+		}
+		delete(pending, t.Id)
+		if !m.isRunnable(t) {
+			delete(active, t.Id)
+			if template == nil {
+				template = &t
+			}
+			return nil
+		}
+		active[t.Id] = t
+		return nil
+	})
+	if err != nil {
+		return response, fmt.Errorf("failed to read tasks from store: %w", err)
+	}
+	for _, t := range active {
+		template = &t
+		break
+	}
+	if template == nil {
+		return response, fmt.Errorf("%w: task %q in namespace %q", ErrNotReplicated, name, namespace)
+	}
+	if template.RegistryAuth == nil {
+		if auth, found := m.registryAuths.Load(template.Id); found {
+			taskAuth := auth.(task.RegistryAuth)
+			template.RegistryAuth = &taskAuth
+		}
+	}
+
+	// Highest indexes first, the lowest ones are kept when scaling down
+	replicasList := make([]task.Task, 0, len(active))
+	for _, t := range active {
+		replicasList = append(replicasList, t)
+	}
+	sort.Slice(replicasList, func(i, j int) bool {
+		return replicaIndex(name, replicasList[i].Name) > replicaIndex(name, replicasList[j].Name)
+	})
+
+	for i := 0; i < len(replicasList)-replicas; i++ {
+		t := replicasList[i]
+		if err := m.stopReplica(t, pending[t.Id]); err != nil {
+			return response, err
+		}
+		response.Stopped = append(response.Stopped, t.Id)
+	}
+
+	if len(active) < replicas {
+		if err := m.CheckHostPorts(*template); err != nil {
+			return response, err
+		}
+	}
+	used := make(map[int]bool, len(active))
+	for _, t := range active {
+		used[replicaIndex(name, t.Name)] = true
+	}
+	for index := 1; len(active)+len(response.Started) < replicas; index++ {
+		if used[index] {
+			continue
+		}
+		t := newReplica(*template, replicaName(name, index), replicas)
+		if err := m.CheckTaskName(t); err != nil {
+			if errors.Is(err, ErrNameConflict) {
+				continue
+			}
+			return response, err
+		}
+		tEvent := task.TaskEvent{
+			Id:        uuid.New(),
+			State:     task.Scheduled,
+			Timestamp: time.Now().UTC(),
+			Task:      t,
+		}
+		if err := m.SubmitTask(tEvent); err != nil {
+			return response, fmt.Errorf("failed to submit replica %s: %w", t.Name, err)
+		}
+		response.Started = append(response.Started, t.Id)
+	}
+
+	log.Info().
+		Str("namespace", namespace).
+		Str("name", name).
+		Int("replicas", replicas).
+		Int("started", len(response.Started)).
+		Int("stopped", len(response.Stopped)).
+		Msg("replicated task scaled")
+	return response, nil
+}
+
+// Create a new replica from the specification of another replica, without its execution state
+func newReplica(template task.Task, name string, replicas int) task.Task {
+	t := template
+	t.Id = uuid.New()
+	t.Name = name
+	t.Replicas = replicas
+	t.State = task.Scheduled
+	t.ContainerId = ""
+	t.StartTime = time.Time{}
+	t.FinishTime = time.Time{}
+	t.RestartCount = 0
+	t.ContainerRestarts = 0
+	t.Error = ""
+	return t
+}
+
+// Request the stop of a replica
+//
+// A replica still waiting to be placed on a node isn't stored yet, it is stored as completed so that
+// its pending start is ignored, and its submission is released. A failed replica has no running container,
+// it is stored as completed so that it isn't restarted
+func (m *Manager) stopReplica(t task.Task, pending bool) error {
+	if pending || t.State == task.Failed {
+		t.State = task.Completed
+		t.FinishTime = time.Now().UTC()
+		if err := m.TaskDb.Put(t.Id, t); err != nil {
+			return fmt.Errorf("failed to store stopped replica %s: %w", t.Name, err)
+		}
+		m.submissions.Delete(t.Id)
+	}
+	stopped := t
+	stopped.State = task.Completed
+	m.AddTask(task.TaskEvent{
+		Id:        uuid.New(),
+		State:     task.Completed,
+		Timestamp: time.Now().UTC(),
+		Task:      stopped,
+	})
+	return nil
+}
@@ -0,0 +1,178 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"testing"
+
+	"orchestrator/task"
+)
+
+// Start a replicated task and place its replicas on the workers
+func startReplicas(t *testing.T, m *Manager, api *Api, name string, replicas int) {
+	t.Helper()
+	tEvent := newTaskEvent(name)
+	tEvent.Task.Replicas = replicas
+	if rec := api.serve(t, http.MethodPost, "/tasks", tEvent); rec.Code != http.StatusCreated {
+		t.Fatalf("unexpected start status %d: %s", rec.Code, rec.Body)
+	}
+	processPending(m)
+}
+
+func scale(t *testing.T, api *Api, name string, replicas int) ScaleResponse {
+	t.Helper()
+	rec := api.serve(t, http.MethodPut, "/tasks/"+name+"/scale?namespace=default", ScaleRequest{Replicas: replicas})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected scale status %d: %s", rec.Code, rec.Body)
+	}
+	var response ScaleResponse
+	if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+		t.Fatalf("failed to decode scale response: %v", err)
+	}
+	return response
+}
+
+// Get the sorted names of the task started by the worker
+func startedNames(fw *fakeWorker) []string {
+	var names []string
+	for _, e := range fw.receivedEvents() {
+		names = append(names, e.Task.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestScaleTaskUp(t *testing.T) {
+	fw := newFakeWorker(t)
+	m := newTestManager(t, fw)
+	api := newTestApi(m)
+	startReplicas(t, m, api, "web", 2)
+
+	response := scale(t, api, "web", 4)
+	if len(response.Started) != 2 || len(response.Stopped) != 0 {
+		t.Fatalf("expected 2 started and no stopped replicas, got %+v", response)
+	}
+	processPending(m)
+
+	names := startedNames(fw)
+	expected := []string{"web-1", "web-2", "web-3", "web-4"}
+	if len(names) != len(expected) {
+		t.Fatalf("expected replicas %v, got %v", expected, names)
+	}
+	for i := range expected {
+		if names[i] != expected[i] {
+			t.Fatalf("expected replicas %v, got %v", expected, names)
+		}
+	}
+}
+
+func TestScaleTaskKeepsRegistryAuth(t *testing.T) {
+	fw := newFakeWorker(t)
+	m := newTestManager(t, fw)
+	api := newTestApi(m)
+	tEvent := newTaskEvent("web")
+	tEvent.Task.Replicas = 1
+	tEvent.RegistryAuth = &task.RegistryAuth{Username: "user", Password: "s3cr3t"}
+	if rec := api.serve(t, http.MethodPost, "/tasks", tEvent); rec.Code != http.StatusCreated {
+		t.Fatalf("unexpected start status %d: %s", rec.Code, rec.Body)
+	}
+	processPending(m)
+
+	scale(t, api, "web", 2)
+	processPending(m)
+	events := fw.receivedEvents()
+	if len(events) != 2 {
+		t.Fatalf("expected 2 replicas started, got %d", len(events))
+	}
+	if auth := events[1].RegistryAuth; auth == nil || auth.Password != "s3cr3t" {
+		t.Errorf("expected the new replica to be started with the credentials, got %v", auth)
+	}
+}
+
+func TestScaleTaskDown(t *testing.T) {
+	fw := newFakeWorker(t)
+	m := newTestManager(t, fw)
+	api := newTestApi(m)
+	startReplicas(t, m, api, "web", 3)
+	tasks, _ := m.TaskDb.List()
+	for _, stored := range tasks {
+		stored.State = task.Running
+		m.TaskDb.Put(stored.Id, stored)
+	}
+
+	response := scale(t, api, "web", 1)
+	if len(response.Started) != 0 || len(response.Stopped) != 2 {
+		t.Fatalf("expected no started and 2 stopped replicas, got %+v", response)
+	}
+	processPending(m)
+	waitFor(t, "the replicas stop", func() bool { return len(fw.receivedStops()) == 2 })
+
+	for _, taskId := range fw.receivedStops() {
+		stopped, err := m.TaskDb.Get(taskId)
+		if err != nil {
+			t.Fatalf("failed to get stopped task: %v", err)
+		}
+		if stopped.Name == "web-1" {
+			t.Error("the replica with the lowest index was stopped")
+		}
+	}
+}
+
+func TestScaleTaskCountsRestartableFailedReplicas(t *testing.T) {
+	fw := newFakeWorker(t)
+	m := newTestManager(t, fw)
+	m.Config.MaxRestarts = 3
+	api := newTestApi(m)
+	startReplicas(t, m, api, "web", 2)
+
+	tasks, _ := m.TaskDb.List()
+	for _, stored := range tasks {
+		if stored.Name == "web-2" {
+			stored.State = task.Failed
+			m.TaskDb.Put(stored.Id, stored)
+		}
+	}
+
+	if response := scale(t, api, "web", 2); len(response.Started) != 0 {
+		t.Fatalf("expected the failed replica to be restarted rather than replaced, got %+v", response)
+	}
+	if response := scale(t, api, "web", 3); len(response.Started) != 1 {
+		t.Fatalf("expected 1 started replica, got %+v", response)
+	}
+	processPending(m)
+	if names := startedNames(fw); len(names) != 3 || names[2] != "web-3" {
+		t.Errorf("expected replica web-3 to be started, got %v", names)
+	}
+
+	// A failed replica removed when scaling down isn't restarted afterwards
+	if response := scale(t, api, "web", 1); len(response.Stopped) != 2 {
+		t.Fatalf("expected 2 stopped replicas, got %+v", response)
+	}
+	tasks, _ = m.TaskDb.List()
+	for _, stored := range tasks {
+		if stored.Name == "web-2" && stored.State != task.Completed {
+			t.Errorf("expected the failed replica to be completed, got state %v", stored.State)
+		}
+	}
+}
+
+func TestSubmitTasksQueuesAllOrNone(t *testing.T) {
+	m := newTestManager(t)
+	if err := m.SubmitTask(newTaskEvent("web-2")); err != nil {
+		t.Fatalf("failed to submit task: %v", err)
+	}
+
+	tEvent := newTaskEvent("web")
+	tEvent.Task.Replicas = 3
+	if err := m.SubmitTasks(ExpandReplicas(tEvent)); !errors.Is(err, ErrNameConflict) {
+		t.Fatalf("expected a name conflict, got %v", err)
+	}
+	if m.Pending.Len() != 1 {
+		t.Errorf("expected only the first task to be queued, got %d queued tasks", m.Pending.Len())
+	}
+	if submitted := m.submittedTasks(); len(submitted) != 1 {
+		t.Errorf("expected the replicas submissions to be released, got %d submissions", len(submitted))
+	}
+}
@@ -0,0 +1,91 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"orchestrator/store"
+	"orchestrator/task"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// Maximum duration of the cluster tasks stop before the manager shuts down anyway
+const clusterShutdownTimeout = time.Minute
+
+// Stop all the tasks of the cluster, waiting for the workers to confirm the stops until the context is done
+//
+// Every node is drained first so that no task is scheduled or restarted meanwhile. The number of stopped tasks
+// is returned, along with the number of tasks which couldn't be confirmed as stopped
+func (m *Manager) StopAllTasks(ctx context.Context) (int, int, error) {
+	m.mu.Lock()
+	for _, n := range m.WorkerNodes {
+		n.Draining = true
+	}
+	assignments := make(map[uuid.UUID]string, len(m.TaskWorkerMap))
+	for taskId, worker := range m.TaskWorkerMap {
+		assignments[taskId] = worker
+	}
+	m.mu.Unlock()
+
+	tasks, err := m.TaskDb.List()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to list tasks: %w", err)
+	}
+
+	var wg sync.WaitGroup
+	for _, t := range tasks {
+		if t.State == task.Completed {
+			continue
+		}
+		worker, found := assignments[t.Id]
+		if !found {
+			// The task was never placed on a node, there is no container to stop
+			t.State = task.Completed
+			t.FinishTime = time.Now().UTC()
+			if err := m.TaskDb.Put(t.Id, t); err != nil {
+				log.Err(err).Str("task-id", t.Id.String()).Msg("failed to update task")
+			}
+			continue
+		}
+		wg.Add(1)
+		go func(taskId uuid.UUID, worker string) {
+			defer wg.Done()
+			m.stopTask(taskId, worker)
+		}(t.Id, worker)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("tasks stop not confirmed in time: %w", ctx.Err())
+	}
+
+	stopped, remaining := m.countStoppedTasks(tasks)
+	return stopped, remaining, err
+}
+
+// Count the given tasks which are now stopped and the ones still active
+func (m *Manager) countStoppedTasks(tasks []task.Task) (int, int) {
+	stopped, remaining := 0, 0
+	for _, t := range tasks {
+		if t.State == task.Completed {
+			continue
+		}
+		current, err := m.TaskDb.Get(t.Id)
+		if errors.Is(err, store.ErrKeyNotFound) || (err == nil && current.State == task.Completed) {
+			stopped++
+		} else {
+			remaining++
+		}
+	}
+	return stopped, remaining
+}
@@ -0,0 +1,116 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"orchestrator/task"
+)
+
+func TestClusterShutdownStopsAllTasks(t *testing.T) {
+	workers := []*fakeWorker{newFakeWorker(t), newFakeWorker(t)}
+	m := newTestManager(t, workers...)
+	api := newTestApi(m)
+	first := storeAssignedTask(t, m, workers[0].addr())
+	second := storeAssignedTask(t, m, workers[1].addr())
+	unplaced := task.Task{Id: uuid.New(), Name: "batch", Image: "nginx", State: task.Pending}
+	if err := m.TaskDb.Put(unplaced.Id, unplaced); err != nil {
+		t.Fatalf("failed to store task: %v", err)
+	}
+
+	rec := api.serve(t, http.MethodPost, "/admin/shutdown", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	var response ShutdownResponse
+	if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if response.StoppedTasks != 3 || response.RemainingTasks != 0 {
+		t.Errorf("expected the 3 tasks to be stopped, got %+v", response)
+	}
+
+	// The stops are confirmed by the time the response is sent
+	for i, tk := range []task.Task{first, second} {
+		if stops := workers[i].receivedStops(); len(stops) != 1 || stops[0] != tk.Id {
+			t.Errorf("expected worker %d to receive the stop of its task, got %v", i, stops)
+		}
+	}
+	for _, tk := range []task.Task{first, second, unplaced} {
+		if stored, _ := m.TaskDb.Get(tk.Id); stored.State != task.Completed {
+			t.Errorf("expected task %s to be stopped, got state %v", tk.Name, stored.State)
+		}
+	}
+	for _, n := range m.WorkerNodes {
+		if !n.Draining {
+			t.Errorf("expected node %s to be drained so that no task is started anymore", n.Name)
+		}
+	}
+
+	// The shutdown is idempotent, a repeated request gets the result of the first one
+	rec = api.serve(t, http.MethodPost, "/admin/shutdown", nil)
+	var repeated ShutdownResponse
+	if err := json.NewDecoder(rec.Body).Decode(&repeated); err != nil || rec.Code != http.StatusOK || repeated != response {
+		t.Errorf("expected a repeated shutdown request to get %+v with status %d, got %+v with status %d", response, http.StatusOK, repeated, rec.Code)
+	}
+	for i := range workers {
+		if stops := workers[i].receivedStops(); len(stops) != 1 {
+			t.Errorf("expected worker %d to receive a single stop, got %v", i, stops)
+		}
+	}
+}
+
+func TestConcurrentClusterShutdownsShareResult(t *testing.T) {
+	fw := newFakeWorker(t)
+	m := newTestManager(t, fw)
+	api := newTestApi(m)
+	storeAssignedTask(t, m, fw.addr())
+
+	var wg sync.WaitGroup
+	responses := make([]ShutdownResponse, 3)
+	for i := range responses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rec := api.serve(t, http.MethodPost, "/admin/shutdown", nil)
+			if rec.Code != http.StatusOK {
+				t.Errorf("expected status %d, got %d", http.StatusOK, rec.Code)
+			}
+			json.NewDecoder(rec.Body).Decode(&responses[i])
+		}(i)
+	}
+	wg.Wait()
+
+	for i, response := range responses {
+		if response.StoppedTasks != 1 || response.RemainingTasks != 0 {
+			t.Errorf("expected request %d to get the stop of the task, got %+v", i, response)
+		}
+	}
+	if stops := fw.receivedStops(); len(stops) != 1 {
+		t.Errorf("expected the task to be stopped once, got %v", stops)
+	}
+}
+
+func TestStopAllTasksReportsUnconfirmedStops(t *testing.T) {
+	fw := newFakeWorker(t)
+	fw.stopFailures = []int{http.StatusBadRequest}
+	m := newTestManager(t, fw)
+	storeAssignedTask(t, m, fw.addr())
+	storeAssignedTask(t, m, fw.addr())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stopped, remaining, err := m.StopAllTasks(ctx)
+	if err != nil {
+		t.Fatalf("failed to stop tasks: %v", err)
+	}
+	if stopped != 1 || remaining != 1 {
+		t.Errorf("expected 1 stopped and 1 remaining task, got %d stopped and %d remaining", stopped, remaining)
+	}
+}
@@ -0,0 +1,215 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"orchestrator/store"
+	"orchestrator/task"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	snapshotFilePrefix = "snapshot-"
+	snapshotFileSuffix = ".json"
+	snapshotTimeLayout = "20060102T150405.000Z" // Fixed width so that the files names sort chronologically
+)
+
+// Database files of the persisted stores
+const (
+	tasksDbFile  = "manager_tasks.db"
+	eventsDbFile = "manager_task_events.db"
+	groupsDbFile = "manager_groups.db"
+)
+
+// Point-in-time state of the cluster, written to disk for audit purposes
+type Snapshot struct {
+	Timestamp   time.Time
+	Tasks       []task.Task
+	Assignments map[string][]uuid.UUID // Tasks ids by worker
+	Nodes       []NodeResponse
+}
+
+var ErrUnknownStore = errors.New("unknown store")
+
+// Get the store, "tasks", "events" or "groups", whose database backups can be written while the manager runs
+//
+// ErrUnknownStore is returned for another store name, and store.ErrSnapshotUnsupported when the store isn't persisted
+func (m *Manager) StoreSnapshotter(name string) (store.Snapshotter, error) {
+	var s any
+	switch name {
+	case "tasks":
+		s = m.TaskDb
+	case "events":
+		s = m.EventDb
+	case "groups":
+		s = m.GroupDb
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownStore, name)
+	}
+	snapshotter, ok := s.(store.Snapshotter)
+	if !ok {
+		return nil, store.ErrSnapshotUnsupported
+	}
+	return snapshotter, nil
+}
+
+// Replace the values of a persisted store, "tasks", "events" or "groups", by the ones of a backup streamed by the
+// store snapshot route
+//
+// The manager must not be running, its database files are locked while it runs. Only the values of the store are
+// restored, the stored tasks assignments are cleared along with the tasks and can then be rebuilt from the workers.
+// ErrUnknownStore is returned for another store name
+func RestoreStore(name string, r io.Reader) error {
+	switch name {
+	case "tasks":
+		return restoreTasksStore(r)
+	case "events":
+		return restorePersistedStore[task.TaskEvent](eventsDbFile, "taskEvents", r)
+	case "groups":
+		return restorePersistedStore[task.TaskGroup](groupsDbFile, "groups", r)
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownStore, name)
+	}
+}
+
+func restorePersistedStore[TVal any](file string, storeName string, r io.Reader) error {
+	s, err := store.NewPersistedStore[uuid.UUID, TVal](file, 0600, storeName)
+	if err != nil {
+		return fmt.Errorf("failed to open store %s: %w", storeName, err)
+	}
+	defer s.Close()
+	return s.RestoreFrom(r)
+}
+
+// Restore the tasks store with the schema version of the running manager and clear the assignments stored in the
+// same database, they refer to the replaced tasks
+func restoreTasksStore(r io.Reader) error {
+	s, err := store.NewPersistedStore[uuid.UUID, task.Task](tasksDbFile, 0600, "tasks")
+	if err != nil {
+		return fmt.Errorf("failed to open store tasks: %w", err)
+	}
+	defer s.Close()
+	if err := s.SetSchema(task.SchemaVersion, task.Migrations); err != nil {
+		return err
+	}
+	if err := s.RestoreFrom(r); err != nil {
+		return err
+	}
+
+	assignmentDb, err := store.NewPersistedStoreFromDb[uuid.UUID, Assignment](s.Db, "assignments")
+	if err != nil {
+		return fmt.Errorf("failed to open store assignments: %w", err)
+	}
+	assignments, err := assignmentDb.List()
+	if err != nil {
+		return fmt.Errorf("failed to get assignments from store: %w", err)
+	}
+	for _, assignment := range assignments {
+		if err := assignmentDb.Delete(assignment.TaskId); err != nil {
+			return fmt.Errorf("failed to delete assignment of task %s: %w", assignment.TaskId, err)
+		}
+	}
+	return nil
+}
+
+// Periodically write a snapshot of the cluster state, it returns once the context is cancelled
+func (m *Manager) SnapshotState(ctx context.Context) {
+	if m.Config.SnapshotInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(m.Config.SnapshotInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if _, err := m.WriteSnapshot(); err != nil {
+			log.Err(err).Msg("failed to write cluster snapshot")
+		}
+	}
+}
+
+// Write a snapshot of the cluster state to the data directory and prune the oldest ones
+//
+// The path of the written file is returned
+func (m *Manager) WriteSnapshot() (string, error) {
+	m.snapshotMu.Lock()
+	defer m.snapshotMu.Unlock()
+
+	tasks, err := m.TaskDb.List()
+	if err != nil {
+		return "", fmt.Errorf("failed to get tasks from store: %w", err)
+	}
+	snapshot := Snapshot{
+		Timestamp: time.Now().UTC(),
+		Tasks:     tasks,
+		Nodes:     m.nodeResponses(),
+	}
+	m.mu.RLock()
+	snapshot.Assignments = make(map[string][]uuid.UUID, len(m.WorkerTaskMap))
+	for worker, taskIds := range m.WorkerTaskMap {
+		snapshot.Assignments[worker] = append([]uuid.UUID(nil), taskIds...)
+	}
+	m.mu.RUnlock()
+
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal snapshot: %w", err)
+	}
+	if err := os.MkdirAll(m.Config.DataDir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create data directory: %w", err)
+	}
+	path := filepath.Join(m.Config.DataDir, snapshotFilePrefix+snapshot.Timestamp.Format(snapshotTimeLayout)+snapshotFileSuffix)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return "", fmt.Errorf("failed to write snapshot file: %w", err)
+	}
+	log.Info().Str("path", path).Msg("cluster snapshot written")
+
+	if err := m.pruneSnapshots(); err != nil {
+		log.Err(err).Msg("failed to prune old snapshots")
+	}
+	return path, nil
+}
+
+// Delete the oldest snapshot files to only keep the configured count
+func (m *Manager) pruneSnapshots() error {
+	if m.Config.SnapshotRetention <= 0 {
+		return nil
+	}
+
+	entries, err := os.ReadDir(m.Config.DataDir)
+	if err != nil {
+		return err
+	}
+	var snapshots []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() && strings.HasPrefix(name, snapshotFilePrefix) && strings.HasSuffix(name, snapshotFileSuffix) {
+			snapshots = append(snapshots, name)
+		}
+	}
+	if len(snapshots) <= m.Config.SnapshotRetention {
+		return nil
+	}
+
+	sort.Strings(snapshots)
+	for _, name := range snapshots[:len(snapshots)-m.Config.SnapshotRetention] {
+		if err := os.Remove(filepath.Join(m.Config.DataDir, name)); err != nil {
+			return err
+		}
+		log.Debug().Str("file", name).Msg("deleted old snapshot")
+	}
+	return nil
+}
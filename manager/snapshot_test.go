@@ -0,0 +1,120 @@
+package manager
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"orchestrator/task"
+)
+
+func TestWriteSnapshotKeepsConfiguredCount(t *testing.T) {
+	fw := newFakeWorker(t)
+	m := newTestManager(t, fw)
+	m.Config.DataDir = t.TempDir()
+	m.Config.SnapshotRetention = 2
+
+	tk := task.Task{Id: uuid.New(), Name: "web", Image: "nginx", State: task.Running}
+	m.TaskDb.Put(tk.Id, tk)
+	m.TaskWorkerMap[tk.Id] = fw.addr()
+	m.WorkerTaskMap[fw.addr()] = []uuid.UUID{tk.Id}
+
+	var path string
+	for i := 0; i < 3; i++ {
+		time.Sleep(2 * time.Millisecond) // The files names have a millisecond precision
+		var err error
+		if path, err = m.WriteSnapshot(); err != nil {
+			t.Fatalf("failed to write snapshot %d: %v", i, err)
+		}
+	}
+
+	files, _ := filepath.Glob(filepath.Join(m.Config.DataDir, snapshotFilePrefix+"*"))
+	if len(files) != 2 {
+		t.Errorf("expected 2 snapshots to be kept, got %v", files)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("expected the last snapshot to be kept: %v", err)
+	}
+	var snapshot Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		t.Fatalf("failed to decode snapshot: %v", err)
+	}
+	if len(snapshot.Tasks) != 1 || snapshot.Tasks[0].Id != tk.Id {
+		t.Errorf("expected the snapshot to contain the stored task, got %v", snapshot.Tasks)
+	}
+	if ids := snapshot.Assignments[fw.addr()]; len(ids) != 1 || ids[0] != tk.Id {
+		t.Errorf("expected the snapshot to contain the task assignment, got %v", snapshot.Assignments)
+	}
+	if len(snapshot.Nodes) != 1 {
+		t.Errorf("expected the snapshot to contain the node, got %v", snapshot.Nodes)
+	}
+}
+
+func TestWriteSnapshotHandler(t *testing.T) {
+	m := newTestManager(t)
+	m.Config.DataDir = t.TempDir()
+	api := newTestApi(m)
+
+	rec := httptest.NewRecorder()
+	api.Router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/snapshots", nil))
+
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d", http.StatusCreated, rec.Code)
+	}
+	var response SnapshotResponse
+	if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if _, err := os.Stat(response.Path); err != nil {
+		t.Errorf("expected the snapshot file to be written: %v", err)
+	}
+}
+
+func TestRestoreTasksStoreFromManagerBackup(t *testing.T) {
+	chdirTemp(t)
+	fw := newFakeWorker(t)
+	m := newPersistedManager(t, fw)
+	kept := task.Task{Id: uuid.New(), Name: "web", Image: "nginx", State: task.Running}
+	m.TaskDb.Put(kept.Id, kept)
+	m.assignTask(kept.Id, fw.addr())
+	snapshotter, err := m.StoreSnapshotter("tasks")
+	if err != nil {
+		t.Fatalf("failed to get tasks store snapshotter: %v", err)
+	}
+	var backup bytes.Buffer
+	if err := snapshotter.Snapshot(&backup); err != nil {
+		t.Fatalf("failed to snapshot tasks store: %v", err)
+	}
+	added := task.Task{Id: uuid.New(), Name: "db", Image: "postgres", State: task.Running}
+	m.TaskDb.Put(added.Id, added)
+	m.assignTask(added.Id, fw.addr())
+	if err := m.Close(); err != nil {
+		t.Fatalf("failed to close manager: %v", err)
+	}
+
+	// The backup of the running manager is written with the current tasks schema version
+	if err := RestoreStore("tasks", &backup); err != nil {
+		t.Fatalf("failed to restore tasks store: %v", err)
+	}
+
+	restarted := newPersistedManager(t, fw)
+	defer restarted.Close()
+	tasks, err := restarted.TaskDb.List()
+	if err != nil || len(tasks) != 1 || tasks[0].Id != kept.Id || tasks[0].Name != kept.Name {
+		t.Fatalf("expected only the backed up task to be restored, got %v (error %v)", tasks, err)
+	}
+	if assignments, _ := restarted.AssignmentDb.List(); len(assignments) != 0 {
+		t.Errorf("expected the stored assignments to be cleared, got %v", assignments)
+	}
+	if worker, found := restarted.taskWorker(added.Id); found {
+		t.Errorf("expected the task missing from the backup not to be assigned, got %s", worker)
+	}
+}
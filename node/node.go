@@ -1,17 +1,31 @@
 package node
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"orchestrator/stats"
+	"time"
+)
+
+// Default maximum duration of a stats retrieval from a worker node
+const DefaultStatsTimeout = 5 * time.Second
+
+// Reachability of a worker node
+type Status string
+
+const (
+	Online  Status = "online"
+	Offline Status = "offline"
 )
 
 // Worker node with machine load information
 type Node struct {
 	Name            string
 	Api             string
+	MetricsApi      string
 	Role            string
 	Stats           stats.Stats
 	Memory          int64
@@ -19,50 +33,128 @@ type Node struct {
 	Disk            int64
 	DiskAllocated   int64
 	TaskCount       int
+	CpuReserved     float64           // CPUs requested by the tasks running on the node
+	MaxCpu          float64           // Hard limit of CPUs reservable by tasks, 0 for no limit
+	CpuCapacity     float64           // Number of CPUs configured on the worker, 0 when it isn't configured
+	MaxMemory       int64             // Hard limit of the memory capacity in KB, 0 for no limit
+	MaxDisk         int64             // Hard limit of the disk capacity in bytes, 0 for no limit
+	Draining        bool              // The node is being shut down, it no longer receives tasks
+	Labels          map[string]string // Labels matched against the node selector of the tasks
+	Status          Status            // Reachability of the node, the tasks of an offline node are moved to other nodes
+	LastHeartbeat   time.Time         // Time of the last successful exchange with the node
+	FailedChecks    int               // Number of consecutive failed exchanges with the node
+	LastStatsUpdate time.Time         // Time of the last successful stats retrieval
+	StatsTimeout    time.Duration     // Maximum duration of a stats retrieval, DefaultStatsTimeout when 0
 }
 
-// Create a new worker node
+// Create a new worker node, its metrics are retrieved from the main API
 func NewNode(name string, api string, role string) Node {
 	return Node{
-		Name: name,
-		Api:  api,
-		Role: role,
+		Name:       name,
+		Api:        api,
+		MetricsApi: api,
+		Role:       role,
+		Status:     Online,
 	}
 }
 
 // Update the worker node stats with the current machine load information
 //
-// Those data are retrieved from the worker API
-func (n *Node) UpdateStats() error {
-	var resp *http.Response
-	var err error
+// Those data are retrieved from the worker API, the request is aborted after the node stats timeout. The node
+// is written without synchronization, the nodes shared between goroutines must use FetchStats and ApplyStats
+func (n *Node) UpdateStats(ctx context.Context) error {
+	nodeStats, err := n.FetchStats(ctx)
+	if err != nil {
+		return err
+	}
+	n.ApplyStats(nodeStats)
+	return nil
+}
+
+// Retrieve the current machine load information from the worker API, without updating the node
+//
+// The request is aborted after the node stats timeout
+func (n *Node) FetchStats(ctx context.Context) (stats.Stats, error) {
+	timeout := n.StatsTimeout
+	if timeout <= 0 {
+		timeout = DefaultStatsTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
 
-	url := fmt.Sprintf("%s/metrics", n.Api)
-	resp, err = http.Get(url)
+	url := fmt.Sprintf("%s/metrics", n.MetricsApi)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return fmt.Errorf("unable to connect to %v", n.Api)
+		return stats.Stats{}, fmt.Errorf("failed to create stats request for node %s: %w", n.Name, err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return stats.Stats{}, fmt.Errorf("unable to connect to %v: %w", n.MetricsApi, err)
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
-		return fmt.Errorf("encountered unexpected http code retrieving stats from %s: %v, err: %v", n.Api, resp.StatusCode, err)
+		return stats.Stats{}, fmt.Errorf("encountered unexpected http code retrieving stats from %s: %v, err: %v", n.MetricsApi, resp.StatusCode, err)
 	}
 
 	body, _ := io.ReadAll(resp.Body)
-	var stats stats.Stats
-	err = json.Unmarshal(body, &stats)
+	var nodeStats stats.Stats
+	err = json.Unmarshal(body, &nodeStats)
 	if err != nil {
-		return fmt.Errorf("error decoding message while getting stats for node %s", n.Name)
+		return stats.Stats{}, fmt.Errorf("error decoding message while getting stats for node %s", n.Name)
+	}
+
+	if nodeStats.MemoryStats == nil || nodeStats.DiskStats == nil {
+		return stats.Stats{}, fmt.Errorf("error getting stats from node %s", n.Name)
 	}
+	return nodeStats, nil
+}
+
+// Update the node with stats retrieved from its worker, the caller must hold the lock guarding the node
+func (n *Node) ApplyStats(nodeStats stats.Stats) {
+	n.Memory = capValue(int64(nodeStats.MemTotalKb()), n.MaxMemory)
+	n.MemoryAllocated = int64(nodeStats.MemUsedKb())
+	n.Disk = capValue(int64(nodeStats.DiskTotal()), n.MaxDisk)
+	n.DiskAllocated = int64(nodeStats.DiskUsed())
+	n.CpuCapacity = nodeStats.CpuCapacity
+	n.Stats = nodeStats
+	n.LastStatsUpdate = time.Now().UTC()
+}
+
+// Check if the node stats were not retrieved successfully for longer than the given duration
+//
+// The stats of a node which never reported them are stale
+func (n *Node) StatsStale(maxAge time.Duration) bool {
+	return n.LastStatsUpdate.IsZero() || time.Since(n.LastStatsUpdate) > maxAge
+}
 
-	if stats.MemoryStats == nil || stats.DiskStats == nil {
-		return fmt.Errorf("error getting stats from node %s", n.Name)
+// Check if the node has all the labels of the selector with the same values
+func (n *Node) MatchSelector(selector map[string]string) bool {
+	for k, v := range selector {
+		if value, found := n.Labels[k]; !found || value != v {
+			return false
+		}
 	}
+	return true
+}
 
-	n.Memory = int64(stats.MemTotalKb())
-	n.MemoryAllocated = int64(stats.MemUsedKb())
-	n.Disk = int64(stats.DiskTotal())
-	n.DiskAllocated = int64(stats.DiskUsed())
-	n.Stats = stats
+// Check if the node CPU limit allows to reserve the given amount of CPUs
+func (n *Node) CanReserveCpu(cpu float64) bool {
+	limit := n.CpuLimit()
+	return limit == 0 || n.CpuReserved+cpu <= limit
+}
 
-	return nil
+// Get the number of CPUs reservable by tasks, the lowest of the node hard limit and the worker capacity, 0 for no limit
+func (n *Node) CpuLimit() float64 {
+	if n.MaxCpu > 0 && (n.CpuCapacity == 0 || n.MaxCpu < n.CpuCapacity) {
+		return n.MaxCpu
+	}
+	return n.CpuCapacity
+}
+
+// Get the given value bounded by the limit, when it is set
+func capValue(value int64, limit int64) int64 {
+	if limit > 0 && value > limit {
+		return limit
+	}
+	return value
 }
@@ -0,0 +1,76 @@
+package node
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Metrics server never answering, until the test ends
+func newStuckServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(func() {
+		close(release)
+		server.Close()
+	})
+	return server
+}
+
+func TestUpdateStatsReturnsWithinTimeout(t *testing.T) {
+	server := newStuckServer(t)
+	n := NewNode("worker", server.URL, "worker")
+	n.StatsTimeout = 50 * time.Millisecond
+
+	start := time.Now()
+	if err := n.UpdateStats(context.Background()); err == nil {
+		t.Fatal("expected the stuck stats retrieval to fail")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the stats retrieval to be aborted after the timeout, took %v", elapsed)
+	}
+	if !n.LastStatsUpdate.IsZero() {
+		t.Error("expected the node stats not to be updated")
+	}
+}
+
+func TestUpdateStatsAbortedWithContext(t *testing.T) {
+	server := newStuckServer(t)
+	n := NewNode("worker", server.URL, "worker")
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if err := n.UpdateStats(ctx); err == nil {
+		t.Fatal("expected the cancelled stats retrieval to fail")
+	}
+	if elapsed := time.Since(start); elapsed >= DefaultStatsTimeout {
+		t.Errorf("expected the stats retrieval to be aborted with the context, took %v", elapsed)
+	}
+}
+
+func TestCpuLimit(t *testing.T) {
+	cases := []struct {
+		maxCpu, capacity, limit float64
+	}{
+		{0, 0, 0},
+		{4, 0, 4},
+		{0, 2, 2},
+		{4, 2, 2},
+		{1, 2, 1},
+	}
+	for _, c := range cases {
+		n := Node{MaxCpu: c.maxCpu, CpuCapacity: c.capacity}
+		if limit := n.CpuLimit(); limit != c.limit {
+			t.Errorf("max %v CPUs and capacity %v: expected a limit of %v, got %v", c.maxCpu, c.capacity, c.limit, limit)
+		}
+	}
+}
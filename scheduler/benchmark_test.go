@@ -0,0 +1,75 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/c9s/goprocinfo/linux"
+
+	"orchestrator/node"
+	"orchestrator/stats"
+	"orchestrator/task"
+)
+
+// Numbers of worker nodes the schedulers are benchmarked with
+var benchmarkNodeCounts = []int{1, 4, 16}
+
+// Create the given number of worker nodes, all reporting the stats served by a worker API double
+func newReportingNodes(tb testing.TB, count int) []*node.Node {
+	tb.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(stats.Stats{
+			MemoryStats: &linux.MemInfo{MemTotal: 16 * 1000 * 1000, MemAvailable: 8 * 1000 * 1000},
+			DiskStats:   &linux.Disk{All: 100 * 1000 * 1000, Used: 10 * 1000 * 1000, Free: 90 * 1000 * 1000},
+			CpuStats:    &linux.CPUStat{User: 25, Idle: 75},
+		})
+	}))
+	tb.Cleanup(server.Close)
+
+	nodes := make([]*node.Node, count)
+	for i := range nodes {
+		n := node.NewNode(fmt.Sprintf("node-%d", i), server.URL, "worker")
+		if err := n.UpdateStats(context.Background()); err != nil {
+			tb.Fatalf("failed to retrieve node stats: %v", err)
+		}
+		nodes[i] = &n
+	}
+	return nodes
+}
+
+func benchmarkScheduler(b *testing.B, newScheduler func() Scheduler) {
+	tk := task.Task{Memory: 100 * 1000, Disk: 1000}
+	for _, count := range benchmarkNodeCounts {
+		b.Run(fmt.Sprintf("nodes-%d", count), func(b *testing.B) {
+			nodes := newReportingNodes(b, count)
+			s := newScheduler()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if s.SelectNode(tk, nodes) == nil {
+					b.Fatal("no node selected")
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkRoundRobin(b *testing.B) {
+	benchmarkScheduler(b, func() Scheduler { return &RoundRobin{} })
+}
+
+func BenchmarkEpvm(b *testing.B) {
+	benchmarkScheduler(b, func() Scheduler { return &Epvm{} })
+}
+
+func BenchmarkRandom(b *testing.B) {
+	benchmarkScheduler(b, func() Scheduler { return NewRandom(rand.NewSource(1)) })
+}
+
+func BenchmarkBinPacking(b *testing.B) {
+	benchmarkScheduler(b, func() Scheduler { return &BinPacking{} })
+}
@@ -0,0 +1,80 @@
+package scheduler
+
+import (
+	"orchestrator/node"
+	"orchestrator/task"
+)
+
+// Scheduler which packs the tasks on the most allocated nodes able to run them,
+// so that the least loaded nodes are left empty and can be scaled down
+type BinPacking struct{}
+
+func (b *BinPacking) SelectNode(t task.Task, nodes []*node.Node) *node.Node {
+	return selectNode(b, t, nodes)
+}
+
+// Get the nodes with enough free memory and disk to run the given task, within their hard limits
+func (b *BinPacking) SelectCandidateNodes(t task.Task, nodes []*node.Node) []*node.Node {
+	var candidates []*node.Node
+	for _, n := range withinLimits(t, nodes) {
+		if checkDisk(t, n.Disk-n.DiskAllocated) && checkMemory(t, n) {
+			candidates = append(candidates, n)
+		}
+	}
+	return candidates
+}
+
+// Score the candidates by their CPU and memory allocation, the most allocated node has the lowest score
+func (b *BinPacking) Score(t task.Task, candidates []*node.Node) map[string]float64 {
+	if len(candidates) == 0 {
+		return nil
+	}
+	scores := make(map[string]float64, len(candidates))
+	for _, n := range candidates {
+		scores[n.Name] = -(cpuAllocation(n) + memoryAllocation(n))
+	}
+	return scores
+}
+
+// Select the candidate with the lowest score, the candidates without score are ignored
+func (b *BinPacking) Pick(scores map[string]float64, candidates []*node.Node) *node.Node {
+	var best *node.Node
+	for _, n := range candidates {
+		score, found := scores[n.Name]
+		if !found {
+			continue
+		}
+		if best == nil || score < scores[best.Name] {
+			best = n
+		}
+	}
+	return best
+}
+
+// Check that the node has enough free memory to run the task, an unknown memory usage is considered as free
+func checkMemory(t task.Task, n *node.Node) bool {
+	if n.Stats.MemoryStats == nil {
+		return true
+	}
+	return float64(n.MemoryAllocated)+float64(t.Memory/1000) <= float64(n.Memory)
+}
+
+// Get the ratio of the node CPUs allocated: the reserved CPUs when the node has a CPU limit, its CPU usage otherwise
+func cpuAllocation(n *node.Node) float64 {
+	if limit := n.CpuLimit(); limit > 0 {
+		return n.CpuReserved / limit
+	}
+	usage, err := n.Stats.CpuUsage()
+	if err != nil {
+		return 0
+	}
+	return usage
+}
+
+// Get the ratio of the node memory allocated, 0 when the memory capacity is unknown
+func memoryAllocation(n *node.Node) float64 {
+	if n.Memory == 0 {
+		return 0
+	}
+	return float64(n.MemoryAllocated) / float64(n.Memory)
+}
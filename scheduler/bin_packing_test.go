@@ -0,0 +1,46 @@
+package scheduler
+
+import (
+	"testing"
+
+	"orchestrator/node"
+	"orchestrator/task"
+)
+
+func TestBinPackingPrefersMostLoadedNodeWhichFits(t *testing.T) {
+	b := &BinPacking{}
+	idle := newTestNode("idle", 1000*1000, 100*1000, 1000*1000, 0)
+	busy := newTestNode("busy", 1000*1000, 700*1000, 1000*1000, 0)
+	nodes := []*node.Node{idle, busy}
+
+	if selected := b.SelectNode(task.Task{Memory: 200 * 1000 * 1000}, nodes); selected != busy {
+		t.Errorf("expected the task to be packed on the busy node, got %v", selected)
+	}
+	// The busy node doesn't have enough free memory left
+	if selected := b.SelectNode(task.Task{Memory: 500 * 1000 * 1000}, nodes); selected != idle {
+		t.Errorf("expected the task to go to the idle node when it doesn't fit on the busy one, got %v", selected)
+	}
+}
+
+func TestBinPackingCountsReservedCpus(t *testing.T) {
+	b := &BinPacking{}
+	reserved := newTestNode("reserved", 1000*1000, 100*1000, 1000*1000, 0)
+	reserved.MaxCpu, reserved.CpuReserved = 4, 3
+	free := newTestNode("free", 1000*1000, 100*1000, 1000*1000, 0)
+	free.MaxCpu = 4
+
+	if selected := b.SelectNode(task.Task{Cpu: 1}, []*node.Node{free, reserved}); selected != reserved {
+		t.Errorf("expected the node with the most reserved CPUs to be selected, got %v", selected)
+	}
+}
+
+func TestBinPackingPickSkipsCandidatesWithoutScore(t *testing.T) {
+	b := &BinPacking{}
+	nodes := []*node.Node{{Name: "a"}, {Name: "b"}}
+	if picked := b.Pick(map[string]float64{"b": 0}, nodes); picked != nodes[1] {
+		t.Errorf("expected the scored candidate to be picked, got %v", picked)
+	}
+	if picked := b.Pick(nil, nodes); picked != nil {
+		t.Errorf("expected no candidate to be picked without scores, got %v", picked)
+	}
+}
@@ -1,6 +1,7 @@
 package scheduler
 
 import (
+	"context"
 	"math"
 	"orchestrator/node"
 	"orchestrator/task"
@@ -12,31 +13,35 @@ import (
 // LIEB square ice constant
 const LIEB = 1.53960071783900203869
 
+// Default weight of the CPU and memory costs in the EPVM score
+const DefaultEpvmWeight = 1.0
+
 // Scheduler which computes a score based on the worker's current system load statistics
 // to pick the most suitable worker for the given task
-type Epvm struct{}
+type Epvm struct {
+	Headroom     float64 // Minimum percentage of free CPU, memory and disk to preserve on nodes
+	CpuWeight    float64 // Factor of the CPU cost in the score, DefaultEpvmWeight when 0
+	MemoryWeight float64 // Factor of the memory cost in the score, DefaultEpvmWeight when 0
+}
 
 func (e *Epvm) SelectNode(t task.Task, nodes []*node.Node) *node.Node {
-	candidates := e.selectCandidateNodes(t, nodes)
-	if len(candidates) == 0 {
-		return nil
-	}
-	scores := e.score(t, candidates)
-	return e.pick(scores, candidates)
+	return selectNode(e, t, nodes)
 }
 
-// Get suitable worker nodes to run the given task, based on the disk space requirement
-func (e *Epvm) selectCandidateNodes(t task.Task, nodes []*node.Node) []*node.Node {
+// Get suitable worker nodes to run the given task, based on the resources requirements
+// and the minimum free headroom to preserve
+func (e *Epvm) SelectCandidateNodes(t task.Task, nodes []*node.Node) []*node.Node {
 	var candidates []*node.Node
-	for node := range nodes {
-		if checkDisk(t, nodes[node].Disk-nodes[node].DiskAllocated) {
-			candidates = append(candidates, nodes[node])
+	for _, n := range withinLimits(t, nodes) {
+		if checkHeadroom(t, n, e.Headroom) {
+			candidates = append(candidates, n)
 		}
 	}
 	return candidates
 }
 
-func (e *Epvm) score(t task.Task, nodes []*node.Node) map[string]float64 {
+// Compute the cost of running the given task on each node, based on their CPU and memory load
+func (e *Epvm) Score(t task.Task, nodes []*node.Node) map[string]float64 {
 	if len(nodes) == 0 {
 		return nil
 	}
@@ -44,42 +49,54 @@ func (e *Epvm) score(t task.Task, nodes []*node.Node) map[string]float64 {
 	maxJobs := 4.0
 
 	for _, node := range nodes {
-		err := node.UpdateStats()
+		err := node.UpdateStats(context.Background())
 		if err != nil {
 			log.Err(err).Str("node", node.Name).Msg("failed to update node stats")
 			continue
 		}
 
-		cpuUsage, err := calculateAvgCpuUsage(node, node.Stats.CpuUsage())
+		cpuUsage, err := calculateAvgCpuUsage(node, cpuUsageOrFull(node))
 		if err != nil {
 			log.Err(err).Str("node", node.Name).Msg("error calculating node CPU usage")
 			continue
 		}
 		cpuLoad := calculateLoad(cpuUsage, math.Pow(2, 0.8))
+		// The task CPU share is added to the node load, so that the busiest CPUs cost the most
+		newCpuLoad := calculateLoad(cpuUsage+taskCpuShare(t, node), math.Pow(2, 0.8))
 
 		memoryAllocated := float64(node.Stats.MemUsedKb()) + float64(node.MemoryAllocated)
 		memoryPercentAllocated := memoryAllocated / float64(node.Memory)
 
 		newMemPercent := calculateLoad(memoryAllocated+float64(t.Memory/1000), float64(node.Memory))
 		memCost := math.Pow(LIEB, newMemPercent) + math.Pow(LIEB, float64(node.TaskCount+1)/maxJobs) - math.Pow(LIEB, memoryPercentAllocated) - math.Pow(LIEB, float64(node.TaskCount)/float64(maxJobs))
-		cpuCost := math.Pow(LIEB, cpuLoad) + math.Pow(LIEB, float64(node.TaskCount+1)/maxJobs) - math.Pow(LIEB, cpuLoad) - math.Pow(LIEB, float64(node.TaskCount)/float64(maxJobs))
+		cpuCost := math.Pow(LIEB, newCpuLoad) + math.Pow(LIEB, float64(node.TaskCount+1)/maxJobs) - math.Pow(LIEB, cpuLoad) - math.Pow(LIEB, float64(node.TaskCount)/float64(maxJobs))
 
-		nodeScores[node.Name] = memCost + cpuCost
+		nodeScores[node.Name] = weightOrDefault(e.MemoryWeight)*memCost + weightOrDefault(e.CpuWeight)*cpuCost
 	}
 	return nodeScores
 }
 
-func (e *Epvm) pick(scores map[string]float64, candidates []*node.Node) *node.Node {
-	if len(candidates) == 0 {
-		return nil
+// Get the given cost weight, DefaultEpvmWeight when it isn't set
+func weightOrDefault(weight float64) float64 {
+	if weight == 0 {
+		return DefaultEpvmWeight
 	}
+	return weight
+}
 
-	minCost := scores[candidates[0].Name]
-	bestNode := candidates[0]
-	for i := 1; i < len(candidates); i++ {
-		node := candidates[i]
-		if scores[node.Name] < minCost {
-			minCost = scores[node.Name]
+// Select the candidate with the lowest cost
+//
+// Candidates without score, whose stats couldn't be retrieved, are never selected
+func (e *Epvm) Pick(scores map[string]float64, candidates []*node.Node) *node.Node {
+	var bestNode *node.Node
+	minCost := math.Inf(1)
+	for _, node := range candidates {
+		cost, found := scores[node.Name]
+		if !found {
+			continue
+		}
+		if bestNode == nil || cost < minCost {
+			minCost = cost
 			bestNode = node
 		}
 	}
@@ -90,19 +107,60 @@ func checkDisk(t task.Task, diskAvailable int64) bool {
 	return t.Disk <= diskAvailable
 }
 
+// Check that the node can run the task while keeping the given percentage of its CPU, memory and disk free
+func checkHeadroom(t task.Task, n *node.Node, headroom float64) bool {
+	usableRatio := 1 - headroom/100
+	if !checkDisk(t, int64(float64(n.Disk)*usableRatio)-n.DiskAllocated) {
+		return false
+	}
+
+	// Memory stats are unknown until they are retrieved from the worker, unknown CPU stats are considered fully used
+	if n.Stats.MemoryStats != nil {
+		memoryRequired := float64(n.MemoryAllocated) + float64(t.Memory/1000)
+		if memoryRequired > float64(n.Memory)*usableRatio {
+			return false
+		}
+	}
+	return cpuUsageOrFull(n) <= usableRatio
+}
+
 func calculateLoad(usage float64, capacity float64) float64 {
 	return usage / capacity
 }
 
+// Get the share of the node CPUs requested by the task
+//
+// The number of CPUs of the node is only known when its capacity or limit is set, a single CPU is assumed otherwise
+func taskCpuShare(t task.Task, n *node.Node) float64 {
+	cpus := n.CpuLimit()
+	if cpus <= 0 {
+		cpus = 1
+	}
+	return t.Cpu / cpus
+}
+
+// Delay between the two samples of the CPU usage averaged by the EPVM score
+var cpuSampleInterval = time.Second
+
 // Calculate CPU usage by sampling 2 times for an average
 func calculateAvgCpuUsage(node *node.Node, initialCpuUsage float64) (float64, error) {
-	time.Sleep(time.Second)
-	err := node.UpdateStats()
+	time.Sleep(cpuSampleInterval)
+	err := node.UpdateStats(context.Background())
 	if err != nil {
 		return 0, err
 	}
-	cpuUsage := node.Stats.CpuUsage()
+	cpuUsage := cpuUsageOrFull(node)
 
 	avgUsage := (initialCpuUsage + cpuUsage) / 2
 	return avgUsage, nil
 }
+
+// Get the CPU usage of the node, an unknown usage is considered as a full load
+// to avoid over-scheduling on a node which seems idle
+func cpuUsageOrFull(n *node.Node) float64 {
+	usage, err := n.Stats.CpuUsage()
+	if err != nil {
+		return 1
+	}
+	return usage
+}
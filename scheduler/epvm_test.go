@@ -0,0 +1,204 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/c9s/goprocinfo/linux"
+
+	"orchestrator/node"
+	"orchestrator/stats"
+	"orchestrator/task"
+)
+
+// Create a node with the given memory and disk capacity and allocations, its CPU being half used
+func newTestNode(name string, memory int64, memoryAllocated int64, disk int64, diskAllocated int64) *node.Node {
+	return &node.Node{
+		Name:            name,
+		Memory:          memory,
+		MemoryAllocated: memoryAllocated,
+		Disk:            disk,
+		DiskAllocated:   diskAllocated,
+		Stats: stats.Stats{
+			MemoryStats: &linux.MemInfo{MemTotal: uint64(memory), MemAvailable: uint64(memory - memoryAllocated)},
+			DiskStats:   &linux.Disk{All: uint64(disk), Used: uint64(diskAllocated), Free: uint64(disk - diskAllocated)},
+			CpuStats:    &linux.CPUStat{User: 50, Idle: 50},
+		},
+	}
+}
+
+func TestEpvmHeadroomExcludesNearlyFullNodes(t *testing.T) {
+	e := &Epvm{Headroom: 10}
+	diskFull := newTestNode("disk-full", 1000, 0, 1000, 850)
+	memoryFull := newTestNode("memory-full", 1000, 850, 1000, 0)
+	roomy := newTestNode("roomy", 1000, 500, 1000, 500)
+
+	// 100 more KB of memory and bytes of disk fit in every node, but not in their 90% usable part
+	tk := task.Task{Memory: 100 * 1000, Disk: 100}
+	candidates := e.SelectCandidateNodes(tk, []*node.Node{diskFull, memoryFull, roomy})
+	if len(candidates) != 1 || candidates[0] != roomy {
+		t.Fatalf("expected only the roomy node to be a candidate, got %v", nodeNames(candidates))
+	}
+
+	// Without headroom the nodes can be filled entirely
+	e.Headroom = 0
+	if candidates := e.SelectCandidateNodes(tk, []*node.Node{diskFull, memoryFull, roomy}); len(candidates) != 3 {
+		t.Errorf("expected all the nodes to be candidates without headroom, got %v", nodeNames(candidates))
+	}
+}
+
+func TestEpvmHeadroomExcludesBusyCpu(t *testing.T) {
+	e := &Epvm{Headroom: 60}
+	busy := newTestNode("busy", 1000, 0, 1000, 0)
+	if candidates := e.SelectCandidateNodes(task.Task{}, []*node.Node{busy}); len(candidates) != 0 {
+		t.Errorf("expected the node using half of its CPU to be excluded, got %v", nodeNames(candidates))
+	}
+}
+
+func TestEpvmUnknownCpuIsFullLoad(t *testing.T) {
+	e := &Epvm{Headroom: 10}
+	unknown := newTestNode("unknown", 1000, 0, 1000, 0)
+	unknown.Stats.CpuStats = &linux.CPUStat{}
+	if candidates := e.SelectCandidateNodes(task.Task{}, []*node.Node{unknown}); len(candidates) != 0 {
+		t.Errorf("expected the node with empty CPU stats to be considered busy, got %v", nodeNames(candidates))
+	}
+}
+
+func TestEpvmHeadroomChecksCpuWithoutMemoryStats(t *testing.T) {
+	e := &Epvm{Headroom: 20}
+	busy := newTestNode("busy", 1000, 0, 1000, 0)
+	busy.Stats.MemoryStats = nil
+	busy.Stats.CpuStats = &linux.CPUStat{User: 90, Idle: 10}
+	idle := newTestNode("idle", 1000, 0, 1000, 0)
+	idle.Stats.MemoryStats = nil
+	idle.Stats.CpuStats = &linux.CPUStat{User: 10, Idle: 90}
+
+	candidates := e.SelectCandidateNodes(task.Task{}, []*node.Node{busy, idle})
+	if len(candidates) != 1 || candidates[0] != idle {
+		t.Errorf("expected only the node with idle CPU to be a candidate, got %v", nodeNames(candidates))
+	}
+}
+
+func TestEpvmSkipsNodesWithUnreachableStats(t *testing.T) {
+	unreachable := newTestNode("unreachable", 16*1000*1000, 0, 100*1000*1000, 0)
+	unreachable.MetricsApi = "http://127.0.0.1:1"
+	reporting := newReportingNodes(t, 1)[0]
+	tk := task.Task{Memory: 100 * 1000, Disk: 1000}
+
+	if selected := (&Epvm{}).SelectNode(tk, []*node.Node{unreachable, reporting}); selected != reporting {
+		t.Errorf("expected the node reporting its stats to be selected, got %v", selected)
+	}
+	if selected := (&Epvm{}).SelectNode(tk, []*node.Node{unreachable}); selected != nil {
+		t.Errorf("expected no node to be selected without stats, got %s", selected.Name)
+	}
+}
+
+func nodeNames(nodes []*node.Node) []string {
+	names := make([]string, len(nodes))
+	for i, n := range nodes {
+		names[i] = n.Name
+	}
+	return names
+}
+
+// Create a node whose worker reports the given CPU usage and used memory, in percent
+func newLoadedNode(t *testing.T, name string, cpuPercent uint64, memoryPercent uint64) *node.Node {
+	t.Helper()
+	return newStatsNode(t, name, &linux.CPUStat{User: cpuPercent, Idle: 100 - cpuPercent}, memoryPercent)
+}
+
+// Create a node whose worker reports the given CPU stats and used memory, in percent
+func newStatsNode(t *testing.T, name string, cpuStats *linux.CPUStat, memoryPercent uint64) *node.Node {
+	t.Helper()
+	const memTotal = 8 << 20
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(stats.Stats{
+			MemoryStats: &linux.MemInfo{MemTotal: memTotal, MemAvailable: memTotal * (100 - memoryPercent) / 100},
+			DiskStats:   &linux.Disk{All: 100 << 30, Free: 80 << 30, Used: 20 << 30},
+			CpuStats:    cpuStats,
+		})
+	}))
+	t.Cleanup(server.Close)
+	n := &node.Node{Name: name, MetricsApi: server.URL}
+	if err := n.UpdateStats(context.Background()); err != nil {
+		t.Fatalf("failed to retrieve stats of node %s: %v", name, err)
+	}
+	return n
+}
+
+// Disable the delay between the CPU usage samples of the EPVM score for the duration of the test
+func disableCpuSampleInterval(t *testing.T) {
+	previousInterval := cpuSampleInterval
+	cpuSampleInterval = 0
+	t.Cleanup(func() { cpuSampleInterval = previousInterval })
+}
+
+func TestEpvmMemoryWeight(t *testing.T) {
+	disableCpuSampleInterval(t)
+	crowded := newLoadedNode(t, "crowded", 10, 10)
+	crowded.TaskCount = 2
+	memoryBusy := newLoadedNode(t, "memory-busy", 10, 70)
+	nodes := []*node.Node{crowded, memoryBusy}
+	tk := task.Task{Name: "cache", Memory: 1e9}
+
+	// The tasks count weighs in both the CPU and memory costs, the node without tasks wins
+	if selected := (&Epvm{}).SelectNode(tk, nodes); selected != memoryBusy {
+		t.Errorf("expected node memory-busy to be selected with the default weights, got %s", nodeName(selected))
+	}
+	// The memory load prevails, the free memory wins
+	if selected := (&Epvm{MemoryWeight: 5}).SelectNode(tk, nodes); selected != crowded {
+		t.Errorf("expected node crowded to be selected with a raised memory weight, got %s", nodeName(selected))
+	}
+	if selected := (&Epvm{CpuWeight: 5}).SelectNode(tk, nodes); selected != memoryBusy {
+		t.Errorf("expected node memory-busy to be selected with a raised CPU weight, got %s", nodeName(selected))
+	}
+}
+
+func TestEpvmDefaultWeightsKeepScores(t *testing.T) {
+	disableCpuSampleInterval(t)
+	cpuBusy := newLoadedNode(t, "cpu-busy", 90, 10)
+	memoryBusy := newLoadedNode(t, "memory-busy", 10, 70)
+	nodes := []*node.Node{cpuBusy, memoryBusy}
+	tk := task.Task{Name: "cache", Cpu: 1, Memory: 1e9}
+
+	unset := (&Epvm{}).Score(tk, nodes)
+	explicit := (&Epvm{CpuWeight: DefaultEpvmWeight, MemoryWeight: DefaultEpvmWeight}).Score(tk, nodes)
+	if !maps.Equal(unset, explicit) {
+		t.Errorf("expected the unset weights to score as the default ones, got %v and %v", unset, explicit)
+	}
+	// The CPU requested by the task weighs as much as its memory, the idle CPU wins
+	if selected := (&Epvm{}).Pick(unset, nodes); selected != memoryBusy {
+		t.Errorf("expected node memory-busy to be selected with the default weights, got %s", nodeName(selected))
+	}
+}
+
+func TestEpvmCpuCostGrowsWithTaskCpu(t *testing.T) {
+	disableCpuSampleInterval(t)
+	n := newLoadedNode(t, "worker", 50, 50)
+	e := &Epvm{MemoryWeight: 1e-9}
+	light := e.Score(task.Task{Cpu: 0.1}, []*node.Node{n})["worker"]
+	heavy := e.Score(task.Task{Cpu: 2}, []*node.Node{n})["worker"]
+	if heavy <= light {
+		t.Errorf("expected the cost of a CPU heavy task to be higher, got %f for 2 CPUs and %f for 0.1 CPU", heavy, light)
+	}
+}
+
+func TestEpvmUnknownCpuCostsAsFullLoad(t *testing.T) {
+	disableCpuSampleInterval(t)
+	unknown := newStatsNode(t, "unknown", &linux.CPUStat{}, 10)
+	idle := newLoadedNode(t, "idle", 10, 10)
+	if selected := (&Epvm{}).SelectNode(task.Task{Cpu: 1}, []*node.Node{unknown, idle}); selected != idle {
+		t.Errorf("expected the node with known idle CPU to be selected, got %s", nodeName(selected))
+	}
+}
+
+func nodeName(n *node.Node) string {
+	if n == nil {
+		return "no node"
+	}
+	return n.Name
+}
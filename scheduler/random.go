@@ -0,0 +1,61 @@
+package scheduler
+
+import (
+	"math/rand"
+	"orchestrator/node"
+	"orchestrator/task"
+)
+
+// Scheduler which selects uniformly at random one of the nodes with enough free disk to run the task
+type Random struct {
+	rand *rand.Rand
+}
+
+// Create a random scheduler drawing from the given source, a seeded source makes the selection deterministic
+func NewRandom(source rand.Source) *Random {
+	return &Random{rand: rand.New(source)}
+}
+
+func (r *Random) SelectNode(t task.Task, nodes []*node.Node) *node.Node {
+	return selectNode(r, t, nodes)
+}
+
+// Get the nodes whose hard limits and free disk allow to run the given task
+func (r *Random) SelectCandidateNodes(t task.Task, nodes []*node.Node) []*node.Node {
+	var candidates []*node.Node
+	for _, n := range withinLimits(t, nodes) {
+		if checkDisk(t, n.Disk-n.DiskAllocated) {
+			candidates = append(candidates, n)
+		}
+	}
+	return candidates
+}
+
+// Give the same score to all the candidates
+func (r *Random) Score(t task.Task, candidates []*node.Node) map[string]float64 {
+	if len(candidates) == 0 {
+		return nil
+	}
+	scores := make(map[string]float64, len(candidates))
+	for _, n := range candidates {
+		scores[n.Name] = 0
+	}
+	return scores
+}
+
+// Select uniformly at random one of the candidates with the lowest score
+func (r *Random) Pick(scores map[string]float64, candidates []*node.Node) *node.Node {
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	var best []*node.Node
+	for _, n := range candidates {
+		if len(best) == 0 || scores[n.Name] < scores[best[0].Name] {
+			best = []*node.Node{n}
+		} else if scores[n.Name] == scores[best[0].Name] {
+			best = append(best, n)
+		}
+	}
+	return best[r.rand.Intn(len(best))]
+}
@@ -0,0 +1,51 @@
+package scheduler
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"orchestrator/node"
+	"orchestrator/task"
+)
+
+func TestRandomPicksUniformly(t *testing.T) {
+	r := NewRandom(rand.NewSource(42))
+	nodes := []*node.Node{{Name: "a", Disk: 1000}, {Name: "b", Disk: 1000}, {Name: "c", Disk: 1000}, {Name: "d", Disk: 1000}}
+
+	const picks = 10000
+	counts := make(map[string]int)
+	for i := 0; i < picks; i++ {
+		counts[r.SelectNode(task.Task{}, nodes).Name]++
+	}
+
+	expected := float64(picks) / float64(len(nodes))
+	for _, n := range nodes {
+		if deviation := math.Abs(float64(counts[n.Name])-expected) / expected; deviation > 0.05 {
+			t.Errorf("expected node %s to be picked about %v times, got %d", n.Name, expected, counts[n.Name])
+		}
+	}
+}
+
+func TestRandomSameSeedSameSelection(t *testing.T) {
+	nodes := []*node.Node{{Name: "a"}, {Name: "b"}, {Name: "c"}}
+	first, second := NewRandom(rand.NewSource(7)), NewRandom(rand.NewSource(7))
+	for i := 0; i < 20; i++ {
+		if a, b := first.SelectNode(task.Task{}, nodes), second.SelectNode(task.Task{}, nodes); a != b {
+			t.Fatalf("pick %d: expected the seeded schedulers to select the same node, got %s and %s", i, a.Name, b.Name)
+		}
+	}
+}
+
+func TestRandomCandidatesNeedFreeDisk(t *testing.T) {
+	r := NewRandom(rand.NewSource(1))
+	nodes := []*node.Node{{Name: "full", Disk: 1000, DiskAllocated: 900}, {Name: "free", Disk: 1000}}
+
+	candidates := r.SelectCandidateNodes(task.Task{Disk: 500}, nodes)
+	if len(candidates) != 1 || candidates[0].Name != "free" {
+		t.Errorf("expected only the node with enough free disk to be a candidate, got %v", nodeNames(candidates))
+	}
+	if selected := r.SelectNode(task.Task{Disk: 2000}, nodes); selected != nil {
+		t.Errorf("expected no node to be selected, got %s", selected.Name)
+	}
+}
@@ -5,24 +5,76 @@ import (
 	"orchestrator/task"
 )
 
-// Simple scheduler that selects the next available worker
+// Simple scheduler that selects the next available worker able to run the task
 //
 // After the last worker is selected, it goes back to the first one
 type RoundRobin struct {
-	LastWorkerNode int
+	LastWorkerNode int // Position of the last selected worker in the nodes list
+
+	positions map[string]int // Position of the candidates in the nodes list they were selected from
+	nodeCount int            // Length of the nodes list the candidates were selected from
 }
 
 func (r *RoundRobin) SelectNode(t task.Task, nodes []*node.Node) *node.Node {
-	if len(nodes) == 0 {
+	return selectNode(r, t, nodes)
+}
+
+// Get the nodes whose hard limits, free memory and free disk allow to run the given task
+//
+// The position of the candidates in the nodes list is recorded, so that the cursor only moves over the viable nodes
+func (r *RoundRobin) SelectCandidateNodes(t task.Task, nodes []*node.Node) []*node.Node {
+	r.positions = make(map[string]int, len(nodes))
+	r.nodeCount = len(nodes)
+	for i, n := range nodes {
+		r.positions[n.Name] = i
+	}
+
+	var candidates []*node.Node
+	for _, n := range withinLimits(t, nodes) {
+		if checkDisk(t, n.Disk-n.DiskAllocated) && checkMemory(t, n) {
+			candidates = append(candidates, n)
+		}
+	}
+	return candidates
+}
+
+// Score the candidates by their distance to the node following the last selected one
+func (r *RoundRobin) Score(t task.Task, candidates []*node.Node) map[string]float64 {
+	if len(candidates) == 0 {
+		return nil
+	}
+	scores := make(map[string]float64, len(candidates))
+	next := r.LastWorkerNode + 1
+	for i, n := range candidates {
+		position, count := r.position(i, n, len(candidates))
+		// The modulo keeps the cursor in range when the nodes list is shorter than on the previous call
+		scores[n.Name] = float64(((position-next)%count + count) % count)
+	}
+	return scores
+}
+
+// Select the candidate with the lowest score and move the cursor to it
+func (r *RoundRobin) Pick(scores map[string]float64, candidates []*node.Node) *node.Node {
+	if len(candidates) == 0 {
 		return nil
 	}
 
-	var newWorker int
-	if r.LastWorkerNode == len(nodes)-1 {
-		newWorker = 0
-	} else {
-		newWorker = r.LastWorkerNode + 1
+	best := 0
+	for i := 1; i < len(candidates); i++ {
+		if scores[candidates[i].Name] < scores[candidates[best].Name] {
+			best = i
+		}
+	}
+	r.LastWorkerNode, _ = r.position(best, candidates[best], len(candidates))
+	return candidates[best]
+}
+
+// Get the position of a candidate in the nodes list along with the list length
+//
+// When the candidates weren't selected by this scheduler, their own list is used
+func (r *RoundRobin) position(i int, n *node.Node, candidatesCount int) (int, int) {
+	if position, found := r.positions[n.Name]; found {
+		return position, r.nodeCount
 	}
-	r.LastWorkerNode = newWorker
-	return nodes[newWorker]
+	return i, candidatesCount
 }
@@ -0,0 +1,70 @@
+package scheduler
+
+import (
+	"testing"
+
+	"orchestrator/node"
+	"orchestrator/task"
+)
+
+func TestRoundRobinSkipsFullNode(t *testing.T) {
+	r := &RoundRobin{}
+	a := newTestNode("a", 1000*1000, 0, 1000*1000, 0)
+	full := newTestNode("full", 1000*1000, 1000*1000, 1000*1000, 0)
+	c := newTestNode("c", 1000*1000, 0, 1000*1000, 0)
+	nodes := []*node.Node{a, full, c}
+	tk := task.Task{Memory: 100 * 1000 * 1000}
+
+	candidates := r.SelectCandidateNodes(tk, nodes)
+	if names := nodeNames(candidates); len(names) != 2 || names[0] != "a" || names[1] != "c" {
+		t.Fatalf("expected the full node not to be a candidate, got %v", names)
+	}
+
+	var selected []string
+	for i := 0; i < 4; i++ {
+		n := r.SelectNode(tk, nodes)
+		if n == nil {
+			t.Fatalf("expected a node to be selected")
+		}
+		selected = append(selected, n.Name)
+	}
+	expected := []string{"c", "a", "c", "a"}
+	for i := range expected {
+		if selected[i] != expected[i] {
+			t.Fatalf("expected the selection order %v, got %v", expected, selected)
+		}
+	}
+}
+
+func TestRoundRobinKeepsOrderWhenNodeFreed(t *testing.T) {
+	r := &RoundRobin{}
+	a := newTestNode("a", 1000*1000, 0, 1000*1000, 0)
+	b := newTestNode("b", 1000*1000, 1000*1000, 1000*1000, 0)
+	c := newTestNode("c", 1000*1000, 0, 1000*1000, 0)
+	nodes := []*node.Node{a, b, c}
+	tk := task.Task{Memory: 100 * 1000 * 1000}
+
+	// The cursor starts on the first node, so the full second node is skipped in favor of the third
+	if selected := r.SelectNode(tk, nodes); selected != c {
+		t.Fatalf("expected the node after the full one to be selected, got %v", selected)
+	}
+	b.MemoryAllocated = 0
+	if selected := r.SelectNode(tk, nodes); selected != a {
+		t.Errorf("expected the rotation to wrap around to the first node, got %v", selected)
+	}
+	if selected := r.SelectNode(tk, nodes); selected != b {
+		t.Errorf("expected the freed node to be selected in turn, got %v", selected)
+	}
+}
+
+func TestRoundRobinNoCandidate(t *testing.T) {
+	r := &RoundRobin{LastWorkerNode: 1}
+	full := newTestNode("full", 1000*1000, 1000*1000, 1000*1000, 0)
+
+	if selected := r.SelectNode(task.Task{Memory: 100 * 1000 * 1000}, []*node.Node{full}); selected != nil {
+		t.Errorf("expected no node to be selected, got %v", selected)
+	}
+	if r.LastWorkerNode != 1 {
+		t.Errorf("expected the cursor not to move, got %d", r.LastWorkerNode)
+	}
+}
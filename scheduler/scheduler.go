@@ -7,6 +7,49 @@ import (
 
 // Selector of worker node to run a task
 type Scheduler interface {
+	// Get the worker nodes able to run the given task
+	SelectCandidateNodes(t task.Task, nodes []*node.Node) []*node.Node
+	// Compute the score of each candidate node by name, the lowest score is the most suitable
+	Score(t task.Task, candidates []*node.Node) map[string]float64
+	// Select the candidate node with the best score, nil if there is no candidate
+	Pick(scores map[string]float64, candidates []*node.Node) *node.Node
 	// Select the most suitable worker node to run the given task
 	SelectNode(t task.Task, nodes []*node.Node) *node.Node
 }
+
+// Select a node by composing the scheduler steps, the node preferred by the task is used if it is a candidate
+func selectNode(s Scheduler, t task.Task, nodes []*node.Node) *node.Node {
+	candidates := s.SelectCandidateNodes(t, nodes)
+	if len(candidates) == 0 {
+		return nil
+	}
+	if preferred := preferredCandidate(t, candidates); preferred != nil {
+		return preferred
+	}
+	scores := s.Score(t, candidates)
+	return s.Pick(scores, candidates)
+}
+
+// Get the node preferred by the task if it is part of the candidates, nil otherwise
+func preferredCandidate(t task.Task, candidates []*node.Node) *node.Node {
+	if t.PreferredNode == "" {
+		return nil
+	}
+	for _, n := range candidates {
+		if n.Name == t.PreferredNode {
+			return n
+		}
+	}
+	return nil
+}
+
+// Get the nodes whose hard limits and labels allow to run the given task
+func withinLimits(t task.Task, nodes []*node.Node) []*node.Node {
+	var candidates []*node.Node
+	for _, n := range nodes {
+		if n.CanReserveCpu(t.Cpu) && n.MatchSelector(t.NodeSelector) {
+			candidates = append(candidates, n)
+		}
+	}
+	return candidates
+}
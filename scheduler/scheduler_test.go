@@ -0,0 +1,112 @@
+package scheduler
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+
+	"orchestrator/node"
+	"orchestrator/task"
+)
+
+func TestPreferredNodeChosenWhenViable(t *testing.T) {
+	nodes := newReportingNodes(t, 3)
+	tk := task.Task{Memory: 100 * 1000, Disk: 1000, PreferredNode: nodes[2].Name}
+
+	for _, s := range []Scheduler{&RoundRobin{}, &Epvm{}} {
+		if selected := s.SelectNode(tk, nodes); selected != nodes[2] {
+			t.Errorf("%T: expected the preferred node %s to be selected, got %v", s, nodes[2].Name, selected)
+		}
+	}
+}
+
+func TestPreferredNodeIgnoredWhenTaskDoesntFit(t *testing.T) {
+	nodes := newReportingNodes(t, 2)
+	full := nodes[0]
+	full.DiskAllocated = full.Disk
+	tk := task.Task{Memory: 100 * 1000, Disk: 1000, PreferredNode: full.Name}
+
+	if selected := (&Epvm{}).SelectNode(tk, nodes); selected != nodes[1] {
+		t.Errorf("expected the preferred node without free disk to be ignored, got %v", selected)
+	}
+}
+
+func TestUnknownPreferredNodeIgnored(t *testing.T) {
+	nodes := []*node.Node{{Name: "a"}, {Name: "b"}}
+	r := &RoundRobin{}
+	if selected := r.SelectNode(task.Task{PreferredNode: "unknown"}, nodes); selected != nodes[1] {
+		t.Errorf("expected the round robin order to be followed, got %v", selected)
+	}
+}
+
+func TestNodeMemoryAndDiskLimitsConstrainCandidates(t *testing.T) {
+	// The nodes report 16 GB of memory and 100 MB of disk
+	nodes := newReportingNodes(t, 3)
+	nodes[0].MaxMemory = 1000 * 1000
+	nodes[1].MaxDisk = 1000
+	for _, n := range nodes {
+		if err := n.UpdateStats(context.Background()); err != nil {
+			t.Fatalf("failed to update node stats: %v", err)
+		}
+	}
+	if nodes[0].Memory != 1000*1000 || nodes[1].Disk != 1000 {
+		t.Fatalf("expected the reported capacities to be capped, got memory %d and disk %d", nodes[0].Memory, nodes[1].Disk)
+	}
+
+	tk := task.Task{Memory: 2 * 1000 * 1000 * 1000, Disk: 10 * 1000}
+	candidates := (&Epvm{}).SelectCandidateNodes(tk, nodes)
+	if len(candidates) != 1 || candidates[0] != nodes[2] {
+		t.Errorf("expected only the node without limits to be a candidate, got %v", nodeNames(candidates))
+	}
+}
+
+func TestNodeCpuLimitConstrainsCandidates(t *testing.T) {
+	limited := &node.Node{Name: "limited", MaxCpu: 2, CpuReserved: 1.5}
+	unlimited := &node.Node{Name: "unlimited", CpuReserved: 8}
+	tk := task.Task{Cpu: 1}
+
+	r := &RoundRobin{}
+	for i := 0; i < 2; i++ {
+		if selected := r.SelectNode(tk, []*node.Node{limited, unlimited}); selected != unlimited {
+			t.Errorf("expected the node without free reservable CPU to be skipped, got %v", selected)
+		}
+	}
+	if selected := r.SelectNode(task.Task{Cpu: 0.5}, []*node.Node{limited}); selected != limited {
+		t.Errorf("expected the task to fit in the remaining reservable CPU, got %v", selected)
+	}
+}
+
+func TestNodeSelectorMatchesLabels(t *testing.T) {
+	nodes := newReportingNodes(t, 3)
+	nodes[0].Labels = map[string]string{"zone": "eu-west", "disk": "hdd"}
+	nodes[1].Labels = map[string]string{"zone": "eu-west", "disk": "ssd"}
+	tk := task.Task{Memory: 100 * 1000, Disk: 1000, NodeSelector: map[string]string{"zone": "eu-west", "disk": "ssd"}}
+
+	schedulers := []Scheduler{&RoundRobin{}, &Epvm{}, NewRandom(rand.NewSource(1)), &BinPacking{}}
+	for _, s := range schedulers {
+		candidates := s.SelectCandidateNodes(tk, nodes)
+		if len(candidates) != 1 || candidates[0] != nodes[1] {
+			t.Errorf("%T: expected only the node with all the selector labels to be a candidate, got %v", s, nodeNames(candidates))
+		}
+		if selected := s.SelectNode(tk, nodes); selected != nodes[1] {
+			t.Errorf("%T: expected the matching node to be selected, got %v", s, selected)
+		}
+	}
+}
+
+func TestNodeSelectorWithoutMatchingNode(t *testing.T) {
+	nodes := newReportingNodes(t, 2)
+	nodes[0].Labels = map[string]string{"zone": "eu-west"}
+	tk := task.Task{Memory: 100 * 1000, Disk: 1000, NodeSelector: map[string]string{"zone": "us-east"}}
+
+	schedulers := []Scheduler{&RoundRobin{}, &Epvm{}, NewRandom(rand.NewSource(1)), &BinPacking{}}
+	for _, s := range schedulers {
+		if selected := s.SelectNode(tk, nodes); selected != nil {
+			t.Errorf("%T: expected no node to be selected, got %v", s, selected.Name)
+		}
+	}
+	// Without selector, the labels don't restrict the candidates
+	if candidates := (&Epvm{}).SelectCandidateNodes(task.Task{Memory: 100 * 1000, Disk: 1000}, nodes); len(candidates) != 2 {
+		t.Errorf("expected all the nodes to be candidates without selector, got %v", nodeNames(candidates))
+	}
+}
@@ -0,0 +1,40 @@
+package stats
+
+// Resources available to the worker, overriding the machine ones when the worker runs under resource limits
+//
+// The zero values keep the collected machine values
+type Capacity struct {
+	Cpu       float64 // Number of CPUs
+	MemoryKb  uint64  // Memory in KB
+	DiskBytes uint64  // Disk space in bytes
+}
+
+// Wrap the given collector so that the stats it reports are bounded by the given capacity
+func WithCapacity(collector StatsCollector, capacity Capacity) StatsCollector {
+	return capacityCollector{collector: collector, capacity: capacity}
+}
+
+// Collector reporting the configured capacity instead of the machine one
+type capacityCollector struct {
+	collector StatsCollector
+	capacity  Capacity
+}
+
+func (c capacityCollector) Collect() *Stats {
+	stats := c.collector.Collect()
+	stats.CpuCapacity = c.capacity.Cpu
+	// The machine usage is kept, bounded by the capacity, so that the scheduler doesn't overcommit the worker
+	if c.capacity.MemoryKb > 0 && stats.MemoryStats != nil {
+		used := min(stats.MemUsedKb(), c.capacity.MemoryKb)
+		stats.MemoryStats.MemTotal = c.capacity.MemoryKb
+		stats.MemoryStats.MemAvailable = c.capacity.MemoryKb - used
+		stats.MemoryStats.MemFree = min(stats.MemoryStats.MemFree, stats.MemoryStats.MemAvailable)
+	}
+	if c.capacity.DiskBytes > 0 && stats.DiskStats != nil {
+		used := min(stats.DiskUsed(), c.capacity.DiskBytes)
+		stats.DiskStats.All = c.capacity.DiskBytes
+		stats.DiskStats.Used = used
+		stats.DiskStats.Free = c.capacity.DiskBytes - used
+	}
+	return stats
+}
@@ -0,0 +1,51 @@
+package stats
+
+import (
+	"testing"
+
+	"github.com/c9s/goprocinfo/linux"
+)
+
+// Collector reporting a copy of fixed machine stats
+type fixedCollector struct {
+	memory linux.MemInfo
+	disk   linux.Disk
+}
+
+func (c fixedCollector) Collect() *Stats {
+	memory, disk := c.memory, c.disk
+	return &Stats{MemoryStats: &memory, DiskStats: &disk}
+}
+
+func TestCapacityReportedInsteadOfMachine(t *testing.T) {
+	machine := fixedCollector{
+		memory: linux.MemInfo{MemTotal: 64000000, MemFree: 60000000, MemAvailable: 62000000},
+		disk:   linux.Disk{All: 1000000000000, Used: 1000000, Free: 999999000000},
+	}
+	s := WithCapacity(machine, Capacity{Cpu: 2, MemoryKb: 4000000, DiskBytes: 10000000}).Collect()
+
+	if s.CpuCapacity != 2 {
+		t.Errorf("expected a capacity of 2 CPUs, got %v", s.CpuCapacity)
+	}
+	if s.MemTotalKb() != 4000000 || s.MemUsedKb() != 2000000 {
+		t.Errorf("expected 2000000 KB used of 4000000 KB, got %d of %d", s.MemUsedKb(), s.MemTotalKb())
+	}
+	if s.DiskTotal() != 10000000 || s.DiskUsed() != 1000000 || s.DiskStats.Free != 9000000 {
+		t.Errorf("expected 1000000 bytes used of 10000000 bytes, got %d of %d", s.DiskUsed(), s.DiskTotal())
+	}
+}
+
+func TestCapacityBoundsMachineUsage(t *testing.T) {
+	machine := fixedCollector{
+		memory: linux.MemInfo{MemTotal: 64000000, MemAvailable: 32000000},
+		disk:   linux.Disk{All: 100, Used: 50},
+	}
+	s := WithCapacity(machine, Capacity{MemoryKb: 1000000}).Collect()
+
+	if s.MemTotalKb() != 1000000 || s.MemUsedKb() != 1000000 {
+		t.Errorf("expected the memory usage to be bounded by the capacity, got %d of %d KB", s.MemUsedKb(), s.MemTotalKb())
+	}
+	if s.DiskTotal() != 100 || s.CpuCapacity != 0 {
+		t.Errorf("expected the omitted resources to keep the machine values, got %d bytes and %v CPUs", s.DiskTotal(), s.CpuCapacity)
+	}
+}
@@ -0,0 +1,30 @@
+package stats
+
+import "fmt"
+
+// Stats collector types
+const (
+	CollectorProcfs   = "procfs"   // Read the Linux procfs
+	CollectorGopsutil = "gopsutil" // Cross-platform, using the gopsutil library
+)
+
+// Default stats collector type
+const DefaultCollector = CollectorProcfs
+
+// Source of the machine stats
+type StatsCollector interface {
+	// Get the current machine stats, the values which couldn't be read are left empty
+	Collect() *Stats
+}
+
+// Create a stats collector of the given type
+func NewCollector(collectorType string) (StatsCollector, error) {
+	switch collectorType {
+	case CollectorProcfs:
+		return linuxCollector{}, nil
+	case CollectorGopsutil:
+		return gopsutilCollector{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported stats collector type: %s", collectorType)
+	}
+}
@@ -0,0 +1,24 @@
+package stats
+
+import "testing"
+
+func TestNewCollector(t *testing.T) {
+	for _, collectorType := range []string{CollectorProcfs, CollectorGopsutil} {
+		if _, err := NewCollector(collectorType); err != nil {
+			t.Errorf("expected the %s collector to be created, got %v", collectorType, err)
+		}
+	}
+	if _, err := NewCollector("wmi"); err == nil {
+		t.Error("expected the unknown collector type to be rejected")
+	}
+}
+
+func TestGopsutilCollectorFillsStats(t *testing.T) {
+	s := gopsutilCollector{}.Collect()
+	if s.MemoryStats == nil || s.DiskStats == nil || s.CpuStats == nil || s.LoadStats == nil {
+		t.Fatalf("expected all the stats to be set, got %+v", s)
+	}
+	if s.MemoryStats.MemTotal == 0 || s.DiskStats.All == 0 {
+		t.Errorf("expected the memory and disk capacities to be read, got %d KB and %d bytes", s.MemoryStats.MemTotal, s.DiskStats.All)
+	}
+}
@@ -0,0 +1,65 @@
+package stats
+
+import (
+	"github.com/c9s/goprocinfo/linux"
+	"github.com/rs/zerolog/log"
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/shirou/gopsutil/v3/load"
+	"github.com/shirou/gopsutil/v3/mem"
+)
+
+// Number of clock ticks per second of the procfs CPU times, the gopsutil times are converted to this unit
+const clockTicks = 100
+
+// Cross-platform collector, the stats are converted to the procfs format
+type gopsutilCollector struct{}
+
+func (c gopsutilCollector) Collect() *Stats {
+	stats := &Stats{
+		MemoryStats: &linux.MemInfo{},
+		DiskStats:   &linux.Disk{},
+		CpuStats:    &linux.CPUStat{},
+		LoadStats:   &linux.LoadAvg{},
+	}
+
+	if memory, err := mem.VirtualMemory(); err != nil {
+		log.Err(err).Msg("error reading memory stats")
+	} else {
+		stats.MemoryStats.MemTotal = memory.Total / 1024
+		stats.MemoryStats.MemFree = memory.Free / 1024
+		stats.MemoryStats.MemAvailable = memory.Available / 1024
+	}
+
+	if usage, err := disk.Usage("/"); err != nil {
+		log.Err(err).Msg("error reading disk stats")
+	} else {
+		stats.DiskStats.All = usage.Total
+		stats.DiskStats.Used = usage.Used
+		stats.DiskStats.Free = usage.Free
+		stats.DiskStats.FreeInodes = usage.InodesFree
+	}
+
+	if times, err := cpu.Times(false); err != nil {
+		log.Err(err).Msg("error reading cpu stats")
+	} else if len(times) > 0 {
+		stats.CpuStats.Id = "cpu"
+		stats.CpuStats.User = uint64(times[0].User * clockTicks)
+		stats.CpuStats.Nice = uint64(times[0].Nice * clockTicks)
+		stats.CpuStats.System = uint64(times[0].System * clockTicks)
+		stats.CpuStats.Idle = uint64(times[0].Idle * clockTicks)
+		stats.CpuStats.IOWait = uint64(times[0].Iowait * clockTicks)
+		stats.CpuStats.IRQ = uint64(times[0].Irq * clockTicks)
+		stats.CpuStats.SoftIRQ = uint64(times[0].Softirq * clockTicks)
+		stats.CpuStats.Steal = uint64(times[0].Steal * clockTicks)
+	}
+
+	if avg, err := load.Avg(); err != nil {
+		log.Err(err).Msg("error reading load average")
+	} else {
+		stats.LoadStats.Last1Min = avg.Load1
+		stats.LoadStats.Last5Min = avg.Load5
+		stats.LoadStats.Last15Min = avg.Load15
+	}
+	return stats
+}
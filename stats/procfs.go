@@ -0,0 +1,54 @@
+package stats
+
+import (
+	"github.com/c9s/goprocinfo/linux"
+	"github.com/rs/zerolog/log"
+)
+
+// Collector reading the machine stats from the Linux procfs
+type linuxCollector struct{}
+
+func (c linuxCollector) Collect() *Stats {
+	return &Stats{
+		MemoryStats: getMemoryInfo(),
+		DiskStats:   getDiskInfo(),
+		CpuStats:    getCpuStats(),
+		LoadStats:   getLoadAvg(),
+	}
+}
+
+func getMemoryInfo() *linux.MemInfo {
+	memstats, err := linux.ReadMemInfo("/proc/meminfo")
+	if err != nil {
+		log.Err(err).Msg("error reading from /proc/meminfo")
+		return &linux.MemInfo{}
+	}
+	return memstats
+}
+
+func getDiskInfo() *linux.Disk {
+	diskstats, err := linux.ReadDisk("/")
+	if err != nil {
+		log.Err(err).Msg("error reading from /")
+		return &linux.Disk{}
+	}
+	return diskstats
+}
+
+func getCpuStats() *linux.CPUStat {
+	stats, err := linux.ReadStat("/proc/stat")
+	if err != nil {
+		log.Err(err).Msg("error reading from /proc/stat")
+		return &linux.CPUStat{}
+	}
+	return &stats.CPUStatAll
+}
+
+func getLoadAvg() *linux.LoadAvg {
+	loadavg, err := linux.ReadLoadAvg("/proc/loadavg")
+	if err != nil {
+		log.Err(err).Msg("error reading from /proc/loadavg")
+		return &linux.LoadAvg{}
+	}
+	return loadavg
+}
@@ -1,16 +1,20 @@
 package stats
 
 import (
+	"errors"
+
 	"github.com/c9s/goprocinfo/linux"
-	"github.com/rs/zerolog/log"
 )
 
+var ErrCpuStatsUnavailable = errors.New("cpu stats unavailable")
+
 // Machine stats
 type Stats struct {
 	MemoryStats *linux.MemInfo
 	DiskStats   *linux.Disk
 	CpuStats    *linux.CPUStat
 	LoadStats   *linux.LoadAvg
+	CpuCapacity float64 // Number of CPUs available to the tasks when the worker capacity is configured, 0 otherwise
 }
 
 func (s *Stats) MemTotalKb() uint64 {
@@ -25,8 +29,12 @@ func (s *Stats) MemUsedKb() uint64 {
 	return s.MemoryStats.MemTotal - s.MemoryStats.MemAvailable
 }
 
-func (s *Stats) MemUsedPercent() uint64 {
-	return s.MemoryStats.MemAvailable / s.MemoryStats.MemTotal
+// Get the percentage of used memory, between 0 and 100, 0 when the total memory is unknown
+func (s *Stats) MemUsedPercent() float64 {
+	if s.MemoryStats == nil || s.MemoryStats.MemTotal == 0 {
+		return 0
+	}
+	return float64(s.MemUsedKb()) / float64(s.MemoryStats.MemTotal) * 100
 }
 
 func (s *Stats) DiskTotal() uint64 {
@@ -41,58 +49,18 @@ func (s *Stats) DiskUsed() uint64 {
 	return s.DiskStats.Used
 }
 
-func (s *Stats) CpuUsage() float64 {
+// Get the CPU usage ratio, between 0 and 1
+//
+// ErrCpuStatsUnavailable is returned when the CPU stats couldn't be read, which must not be mistaken for an idle CPU
+func (s *Stats) CpuUsage() (float64, error) {
+	if s.CpuStats == nil {
+		return 0, ErrCpuStatsUnavailable
+	}
 	idle := s.CpuStats.Idle + s.CpuStats.IOWait
 	active := s.CpuStats.User + s.CpuStats.Nice + s.CpuStats.System + s.CpuStats.IRQ + s.CpuStats.SoftIRQ + s.CpuStats.Steal
 	total := idle + active
 	if total == 0 {
-		return 0
-	}
-	return (float64(total) - float64(idle)) / float64(total)
-}
-
-// Get the machine stats
-func GetStats() *Stats {
-	return &Stats{
-		MemoryStats: getMemoryInfo(),
-		DiskStats:   getDiskInfo(),
-		CpuStats:    getCpuStats(),
-		LoadStats:   getLoadAvg(),
-	}
-}
-
-func getMemoryInfo() *linux.MemInfo {
-	memstats, err := linux.ReadMemInfo("/proc/meminfo")
-	if err != nil {
-		log.Err(err).Msg("error reading from /proc/meminfo")
-		return &linux.MemInfo{}
-	}
-	return memstats
-}
-
-func getDiskInfo() *linux.Disk {
-	diskstats, err := linux.ReadDisk("/")
-	if err != nil {
-		log.Err(err).Msg("error reading from /")
-		return &linux.Disk{}
-	}
-	return diskstats
-}
-
-func getCpuStats() *linux.CPUStat {
-	stats, err := linux.ReadStat("/proc/stat")
-	if err != nil {
-		log.Err(err).Msg("error reading from /proc/stat")
-		return &linux.CPUStat{}
-	}
-	return &stats.CPUStatAll
-}
-
-func getLoadAvg() *linux.LoadAvg {
-	loadavg, err := linux.ReadLoadAvg("/proc/loadavg")
-	if err != nil {
-		log.Err(err).Msg("error reading from /proc/loadavg")
-		return &linux.LoadAvg{}
+		return 0, ErrCpuStatsUnavailable
 	}
-	return loadavg
+	return (float64(total) - float64(idle)) / float64(total), nil
 }
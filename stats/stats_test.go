@@ -0,0 +1,46 @@
+package stats
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/c9s/goprocinfo/linux"
+)
+
+func TestCpuUsageUnavailable(t *testing.T) {
+	for name, s := range map[string]Stats{
+		"missing": {},
+		"empty":   {CpuStats: &linux.CPUStat{}},
+	} {
+		if _, err := s.CpuUsage(); !errors.Is(err, ErrCpuStatsUnavailable) {
+			t.Errorf("%s CPU stats: expected ErrCpuStatsUnavailable, got %v", name, err)
+		}
+	}
+}
+
+func TestCpuUsage(t *testing.T) {
+	s := Stats{CpuStats: &linux.CPUStat{User: 20, System: 10, Idle: 60, IOWait: 10}}
+	usage, err := s.CpuUsage()
+	if err != nil {
+		t.Fatalf("failed to compute CPU usage: %v", err)
+	}
+	if usage != 0.3 {
+		t.Errorf("expected a CPU usage of 0.3, got %f", usage)
+	}
+}
+
+func TestMemUsedPercent(t *testing.T) {
+	s := Stats{MemoryStats: &linux.MemInfo{MemTotal: 16000000, MemAvailable: 4000000}}
+	if percent := s.MemUsedPercent(); percent != 75 {
+		t.Errorf("expected 75%% of used memory, got %f", percent)
+	}
+
+	for name, s := range map[string]Stats{
+		"missing": {},
+		"empty":   {MemoryStats: &linux.MemInfo{}},
+	} {
+		if percent := s.MemUsedPercent(); percent != 0 {
+			t.Errorf("%s memory stats: expected 0%% of used memory, got %f", name, percent)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package store
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Store keeping the list of its values in memory for a short duration
+//
+// The cached list is dropped on each write, so that it never returns outdated values written through this store
+type CachedStore[TKey comparable, TVal any] struct {
+	Store[TKey, TVal]
+	ttl time.Duration
+
+	mu         sync.Mutex
+	list       []TVal
+	expiration time.Time
+	generation uint64 // Incremented on each write, so that a list read during a write isn't cached
+}
+
+// Wrap the given store, its values list is cached during the given duration
+func NewCachedStore[TKey comparable, TVal any](store Store[TKey, TVal], ttl time.Duration) *CachedStore[TKey, TVal] {
+	return &CachedStore[TKey, TVal]{Store: store, ttl: ttl}
+}
+
+func (s *CachedStore[TKey, TVal]) List() ([]TVal, error) {
+	s.mu.Lock()
+	if s.list != nil && time.Now().Before(s.expiration) {
+		list := make([]TVal, len(s.list))
+		copy(list, s.list)
+		s.mu.Unlock()
+		return list, nil
+	}
+	generation := s.generation
+	s.mu.Unlock()
+
+	list, err := s.Store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.generation == generation {
+		s.list = make([]TVal, len(list))
+		copy(s.list, list)
+		s.expiration = time.Now().Add(s.ttl)
+	}
+	return list, nil
+}
+
+func (s *CachedStore[TKey, TVal]) Put(key TKey, value TVal) error {
+	defer s.invalidate()
+	return s.Store.Put(key, value)
+}
+
+func (s *CachedStore[TKey, TVal]) PutBatch(items map[TKey]TVal) error {
+	defer s.invalidate()
+	return s.Store.PutBatch(items)
+}
+
+func (s *CachedStore[TKey, TVal]) Delete(key TKey) error {
+	defer s.invalidate()
+	return s.Store.Delete(key)
+}
+
+// Write a backup of the wrapped store database, ErrSnapshotUnsupported is returned if it isn't a Snapshotter
+func (s *CachedStore[TKey, TVal]) Snapshot(w io.Writer) error {
+	snapshotter, ok := s.Store.(Snapshotter)
+	if !ok {
+		return ErrSnapshotUnsupported
+	}
+	return snapshotter.Snapshot(w)
+}
+
+// Drop the cached values list
+func (s *CachedStore[TKey, TVal]) invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.list = nil
+	s.generation++
+}
@@ -0,0 +1,68 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+// Store counting the values lists read from it
+type countingStore[TKey comparable, TVal any] struct {
+	Store[TKey, TVal]
+	lists int
+}
+
+func (s *countingStore[TKey, TVal]) List() ([]TVal, error) {
+	s.lists++
+	return s.Store.List()
+}
+
+func TestCachedStoreServesListWithinTtl(t *testing.T) {
+	counting := &countingStore[int, string]{Store: NewMemoryStore[int, string]()}
+	s := NewCachedStore[int, string](counting, time.Hour)
+	s.Put(1, "web")
+
+	for i := 0; i < 3; i++ {
+		list, err := s.List()
+		if err != nil {
+			t.Fatalf("failed to list values: %v", err)
+		}
+		if len(list) != 1 || list[0] != "web" {
+			t.Fatalf("expected the stored value to be listed, got %v", list)
+		}
+		list[0] = "modified"
+	}
+	if counting.lists != 1 {
+		t.Errorf("expected a single store read within the ttl, got %d", counting.lists)
+	}
+}
+
+func TestCachedStoreInvalidatedOnWrite(t *testing.T) {
+	counting := &countingStore[int, string]{Store: NewMemoryStore[int, string]()}
+	s := NewCachedStore[int, string](counting, time.Hour)
+	s.Put(1, "web")
+	s.List()
+
+	s.Put(2, "db")
+	if list, _ := s.List(); len(list) != 2 {
+		t.Errorf("expected the put value to be listed, got %v", list)
+	}
+	s.Delete(1)
+	if list, _ := s.List(); len(list) != 1 || list[0] != "db" {
+		t.Errorf("expected the deleted value not to be listed, got %v", list)
+	}
+	if counting.lists != 3 {
+		t.Errorf("expected a store read after each write, got %d reads", counting.lists)
+	}
+}
+
+func TestCachedStoreExpires(t *testing.T) {
+	counting := &countingStore[int, string]{Store: NewMemoryStore[int, string]()}
+	s := NewCachedStore[int, string](counting, time.Millisecond)
+	s.List()
+
+	time.Sleep(5 * time.Millisecond)
+	s.List()
+	if counting.lists != 2 {
+		t.Errorf("expected the expired list to be read again, got %d reads", counting.lists)
+	}
+}
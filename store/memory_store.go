@@ -1,14 +1,28 @@
 package store
 
+import (
+	"context"
+	"sync"
+)
+
+// Store keeping the values in memory, safe for concurrent use
 type MemoryStore[TKey comparable, TVal any] struct {
-	Db map[TKey]TVal
+	Db      map[TKey]TVal // Guarded by mu
+	mu      sync.RWMutex
+	changes broadcaster[TKey, TVal]
 }
 
 func NewMemoryStore[TKey comparable, TVal any]() *MemoryStore[TKey, TVal] {
-	return &MemoryStore[TKey, TVal]{map[TKey]TVal{}}
+	return &MemoryStore[TKey, TVal]{Db: map[TKey]TVal{}}
+}
+
+func (s *MemoryStore[TKey, TVal]) Watch(ctx context.Context) (<-chan ChangeEvent[TKey, TVal], error) {
+	return s.changes.subscribe(ctx), nil
 }
 
 func (s *MemoryStore[TKey, TVal]) List() ([]TVal, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	tasks := make([]TVal, len(s.Db))
 	i := 0
 	for _, storedTask := range s.Db {
@@ -18,11 +32,26 @@ func (s *MemoryStore[TKey, TVal]) List() ([]TVal, error) {
 	return tasks, nil
 }
 
+// Call the function on a copy of the values, so that the function can write to the store
+func (s *MemoryStore[TKey, TVal]) ForEach(fn func(value TVal) error) error {
+	values, _ := s.List()
+	for _, storedTask := range values {
+		if err := fn(storedTask); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (s *MemoryStore[TKey, TVal]) Count() (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	return len(s.Db), nil
 }
 
 func (s *MemoryStore[TKey, TVal]) Get(key TKey) (TVal, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	storedTask, found := s.Db[key]
 	if !found {
 		var defaultVal TVal
@@ -32,7 +61,43 @@ func (s *MemoryStore[TKey, TVal]) Get(key TKey) (TVal, error) {
 }
 
 func (s *MemoryStore[TKey, TVal]) Put(key TKey, value TVal) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.Db[key] = value
+	s.changes.publish(ChangeEvent[TKey, TVal]{Type: ChangePut, Key: key, Value: value})
+	return nil
+}
+
+func (s *MemoryStore[TKey, TVal]) GetBatch(keys []TKey) (map[TKey]TVal, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	values := make(map[TKey]TVal, len(keys))
+	for _, key := range keys {
+		if value, found := s.Db[key]; found {
+			values[key] = value
+		}
+	}
+	return values, nil
+}
+
+func (s *MemoryStore[TKey, TVal]) PutBatch(items map[TKey]TVal) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, value := range items {
+		s.Db[key] = value
+		s.changes.publish(ChangeEvent[TKey, TVal]{Type: ChangePut, Key: key, Value: value})
+	}
+	return nil
+}
+
+func (s *MemoryStore[TKey, TVal]) Delete(key TKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, found := s.Db[key]; !found {
+		return ErrKeyNotFound
+	}
+	delete(s.Db, key)
+	s.changes.publish(ChangeEvent[TKey, TVal]{Type: ChangeDelete, Key: key})
 	return nil
 }
 
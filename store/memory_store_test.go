@@ -0,0 +1,82 @@
+package store
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestMemoryStoreDelete(t *testing.T) {
+	s := NewMemoryStore[uuid.UUID, record]()
+	key := uuid.New()
+	s.Put(key, record{Name: "web"})
+
+	if err := s.Delete(key); err != nil {
+		t.Fatalf("failed to delete present key: %v", err)
+	}
+	if _, err := s.Get(key); err != ErrKeyNotFound {
+		t.Errorf("expected the deleted key to be absent, got %v", err)
+	}
+	if err := s.Delete(key); err != ErrKeyNotFound {
+		t.Errorf("expected ErrKeyNotFound deleting an absent key, got %v", err)
+	}
+}
+
+func TestMemoryStoreConcurrentWrites(t *testing.T) {
+	s := NewMemoryStore[int, string]()
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s.Put(i, fmt.Sprint(i))
+			s.Put(i+100, fmt.Sprint(i))
+			s.Get(i)
+			s.List()
+			s.Delete(i + 100)
+		}(i)
+	}
+	wg.Wait()
+
+	count, err := s.Count()
+	if err != nil {
+		t.Fatalf("failed to count values: %v", err)
+	}
+	if count != 50 {
+		t.Errorf("expected 50 values, got %d", count)
+	}
+}
+
+func TestMemoryStoreForEachCanWrite(t *testing.T) {
+	s := NewMemoryStore[int, int]()
+	s.Put(1, 1)
+	s.Put(2, 2)
+
+	err := s.ForEach(func(value int) error {
+		return s.Put(value, value*10)
+	})
+	if err != nil {
+		t.Fatalf("failed to iterate values: %v", err)
+	}
+	if value, _ := s.Get(2); value != 20 {
+		t.Errorf("expected value 20, got %d", value)
+	}
+}
+
+func TestMemoryStoreBatches(t *testing.T) {
+	s := NewMemoryStore[int, string]()
+	s.Put(1, "web")
+	if err := s.PutBatch(map[int]string{1: "proxy", 2: "db"}); err != nil {
+		t.Fatalf("failed to put batch: %v", err)
+	}
+
+	values, err := s.GetBatch([]int{1, 2, 3})
+	if err != nil {
+		t.Fatalf("failed to get batch: %v", err)
+	}
+	if len(values) != 2 || values[1] != "proxy" || values[2] != "db" {
+		t.Errorf("expected the written values without the absent key, got %v", values)
+	}
+}
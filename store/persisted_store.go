@@ -2,26 +2,54 @@ package store
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/fs"
+	"os"
+	"time"
 
 	bolt "go.etcd.io/bbolt"
 )
 
-type PersistedStore[TKey fmt.Stringer, TVal any] struct {
+// Name of the bucket recording the schema version of each store of a database file
+const metaBucketName = "meta"
+
+// Schema version of the records written before versioning was introduced, they are stored without version prefix
+const InitialSchemaVersion byte = 1
+
+// Upgrade the JSON representation of a record to the next schema version
+type Migration func(data []byte) ([]byte, error)
+
+type PersistedStore[TKey StringKey, TVal any] struct {
 	Db         *bolt.DB
 	BucketName string
+	Version    byte               // Schema version of the written records
+	Migrations map[byte]Migration // Upgrades applied on read to older records, by source version
 }
 
-func NewPersistedStore[TKey fmt.Stringer, TVal any](file string, mode fs.FileMode, storeName string) (*PersistedStore[TKey, TVal], error) {
+func NewPersistedStore[TKey StringKey, TVal any](file string, mode fs.FileMode, storeName string) (*PersistedStore[TKey, TVal], error) {
 	db, err := bolt.Open(file, mode, nil)
 	if err != nil {
 		return nil, err
 	}
+	return NewPersistedStoreFromDb[TKey, TVal](db, storeName)
+}
 
-	err = db.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists([]byte(storeName))
-		return err
+// Create a store in a new bucket of an already opened database, closing any of the stores sharing it closes the database
+func NewPersistedStoreFromDb[TKey StringKey, TVal any](db *bolt.DB, storeName string) (*PersistedStore[TKey, TVal], error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists([]byte(storeName)); err != nil {
+			return err
+		}
+		meta, err := tx.CreateBucketIfNotExists([]byte(metaBucketName))
+		if err != nil {
+			return err
+		}
+		if meta.Get([]byte(storeName)) == nil {
+			return meta.Put([]byte(storeName), []byte{InitialSchemaVersion})
+		}
+		return nil
 	})
 	if err != nil {
 		return nil, err
@@ -30,9 +58,98 @@ func NewPersistedStore[TKey fmt.Stringer, TVal any](file string, mode fs.FileMod
 	return &PersistedStore[TKey, TVal]{
 		Db:         db,
 		BucketName: storeName,
+		Version:    InitialSchemaVersion,
 	}, err
 }
 
+// Set the current schema version of the records and the migrations upgrading older records
+//
+// The version is recorded in the meta bucket, an error is returned if the store was written with a newer version
+func (s *PersistedStore[TKey, TVal]) SetSchema(version byte, migrations map[byte]Migration) error {
+	if version < InitialSchemaVersion || version >= ' ' {
+		return fmt.Errorf("invalid schema version %d", version)
+	}
+	for v := InitialSchemaVersion; v < version; v++ {
+		if migrations[v] == nil {
+			return fmt.Errorf("missing migration from schema version %d", v)
+		}
+	}
+
+	err := s.Db.Update(func(tx *bolt.Tx) error {
+		meta := tx.Bucket([]byte(metaBucketName))
+		if meta == nil {
+			return fmt.Errorf("bucket with name %s doesn't exist", metaBucketName)
+		}
+		if stored := meta.Get([]byte(s.BucketName)); len(stored) == 1 && stored[0] > version {
+			return fmt.Errorf("store %s schema version %d is newer than the supported version %d", s.BucketName, stored[0], version)
+		}
+		return meta.Put([]byte(s.BucketName), []byte{version})
+	})
+	if err != nil {
+		return err
+	}
+
+	s.Version = version
+	s.Migrations = migrations
+	return nil
+}
+
+// Get the schema version recorded for the store
+func (s *PersistedStore[TKey, TVal]) SchemaVersion() (byte, error) {
+	var version byte
+	err := s.Db.View(func(tx *bolt.Tx) error {
+		meta := tx.Bucket([]byte(metaBucketName))
+		if meta == nil {
+			return fmt.Errorf("bucket with name %s doesn't exist", metaBucketName)
+		}
+		stored := meta.Get([]byte(s.BucketName))
+		if len(stored) != 1 {
+			return fmt.Errorf("no schema version recorded for store %s", s.BucketName)
+		}
+		version = stored[0]
+		return nil
+	})
+	return version, err
+}
+
+// Serialize a value as its JSON representation prefixed with the schema version
+func (s *PersistedStore[TKey, TVal]) encode(value TVal) ([]byte, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{s.Version}, data...), nil
+}
+
+// Deserialize a record, upgrading it to the current schema version when it is older
+//
+// JSON representations never start with a control character, so records without
+// a version prefix are identified as written with the initial schema version
+func (s *PersistedStore[TKey, TVal]) decode(record []byte) (TVal, error) {
+	var value TVal
+	version, data := InitialSchemaVersion, record
+	if len(record) > 0 && record[0] < ' ' {
+		version, data = record[0], record[1:]
+	}
+	if version > s.Version {
+		return value, fmt.Errorf("record schema version %d is newer than the supported version %d", version, s.Version)
+	}
+
+	for ; version < s.Version; version++ {
+		migrate := s.Migrations[version]
+		if migrate == nil {
+			return value, fmt.Errorf("missing migration from schema version %d", version)
+		}
+		var err error
+		if data, err = migrate(data); err != nil {
+			return value, fmt.Errorf("migration from schema version %d failed: %w", version, err)
+		}
+	}
+
+	err := json.Unmarshal(data, &value)
+	return value, err
+}
+
 func (s *PersistedStore[TKey, TVal]) List() ([]TVal, error) {
 	items := []TVal{}
 	err := s.Db.View(func(tx *bolt.Tx) error {
@@ -42,19 +159,35 @@ func (s *PersistedStore[TKey, TVal]) List() ([]TVal, error) {
 		}
 
 		cur := b.Cursor()
-		var err error
-		for key, jsonVal := cur.First(); key != nil; _, jsonVal = cur.Next() {
-			var value TVal
-			err = json.Unmarshal(jsonVal, &value)
+		for key, jsonVal := cur.First(); key != nil; key, jsonVal = cur.Next() {
+			value, err := s.decode(jsonVal)
 			if err != nil {
-				items = append(items, value)
+				return fmt.Errorf("failed to decode value of key %s: %w", key, err)
 			}
+			items = append(items, value)
 		}
-		return err
+		return nil
 	})
 	return items, err
 }
 
+func (s *PersistedStore[TKey, TVal]) ForEach(fn func(value TVal) error) error {
+	return s.Db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(s.BucketName))
+		if b == nil {
+			return fmt.Errorf("bucket with name %s doesn't exist", s.BucketName)
+		}
+
+		return b.ForEach(func(_, jsonVal []byte) error {
+			value, err := s.decode(jsonVal)
+			if err != nil {
+				return err
+			}
+			return fn(value)
+		})
+	})
+}
+
 func (s *PersistedStore[TKey, TVal]) Count() (int, error) {
 	var count int
 	err := s.Db.View(func(tx *bolt.Tx) error {
@@ -81,7 +214,9 @@ func (s *PersistedStore[TKey, TVal]) Get(key TKey) (TVal, error) {
 			return ErrKeyNotFound
 		}
 
-		return json.Unmarshal(jsonVal, &value)
+		var err error
+		value, err = s.decode(jsonVal)
+		return err
 	})
 	return value, err
 }
@@ -93,7 +228,7 @@ func (s *PersistedStore[TKey, TVal]) Put(key TKey, value TVal) error {
 			return fmt.Errorf("bucket with name %s doesn't exist", s.BucketName)
 		}
 
-		jsonVal, err := json.Marshal(value)
+		jsonVal, err := s.encode(value)
 		if err != nil {
 			return err
 		}
@@ -103,6 +238,132 @@ func (s *PersistedStore[TKey, TVal]) Put(key TKey, value TVal) error {
 	return err
 }
 
+func (s *PersistedStore[TKey, TVal]) GetBatch(keys []TKey) (map[TKey]TVal, error) {
+	values := make(map[TKey]TVal, len(keys))
+	err := s.Db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(s.BucketName))
+		if b == nil {
+			return fmt.Errorf("bucket with name %s doesn't exist", s.BucketName)
+		}
+
+		for _, key := range keys {
+			jsonVal := b.Get([]byte(key.String()))
+			if jsonVal == nil {
+				continue
+			}
+			value, err := s.decode(jsonVal)
+			if err != nil {
+				return fmt.Errorf("failed to decode value of key %s: %w", key, err)
+			}
+			values[key] = value
+		}
+		return nil
+	})
+	return values, err
+}
+
+// Write all the values in a single transaction, which is much faster than a transaction per value
+func (s *PersistedStore[TKey, TVal]) PutBatch(items map[TKey]TVal) error {
+	return s.Db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(s.BucketName))
+		if b == nil {
+			return fmt.Errorf("bucket with name %s doesn't exist", s.BucketName)
+		}
+
+		for key, value := range items {
+			jsonVal, err := s.encode(value)
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(key.String()), jsonVal); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *PersistedStore[TKey, TVal]) Delete(key TKey) error {
+	err := s.Db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(s.BucketName))
+		if b == nil {
+			return fmt.Errorf("bucket with name %s doesn't exist", s.BucketName)
+		}
+
+		k := []byte(key.String())
+		if b.Get(k) == nil {
+			return ErrKeyNotFound
+		}
+		return b.Delete(k)
+	})
+	return err
+}
+
+// Write a copy of the whole database file, consistent with the writes made before the call
+//
+// The stores are written to during the copy, which can be restored with RestoreFrom
+func (s *PersistedStore[TKey, TVal]) Snapshot(w io.Writer) error {
+	return s.Db.View(func(tx *bolt.Tx) error {
+		_, err := tx.WriteTo(w)
+		return err
+	})
+}
+
+// Replace the values of the store by the ones of its bucket in a database file written by Snapshot
+//
+// The values are replaced in a single transaction, they are left untouched when an error is returned
+func (s *PersistedStore[TKey, TVal]) RestoreFrom(r io.Reader) error {
+	file, err := os.CreateTemp("", "restore-*.db")
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot file: %w", err)
+	}
+	defer os.Remove(file.Name())
+	_, err = io.Copy(file, r)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write snapshot file: %w", err)
+	}
+
+	snapshot, err := bolt.Open(file.Name(), 0600, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer snapshot.Close()
+
+	return snapshot.View(func(src *bolt.Tx) error {
+		source := src.Bucket([]byte(s.BucketName))
+		if source == nil {
+			return fmt.Errorf("snapshot has no bucket with name %s", s.BucketName)
+		}
+		if meta := src.Bucket([]byte(metaBucketName)); meta != nil {
+			if stored := meta.Get([]byte(s.BucketName)); len(stored) == 1 && stored[0] > s.Version {
+				return fmt.Errorf("snapshot schema version %d is newer than the supported version %d", stored[0], s.Version)
+			}
+		}
+
+		return s.Db.Update(func(tx *bolt.Tx) error {
+			if err := tx.DeleteBucket([]byte(s.BucketName)); err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+				return err
+			}
+			b, err := tx.CreateBucket([]byte(s.BucketName))
+			if err != nil {
+				return err
+			}
+			if err := source.ForEach(b.Put); err != nil {
+				return err
+			}
+			// The restored records keep their version prefix and are upgraded on read
+			meta, err := tx.CreateBucketIfNotExists([]byte(metaBucketName))
+			if err != nil {
+				return err
+			}
+			return meta.Put([]byte(s.BucketName), []byte{s.Version})
+		})
+	})
+}
+
 func (s *PersistedStore[TKey, TVal]) Close() error {
 	return s.Db.Close()
 }
@@ -0,0 +1,279 @@
+package store
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/google/uuid"
+	bolt "go.etcd.io/bbolt"
+)
+
+type record struct {
+	Name  string
+	Count int
+}
+
+func newTestPersistedStore(t *testing.T, file string) *PersistedStore[uuid.UUID, record] {
+	t.Helper()
+	s, err := NewPersistedStore[uuid.UUID, record](filepath.Join(t.TempDir(), file), 0600, "records")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestPersistedStoreListReturnsAllValues(t *testing.T) {
+	s := newTestPersistedStore(t, "records.db")
+	for i, name := range []string{"web", "db", "cache"} {
+		if err := s.Put(uuid.New(), record{Name: name, Count: i}); err != nil {
+			t.Fatalf("failed to put value: %v", err)
+		}
+	}
+
+	values, err := s.List()
+	if err != nil {
+		t.Fatalf("failed to list values: %v", err)
+	}
+	var names []string
+	for _, value := range values {
+		names = append(names, value.Name)
+	}
+	sort.Strings(names)
+	if len(names) != 3 || names[0] != "cache" || names[1] != "db" || names[2] != "web" {
+		t.Errorf("expected the 3 stored values, got %v", names)
+	}
+}
+
+func TestPersistedStoreListFailsOnCorruptValue(t *testing.T) {
+	s := newTestPersistedStore(t, "records.db")
+	s.Put(uuid.New(), record{Name: "web"})
+	corrupt := uuid.New()
+	s.Db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(s.BucketName)).Put([]byte(corrupt.String()), []byte("{"))
+	})
+
+	if _, err := s.List(); err == nil {
+		t.Error("expected the corrupt value to fail the listing")
+	}
+}
+
+func TestPersistedStoreDelete(t *testing.T) {
+	s := newTestPersistedStore(t, "records.db")
+	key, kept := uuid.New(), uuid.New()
+	s.Put(key, record{Name: "web"})
+	s.Put(kept, record{Name: "db"})
+
+	if err := s.Delete(key); err != nil {
+		t.Fatalf("failed to delete present key: %v", err)
+	}
+	if _, err := s.Get(key); err != ErrKeyNotFound {
+		t.Errorf("expected the deleted key to be absent, got %v", err)
+	}
+	if err := s.Delete(key); err != ErrKeyNotFound {
+		t.Errorf("expected ErrKeyNotFound deleting an absent key, got %v", err)
+	}
+	if count, _ := s.Count(); count != 1 {
+		t.Errorf("expected the other value to be kept, got %d values", count)
+	}
+}
+
+// Record of the schema version 2, whose Name field was renamed to Title
+type recordV2 struct {
+	Title string
+	Count int
+}
+
+// Write a database file as the stores did before the schema versioning, without meta bucket nor version prefixes
+func writeUnversionedFile(t *testing.T, file string, bucket string, records map[uuid.UUID]string) {
+	t.Helper()
+	db, err := bolt.Open(file, 0600, nil)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte(bucket))
+		if err != nil {
+			return err
+		}
+		for key, record := range records {
+			if err := b.Put([]byte(key.String()), []byte(record)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to write records: %v", err)
+	}
+}
+
+func TestPersistedStoreMigratesOlderRecords(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "records.db")
+	key := uuid.New()
+	writeUnversionedFile(t, file, "records", map[uuid.UUID]string{key: `{"Name":"web","Count":3}`})
+
+	s, err := NewPersistedStore[uuid.UUID, recordV2](file, 0600, "records")
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	defer s.Close()
+	if version, _ := s.SchemaVersion(); version != InitialSchemaVersion {
+		t.Errorf("expected the unversioned file to be recorded with the initial schema version, got %d", version)
+	}
+	renameName := func(data []byte) ([]byte, error) {
+		return bytes.Replace(data, []byte(`"Name":`), []byte(`"Title":`), 1), nil
+	}
+	if err := s.SetSchema(2, map[byte]Migration{1: renameName}); err != nil {
+		t.Fatalf("failed to set schema: %v", err)
+	}
+
+	value, err := s.Get(key)
+	if err != nil {
+		t.Fatalf("failed to get migrated value: %v", err)
+	}
+	if value != (recordV2{Title: "web", Count: 3}) {
+		t.Errorf("unexpected migrated value %+v", value)
+	}
+	if values, _ := s.List(); len(values) != 1 || values[0] != value {
+		t.Errorf("expected the listed values to be migrated, got %+v", values)
+	}
+	if version, _ := s.SchemaVersion(); version != 2 {
+		t.Errorf("expected schema version 2 to be recorded, got %d", version)
+	}
+
+	// The records written with the new schema are read as is
+	s.Put(key, recordV2{Title: "api", Count: 1})
+	if value, _ := s.Get(key); value.Title != "api" {
+		t.Errorf("unexpected value %+v", value)
+	}
+	// The store can't be used with the previous schema anymore
+	if err := s.SetSchema(1, nil); err == nil {
+		t.Error("expected the downgrade of the schema to be rejected")
+	}
+	if err := s.SetSchema(3, map[byte]Migration{1: renameName}); err == nil {
+		t.Error("expected the schema without migration from version 2 to be rejected")
+	}
+}
+
+func TestPersistedStoreBatches(t *testing.T) {
+	s := newTestPersistedStore(t, "records.db")
+	items := map[uuid.UUID]record{uuid.New(): {Name: "web"}, uuid.New(): {Name: "db"}}
+	if err := s.PutBatch(items); err != nil {
+		t.Fatalf("failed to put batch: %v", err)
+	}
+
+	keys := []uuid.UUID{uuid.New()}
+	for key := range items {
+		keys = append(keys, key)
+	}
+	values, err := s.GetBatch(keys)
+	if err != nil {
+		t.Fatalf("failed to get batch: %v", err)
+	}
+	if !reflect.DeepEqual(values, items) {
+		t.Errorf("expected the stored values without the absent key, got %v", values)
+	}
+}
+
+// Compare a transaction per value with a single transaction for all the values
+func BenchmarkPersistedStorePut(b *testing.B) {
+	const count = 100
+	items := make(map[uuid.UUID]record, count)
+	for i := 0; i < count; i++ {
+		items[uuid.New()] = record{Name: "web", Count: i}
+	}
+
+	b.Run("Put", func(b *testing.B) {
+		s, err := NewPersistedStore[uuid.UUID, record](filepath.Join(b.TempDir(), "records.db"), 0600, "records")
+		if err != nil {
+			b.Fatalf("failed to create store: %v", err)
+		}
+		defer s.Close()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			for key, value := range items {
+				if err := s.Put(key, value); err != nil {
+					b.Fatalf("failed to put value: %v", err)
+				}
+			}
+		}
+	})
+	b.Run("PutBatch", func(b *testing.B) {
+		s, err := NewPersistedStore[uuid.UUID, record](filepath.Join(b.TempDir(), "records.db"), 0600, "records")
+		if err != nil {
+			b.Fatalf("failed to create store: %v", err)
+		}
+		defer s.Close()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := s.PutBatch(items); err != nil {
+				b.Fatalf("failed to put batch: %v", err)
+			}
+		}
+	})
+}
+
+func TestPersistedStoreSnapshotRestore(t *testing.T) {
+	source := newTestPersistedStore(t, "source.db")
+	values := map[uuid.UUID]record{
+		uuid.New(): {Name: "web", Count: 1},
+		uuid.New(): {Name: "db", Count: 2},
+	}
+	if err := source.PutBatch(values); err != nil {
+		t.Fatalf("failed to write values: %v", err)
+	}
+	var snapshot bytes.Buffer
+	if err := source.Snapshot(&snapshot); err != nil {
+		t.Fatalf("failed to write snapshot: %v", err)
+	}
+
+	target := newTestPersistedStore(t, "target.db")
+	stale := uuid.New()
+	target.Put(stale, record{Name: "stale"})
+	if err := target.RestoreFrom(&snapshot); err != nil {
+		t.Fatalf("failed to restore snapshot: %v", err)
+	}
+
+	if count, _ := target.Count(); count != len(values) {
+		t.Errorf("expected %d restored values, got %d", len(values), count)
+	}
+	for key, expected := range values {
+		value, err := target.Get(key)
+		if err != nil {
+			t.Fatalf("failed to get restored value: %v", err)
+		}
+		if value != expected {
+			t.Errorf("expected restored value %v, got %v", expected, value)
+		}
+	}
+	if _, err := target.Get(stale); err != ErrKeyNotFound {
+		t.Errorf("expected the values missing from the snapshot to be removed, got %v", err)
+	}
+}
+
+func TestPersistedStoreRestoreRejectsOtherBucket(t *testing.T) {
+	source, err := NewPersistedStore[uuid.UUID, record](filepath.Join(t.TempDir(), "source.db"), 0600, "others")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer source.Close()
+	var snapshot bytes.Buffer
+	if err := source.Snapshot(&snapshot); err != nil {
+		t.Fatalf("failed to write snapshot: %v", err)
+	}
+
+	target := newTestPersistedStore(t, "target.db")
+	key := uuid.New()
+	target.Put(key, record{Name: "kept"})
+	if err := target.RestoreFrom(&snapshot); err == nil {
+		t.Fatal("expected the restore of a snapshot without the store bucket to fail")
+	}
+	if _, err := target.Get(key); err != nil {
+		t.Errorf("expected the values to be left untouched, got %v", err)
+	}
+}
@@ -0,0 +1,177 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	_ "modernc.org/sqlite"
+)
+
+// Store persisted in a table of a SQLite database file, the values being stored as their JSON representation
+//
+// Each store has its own (key, value) table named after the store, so that the records can be queried with any
+// SQLite client
+type SqliteStore[TKey StringKey, TVal any] struct {
+	Db        *sql.DB
+	TableName string
+}
+
+func NewSqliteStore[TKey StringKey, TVal any](file string, storeName string) (*SqliteStore[TKey, TVal], error) {
+	// The write-ahead log lets the reads run along a write, and concurrent writes wait for the lock instead of failing
+	db, err := sql.Open("sqlite", fmt.Sprintf("file:%s?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)", file))
+	if err != nil {
+		return nil, err
+	}
+	s, err := NewSqliteStoreFromDb[TKey, TVal](db, storeName)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Create a store in a new table of an already opened database, closing any of the stores sharing it closes the database
+func NewSqliteStoreFromDb[TKey StringKey, TVal any](db *sql.DB, storeName string) (*SqliteStore[TKey, TVal], error) {
+	if storeName == "" {
+		return nil, errors.New("store name is required")
+	}
+	s := &SqliteStore[TKey, TVal]{Db: db, TableName: storeName}
+	if _, err := db.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (key TEXT PRIMARY KEY, value BLOB NOT NULL)", s.table())); err != nil {
+		return nil, fmt.Errorf("failed to create table %s: %w", storeName, err)
+	}
+	return s, nil
+}
+
+// Get the quoted table name, usable in a query whatever the store name
+func (s *SqliteStore[TKey, TVal]) table() string {
+	return `"` + strings.ReplaceAll(s.TableName, `"`, `""`) + `"`
+}
+
+func (s *SqliteStore[TKey, TVal]) List() ([]TVal, error) {
+	items := []TVal{}
+	err := s.ForEach(func(value TVal) error {
+		items = append(items, value)
+		return nil
+	})
+	return items, err
+}
+
+func (s *SqliteStore[TKey, TVal]) ForEach(fn func(value TVal) error) error {
+	rows, err := s.Db.Query(fmt.Sprintf("SELECT key, value FROM %s ORDER BY key", s.table()))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key string
+		var jsonVal []byte
+		if err := rows.Scan(&key, &jsonVal); err != nil {
+			return err
+		}
+		var value TVal
+		if err := json.Unmarshal(jsonVal, &value); err != nil {
+			return fmt.Errorf("failed to decode value of key %s: %w", key, err)
+		}
+		if err := fn(value); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (s *SqliteStore[TKey, TVal]) Count() (int, error) {
+	var count int
+	err := s.Db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s", s.table())).Scan(&count)
+	return count, err
+}
+
+func (s *SqliteStore[TKey, TVal]) Get(key TKey) (TVal, error) {
+	var value TVal
+	var jsonVal []byte
+	err := s.Db.QueryRow(fmt.Sprintf("SELECT value FROM %s WHERE key = ?", s.table()), key.String()).Scan(&jsonVal)
+	if errors.Is(err, sql.ErrNoRows) {
+		return value, ErrKeyNotFound
+	}
+	if err != nil {
+		return value, err
+	}
+	err = json.Unmarshal(jsonVal, &value)
+	return value, err
+}
+
+func (s *SqliteStore[TKey, TVal]) GetBatch(keys []TKey) (map[TKey]TVal, error) {
+	values := make(map[TKey]TVal, len(keys))
+	for _, key := range keys {
+		value, err := s.Get(key)
+		if errors.Is(err, ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get value of key %s: %w", key, err)
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
+// Query creating or updating the value of a key
+func (s *SqliteStore[TKey, TVal]) upsertQuery() string {
+	return fmt.Sprintf("INSERT INTO %s (key, value) VALUES (?, ?) ON CONFLICT (key) DO UPDATE SET value = excluded.value", s.table())
+}
+
+func (s *SqliteStore[TKey, TVal]) Put(key TKey, value TVal) error {
+	jsonVal, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	_, err = s.Db.Exec(s.upsertQuery(), key.String(), jsonVal)
+	return err
+}
+
+// Write all the values in a single transaction, which is much faster than a transaction per value
+func (s *SqliteStore[TKey, TVal]) PutBatch(items map[TKey]TVal) error {
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(s.upsertQuery())
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for key, value := range items {
+		jsonVal, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		if _, err := stmt.Exec(key.String(), jsonVal); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SqliteStore[TKey, TVal]) Delete(key TKey) error {
+	result, err := s.Db.Exec(fmt.Sprintf("DELETE FROM %s WHERE key = ?", s.table()), key.String())
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrKeyNotFound
+	}
+	return nil
+}
+
+func (s *SqliteStore[TKey, TVal]) Close() error {
+	return s.Db.Close()
+}
@@ -0,0 +1,128 @@
+package store
+
+import (
+	"database/sql"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func newTestSqliteStore(t *testing.T, file string, storeName string) *SqliteStore[uuid.UUID, record] {
+	t.Helper()
+	if !slices.Contains(sql.Drivers(), "sqlite") {
+		t.Skip("sqlite driver unavailable")
+	}
+	s, err := NewSqliteStore[uuid.UUID, record](filepath.Join(t.TempDir(), file), storeName)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestSqliteStorePutGet(t *testing.T) {
+	s := newTestSqliteStore(t, "records.db", "records")
+	key := uuid.New()
+	if err := s.Put(key, record{Name: "web", Count: 1}); err != nil {
+		t.Fatalf("failed to put value: %v", err)
+	}
+	if err := s.Put(key, record{Name: "web", Count: 2}); err != nil {
+		t.Fatalf("failed to replace value: %v", err)
+	}
+
+	value, err := s.Get(key)
+	if err != nil {
+		t.Fatalf("failed to get value: %v", err)
+	}
+	if value.Name != "web" || value.Count != 2 {
+		t.Errorf("expected the replaced value, got %+v", value)
+	}
+	if count, _ := s.Count(); count != 1 {
+		t.Errorf("expected a single value, got %d", count)
+	}
+	if _, err := s.Get(uuid.New()); err != ErrKeyNotFound {
+		t.Errorf("expected ErrKeyNotFound for an absent key, got %v", err)
+	}
+}
+
+func TestSqliteStoreListAndDelete(t *testing.T) {
+	s := newTestSqliteStore(t, "records.db", "records")
+	key, kept := uuid.New(), uuid.New()
+	s.Put(key, record{Name: "web"})
+	s.Put(kept, record{Name: "db"})
+
+	if err := s.Delete(key); err != nil {
+		t.Fatalf("failed to delete present key: %v", err)
+	}
+	if err := s.Delete(key); err != ErrKeyNotFound {
+		t.Errorf("expected ErrKeyNotFound deleting an absent key, got %v", err)
+	}
+	values, err := s.List()
+	if err != nil {
+		t.Fatalf("failed to list values: %v", err)
+	}
+	if len(values) != 1 || values[0].Name != "db" {
+		t.Errorf("expected only the kept value, got %+v", values)
+	}
+}
+
+func TestSqliteStoresShareDatabase(t *testing.T) {
+	s := newTestSqliteStore(t, "records.db", `odd "name"`)
+	other, err := NewSqliteStoreFromDb[uuid.UUID, record](s.Db, "others")
+	if err != nil {
+		t.Fatalf("failed to create second store: %v", err)
+	}
+	s.Put(uuid.New(), record{Name: "web"})
+
+	if count, _ := other.Count(); count != 0 {
+		t.Errorf("expected the stores tables to be separate, got %d values", count)
+	}
+	if _, err := NewSqliteStoreFromDb[uuid.UUID, record](s.Db, ""); err == nil {
+		t.Error("expected an empty store name to be rejected")
+	}
+}
+
+func TestSqliteStorePersistsValues(t *testing.T) {
+	if !slices.Contains(sql.Drivers(), "sqlite") {
+		t.Skip("sqlite driver unavailable")
+	}
+	file := filepath.Join(t.TempDir(), "records.db")
+	s, err := NewSqliteStore[uuid.UUID, record](file, "records")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	key := uuid.New()
+	s.Put(key, record{Name: "web"})
+	s.Close()
+
+	reopened, err := NewSqliteStore[uuid.UUID, record](file, "records")
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer reopened.Close()
+	if value, err := reopened.Get(key); err != nil || value.Name != "web" {
+		t.Errorf("expected the value to survive reopening, got %+v, %v", value, err)
+	}
+}
+
+func TestSqliteStoreBatches(t *testing.T) {
+	s := newTestSqliteStore(t, "records.db", "records")
+	items := map[uuid.UUID]record{uuid.New(): {Name: "web"}, uuid.New(): {Name: "db"}}
+	if err := s.PutBatch(items); err != nil {
+		t.Fatalf("failed to put batch: %v", err)
+	}
+
+	keys := []uuid.UUID{uuid.New()}
+	for key := range items {
+		keys = append(keys, key)
+	}
+	values, err := s.GetBatch(keys)
+	if err != nil {
+		t.Fatalf("failed to get batch: %v", err)
+	}
+	if len(values) != len(items) {
+		t.Errorf("expected the stored values without the absent key, got %v", values)
+	}
+}
@@ -1,14 +1,30 @@
 package store
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"io"
+)
 
-var ErrKeyNotFound = errors.New("key not found")
+var (
+	ErrKeyNotFound         = errors.New("key not found")
+	ErrSnapshotUnsupported = errors.New("store doesn't support snapshots")
+)
+
+// Key of the stores persisting the values by the string representation of their key
+type StringKey interface {
+	comparable
+	fmt.Stringer
+}
 
 // Generic Key/Value data store
-type Store[TKey, TVal any] interface {
+type Store[TKey comparable, TVal any] interface {
 	// Retrieve all stored values
 	List() ([]TVal, error)
 
+	// Call the given function for each stored value, stopping at the first error returned
+	ForEach(fn func(value TVal) error) error
+
 	// Count all stored values
 	Count() (int, error)
 
@@ -17,9 +33,28 @@ type Store[TKey, TVal any] interface {
 	// Check if error is store.ErrKeyNotFound to differentiate from technical errors
 	Get(key TKey) (TVal, error)
 
+	// Get the values associated with the given keys, by key
+	//
+	// The keys which don't exist are missing from the result instead of being an error
+	GetBatch(keys []TKey) (map[TKey]TVal, error)
+
 	// Create or update the value associated with the given key
 	Put(key TKey, value TVal) error
 
+	// Create or update the given values by key, all of them or none when an error is returned
+	PutBatch(items map[TKey]TVal) error
+
+	// Remove the value associated with the given key
+	//
+	// Returns store.ErrKeyNotFound if the key doesn't exist
+	Delete(key TKey) error
+
 	// Close the store
 	Close() error
 }
+
+// Store able to write a backup of its database while it is used, optionally implemented by the stores
+type Snapshotter interface {
+	// Write a consistent copy of the database
+	Snapshot(w io.Writer) error
+}
@@ -0,0 +1,138 @@
+package store
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// Number of change events buffered for a subscriber, a subscriber falling further behind is dropped
+const watchBufferSize = 64
+
+type ChangeType string
+
+const (
+	ChangePut    ChangeType = "put"
+	ChangeDelete ChangeType = "delete"
+)
+
+// Change of a stored value, the value is the zero value for a deletion
+type ChangeEvent[TKey, TVal any] struct {
+	Type  ChangeType
+	Key   TKey
+	Value TVal
+}
+
+// Store notifying the changes of its values, optionally implemented by the stores
+type Watcher[TKey, TVal any] interface {
+	// Subscribe to the changes written after the call, until the context is done
+	//
+	// The channel is closed when the context is done, or when the subscriber doesn't keep up with the changes, in
+	// which case the values should be listed again before watching them anew
+	Watch(ctx context.Context) (<-chan ChangeEvent[TKey, TVal], error)
+}
+
+// Registry of the change subscribers of a store, its zero value has no subscriber
+type broadcaster[TKey, TVal any] struct {
+	mu          sync.Mutex
+	subscribers map[chan ChangeEvent[TKey, TVal]]struct{}
+}
+
+func (b *broadcaster[TKey, TVal]) subscribe(ctx context.Context) <-chan ChangeEvent[TKey, TVal] {
+	ch := make(chan ChangeEvent[TKey, TVal], watchBufferSize)
+	b.mu.Lock()
+	if b.subscribers == nil {
+		b.subscribers = make(map[chan ChangeEvent[TKey, TVal]]struct{})
+	}
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		b.unsubscribe(ch)
+	}()
+	return ch
+}
+
+// Remove a subscriber and close its channel, unless it was already removed
+func (b *broadcaster[TKey, TVal]) unsubscribe(ch chan ChangeEvent[TKey, TVal]) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, found := b.subscribers[ch]; found {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// Send a change to all the subscribers without blocking, the ones whose buffer is full are dropped
+func (b *broadcaster[TKey, TVal]) publish(event ChangeEvent[TKey, TVal]) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Store notifying the changes written through it, for the stores which don't implement Watcher themselves
+//
+// The writes are serialized, so that the subscribers receive the changes in the order they were written
+type WatchedStore[TKey comparable, TVal any] struct {
+	Store[TKey, TVal]
+	mu      sync.Mutex
+	changes broadcaster[TKey, TVal]
+}
+
+// Wrap the given store, the changes written through the wrapper are notified to its subscribers
+func NewWatchedStore[TKey comparable, TVal any](store Store[TKey, TVal]) *WatchedStore[TKey, TVal] {
+	return &WatchedStore[TKey, TVal]{Store: store}
+}
+
+func (s *WatchedStore[TKey, TVal]) Watch(ctx context.Context) (<-chan ChangeEvent[TKey, TVal], error) {
+	return s.changes.subscribe(ctx), nil
+}
+
+func (s *WatchedStore[TKey, TVal]) Put(key TKey, value TVal) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.Store.Put(key, value); err != nil {
+		return err
+	}
+	s.changes.publish(ChangeEvent[TKey, TVal]{Type: ChangePut, Key: key, Value: value})
+	return nil
+}
+
+func (s *WatchedStore[TKey, TVal]) PutBatch(items map[TKey]TVal) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.Store.PutBatch(items); err != nil {
+		return err
+	}
+	for key, value := range items {
+		s.changes.publish(ChangeEvent[TKey, TVal]{Type: ChangePut, Key: key, Value: value})
+	}
+	return nil
+}
+
+func (s *WatchedStore[TKey, TVal]) Delete(key TKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.Store.Delete(key); err != nil {
+		return err
+	}
+	s.changes.publish(ChangeEvent[TKey, TVal]{Type: ChangeDelete, Key: key})
+	return nil
+}
+
+// Write a backup of the wrapped store database, ErrSnapshotUnsupported is returned if it isn't a Snapshotter
+func (s *WatchedStore[TKey, TVal]) Snapshot(w io.Writer) error {
+	snapshotter, ok := s.Store.(Snapshotter)
+	if !ok {
+		return ErrSnapshotUnsupported
+	}
+	return snapshotter.Snapshot(w)
+}
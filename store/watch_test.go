@@ -0,0 +1,146 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Read the next change of a subscription, failing the test if it doesn't come in time
+func nextChange[TKey, TVal any](t *testing.T, changes <-chan ChangeEvent[TKey, TVal]) ChangeEvent[TKey, TVal] {
+	t.Helper()
+	select {
+	case change, ok := <-changes:
+		if !ok {
+			t.Fatal("changes channel closed")
+		}
+		return change
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a change")
+	}
+	return ChangeEvent[TKey, TVal]{}
+}
+
+func TestMemoryStoreWatchReceivesChanges(t *testing.T) {
+	s := NewMemoryStore[uuid.UUID, record]()
+	ctx, cancel := context.WithCancel(context.Background())
+	changes, err := s.Watch(ctx)
+	if err != nil {
+		t.Fatalf("failed to watch store: %v", err)
+	}
+
+	keys := []uuid.UUID{uuid.New(), uuid.New(), uuid.New()}
+	for i, key := range keys {
+		s.Put(key, record{Name: "web", Count: i})
+	}
+	s.Delete(keys[0])
+	s.Delete(uuid.New()) // Absent keys aren't notified
+
+	for i, key := range keys {
+		change := nextChange(t, changes)
+		if change.Type != ChangePut || change.Key != key || change.Value.Count != i {
+			t.Errorf("expected the put of value %d, got %+v", i, change)
+		}
+	}
+	if change := nextChange(t, changes); change.Type != ChangeDelete || change.Key != keys[0] {
+		t.Errorf("expected the deletion of the first key, got %+v", change)
+	}
+
+	cancel()
+	select {
+	case _, ok := <-changes:
+		if ok {
+			t.Error("expected no other change")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("expected the channel to be closed once the context is done")
+	}
+}
+
+func TestWatchDropsSlowSubscriber(t *testing.T) {
+	s := NewWatchedStore[int, int](NewMemoryStore[int, int]())
+	changes, _ := s.Watch(context.Background())
+	for i := 0; i <= watchBufferSize; i++ {
+		s.Put(i, i)
+	}
+
+	received := 0
+	for range changes {
+		received++
+	}
+	if received != watchBufferSize {
+		t.Errorf("expected the buffered changes before the channel is closed, got %d", received)
+	}
+}
+
+// Store whose writes return after a delay depending on the value, so that concurrent writes return out of order
+type slowStore struct {
+	Store[int, int]
+}
+
+func (s slowStore) Put(key int, value int) error {
+	err := s.Store.Put(key, value)
+	time.Sleep(time.Duration(value%3) * time.Millisecond)
+	return err
+}
+
+func TestWatchedStoreNotifiesConcurrentWritesInOrder(t *testing.T) {
+	s := NewWatchedStore[int, int](slowStore{NewMemoryStore[int, int]()})
+	for round := 0; round < 5; round++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		changes, _ := s.Watch(ctx)
+
+		var wg sync.WaitGroup
+		for i := 0; i < watchBufferSize; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				s.Put(0, i)
+			}(i)
+		}
+		wg.Wait()
+
+		// The last change received is the value left in the store
+		var last ChangeEvent[int, int]
+		for i := 0; i < watchBufferSize; i++ {
+			last = nextChange(t, changes)
+		}
+		if stored, _ := s.Get(0); last.Value != stored {
+			t.Fatalf("expected the last change to be the stored value %d, got %d", stored, last.Value)
+		}
+		cancel()
+	}
+}
+
+func TestWatchedStoreNotifiesBatches(t *testing.T) {
+	s := NewWatchedStore[int, string](NewMemoryStore[int, string]())
+	changes, _ := s.Watch(context.Background())
+	s.PutBatch(map[int]string{1: "web", 2: "db"})
+
+	received := map[int]string{}
+	for i := 0; i < 2; i++ {
+		change := nextChange(t, changes)
+		received[change.Key] = change.Value
+	}
+	if received[1] != "web" || received[2] != "db" {
+		t.Errorf("expected a change per written value, got %v", received)
+	}
+}
+
+func TestWatchedStoreSnapshotsWrappedStore(t *testing.T) {
+	persisted := newTestPersistedStore(t, "records.db")
+	persisted.Put(uuid.New(), record{Name: "web"})
+	var snapshot bytes.Buffer
+	if err := NewWatchedStore[uuid.UUID, record](persisted).Snapshot(&snapshot); err != nil || snapshot.Len() == 0 {
+		t.Errorf("expected the wrapped store database to be written, got %v", err)
+	}
+
+	memory := NewWatchedStore[uuid.UUID, record](NewMemoryStore[uuid.UUID, record]())
+	if err := memory.Snapshot(&snapshot); err != ErrSnapshotUnsupported {
+		t.Errorf("expected ErrSnapshotUnsupported for a memory store, got %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package task
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// State of a task group deployment
+type GroupState string
+
+const (
+	GroupDeploying  GroupState = "deploying"   // The group tasks are being scheduled
+	GroupDeployed   GroupState = "deployed"    // All the group tasks were scheduled
+	GroupRolledBack GroupState = "rolled-back" // A task couldn't be scheduled, the other tasks were stopped
+	GroupStopped    GroupState = "stopped"     // The group was torn down
+)
+
+// Set of tasks deployed together: either all of them are scheduled, or none
+type TaskGroup struct {
+	Id      uuid.UUID
+	Name    string
+	TaskIds []uuid.UUID
+	State   GroupState
+	Error   string // Reason of the rollback
+}
+
+// Check the group tasks before their submission
+//
+// All the problems found are reported at once, the returned error is then a *ValidationError
+// whose fields are prefixed with the index of the task in the group
+func ValidateGroup(tasks []Task) error {
+	e := &ValidationError{}
+	if len(tasks) == 0 {
+		e.add("Tasks", "a group must contain at least one task")
+	}
+	names := make(map[string]bool, len(tasks))
+	for i, t := range tasks {
+		prefix := fmt.Sprintf("Tasks[%d].", i)
+		var taskErr *ValidationError
+		if errors.As(t.Validate(), &taskErr) {
+			for _, v := range taskErr.Violations {
+				e.add(prefix+v.Field, v.Message)
+			}
+		}
+		if t.Replicas != 0 {
+			e.add(prefix+"Replicas", "group tasks can't be replicated")
+		}
+		key := t.Namespace + "/" + t.Name
+		if names[key] {
+			e.add(prefix+"Name", fmt.Sprintf("task name %q is used twice in namespace %q", t.Name, t.Namespace))
+		}
+		names[key] = true
+	}
+	return e.errOrNil()
+}
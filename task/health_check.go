@@ -0,0 +1,47 @@
+package task
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// Command run periodically by Docker inside the container to check its health
+//
+// A task whose container becomes unhealthy is considered failed
+type HealthCheck struct {
+	Cmd      []string      // Command run inside the container, a zero exit code means healthy
+	Interval time.Duration // Time between two checks, the Docker default (30s) when 0
+	Retries  int           // Consecutive failures needed to consider the container unhealthy, the Docker default (3) when 0
+}
+
+// Verify that the health check can be applied to a container, a nil health check is valid and means the image default
+func ValidateHealthCheck(check *HealthCheck) error {
+	if check == nil {
+		return nil
+	}
+	if len(check.Cmd) == 0 {
+		return errors.New("health check command is required")
+	}
+	if check.Interval != 0 && check.Interval < time.Millisecond {
+		return fmt.Errorf("invalid health check interval %v: must be at least 1ms", check.Interval)
+	}
+	if check.Retries < 0 {
+		return fmt.Errorf("invalid health check retries %d: must be positive", check.Retries)
+	}
+	return nil
+}
+
+// Get the Docker health check configuration, nil to keep the image one
+func (check *HealthCheck) dockerConfig() *container.HealthConfig {
+	if check == nil {
+		return nil
+	}
+	return &container.HealthConfig{
+		Test:     append([]string{"CMD"}, check.Cmd...),
+		Interval: check.Interval,
+		Retries:  check.Retries,
+	}
+}
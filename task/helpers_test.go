@@ -0,0 +1,152 @@
+package task
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog"
+)
+
+func TestMain(m *testing.M) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+	os.Exit(m.Run())
+}
+
+// Container creation request received by the Docker daemon double
+type createRequest struct {
+	container.Config
+	HostConfig *container.HostConfig
+	Name       string `json:"-"`
+}
+
+// Docker daemon double recording the containers creation requests, rejecting duplicate names like Docker does
+type fakeDocker struct {
+	*httptest.Server
+
+	startDelay time.Duration // Duration of the containers start
+
+	mu          sync.Mutex
+	creates     []createRequest
+	removes     []string
+	pullAuths   []string // X-Registry-Auth headers of the images pulls, in pull order
+	stopQueries []string // Queries of the containers stops, in stop order
+}
+
+// Version prefix of the Docker API paths
+var apiVersionPrefix = regexp.MustCompile(`^/v[0-9.]+`)
+
+func newFakeDocker(t *testing.T) *fakeDocker {
+	t.Helper()
+	fd := &fakeDocker{}
+	router := chi.NewRouter()
+	router.Get("/_ping", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	})
+	router.Post("/images/create", func(w http.ResponseWriter, r *http.Request) {
+		fd.mu.Lock()
+		fd.pullAuths = append(fd.pullAuths, r.Header.Get("X-Registry-Auth"))
+		fd.mu.Unlock()
+		w.Write([]byte("{}"))
+	})
+	router.Post("/containers/create", func(w http.ResponseWriter, r *http.Request) {
+		var req createRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		req.Name = r.URL.Query().Get("name")
+		fd.mu.Lock()
+		for _, created := range fd.creates {
+			if req.Name != "" && created.Name == req.Name {
+				fd.mu.Unlock()
+				w.WriteHeader(http.StatusConflict)
+				json.NewEncoder(w).Encode(map[string]string{"message": "container name already in use"})
+				return
+			}
+		}
+		fd.creates = append(fd.creates, req)
+		fd.mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(container.CreateResponse{ID: "container-1", Warnings: []string{}})
+	})
+	router.Post("/containers/{id}/start", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(fd.startDelay):
+		case <-r.Context().Done():
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	router.Post("/containers/{id}/stop", func(w http.ResponseWriter, r *http.Request) {
+		fd.mu.Lock()
+		fd.stopQueries = append(fd.stopQueries, r.URL.RawQuery)
+		fd.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	})
+	router.Delete("/containers/{id}", func(w http.ResponseWriter, r *http.Request) {
+		fd.mu.Lock()
+		fd.removes = append(fd.removes, chi.URLParam(r, "id"))
+		fd.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	})
+	router.Get("/containers/{id}/logs", func(w http.ResponseWriter, r *http.Request) {})
+	fd.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.URL.Path = apiVersionPrefix.ReplaceAllString(r.URL.Path, "")
+		w.Header().Set("Content-Type", "application/json")
+		router.ServeHTTP(w, r)
+	}))
+	t.Cleanup(fd.Close)
+	return fd
+}
+
+// Create a container client connected to the Docker daemon double
+func (fd *fakeDocker) client(t *testing.T) *ContainerClient {
+	t.Helper()
+	c, err := client.NewClientWithOpts(client.WithHost("tcp://"+fd.Listener.Addr().String()), client.WithHTTPClient(fd.Server.Client()))
+	if err != nil {
+		t.Fatalf("failed to create docker client: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return &ContainerClient{c}
+}
+
+// Get the last container creation request, failing the test if there is none
+func (fd *fakeDocker) lastCreate(t *testing.T) createRequest {
+	t.Helper()
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+	if len(fd.creates) == 0 {
+		t.Fatal("no container created")
+	}
+	return fd.creates[len(fd.creates)-1]
+}
+
+// Get the X-Registry-Auth headers of the images pulls, in pull order
+func (fd *fakeDocker) pullAuthHeaders() []string {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+	return append([]string(nil), fd.pullAuths...)
+}
+
+// Get the queries of the containers stops, in stop order
+func (fd *fakeDocker) stops() []string {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+	return append([]string(nil), fd.stopQueries...)
+}
+
+// Get the ids of the removed containers
+func (fd *fakeDocker) removedContainers() []string {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+	return append([]string(nil), fd.removes...)
+}
@@ -0,0 +1,69 @@
+package task
+
+import (
+	"encoding/base64"
+	"errors"
+
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types/registry"
+)
+
+// Credentials of the private registry a task image is pulled from
+//
+// The credentials are only used to pull the image: workers never store them
+type RegistryAuth struct {
+	Username string
+	Password string
+	Token    string // Encoded X-Registry-Auth value (base64url encoded JSON), used instead of the username and password
+}
+
+// Hide the credentials when the value is printed
+func (a RegistryAuth) String() string {
+	return "[redacted]"
+}
+
+// Get the X-Registry-Auth value sent to the Docker daemon to pull an image from the given registry
+func (a *RegistryAuth) encode(serverAddress string) (string, error) {
+	if a == nil {
+		return "", nil
+	}
+	if a.Token != "" {
+		return a.Token, nil
+	}
+	return registry.EncodeAuthConfig(registry.AuthConfig{
+		Username:      a.Username,
+		Password:      a.Password,
+		ServerAddress: serverAddress,
+	})
+}
+
+// Verify that the credentials are either a username and password or a base64url encoded token
+//
+// Nil credentials are valid and mean an anonymous pull
+func ValidateRegistryAuth(auth *RegistryAuth) error {
+	if auth == nil {
+		return nil
+	}
+	if auth.Token != "" {
+		if auth.Username != "" || auth.Password != "" {
+			return errors.New("registry token can't be combined with a username and password")
+		}
+		if _, err := base64.URLEncoding.DecodeString(auth.Token); err != nil {
+			return errors.New("invalid registry token: must be base64url encoded")
+		}
+		return nil
+	}
+	if auth.Username == "" || auth.Password == "" {
+		return errors.New("registry username and password are required, unless a token is set")
+	}
+	return nil
+}
+
+// Get the host of the registry the image is pulled from, "docker.io" for the Docker Hub images
+func RegistryHost(image string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", err
+	}
+	return reference.Domain(named), nil
+}
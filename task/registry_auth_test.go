@@ -0,0 +1,106 @@
+package task
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/registry"
+	"github.com/google/uuid"
+)
+
+func TestRunSendsRegistryAuth(t *testing.T) {
+	fd := newFakeDocker(t)
+	c := fd.client(t)
+
+	tk := Task{Id: uuid.New(), Name: "web", Image: "registry.example.com/shop/web:1.0"}
+	conf := NewConfig(tk)
+	conf.RegistryAuth = &RegistryAuth{Username: "user", Password: "s3cr3t"}
+	if _, err := c.Run(context.Background(), conf); err != nil {
+		t.Fatalf("failed to run container: %v", err)
+	}
+
+	headers := fd.pullAuthHeaders()
+	if len(headers) != 1 || headers[0] == "" {
+		t.Fatalf("expected the pull to carry the registry credentials, got headers %q", headers)
+	}
+	auth, err := registry.DecodeAuthConfig(headers[0])
+	if err != nil {
+		t.Fatalf("failed to decode the registry credentials: %v", err)
+	}
+	if auth.Username != "user" || auth.Password != "s3cr3t" || auth.ServerAddress != "registry.example.com" {
+		t.Errorf("unexpected registry credentials %+v", auth)
+	}
+
+	// The pull is anonymous without credentials
+	tk.Id = uuid.New()
+	if _, err := c.Run(context.Background(), NewConfig(tk)); err != nil {
+		t.Fatalf("failed to run container: %v", err)
+	}
+	if headers := fd.pullAuthHeaders(); headers[1] != "" {
+		t.Errorf("expected an anonymous pull, got the header %q", headers[1])
+	}
+}
+
+func TestRegistryAuthTokenSentAsIs(t *testing.T) {
+	token, err := registry.EncodeAuthConfig(registry.AuthConfig{IdentityToken: "identity"})
+	if err != nil {
+		t.Fatalf("failed to encode token: %v", err)
+	}
+	auth := &RegistryAuth{Token: token}
+	if encoded, err := auth.encode("docker.io"); err != nil || encoded != token {
+		t.Errorf("expected the token to be sent as is, got %q and error %v", encoded, err)
+	}
+}
+
+func TestValidateRegistryAuth(t *testing.T) {
+	valid := []*RegistryAuth{nil, {Username: "user", Password: "secret"}, {Token: "eyJ1c2VybmFtZSI6InVzZXIifQ=="}}
+	for _, auth := range valid {
+		if err := ValidateRegistryAuth(auth); err != nil {
+			t.Errorf("expected the credentials to be valid, got %v", err)
+		}
+	}
+	invalid := []*RegistryAuth{{}, {Username: "user"}, {Token: "not base64!"}, {Token: "eyJ9", Username: "user", Password: "secret"}}
+	for i, auth := range invalid {
+		if err := ValidateRegistryAuth(auth); err == nil {
+			t.Errorf("expected the credentials %d to be rejected", i)
+		}
+	}
+}
+
+func TestRegistryAuthRedacted(t *testing.T) {
+	auth := &RegistryAuth{Username: "user", Password: "s3cr3t"}
+	tEvent := TaskEvent{Id: uuid.New(), Task: Task{Id: uuid.New(), RegistryAuth: auth}, RegistryAuth: auth}
+
+	if printed := fmt.Sprintf("%v %+v", *auth, tEvent); strings.Contains(printed, "s3cr3t") {
+		t.Errorf("expected the credentials to be redacted, got %s", printed)
+	}
+	// The task never encodes its credentials, the event only carries them until they are received
+	data, _ := json.Marshal(tEvent.Task)
+	if strings.Contains(string(data), "s3cr3t") {
+		t.Error("expected the task to be encoded without its credentials")
+	}
+	stored, _ := json.Marshal(tEvent.WithoutRegistryAuth())
+	if strings.Contains(string(stored), "s3cr3t") {
+		t.Error("expected the stored event to be encoded without credentials")
+	}
+
+	var received TaskEvent
+	data, _ = json.Marshal(tEvent)
+	json.Unmarshal(data, &received)
+	received.ReceiveRegistryAuth()
+	if received.RegistryAuth != nil || received.Task.RegistryAuth == nil || received.Task.RegistryAuth.Password != "s3cr3t" {
+		t.Errorf("expected the received credentials to be moved to the task, got %+v", received.Task.RegistryAuth)
+	}
+}
+
+func TestRegistryHost(t *testing.T) {
+	hosts := map[string]string{"nginx": "docker.io", "registry.example.com/shop/web:1.0": "registry.example.com", "localhost:5000/web": "localhost:5000"}
+	for image, expected := range hosts {
+		if host, err := RegistryHost(image); err != nil || host != expected {
+			t.Errorf("expected the registry host of %s to be %s, got %q and error %v", image, expected, host, err)
+		}
+	}
+}
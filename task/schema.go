@@ -0,0 +1,40 @@
+package task
+
+import (
+	"encoding/json"
+
+	"orchestrator/store"
+)
+
+// Schema version of the persisted tasks, increased along with a migration of the older records whenever a change of
+// the Task fields would make them misread
+const SchemaVersion byte = 2
+
+// Namespace of the tasks persisted before namespaces were introduced
+const DefaultNamespace = "default"
+
+// Upgrades of the persisted tasks records, by source schema version
+var Migrations = map[byte]store.Migration{
+	// The version 1 records may predate the namespaces, their names are then scoped to the default namespace
+	1: setDefaultNamespace,
+}
+
+// Set the default namespace on a task record which has none
+func setDefaultNamespace(data []byte) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	var namespace string
+	if raw, found := fields["Namespace"]; found {
+		if err := json.Unmarshal(raw, &namespace); err != nil {
+			return nil, err
+		}
+	}
+	if namespace != "" {
+		return data, nil
+	}
+
+	fields["Namespace"], _ = json.Marshal(DefaultNamespace)
+	return json.Marshal(fields)
+}
@@ -0,0 +1,37 @@
+package task
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMigrationSetsDefaultNamespace(t *testing.T) {
+	v1 := []byte(`{"Name":"web","Image":"nginx","Cpu":0.5}`)
+	data, err := Migrations[1](v1)
+	if err != nil {
+		t.Fatalf("migration failed: %v", err)
+	}
+	var migrated Task
+	if err := json.Unmarshal(data, &migrated); err != nil {
+		t.Fatalf("failed to decode migrated task: %v", err)
+	}
+	if migrated.Namespace != DefaultNamespace {
+		t.Errorf("expected the task to be moved to namespace %q, got %q", DefaultNamespace, migrated.Namespace)
+	}
+	if migrated.Name != "web" || migrated.Image != "nginx" || migrated.Cpu != 0.5 {
+		t.Errorf("unexpected migrated task %+v", migrated)
+	}
+}
+
+func TestMigrationKeepsNamespace(t *testing.T) {
+	v1 := []byte(`{"Name":"web","Namespace":"shop"}`)
+	data, err := Migrations[1](v1)
+	if err != nil {
+		t.Fatalf("migration failed: %v", err)
+	}
+	var migrated Task
+	json.Unmarshal(data, &migrated)
+	if migrated.Namespace != "shop" {
+		t.Errorf("expected the task namespace to be kept, got %q", migrated.Namespace)
+	}
+}
@@ -1,5 +1,7 @@
 package task
 
+import "fmt"
+
 // State of a task
 type State int
 
@@ -11,6 +13,23 @@ const (
 	Failed                 // The task execution failed
 )
 
+func (s State) String() string {
+	switch s {
+	case Pending:
+		return "pending"
+	case Scheduled:
+		return "scheduled"
+	case Running:
+		return "running"
+	case Completed:
+		return "completed"
+	case Failed:
+		return "failed"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(s))
+	}
+}
+
 // Allowed state transitions
 var stateTransitionMap = map[State][]State{
 	Pending:   {Scheduled},
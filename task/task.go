@@ -2,73 +2,428 @@ package task
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"io"
 	"math"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/docker/go-connections/nat"
+	"github.com/docker/go-units"
 	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
 )
 
 // Container specification with desired state
 type Task struct {
-	Id            uuid.UUID
-	Name          string
-	ContainerId   string
-	State         State
-	Image         string
-	Cpu           float64
-	Memory        int64
-	Disk          int64
-	ExposedPorts  nat.PortSet
-	PortBindings  map[string]string
-	RestartPolicy string
-	StartTime     time.Time
-	FinishTime    time.Time
-	RestartCount  int
+	Id                uuid.UUID
+	Name              string
+	Namespace         string // Project the task belongs to, its name is unique in this namespace
+	Hostname          string // Hostname of the container, the task name when empty and valid as a hostname
+	ContainerId       string
+	State             State
+	Image             string
+	PullPolicy        string        // When the image is pulled before starting the container, DefaultPullPolicy when empty
+	RegistryAuth      *RegistryAuth `json:"-"` // Credentials of the image private registry, the manager ones when nil. Never encoded, see TaskEvent.RegistryAuth
+	Cpu               float64
+	Memory            int64
+	Disk              int64
+	CpusetCpus        string
+	ReadonlyRootfs    bool              // Mount the container root filesystem as read only
+	Tmpfs             map[string]string // Writable tmpfs mounts by container path, with their mount options
+	Volumes           []string          // Bind mounts and named volumes, in the "docker run -v" syntax (e.g. "/host:/container:ro")
+	Env               []string          // Environment variables of the container, in the KEY=value format
+	Cmd               []string          // Command run by the container, overriding the image default command
+	ExposedPorts      nat.PortSet
+	PortBindings      map[string]string
+	RestartPolicy     string
+	StopSignal        string       // Signal sent to stop the container (e.g. "SIGINT"), the image default or SIGTERM when empty
+	StopTimeout       *int         // Seconds given to the container to exit after the stop signal before it is killed, the Docker default when nil
+	HealthCheck       *HealthCheck // Check of the container health, the image one is used when nil
+	CaptureOutput     bool
+	MaxLogSize        string // Maximum size of the container log file before it is rotated (e.g. "10m"), DefaultMaxLogSize when empty
+	MaxLogFiles       int    // Maximum number of container log files kept, DefaultMaxLogFiles when 0
+	Priority          int
+	Labels            map[string]string
+	AffinityTaskId    uuid.UUID         // Task to colocate this task with, on the same node
+	AffinityRequired  bool              // Fail the scheduling instead of using another node when colocation isn't possible
+	PreferredNode     string            // Node to use if it can run the task, this is only a hint for the scheduler
+	NodeSelector      map[string]string // Labels the node running the task must have
+	AntiAffinity      []string          // Names of the tasks of the same namespace which must not run on the same node
+	MaxRestarts       int               // Maximum number of restarts after a failure, the manager default is used when 0
+	Replicas          int               // Number of identical tasks to run, named "<name>-<index>", a single task is run when 0
+	ReplicaOf         string            // Name of the replicated task this task is a replica of, set by the manager
+	StartTime         time.Time
+	FinishTime        time.Time
+	RestartCount      int
+	ContainerRestarts int    // Restarts of the current container by the docker restart policy, already counted in RestartCount
+	Error             string // Reason of the last execution failure
 }
 
 // Task Submission event
 type TaskEvent struct {
-	Id        uuid.UUID
-	State     State
-	Timestamp time.Time
-	Task      Task
+	Id           uuid.UUID
+	State        State
+	Timestamp    time.Time
+	Task         Task
+	RegistryAuth *RegistryAuth // Credentials of the task image registry, only carried with the event sent to the manager or a worker
 }
 
+// Move the registry credentials carried by a received event to its task, where they are kept in memory only
+func (e *TaskEvent) ReceiveRegistryAuth() {
+	if e.RegistryAuth != nil {
+		e.Task.RegistryAuth = e.RegistryAuth
+	}
+	e.RegistryAuth = nil
+}
+
+// Get a copy of the event without any registry credentials, which can be stored
+func (e TaskEvent) WithoutRegistryAuth() TaskEvent {
+	e.RegistryAuth = nil
+	e.Task.RegistryAuth = nil
+	return e
+}
+
+// Default prefix of the containers names, followed by the task id
+const DefaultContainerPrefix = "orchestrator"
+
+// Labels set on the containers to identify their task
+const (
+	LabelTaskId        = "orchestrator.task-id"
+	LabelTaskName      = "orchestrator.task-name"
+	LabelTaskNamespace = "orchestrator.task-namespace"
+)
+
+// Default rotation settings of the containers logs
+const (
+	DefaultMaxLogSize  = "10m"
+	DefaultMaxLogFiles = 3
+)
+
+// Image pull policies
+const (
+	PullAlways       = "Always"       // Pull the image before each container creation
+	PullIfNotPresent = "IfNotPresent" // Only pull the image when it isn't already present on the node
+	PullNever        = "Never"        // Never pull the image, the task fails when it isn't present on the node
+)
+
+// Pull policy of the tasks which don't define one
+const DefaultPullPolicy = PullIfNotPresent
+
 // Container configuration
 type Config struct {
-	Name          string
-	ContainerId   string
-	Cmd           []string
-	Image         string
-	Cpu           float64
-	Memory        int64
-	Disk          int64
-	Env           []string
-	RestartPolicy string
-	ExposedPorts  nat.PortSet
-	PortBindings  map[string]string
+	Name           string
+	ContainerId    string
+	Hostname       string
+	Cmd            []string
+	Image          string
+	SkipPull       bool          // Use the local image instead of pulling it
+	RegistryAuth   *RegistryAuth // Credentials used to pull the image, anonymous pull when nil
+	Cpu            float64
+	Memory         int64
+	Disk           int64
+	CpusetCpus     string
+	ReadonlyRootfs bool
+	Tmpfs          map[string]string
+	Volumes        []string
+	Env            []string
+	RestartPolicy  string
+	StopSignal     string
+	StopTimeout    *int
+	HealthCheck    *HealthCheck
+	MaxLogSize     string
+	MaxLogFiles    int
+	ExposedPorts   nat.PortSet
+	PortBindings   map[string]string
+	Labels         map[string]string
 }
 
 // Create a Config object from a Task object
 func NewConfig(t Task) Config {
-	return Config{
-		Name:          t.Name,
-		ExposedPorts:  t.ExposedPorts,
-		PortBindings:  t.PortBindings,
-		Image:         t.Image,
-		Cpu:           t.Cpu,
-		Memory:        t.Memory,
-		Disk:          t.Disk,
-		RestartPolicy: t.RestartPolicy,
+	config := Config{
+		Name:           ContainerName(DefaultContainerPrefix, t.Id),
+		ExposedPorts:   t.ExposedPorts,
+		PortBindings:   t.PortBindings,
+		Image:          t.Image,
+		RegistryAuth:   t.RegistryAuth,
+		Cpu:            t.Cpu,
+		Memory:         t.Memory,
+		Disk:           t.Disk,
+		CpusetCpus:     t.CpusetCpus,
+		ReadonlyRootfs: t.ReadonlyRootfs,
+		Tmpfs:          t.Tmpfs,
+		Volumes:        t.Volumes,
+		Env:            t.Env,
+		Cmd:            t.Cmd,
+		RestartPolicy:  t.RestartPolicy,
+		StopSignal:     t.StopSignal,
+		StopTimeout:    t.StopTimeout,
+		HealthCheck:    t.HealthCheck,
+		MaxLogSize:     t.MaxLogSize,
+		MaxLogFiles:    t.MaxLogFiles,
+		Hostname:       t.Hostname,
+		Labels:         containerLabels(t),
+	}
+	if config.Hostname == "" && ValidateHostname(t.Name) == nil {
+		config.Hostname = t.Name
 	}
+	if config.MaxLogSize == "" {
+		config.MaxLogSize = DefaultMaxLogSize
+	}
+	if config.MaxLogFiles == 0 {
+		config.MaxLogFiles = DefaultMaxLogFiles
+	}
+	return config
+}
+
+// Get the labels of the task container: the task labels along with its identification labels
+func containerLabels(t Task) map[string]string {
+	labels := make(map[string]string, len(t.Labels)+3)
+	for k, v := range t.Labels {
+		labels[k] = v
+	}
+	labels[LabelTaskId] = t.Id.String()
+	labels[LabelTaskName] = t.Name
+	labels[LabelTaskNamespace] = t.Namespace
+	return labels
+}
+
+// Check if the task has all the given labels with the same values
+func (t *Task) MatchLabels(selector map[string]string) bool {
+	for k, v := range selector {
+		if value, found := t.Labels[k]; !found || value != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Parse label selectors in the "key=value" format
+//
+// All the selectors must match, a label given twice with different values is rejected since it can't match
+func ParseLabelSelector(selectors []string) (map[string]string, error) {
+	labels := make(map[string]string, len(selectors))
+	for _, selector := range selectors {
+		k, v, found := strings.Cut(selector, "=")
+		if !found || k == "" {
+			return nil, fmt.Errorf("invalid label selector %q, expected format: key=value", selector)
+		}
+		if existing, found := labels[k]; found && existing != v {
+			return nil, fmt.Errorf("conflicting label selectors %q and %q", k+"="+existing, selector)
+		}
+		labels[k] = v
+	}
+	return labels, nil
+}
+
+// Get the unique container name of a task
+func ContainerName(prefix string, taskId uuid.UUID) string {
+	return fmt.Sprintf("%s-%v", prefix, taskId)
+}
+
+// Check if the task is submitted to start tracking an existing container instead of creating a new one
+//
+// The container is identified by the task ContainerId and runs on the task PreferredNode
+func (t *Task) IsAdoption() bool {
+	return t.Image == "" && t.ContainerId != ""
+}
+
+// Verify that the task specification is valid
+//
+// All the problems found are reported at once, the returned error is then a *ValidationError
+func (t *Task) Validate() error {
+	e := &ValidationError{}
+	if t.Namespace == "" {
+		e.add("Namespace", "namespace is required")
+	}
+	if t.Image == "" {
+		if t.ContainerId == "" {
+			e.add("Image", "image is required, unless adopting an existing container")
+		} else if t.PreferredNode == "" {
+			e.add("PreferredNode", "the node running the adopted container is required")
+		}
+	}
+	if t.Cpu < 0 {
+		e.add("Cpu", fmt.Sprintf("invalid cpu %v: must be positive", t.Cpu))
+	}
+	if t.Memory < 0 {
+		e.add("Memory", fmt.Sprintf("invalid memory %d: must be positive", t.Memory))
+	}
+	if t.Disk < 0 {
+		e.add("Disk", fmt.Sprintf("invalid disk %d: must be positive", t.Disk))
+	}
+	e.addErr("CpusetCpus", ValidateCpuset(t.CpusetCpus))
+	e.addErr("RestartPolicy", ValidateRestartPolicy(t.RestartPolicy))
+	e.addErr("PullPolicy", ValidatePullPolicy(t.PullPolicy))
+	e.addErr("RegistryAuth", ValidateRegistryAuth(t.RegistryAuth))
+	e.addErr("StopSignal", ValidateStopSignal(t.StopSignal))
+	if t.StopTimeout != nil && *t.StopTimeout < 0 {
+		e.add("StopTimeout", fmt.Sprintf("invalid stop timeout %d: must be positive", *t.StopTimeout))
+	}
+	if t.Hostname != "" {
+		e.addErr("Hostname", ValidateHostname(t.Hostname))
+	}
+	e.addErr("HealthCheck", ValidateHealthCheck(t.HealthCheck))
+	if t.MaxLogSize != "" {
+		if size, err := units.RAMInBytes(t.MaxLogSize); err != nil || size <= 0 {
+			e.add("MaxLogSize", fmt.Sprintf("invalid max log size %q: must be a positive size such as \"10m\"", t.MaxLogSize))
+		}
+	}
+	if t.MaxLogFiles < 0 {
+		e.add("MaxLogFiles", fmt.Sprintf("invalid max log files %d: must be positive", t.MaxLogFiles))
+	}
+	if t.MaxRestarts < 0 {
+		e.add("MaxRestarts", fmt.Sprintf("invalid max restarts %d: must be positive", t.MaxRestarts))
+	}
+	if t.Replicas < 0 {
+		e.add("Replicas", fmt.Sprintf("invalid replicas %d: must be positive", t.Replicas))
+	} else if t.Replicas > 0 && t.IsAdoption() {
+		e.add("Replicas", "an adopted container can't be replicated")
+	}
+	for containerPort, hostPort := range t.PortBindings {
+		port := nat.Port(containerPort).Port()
+		if _, err := nat.ParsePort(port); err != nil || port == "" {
+			e.add("PortBindings", fmt.Sprintf("invalid container port %q", containerPort))
+		}
+		if _, err := nat.ParsePort(hostPort); err != nil {
+			e.add("PortBindings", fmt.Sprintf("invalid host port %q bound to %q", hostPort, containerPort))
+		}
+	}
+	for k := range t.NodeSelector {
+		if k == "" {
+			e.add("NodeSelector", "node selector label names can't be empty")
+		}
+	}
+	for _, name := range t.AntiAffinity {
+		if name == t.Name {
+			e.add("AntiAffinity", fmt.Sprintf("task %q can't be in anti-affinity with itself", name))
+		}
+	}
+	for _, volume := range t.Volumes {
+		e.addErr("Volumes", ValidateVolume(volume))
+	}
+	for path := range t.Tmpfs {
+		if !strings.HasPrefix(path, "/") {
+			e.add("Tmpfs", fmt.Sprintf("invalid tmpfs mount path %q: must be absolute", path))
+		}
+	}
+	return e.errOrNil()
+}
+
+// Verify that the restart policy is supported by Docker, an empty policy means no restart
+func ValidateRestartPolicy(policy string) error {
+	switch policy {
+	case "", "no", "always", "on-failure", "unless-stopped":
+		return nil
+	default:
+		return fmt.Errorf("invalid restart policy %q", policy)
+	}
+}
+
+// Verify that the image pull policy is supported, regardless of its case, an empty policy means DefaultPullPolicy
+func ValidatePullPolicy(policy string) error {
+	if policy == "" || normalizePullPolicy(policy) != "" {
+		return nil
+	}
+	return fmt.Errorf("invalid pull policy %q, allowed values: %q, %q, %q", policy, PullAlways, PullIfNotPresent, PullNever)
+}
+
+// Get the pull policy applied to the task image: its own policy whatever its case, DefaultPullPolicy when empty
+func (t *Task) EffectivePullPolicy() string {
+	if t.PullPolicy == "" {
+		return DefaultPullPolicy
+	}
+	return normalizePullPolicy(t.PullPolicy)
+}
+
+// Get the pull policy constant matching the given policy regardless of its case, empty if it isn't supported
+func normalizePullPolicy(policy string) string {
+	for _, p := range []string{PullAlways, PullIfNotPresent, PullNever} {
+		if strings.EqualFold(policy, p) {
+			return p
+		}
+	}
+	return ""
+}
+
+// Names of the signals which can stop a container, without their "SIG" prefix
+var stopSignals = map[string]bool{
+	"ABRT": true, "ALRM": true, "BUS": true, "CHLD": true, "CONT": true, "FPE": true, "HUP": true, "ILL": true,
+	"INT": true, "IO": true, "KILL": true, "PIPE": true, "PROF": true, "PWR": true, "QUIT": true, "SEGV": true,
+	"STKFLT": true, "STOP": true, "SYS": true, "TERM": true, "TRAP": true, "TSTP": true, "TTIN": true, "TTOU": true,
+	"URG": true, "USR1": true, "USR2": true, "VTALRM": true, "WINCH": true, "XCPU": true, "XFSZ": true,
+}
+
+// Verify that the stop signal is a known signal name (e.g. "SIGINT" or "INT") or number
+//
+// An empty signal is valid and means the image default signal
+func ValidateStopSignal(signal string) error {
+	if signal == "" {
+		return nil
+	}
+	if number, err := strconv.Atoi(signal); err == nil {
+		if number < 1 || number > 64 {
+			return fmt.Errorf("invalid stop signal %d: must be between 1 and 64", number)
+		}
+		return nil
+	}
+	if !stopSignals[strings.TrimPrefix(strings.ToUpper(signal), "SIG")] {
+		return fmt.Errorf("invalid stop signal %q", signal)
+	}
+	return nil
+}
+
+// Label of a hostname: alphanumeric characters and hyphens, without a leading or trailing hyphen
+var hostnameLabelPattern = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?$`)
+
+// Verify that the hostname is valid according to RFC 1123: dot separated labels of up to 63 characters
+func ValidateHostname(hostname string) error {
+	if hostname == "" || len(hostname) > 253 {
+		return fmt.Errorf("invalid hostname %q: must be between 1 and 253 characters", hostname)
+	}
+	for _, label := range strings.Split(hostname, ".") {
+		if !hostnameLabelPattern.MatchString(label) {
+			return fmt.Errorf("invalid hostname %q: label %q must be alphanumeric characters and hyphens, not starting or ending with a hyphen", hostname, label)
+		}
+	}
+	return nil
+}
+
+// Verify the syntax of a cpuset, which is a comma separated list of CPU numbers or ranges (e.g. "0-2,4")
+//
+// An empty cpuset is valid and means no restriction
+func ValidateCpuset(cpuset string) error {
+	if cpuset == "" {
+		return nil
+	}
+	for _, part := range strings.Split(cpuset, ",") {
+		bounds := strings.SplitN(part, "-", 2)
+		low, err := strconv.ParseUint(bounds[0], 10, 16)
+		if err != nil {
+			return fmt.Errorf("invalid cpuset %q: %q isn't a valid cpu number", cpuset, bounds[0])
+		}
+		if len(bounds) == 1 {
+			continue
+		}
+		high, err := strconv.ParseUint(bounds[1], 10, 16)
+		if err != nil {
+			return fmt.Errorf("invalid cpuset %q: %q isn't a valid cpu number", cpuset, bounds[1])
+		}
+		if low > high {
+			return fmt.Errorf("invalid cpuset %q: range %q is reversed", cpuset, part)
+		}
+	}
+	return nil
 }
 
 // Docker container client
@@ -82,28 +437,82 @@ func NewContainerClient() *ContainerClient {
 	return &ContainerClient{client}
 }
 
-// Start a new docker container with the given configuration
-func (c *ContainerClient) Run(conf Config) (string, error) {
-	ctx := context.Background()
-	reader, err := c.ImagePull(ctx, conf.Image, types.ImagePullOptions{})
+// Timeout of the Docker daemon connectivity check
+const dockerPingTimeout = 10 * time.Second
+
+// Connection settings of the Docker daemon
+type DockerConfig struct {
+	Host    string // Address of the daemon, the DOCKER_HOST environment variable is used when empty
+	TLSPath string // Directory of the ca.pem, cert.pem and key.pem files used to connect with TLS, disabled when empty
+}
+
+// Create a container client connected to the configured Docker daemon, an error is returned if it can't be reached
+func NewConfiguredContainerClient(config DockerConfig) (*ContainerClient, error) {
+	opts := []client.Opt{client.FromEnv}
+	if config.Host != "" {
+		opts = append(opts, client.WithHost(config.Host))
+	}
+	if config.TLSPath != "" {
+		opts = append(opts, client.WithTLSClientConfig(
+			filepath.Join(config.TLSPath, "ca.pem"),
+			filepath.Join(config.TLSPath, "cert.pem"),
+			filepath.Join(config.TLSPath, "key.pem"),
+		))
+	}
+	c, err := client.NewClientWithOpts(opts...)
 	if err != nil {
-		log.Err(err).Str("image", conf.Image).Msg("error pulling image")
-		return "", err
+		return nil, fmt.Errorf("invalid docker client configuration: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dockerPingTimeout)
+	defer cancel()
+	if _, err := c.Ping(ctx); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("docker daemon %s is unreachable: %w", c.DaemonHost(), err)
+	}
+	return &ContainerClient{c}, nil
+}
+
+// Start a new docker container with the given configuration
+//
+// The image pull, container creation and start are bound to the given context. When the
+// context ends after the container creation, the partially started container is removed
+func (c *ContainerClient) Run(ctx context.Context, conf Config) (string, error) {
+	if !conf.SkipPull {
+		if err := c.pullImage(ctx, conf.Image, conf.RegistryAuth); err != nil {
+			return "", err
+		}
 	}
-	io.Copy(os.Stdout, reader) // Display pull result
 
 	containerConfig := container.Config{
 		Image:        conf.Image,
+		Hostname:     conf.Hostname,
+		Cmd:          conf.Cmd,
 		Env:          conf.Env,
 		ExposedPorts: conf.ExposedPorts,
+		Labels:       conf.Labels,
+		StopSignal:   conf.StopSignal,
+		StopTimeout:  conf.StopTimeout,
+		Healthcheck:  conf.HealthCheck.dockerConfig(),
 	}
 	hostConfig := container.HostConfig{
 		RestartPolicy: container.RestartPolicy{Name: conf.RestartPolicy},
 		Resources: container.Resources{
-			Memory:   conf.Memory,
-			NanoCPUs: int64(conf.Cpu * math.Pow(10, 9)),
+			Memory:     conf.Memory,
+			NanoCPUs:   int64(conf.Cpu * math.Pow(10, 9)),
+			CpusetCpus: conf.CpusetCpus,
 		},
 		PortBindings: createPortMap(conf.PortBindings, "127.0.0.1"),
+		LogConfig: container.LogConfig{
+			Type: "json-file",
+			Config: map[string]string{
+				"max-size": conf.MaxLogSize,
+				"max-file": strconv.Itoa(conf.MaxLogFiles),
+			},
+		},
+		ReadonlyRootfs: conf.ReadonlyRootfs,
+		Tmpfs:          conf.Tmpfs,
+		Mounts:         createMounts(conf.Volumes),
 	}
 	response, err := c.ContainerCreate(ctx, &containerConfig, &hostConfig, nil, nil, conf.Name)
 	if err != nil {
@@ -114,6 +523,7 @@ func (c *ContainerClient) Run(conf Config) (string, error) {
 	err = c.ContainerStart(ctx, response.ID, types.ContainerStartOptions{})
 	if err != nil {
 		log.Err(err).Str("image", conf.Image).Str("container-id", response.ID).Msg("error starting container")
+		c.removeContainer(response.ID)
 		return "", err
 	}
 
@@ -127,11 +537,103 @@ func (c *ContainerClient) Run(conf Config) (string, error) {
 	return response.ID, nil
 }
 
+// Pull an image, displaying the pull progress
+func (c *ContainerClient) pullImage(ctx context.Context, image string, auth *RegistryAuth) error {
+	options := types.ImagePullOptions{}
+	if auth != nil {
+		host, err := RegistryHost(image)
+		if err != nil {
+			return fmt.Errorf("invalid image reference %q: %w", image, err)
+		}
+		if options.RegistryAuth, err = auth.encode(host); err != nil {
+			return fmt.Errorf("failed to encode the credentials of registry %s", host)
+		}
+	}
+	reader, err := c.ImagePull(ctx, image, options)
+	if err != nil {
+		log.Err(err).Str("image", image).Msg("error pulling image")
+		return err
+	}
+	defer reader.Close()
+	if _, err := io.Copy(os.Stdout, reader); err != nil { // Display pull result
+		log.Err(err).Str("image", image).Msg("error pulling image")
+		return err
+	}
+	return nil
+}
+
+// Get the id of the local image with the given reference, the error satisfies client.IsErrNotFound if it isn't present
+func (c *ContainerClient) ImageId(ctx context.Context, image string) (string, error) {
+	inspect, _, err := c.ImageInspectWithRaw(ctx, image)
+	if err != nil {
+		return "", err
+	}
+	return inspect.ID, nil
+}
+
+// Force the removal of a container which couldn't be started
+func (c *ContainerClient) removeContainer(containerId string) {
+	// The run context may be over, use a new one for the cleanup
+	ctx := context.Background()
+	if err := c.ContainerRemove(ctx, containerId, types.ContainerRemoveOptions{Force: true}); err != nil {
+		log.Err(err).Str("container-id", containerId).Msg("failed to remove partially started container")
+	}
+}
+
+// Copy the demultiplexed stdout and stderr streams of the container with the given id to the writer,
+// starting from the given time when it isn't zero
+//
+// This call blocks until the container stops or the context is cancelled
+func (c *ContainerClient) CaptureLogs(ctx context.Context, containerId string, since time.Time, w io.Writer) error {
+	options := types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true, Follow: true}
+	if !since.IsZero() {
+		options.Since = since.Format(time.RFC3339Nano)
+	}
+	out, err := c.ContainerLogs(ctx, containerId, options)
+	if err != nil {
+		log.Err(err).Str("container-id", containerId).Msg("error getting logs for container")
+		return err
+	}
+	defer out.Close()
+
+	_, err = stdcopy.StdCopy(w, w, out)
+	return err
+}
+
+// Get the multiplexed stdout and stderr streams of the container with the given id
+//
+// When following, the stream stays open until the container stops or the reader is closed
+func (c *ContainerClient) Logs(containerId string, follow bool) (io.ReadCloser, error) {
+	ctx := context.Background()
+	out, err := c.ContainerLogs(ctx, containerId, types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true, Follow: follow})
+	if err != nil {
+		log.Err(err).Str("container-id", containerId).Msg("error getting logs for container")
+		return nil, err
+	}
+	return out, nil
+}
+
+// List all the containers created for tasks, whatever their state
+func (c *ContainerClient) ListManaged() ([]types.Container, error) {
+	ctx := context.Background()
+	containers, err := c.ContainerList(ctx, types.ContainerListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", LabelTaskId)),
+	})
+	if err != nil {
+		log.Err(err).Msg("error listing managed containers")
+		return nil, err
+	}
+	return containers, nil
+}
+
 // Stop the container with the given id
-func (c *ContainerClient) Stop(containerId string) error {
+//
+// The container is given the timeout in seconds to exit before it is killed, or the Docker default grace period when
+// nil. The stop and removal are bound to the given context
+func (c *ContainerClient) Stop(ctx context.Context, containerId string, timeout *int) error {
 	log.Debug().Str("container-id", containerId).Msg("attempting to stop container")
-	ctx := context.Background()
-	if err := c.ContainerStop(ctx, containerId, container.StopOptions{}); err != nil {
+	if err := c.ContainerStop(ctx, containerId, container.StopOptions{Timeout: timeout}); err != nil {
 		log.Err(err).Str("container-id", containerId).Msg("failed to stop container")
 		return err
 	}
@@ -144,8 +646,7 @@ func (c *ContainerClient) Stop(containerId string) error {
 }
 
 // Retrieve informations about the container with the given id
-func (c *ContainerClient) Inspect(containerId string) (types.ContainerJSON, error) {
-	ctx := context.Background()
+func (c *ContainerClient) Inspect(ctx context.Context, containerId string) (types.ContainerJSON, error) {
 	response, err := c.ContainerInspect(ctx, containerId)
 	if err != nil {
 		log.Err(err).Str("container-id", containerId).Msg("error inspecting container")
@@ -154,6 +655,25 @@ func (c *ContainerClient) Inspect(containerId string) (types.ContainerJSON, erro
 	return response, nil
 }
 
+// Retrieve the resources usage of the container with the given id
+//
+// A single sample is taken without waiting for the next one, so the CPU usage of the previous sample isn't reported
+func (c *ContainerClient) Stats(containerId string) (types.StatsJSON, error) {
+	ctx := context.Background()
+	response, err := c.ContainerStatsOneShot(ctx, containerId)
+	if err != nil {
+		log.Err(err).Str("container-id", containerId).Msg("error getting container stats")
+		return types.StatsJSON{}, err
+	}
+	defer response.Body.Close()
+
+	var stats types.StatsJSON
+	if err := json.NewDecoder(response.Body).Decode(&stats); err != nil {
+		return types.StatsJSON{}, fmt.Errorf("error decoding stats of container %s: %w", containerId, err)
+	}
+	return stats, nil
+}
+
 // Generate a PortMap based on the given map and host IP address
 func createPortMap(m map[string]string, hostIp string) nat.PortMap {
 	pm := make(nat.PortMap, len(m))
@@ -0,0 +1,416 @@
+package task
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestRunSetsCpuset(t *testing.T) {
+	fd := newFakeDocker(t)
+	c := fd.client(t)
+
+	conf := NewConfig(Task{Name: "web", Image: "nginx", CpusetCpus: "0-2,4"})
+	if _, err := c.Run(context.Background(), conf); err != nil {
+		t.Fatalf("failed to run container: %v", err)
+	}
+	if cpuset := fd.lastCreate(t).HostConfig.Resources.CpusetCpus; cpuset != "0-2,4" {
+		t.Errorf("expected the container cpuset to be %q, got %q", "0-2,4", cpuset)
+	}
+}
+
+func TestValidateCpuset(t *testing.T) {
+	for _, cpuset := range []string{"", "0", "0-2", "0-2,4", "1,3,5-7"} {
+		if err := ValidateCpuset(cpuset); err != nil {
+			t.Errorf("expected cpuset %q to be valid, got %v", cpuset, err)
+		}
+	}
+	for _, cpuset := range []string{"a", "0-", "-2", "2-0", "0,,1", "0-2-4", "1.5"} {
+		if err := ValidateCpuset(cpuset); err == nil {
+			t.Errorf("expected cpuset %q to be rejected", cpuset)
+		}
+	}
+	invalid := Task{CpusetCpus: "3-1"}
+	if err := invalid.Validate(); err == nil {
+		t.Error("expected the task with a reversed cpuset range to be rejected")
+	}
+}
+
+func TestRunSameNamedTasksInUniqueContainers(t *testing.T) {
+	fd := newFakeDocker(t)
+	c := fd.client(t)
+
+	var names []string
+	for i := 0; i < 2; i++ {
+		tk := Task{Id: uuid.New(), Name: "web", Image: "nginx"}
+		if _, err := c.Run(context.Background(), NewConfig(tk)); err != nil {
+			t.Fatalf("failed to run container %d: %v", i, err)
+		}
+		created := fd.lastCreate(t)
+		if expected := ContainerName(DefaultContainerPrefix, tk.Id); created.Name != expected {
+			t.Errorf("expected container name %s, got %s", expected, created.Name)
+		}
+		if created.Labels[LabelTaskName] != "web" || created.Labels[LabelTaskId] != tk.Id.String() {
+			t.Errorf("expected the task name and id as labels, got %v", created.Labels)
+		}
+		names = append(names, created.Name)
+	}
+	if names[0] == names[1] {
+		t.Errorf("expected unique container names, got %s twice", names[0])
+	}
+}
+
+func TestRunRemovesContainerWhenStartTimesOut(t *testing.T) {
+	fd := newFakeDocker(t)
+	fd.startDelay = time.Second
+	c := fd.client(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := c.Run(ctx, NewConfig(Task{Id: uuid.New(), Name: "web", Image: "nginx"}))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the start to time out, got %v", err)
+	}
+	if removed := fd.removedContainers(); !slices.Equal(removed, []string{"container-1"}) {
+		t.Errorf("expected the partially started container to be removed, got %v", removed)
+	}
+}
+
+func TestRunSetsReadonlyRootfsAndTmpfs(t *testing.T) {
+	fd := newFakeDocker(t)
+	c := fd.client(t)
+
+	tmpfs := map[string]string{"/tmp": "size=64m", "/run": ""}
+	conf := NewConfig(Task{Id: uuid.New(), Name: "web", Image: "nginx", ReadonlyRootfs: true, Tmpfs: tmpfs})
+	if _, err := c.Run(context.Background(), conf); err != nil {
+		t.Fatalf("failed to run container: %v", err)
+	}
+	hostConfig := fd.lastCreate(t).HostConfig
+	if !hostConfig.ReadonlyRootfs {
+		t.Error("expected the container root filesystem to be read only")
+	}
+	if !maps.Equal(hostConfig.Tmpfs, tmpfs) {
+		t.Errorf("expected the container tmpfs mounts to be %v, got %v", tmpfs, hostConfig.Tmpfs)
+	}
+}
+
+func TestValidateTmpfsPaths(t *testing.T) {
+	valid := Task{Namespace: "default", Image: "nginx", Tmpfs: map[string]string{"/tmp": "size=64m"}}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("expected the task with an absolute tmpfs path to be valid, got %v", err)
+	}
+	invalid := Task{Tmpfs: map[string]string{"tmp": ""}}
+	if err := invalid.Validate(); err == nil {
+		t.Error("expected the task with a relative tmpfs path to be rejected")
+	}
+}
+
+func TestTaskNamesUniqueInGroupNamespace(t *testing.T) {
+	tasks := []Task{
+		{Name: "web", Namespace: "shop", Image: "nginx"},
+		{Name: "web", Namespace: "blog", Image: "nginx"},
+	}
+	if err := ValidateGroup(tasks); err != nil {
+		t.Errorf("expected same named tasks of different namespaces to be valid, got %v", err)
+	}
+	tasks = append(tasks, Task{Name: "web", Namespace: "shop", Image: "nginx"})
+	if err := ValidateGroup(tasks); err == nil {
+		t.Error("expected the group with a name used twice in a namespace to be rejected")
+	}
+}
+
+func TestValidateRequiresNamespace(t *testing.T) {
+	if err := (&Task{}).Validate(); err == nil {
+		t.Error("expected the task without namespace to be rejected")
+	}
+	if labels := containerLabels(Task{Name: "web", Namespace: "shop"}); labels[LabelTaskNamespace] != "shop" {
+		t.Errorf("expected the task namespace as container label, got %v", labels)
+	}
+}
+
+func TestValidateAdoption(t *testing.T) {
+	adoption := Task{Name: "legacy", Namespace: "default", ContainerId: "legacy-1", PreferredNode: "worker-1"}
+	if err := adoption.Validate(); err != nil || !adoption.IsAdoption() {
+		t.Errorf("expected the adoption of a container to be valid, got %v", err)
+	}
+	adoption.PreferredNode = ""
+	if err := adoption.Validate(); err == nil {
+		t.Error("expected the adoption without node to be rejected")
+	}
+	if err := (&Task{Name: "web", Namespace: "default"}).Validate(); err == nil {
+		t.Error("expected the task without image nor container to be rejected")
+	}
+}
+
+func TestNewConfigCarriesEnv(t *testing.T) {
+	env := []string{"PORT=8080", "MODE=production"}
+	tk := Task{Name: "web", Image: "nginx", Env: env}
+	if conf := NewConfig(tk); !slices.Equal(conf.Env, env) {
+		t.Errorf("expected the config env to be %v, got %v", env, conf.Env)
+	}
+
+	// The env survives the manager to worker hop
+	data, _ := json.Marshal(TaskEvent{Task: tk})
+	var received TaskEvent
+	if err := json.Unmarshal(data, &received); err != nil {
+		t.Fatalf("failed to decode task event: %v", err)
+	}
+	if !slices.Equal(received.Task.Env, env) {
+		t.Errorf("expected the decoded env to be %v, got %v", env, received.Task.Env)
+	}
+}
+
+func TestRunSetsCommand(t *testing.T) {
+	fd := newFakeDocker(t)
+	c := fd.client(t)
+
+	cmd := []string{"echo", "hello"}
+	if _, err := c.Run(context.Background(), NewConfig(Task{Id: uuid.New(), Name: "hello", Image: "alpine", Cmd: cmd})); err != nil {
+		t.Fatalf("failed to run container: %v", err)
+	}
+	if created := fd.lastCreate(t).Cmd; !slices.Equal(created, cmd) {
+		t.Errorf("expected the container command to be %v, got %v", cmd, created)
+	}
+}
+
+func TestValidatePullPolicy(t *testing.T) {
+	for _, policy := range []string{"", PullAlways, PullIfNotPresent, PullNever, "ifnotpresent", "never"} {
+		if err := ValidatePullPolicy(policy); err != nil {
+			t.Errorf("expected pull policy %q to be valid, got %v", policy, err)
+		}
+	}
+	invalid := Task{Name: "web", Namespace: "default", Image: "nginx", PullPolicy: "Sometimes"}
+	if err := invalid.Validate(); err == nil {
+		t.Error("expected the task with an unknown pull policy to be rejected")
+	}
+}
+
+func TestConfiguredClientChecksDaemon(t *testing.T) {
+	fd := newFakeDocker(t)
+	host := "tcp://" + fd.Listener.Addr().String()
+
+	c, err := NewConfiguredContainerClient(DockerConfig{Host: host})
+	if err != nil {
+		t.Fatalf("failed to connect to the reachable daemon: %v", err)
+	}
+	c.Close()
+
+	fd.Close()
+	if _, err := NewConfiguredContainerClient(DockerConfig{Host: host}); err == nil || !strings.Contains(err.Error(), host) {
+		t.Errorf("expected the unreachable daemon address to be reported, got %v", err)
+	}
+	if _, err := NewConfiguredContainerClient(DockerConfig{Host: "not a host"}); err == nil {
+		t.Error("expected the invalid daemon address to be rejected")
+	}
+}
+
+func TestRunSetsLogRotation(t *testing.T) {
+	fd := newFakeDocker(t)
+	c := fd.client(t)
+
+	cases := []struct {
+		task     Task
+		maxSize  string
+		maxFiles string
+	}{
+		{Task{Id: uuid.New(), Name: "default", Image: "nginx"}, DefaultMaxLogSize, "3"},
+		{Task{Id: uuid.New(), Name: "custom", Image: "nginx", MaxLogSize: "50m", MaxLogFiles: 5}, "50m", "5"},
+	}
+	for _, tc := range cases {
+		if _, err := c.Run(context.Background(), NewConfig(tc.task)); err != nil {
+			t.Fatalf("failed to run container: %v", err)
+		}
+		logConfig := fd.lastCreate(t).HostConfig.LogConfig
+		if logConfig.Type != "json-file" || logConfig.Config["max-size"] != tc.maxSize || logConfig.Config["max-file"] != tc.maxFiles {
+			t.Errorf("task %s: expected json-file logs rotated at %s with %s files, got %+v", tc.task.Name, tc.maxSize, tc.maxFiles, logConfig)
+		}
+	}
+}
+
+func TestValidateLogRotation(t *testing.T) {
+	for _, size := range []string{"abc", "-1m", "0"} {
+		invalid := Task{Name: "web", Namespace: "default", Image: "nginx", MaxLogSize: size}
+		if err := invalid.Validate(); err == nil {
+			t.Errorf("expected the max log size %q to be rejected", size)
+		}
+	}
+	invalid := Task{Name: "web", Namespace: "default", Image: "nginx", MaxLogFiles: -1}
+	if err := invalid.Validate(); err == nil {
+		t.Error("expected the negative max log files to be rejected")
+	}
+}
+
+func TestRunSetsStopSignal(t *testing.T) {
+	fd := newFakeDocker(t)
+	c := fd.client(t)
+
+	tk := Task{Id: uuid.New(), Name: "web", Image: "nginx", StopSignal: "SIGINT"}
+	if _, err := c.Run(context.Background(), NewConfig(tk)); err != nil {
+		t.Fatalf("failed to run container: %v", err)
+	}
+	if signal := fd.lastCreate(t).StopSignal; signal != "SIGINT" {
+		t.Errorf("expected the container stop signal to be SIGINT, got %q", signal)
+	}
+}
+
+func TestValidateStopSignal(t *testing.T) {
+	for _, signal := range []string{"", "SIGINT", "sigquit", "USR1", "9"} {
+		if err := ValidateStopSignal(signal); err != nil {
+			t.Errorf("expected stop signal %q to be valid, got %v", signal, err)
+		}
+	}
+	for _, signal := range []string{"SIGFOO", "0", "65", "SIG"} {
+		if err := ValidateStopSignal(signal); err == nil {
+			t.Errorf("expected stop signal %q to be rejected", signal)
+		}
+	}
+	invalid := Task{Name: "web", Namespace: "default", Image: "nginx", StopSignal: "SIGFOO"}
+	if err := invalid.Validate(); err == nil {
+		t.Error("expected the task with an unknown stop signal to be rejected")
+	}
+}
+
+func TestRunSetsHealthCheck(t *testing.T) {
+	fd := newFakeDocker(t)
+	c := fd.client(t)
+
+	check := &HealthCheck{Cmd: []string{"curl", "-f", "localhost"}, Interval: 5 * time.Second, Retries: 2}
+	tk := Task{Id: uuid.New(), Name: "web", Image: "nginx", HealthCheck: check}
+	if _, err := c.Run(context.Background(), NewConfig(tk)); err != nil {
+		t.Fatalf("failed to run container: %v", err)
+	}
+	healthcheck := fd.lastCreate(t).Healthcheck
+	if healthcheck == nil {
+		t.Fatal("expected the container to have a health check")
+	}
+	if !slices.Equal(healthcheck.Test, []string{"CMD", "curl", "-f", "localhost"}) || healthcheck.Interval != 5*time.Second || healthcheck.Retries != 2 {
+		t.Errorf("unexpected container health check %+v", healthcheck)
+	}
+
+	// The image health check is kept without a task one
+	tk.Id, tk.HealthCheck = uuid.New(), nil
+	if _, err := c.Run(context.Background(), NewConfig(tk)); err != nil {
+		t.Fatalf("failed to run container: %v", err)
+	}
+	if healthcheck := fd.lastCreate(t).Healthcheck; healthcheck != nil {
+		t.Errorf("expected no container health check, got %+v", healthcheck)
+	}
+}
+
+func TestValidateHealthCheck(t *testing.T) {
+	valid := []*HealthCheck{nil, {Cmd: []string{"true"}}, {Cmd: []string{"true"}, Interval: time.Second, Retries: 3}}
+	for _, check := range valid {
+		if err := ValidateHealthCheck(check); err != nil {
+			t.Errorf("expected health check %+v to be valid, got %v", check, err)
+		}
+	}
+	invalid := []*HealthCheck{{}, {Cmd: []string{"true"}, Interval: time.Microsecond}, {Cmd: []string{"true"}, Retries: -1}}
+	for _, check := range invalid {
+		if err := ValidateHealthCheck(check); err == nil {
+			t.Errorf("expected health check %+v to be rejected", check)
+		}
+	}
+}
+
+func TestEffectivePullPolicy(t *testing.T) {
+	policies := map[string]string{"": PullIfNotPresent, "always": PullAlways, "IFNOTPRESENT": PullIfNotPresent, PullNever: PullNever}
+	for policy, expected := range policies {
+		tk := Task{PullPolicy: policy}
+		if effective := tk.EffectivePullPolicy(); effective != expected {
+			t.Errorf("expected the pull policy %q to be applied as %s, got %q", policy, expected, effective)
+		}
+	}
+}
+
+func TestRunSetsHostname(t *testing.T) {
+	fd := newFakeDocker(t)
+	c := fd.client(t)
+
+	hostnames := map[string]string{"db-0.cluster": "db-0.cluster", "": "web"}
+	for hostname, expected := range hostnames {
+		tk := Task{Id: uuid.New(), Name: "web", Image: "nginx", Hostname: hostname}
+		if _, err := c.Run(context.Background(), NewConfig(tk)); err != nil {
+			t.Fatalf("failed to run container: %v", err)
+		}
+		if created := fd.lastCreate(t).Hostname; created != expected {
+			t.Errorf("expected the container hostname %q, got %q", expected, created)
+		}
+	}
+
+	// A task name which isn't a valid hostname leaves the Docker default
+	if config := NewConfig(Task{Name: "web_server"}); config.Hostname != "" {
+		t.Errorf("expected no hostname for an invalid task name, got %q", config.Hostname)
+	}
+}
+
+func TestValidateHostname(t *testing.T) {
+	for _, hostname := range []string{"web", "db-0", "db-0.cluster.local", "A1", strings.Repeat("a", 63)} {
+		if err := ValidateHostname(hostname); err != nil {
+			t.Errorf("expected hostname %q to be valid, got %v", hostname, err)
+		}
+	}
+	invalid := []string{"", "-web", "web-", "web_server", "db..local", "web.", strings.Repeat("a", 64), strings.Repeat("a.", 127) + "a"}
+	for _, hostname := range invalid {
+		if err := ValidateHostname(hostname); err == nil {
+			t.Errorf("expected hostname %q to be rejected", hostname)
+		}
+	}
+	tk := Task{Name: "web", Namespace: "default", Image: "nginx", Hostname: "web_server"}
+	if fields := violatedFields(t, tk.Validate()); len(fields) != 1 || fields[0] != "Hostname" {
+		t.Errorf("expected a violation on Hostname, got violations on %v", fields)
+	}
+}
+
+func TestStopPassesTimeout(t *testing.T) {
+	fd := newFakeDocker(t)
+	c := fd.client(t)
+
+	timeout := 30
+	if err := c.Stop(context.Background(), "container-1", &timeout); err != nil {
+		t.Fatalf("failed to stop container: %v", err)
+	}
+	if err := c.Stop(context.Background(), "container-2", nil); err != nil {
+		t.Fatalf("failed to stop container: %v", err)
+	}
+	if stops := fd.stops(); !slices.Equal(stops, []string{"t=30", ""}) {
+		t.Errorf("expected the stop timeout to be sent only when set, got stop queries %q", stops)
+	}
+	if removed := fd.removedContainers(); !slices.Equal(removed, []string{"container-1", "container-2"}) {
+		t.Errorf("expected the stopped containers to be removed, got %v", removed)
+	}
+
+	tk := Task{Id: uuid.New(), Name: "web", Image: "nginx", StopTimeout: &timeout}
+	if _, err := c.Run(context.Background(), NewConfig(tk)); err != nil {
+		t.Fatalf("failed to run container: %v", err)
+	}
+	if created := fd.lastCreate(t).StopTimeout; created == nil || *created != 30 {
+		t.Errorf("expected the container stop timeout to be 30, got %v", created)
+	}
+	negative := -1
+	invalid := Task{Name: "web", Namespace: "default", Image: "nginx", StopTimeout: &negative}
+	if fields := violatedFields(t, invalid.Validate()); len(fields) != 1 || fields[0] != "StopTimeout" {
+		t.Errorf("expected a violation on StopTimeout, got violations on %v", fields)
+	}
+}
+
+func TestParseLabelSelector(t *testing.T) {
+	selector, err := ParseLabelSelector([]string{"app=web", "env=prod", "app=web"})
+	if err != nil {
+		t.Fatalf("failed to parse selector: %v", err)
+	}
+	if len(selector) != 2 || selector["app"] != "web" || selector["env"] != "prod" {
+		t.Errorf("unexpected selector %v", selector)
+	}
+	for _, selectors := range [][]string{{"app"}, {"=web"}, {"app=web", "app=db"}} {
+		if _, err := ParseLabelSelector(selectors); err == nil {
+			t.Errorf("expected the selectors %q to be rejected", selectors)
+		}
+	}
+}
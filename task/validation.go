@@ -0,0 +1,45 @@
+package task
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Problem found on a field of a task specification
+type Violation struct {
+	Field   string
+	Message string
+}
+
+// Error listing all the problems found on a task specification
+type ValidationError struct {
+	Violations []Violation
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		messages[i] = fmt.Sprintf("%s: %s", v.Field, v.Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Record a problem on the given field
+func (e *ValidationError) add(field string, message string) {
+	e.Violations = append(e.Violations, Violation{Field: field, Message: message})
+}
+
+// Record the given error as a problem on the given field, if it isn't nil
+func (e *ValidationError) addErr(field string, err error) {
+	if err != nil {
+		e.add(field, err.Error())
+	}
+}
+
+// Get the error to return: nil if no problem was found
+func (e *ValidationError) errOrNil() error {
+	if len(e.Violations) == 0 {
+		return nil
+	}
+	return e
+}
@@ -0,0 +1,80 @@
+package task
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+// Get the fields of the violations reported by the error, failing the test if it isn't a validation error
+func violatedFields(t *testing.T, err error) []string {
+	t.Helper()
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("expected a validation error, got %v", err)
+	}
+	fields := make([]string, len(validationErr.Violations))
+	for i, v := range validationErr.Violations {
+		fields[i] = v.Field
+	}
+	return fields
+}
+
+func TestValidateReportsAllViolations(t *testing.T) {
+	invalid := Task{
+		Name:         "web",
+		Namespace:    "default",
+		Cpu:          -1,
+		PortBindings: map[string]string{"80/tcp": "http"},
+		Tmpfs:        map[string]string{"tmp": ""},
+	}
+
+	fields := violatedFields(t, invalid.Validate())
+	for _, field := range []string{"Image", "Cpu", "PortBindings", "Tmpfs"} {
+		if !slices.Contains(fields, field) {
+			t.Errorf("expected a violation on %s, got violations on %v", field, fields)
+		}
+	}
+	if len(fields) != 4 {
+		t.Errorf("expected 4 violations, got %v", fields)
+	}
+}
+
+func TestValidTaskHasNoViolation(t *testing.T) {
+	valid := Task{Name: "web", Namespace: "default", Image: "nginx", PortBindings: map[string]string{"80/tcp": "8080"}}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("expected the task to be valid, got %v", err)
+	}
+}
+
+func TestValidateGroupPrefixesViolationsWithTaskIndex(t *testing.T) {
+	tasks := []Task{
+		{Name: "web", Namespace: "default", Image: "nginx"},
+		{Name: "web", Namespace: "default", Memory: -1},
+	}
+
+	fields := violatedFields(t, ValidateGroup(tasks))
+	expected := []string{"Tasks[1].Image", "Tasks[1].Memory", "Tasks[1].Name"}
+	if !slices.Equal(fields, expected) {
+		t.Errorf("expected violations on %v, got %v", expected, fields)
+	}
+}
+
+func TestValidateSchedulingConstraints(t *testing.T) {
+	invalid := Task{
+		Name:         "db",
+		Namespace:    "default",
+		Image:        "postgres",
+		NodeSelector: map[string]string{"": "ssd"},
+		AntiAffinity: []string{"db"},
+	}
+	fields := violatedFields(t, invalid.Validate())
+	if !slices.Equal(fields, []string{"NodeSelector", "AntiAffinity"}) {
+		t.Errorf("expected violations on the node selector and the anti-affinity, got %v", fields)
+	}
+
+	valid := Task{Name: "db", Namespace: "default", Image: "postgres", NodeSelector: map[string]string{"disk": "ssd"}, AntiAffinity: []string{"db-replica"}}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("expected the task to be valid, got %v", err)
+	}
+}
@@ -0,0 +1,61 @@
+package task
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/docker/docker/api/types/mount"
+)
+
+// Allowed names of the Docker named volumes
+var volumeNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// Verify that the volume follows the "docker run -v" syntax: "source:target[:mode]"
+//
+// The source is an absolute host path for a bind mount, or a volume name. The target is an absolute
+// container path and the mode is "ro" or "rw"
+func ValidateVolume(volume string) error {
+	_, err := parseVolume(volume)
+	return err
+}
+
+// Parse a volume in the "docker run -v" syntax into the matching Docker mount
+func parseVolume(volume string) (mount.Mount, error) {
+	parts := strings.Split(volume, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return mount.Mount{}, fmt.Errorf("invalid volume %q, expected format: source:target[:mode]", volume)
+	}
+	source, target := parts[0], parts[1]
+	m := mount.Mount{Type: mount.TypeVolume, Source: source, Target: target}
+	if path.IsAbs(source) {
+		m.Type = mount.TypeBind
+	} else if !volumeNamePattern.MatchString(source) {
+		return mount.Mount{}, fmt.Errorf("invalid volume %q: source must be an absolute host path or a volume name", volume)
+	}
+	if !path.IsAbs(target) {
+		return mount.Mount{}, fmt.Errorf("invalid volume %q: target must be an absolute container path", volume)
+	}
+	if target == "/" {
+		return mount.Mount{}, fmt.Errorf("invalid volume %q: target can't be the container root", volume)
+	}
+	if len(parts) == 3 {
+		if parts[2] != "ro" && parts[2] != "rw" {
+			return mount.Mount{}, fmt.Errorf("invalid volume %q: mode must be \"ro\" or \"rw\"", volume)
+		}
+		m.ReadOnly = parts[2] == "ro"
+	}
+	return m, nil
+}
+
+// Create the Docker mounts of the given volumes, the invalid ones are skipped as they are rejected by the task validation
+func createMounts(volumes []string) []mount.Mount {
+	mounts := make([]mount.Mount, 0, len(volumes))
+	for _, volume := range volumes {
+		if m, err := parseVolume(volume); err == nil {
+			mounts = append(mounts, m)
+		}
+	}
+	return mounts
+}
@@ -0,0 +1,63 @@
+package task
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/docker/docker/api/types/mount"
+	"github.com/google/uuid"
+)
+
+func TestParseVolume(t *testing.T) {
+	valid := map[string]mount.Mount{
+		"/srv/web:/usr/share/nginx/html":    {Type: mount.TypeBind, Source: "/srv/web", Target: "/usr/share/nginx/html"},
+		"/srv/web:/usr/share/nginx/html:ro": {Type: mount.TypeBind, Source: "/srv/web", Target: "/usr/share/nginx/html", ReadOnly: true},
+		"data:/var/lib/data:rw":             {Type: mount.TypeVolume, Source: "data", Target: "/var/lib/data"},
+		"pg_data.1:/var/lib/postgresql":     {Type: mount.TypeVolume, Source: "pg_data.1", Target: "/var/lib/postgresql"},
+	}
+	for volume, expected := range valid {
+		m, err := parseVolume(volume)
+		if err != nil {
+			t.Errorf("expected volume %q to be valid, got %v", volume, err)
+			continue
+		}
+		if !reflect.DeepEqual(m, expected) {
+			t.Errorf("expected volume %q to be parsed as %+v, got %+v", volume, expected, m)
+		}
+	}
+
+	invalid := []string{"", "/srv/web", "/a:/b:ro:z", "rel/path:/data", "-data:/data", "data:relative", "data:/", "data:/data:rx"}
+	for _, volume := range invalid {
+		if _, err := parseVolume(volume); err == nil {
+			t.Errorf("expected volume %q to be rejected", volume)
+		}
+	}
+}
+
+func TestValidateRejectsMalformedVolumes(t *testing.T) {
+	invalid := Task{Name: "web", Namespace: "default", Image: "nginx", Volumes: []string{"data:/data", "data", "/a:b"}}
+
+	fields := violatedFields(t, invalid.Validate())
+	if !slices.Equal(fields, []string{"Volumes", "Volumes"}) {
+		t.Errorf("expected 2 violations on Volumes, got violations on %v", fields)
+	}
+}
+
+func TestRunSetsMounts(t *testing.T) {
+	fd := newFakeDocker(t)
+	c := fd.client(t)
+
+	tk := Task{Id: uuid.New(), Name: "web", Image: "nginx", Volumes: []string{"/srv/web:/usr/share/nginx/html:ro", "cache:/var/cache/nginx"}}
+	if _, err := c.Run(context.Background(), NewConfig(tk)); err != nil {
+		t.Fatalf("failed to run container: %v", err)
+	}
+	expected := []mount.Mount{
+		{Type: mount.TypeBind, Source: "/srv/web", Target: "/usr/share/nginx/html", ReadOnly: true},
+		{Type: mount.TypeVolume, Source: "cache", Target: "/var/cache/nginx"},
+	}
+	if mounts := fd.lastCreate(t).HostConfig.Mounts; !reflect.DeepEqual(mounts, expected) {
+		t.Errorf("expected the container mounts %+v, got %+v", expected, mounts)
+	}
+}
@@ -1,37 +1,100 @@
 package worker
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"sync"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/rs/zerolog/log"
+
+	"orchestrator/httpapi"
+	"orchestrator/logger"
 )
 
 // Worker API for tasks management and data retrieval
 type Api struct {
-	Address string
-	Port    int
-	Worker  *Worker
-	Router  *chi.Mux
+	Address       string
+	Port          int
+	MetricsPort   int // Port serving the metrics route, the main port is used when unset
+	Worker        *Worker
+	Router        *chi.Mux
+	MetricsRouter *chi.Mux             // Router of the metrics route, only set when it is served on a dedicated port
+	Server        httpapi.ServerConfig // Timeouts and body size limit of the HTTP servers
+
+	mu            sync.Mutex
+	server        *http.Server
+	metricsServer *http.Server
 }
 
-// Start the worker API server
+// Start the worker API server, it returns once the server is stopped
 func (a *Api) StartRouter() {
 	a.initRouter()
-	if err := http.ListenAndServe(fmt.Sprintf("%s:%d", a.Address, a.Port), a.Router); err != nil {
+	a.mu.Lock()
+	a.server = httpapi.NewServer(fmt.Sprintf("%s:%d", a.Address, a.Port), a.Router, a.Server)
+	if a.MetricsRouter != nil {
+		a.metricsServer = httpapi.NewServer(fmt.Sprintf("%s:%d", a.Address, a.MetricsPort), a.MetricsRouter, a.Server)
+	}
+	server, metricsServer := a.server, a.metricsServer
+	a.mu.Unlock()
+
+	if metricsServer != nil {
+		go func() {
+			if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Err(err).Msg("metrics api server error")
+			}
+		}()
+	}
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Err(err).Msg("api server error")
 	}
 }
 
+// Stop accepting requests on both servers and wait for the in-flight ones until the context is done
+func (a *Api) Shutdown(ctx context.Context) error {
+	a.mu.Lock()
+	server, metricsServer := a.server, a.metricsServer
+	a.mu.Unlock()
+
+	var err error
+	if metricsServer != nil {
+		err = metricsServer.Shutdown(ctx)
+	}
+	if server != nil {
+		err = errors.Join(err, server.Shutdown(ctx))
+	}
+	return err
+}
+
 func (a *Api) initRouter() {
 	a.Router = chi.NewRouter()
 	a.Router.Route("/tasks", func(r chi.Router) {
 		r.Post("/", a.startTaskHandler)
 		r.Delete("/{taskId}", a.stopTaskHandler)
 		r.Get("/", a.getTasksHandler)
+		r.Get("/watch", a.watchTasksHandler)
+		r.Get("/{taskId}/output", a.getTaskOutputHandler)
+		r.Get("/{taskId}/logs", a.getTaskLogsHandler)
+		r.Get("/{taskId}/stats", a.getTaskStatsHandler)
+	})
+	a.Router.Route("/reconcile", func(r chi.Router) {
+		r.Get("/report", a.getReconcileReportHandler)
 	})
-	a.Router.Route("/metrics", func(r chi.Router) {
+	a.Router.Method(http.MethodGet, "/logs", logger.Recent)
+	a.Router.Get("/health", a.healthHandler)
+	a.Router.Get("/ready", a.readyHandler)
+	a.Router.Route("/debug", func(r chi.Router) {
+		r.Get("/stats", a.getDebugStatsHandler)
+	})
+
+	metricsRouter := a.Router
+	if a.MetricsPort != 0 && a.MetricsPort != a.Port {
+		a.MetricsRouter = chi.NewRouter()
+		metricsRouter = a.MetricsRouter
+	}
+	metricsRouter.Route("/metrics", func(r chi.Router) {
 		r.Get("/", a.getMetricsHandler)
 	})
 }
@@ -0,0 +1,76 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// Get a port which is free at the time of the call
+func freePort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to find a free port: %v", err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// Get the status code of a GET request, retrying while the server isn't listening yet
+func getStatus(t *testing.T, url string) int {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, err := http.Get(url)
+		if err == nil {
+			resp.Body.Close()
+			return resp.StatusCode
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("failed to request %s: %v", url, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMetricsServedOnDedicatedPort(t *testing.T) {
+	w := newTestWorker(t, newFakeDocker(t, ""))
+	api := &Api{Address: "127.0.0.1", Port: freePort(t), MetricsPort: freePort(t), Worker: w}
+	go api.StartRouter()
+	t.Cleanup(func() { api.Shutdown(context.Background()) })
+
+	main := fmt.Sprintf("http://127.0.0.1:%d", api.Port)
+	metrics := fmt.Sprintf("http://127.0.0.1:%d", api.MetricsPort)
+	cases := []struct {
+		url    string
+		status int
+	}{
+		{main + "/tasks", http.StatusOK},
+		{main + "/metrics", http.StatusNotFound},
+		{metrics + "/metrics", http.StatusOK},
+		{metrics + "/tasks", http.StatusNotFound},
+	}
+	for _, c := range cases {
+		if status := getStatus(t, c.url); status != c.status {
+			t.Errorf("expected status %d for %s, got %d", c.status, c.url, status)
+		}
+	}
+}
+
+func TestMetricsServedOnMainPortByDefault(t *testing.T) {
+	w := newTestWorker(t, newFakeDocker(t, ""))
+	api := &Api{Address: "127.0.0.1", Port: freePort(t), Worker: w}
+	go api.StartRouter()
+	t.Cleanup(func() { api.Shutdown(context.Background()) })
+
+	for _, route := range []string{"/tasks", "/metrics"} {
+		url := fmt.Sprintf("http://127.0.0.1:%d%s", api.Port, route)
+		if status := getStatus(t, url); status != http.StatusOK {
+			t.Errorf("expected status %d for %s, got %d", http.StatusOK, url, status)
+		}
+	}
+}
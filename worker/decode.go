@@ -0,0 +1,37 @@
+package worker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Decode the JSON request body into a value of the given type
+//
+// Unknown fields are rejected, as well as bodies over the server maximum size. On failure, a response
+// describing the error is written and the error is returned
+func DecodeRequest[T any](w http.ResponseWriter, r *http.Request) (T, error) {
+	var value T
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(&value); err != nil {
+		log.Debug().Err(err).Str("path", r.URL.Path).Msg("failed to unmarshall request body")
+		statusCode := http.StatusBadRequest
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			statusCode = http.StatusRequestEntityTooLarge
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
+		json.NewEncoder(w).Encode(ErrResponse{
+			Message:        fmt.Sprintf("error unmarshalling request body: %v", err),
+			HTTPStatusCode: statusCode,
+		})
+		return value, err
+	}
+	return value, nil
+}
@@ -0,0 +1,73 @@
+package worker
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type decodedRequest struct {
+	Name string
+}
+
+// Decode the body as a request to a handler, returning the response
+func decodeBody(body string) (decodedRequest, *httptest.ResponseRecorder, error) {
+	rec := httptest.NewRecorder()
+	value, err := DecodeRequest[decodedRequest](rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+	return value, rec, err
+}
+
+func TestDecodeRequest(t *testing.T) {
+	value, rec, err := decodeBody(`{"Name": "web"}`)
+	if err != nil {
+		t.Fatalf("failed to decode request: %v", err)
+	}
+	if value.Name != "web" {
+		t.Errorf("expected name web, got %q", value.Name)
+	}
+	if rec.Body.Len() != 0 {
+		t.Errorf("expected no response to be written, got %q", rec.Body.String())
+	}
+}
+
+func TestDecodeRequestRejectsInvalidBodies(t *testing.T) {
+	cases := map[string]string{
+		"unknown field": `{"Name": "web", "Imgae": "nginx"}`,
+		"malformed":     `{"Name": `,
+	}
+	for name, body := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, rec, err := decodeBody(body)
+			if err == nil {
+				t.Fatal("expected the body to be rejected")
+			}
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("expected status %d, got %d", http.StatusBadRequest, rec.Code)
+			}
+			e := ErrResponse{}
+			if err := json.NewDecoder(rec.Body).Decode(&e); err != nil || e.HTTPStatusCode != http.StatusBadRequest {
+				t.Errorf("expected a structured bad request error, got %+v (%v)", e, err)
+			}
+		})
+	}
+}
+
+func TestDecodeRequestRejectsOversizedBody(t *testing.T) {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"Name": "`+strings.Repeat("a", 64)+`"}`))
+	// The servers limit the size of the request bodies
+	req.Body = http.MaxBytesReader(rec, req.Body, 32)
+
+	if _, err := DecodeRequest[decodedRequest](rec, req); err == nil {
+		t.Fatal("expected the oversized body to be rejected")
+	}
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected status %d, got %d", http.StatusRequestEntityTooLarge, rec.Code)
+	}
+	e := ErrResponse{}
+	if err := json.NewDecoder(rec.Body).Decode(&e); err != nil || e.HTTPStatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected a structured error, got %+v (%v)", e, err)
+	}
+}
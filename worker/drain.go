@@ -0,0 +1,89 @@
+package worker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"orchestrator/task"
+)
+
+// Default maximum duration waited for the manager to acknowledge a worker drain
+const DefaultDrainTimeout = 30 * time.Second
+
+// Settings of the tasks migration requested to the manager when the worker shuts down
+type DrainConfig struct {
+	ManagerAddress string        // Address of the manager API, the drain is disabled when empty
+	NodeAddress    string        // Address of this worker as registered on the manager
+	Timeout        time.Duration // Maximum duration waited for the manager acknowledgment
+}
+
+// Acknowledgment of a node drain, sent by the manager once the node tasks are queued for rescheduling
+type DrainResponse struct {
+	Node          string
+	MigratedTasks int
+}
+
+// Stop accepting tasks and ask the manager to migrate the tasks of this worker to other nodes
+//
+// Once the manager acknowledged the drain, the local containers are stopped so that the migrated
+// tasks don't run twice. The containers are left running when the manager couldn't be reached
+func (w *Worker) Drain(config DrainConfig) error {
+	w.draining.Store(true)
+
+	client := http.Client{Timeout: config.Timeout}
+	url := fmt.Sprintf("http://%s/nodes/%s/drain", config.ManagerAddress, neturl.PathEscape(config.NodeAddress))
+	response, err := client.Post(url, "application/json", nil)
+	if err != nil {
+		return fmt.Errorf("failed to send drain request to manager: %w", err)
+	}
+	defer response.Body.Close()
+
+	decoder := json.NewDecoder(response.Body)
+	if response.StatusCode != http.StatusOK {
+		e := ErrResponse{}
+		if err := decoder.Decode(&e); err != nil {
+			return fmt.Errorf("manager responded with status code %d", response.StatusCode)
+		}
+		return fmt.Errorf("manager responded with status code %d: %s", response.StatusCode, e.Message)
+	}
+	ack := DrainResponse{}
+	if err := decoder.Decode(&ack); err != nil {
+		return fmt.Errorf("error decoding drain response: %w", err)
+	}
+	log.Info().Int("migrated-tasks", ack.MigratedTasks).Msg("manager acknowledged the worker drain")
+
+	tasks, err := w.Db.List()
+	if err != nil {
+		return fmt.Errorf("failed to retrieve task list from store: %w", err)
+	}
+	for _, t := range tasks {
+		if t.State != task.Running {
+			continue
+		}
+		if err := w.stopTask(t); err != nil {
+			log.Err(err).Str("task-id", t.Id.String()).Msg("failed to stop migrated task")
+		}
+	}
+	return nil
+}
+
+// Check if the worker is being drained and no longer accepts tasks
+func (w *Worker) IsDraining() bool {
+	return w.draining.Load()
+}
+
+// Check if the worker can accept tasks, an error is returned when it is draining or its tasks store is unreachable
+func (w *Worker) Ready() error {
+	if w.IsDraining() {
+		return ErrDraining
+	}
+	if _, err := w.Db.Count(); err != nil {
+		return fmt.Errorf("tasks store unreachable: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package worker
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"orchestrator/task"
+)
+
+// Manager double acknowledging the drain requests, recording their paths
+type fakeManager struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []string
+}
+
+func newFakeManager(t *testing.T) *fakeManager {
+	t.Helper()
+	fm := &fakeManager{}
+	fm.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fm.mu.Lock()
+		fm.requests = append(fm.requests, r.Method+" "+r.URL.Path)
+		fm.mu.Unlock()
+		json.NewEncoder(w).Encode(DrainResponse{Node: "worker-1", MigratedTasks: 1})
+	}))
+	t.Cleanup(fm.Close)
+	return fm
+}
+
+func (fm *fakeManager) receivedRequests() []string {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	return append([]string(nil), fm.requests...)
+}
+
+func TestDrainRequestsMigrationAndStopsTasks(t *testing.T) {
+	fd := newFakeDocker(t, "")
+	w := newTestWorker(t, fd)
+	running := task.Task{Id: uuid.New(), Name: "web", State: task.Running, ContainerId: "web-1"}
+	completed := task.Task{Id: uuid.New(), Name: "job", State: task.Completed, ContainerId: "job-1"}
+	for _, tk := range []task.Task{running, completed} {
+		if err := w.Db.Put(tk.Id, tk); err != nil {
+			t.Fatalf("failed to store task: %v", err)
+		}
+	}
+	fm := newFakeManager(t)
+
+	err := w.Drain(DrainConfig{ManagerAddress: fm.Listener.Addr().String(), NodeAddress: "worker-1", Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("failed to drain worker: %v", err)
+	}
+	if requests := fm.receivedRequests(); len(requests) != 1 || requests[0] != "POST /nodes/worker-1/drain" {
+		t.Fatalf("expected a single drain request to the manager, got %v", requests)
+	}
+	if removed := fd.removedContainers(); len(removed) != 1 || removed[0] != "web-1" {
+		t.Errorf("expected only the running container to be removed, got %v", removed)
+	}
+	if stored, _ := w.Db.Get(running.Id); stored.State != task.Completed {
+		t.Errorf("expected the migrated task to be completed locally, got state %v", stored.State)
+	}
+}
+
+func TestDrainKeepsTasksWhenManagerUnreachable(t *testing.T) {
+	fd := newFakeDocker(t, "")
+	w := newTestWorker(t, fd)
+	tk := task.Task{Id: uuid.New(), Name: "web", State: task.Running, ContainerId: "web-1"}
+	if err := w.Db.Put(tk.Id, tk); err != nil {
+		t.Fatalf("failed to store task: %v", err)
+	}
+	fm := newFakeManager(t)
+	address := fm.Listener.Addr().String()
+	fm.Close()
+
+	if err := w.Drain(DrainConfig{ManagerAddress: address, NodeAddress: "worker-1", Timeout: time.Second}); err == nil {
+		t.Fatal("expected the drain to fail when the manager is unreachable")
+	}
+	if removed := fd.removedContainers(); len(removed) != 0 {
+		t.Errorf("expected the containers to be left running, got removed %v", removed)
+	}
+	if !w.IsDraining() {
+		t.Error("expected the worker to stop accepting tasks")
+	}
+}
+
+func TestDrainingWorkerRejectsTasks(t *testing.T) {
+	w := newTestWorker(t, newFakeDocker(t, ""))
+	w.draining.Store(true)
+	api := &Api{Worker: w}
+	api.initRouter()
+
+	body, _ := json.Marshal(task.TaskEvent{Id: uuid.New(), Task: task.Task{Id: uuid.New(), Name: "web", Namespace: "default", Image: "nginx"}})
+	rec := httptest.NewRecorder()
+	api.Router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/tasks", bytes.NewReader(body)))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status %d, got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+	select {
+	case tk := <-w.Pending:
+		t.Errorf("expected the task not to be queued, got %v", tk.Id)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
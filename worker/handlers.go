@@ -4,36 +4,67 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"orchestrator/store"
 	"orchestrator/task"
+	"os"
+	"runtime"
+	"strings"
+	"time"
 
+	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
 )
 
+// Content type of newline delimited JSON, one value per line
+const ndjsonContentType = "application/x-ndjson"
+
 type ErrResponse struct {
 	HTTPStatusCode int
 	Message        string
 }
 
-func (a *Api) startTaskHandler(w http.ResponseWriter, r *http.Request) {
-	data := json.NewDecoder(r.Body)
+// Liveness or readiness probe result
+type HealthResponse struct {
+	Status string
+}
 
-	tEvent := task.TaskEvent{}
-	err := data.Decode(&tEvent)
+// Process diagnostics information
+type DebugStats struct {
+	Goroutines    int
+	PendingTasks  int
+	StoredTasks   int
+	UptimeSeconds float64
+}
+
+func (a *Api) startTaskHandler(w http.ResponseWriter, r *http.Request) {
+	tEvent, err := DecodeRequest[task.TaskEvent](w, r)
 	if err != nil {
-		log.Err(err).Msg("start task handler error: failed to unmarshall request body")
-		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	tEvent.ReceiveRegistryAuth()
+	if a.Worker.IsDraining() {
+		log.Info().Str("task-id", tEvent.Task.Id.String()).Msg("rejecting task, the worker is draining")
+		w.WriteHeader(http.StatusServiceUnavailable)
 		json.NewEncoder(w).Encode(ErrResponse{
-			Message:        fmt.Sprintf("error unmarshalling request body: %v", err),
-			HTTPStatusCode: http.StatusBadRequest,
+			Message:        "worker is draining",
+			HTTPStatusCode: http.StatusServiceUnavailable,
 		})
 		return
 	}
 
-	a.Worker.AddTask(tEvent.Task)
+	if err := a.Worker.AddTask(tEvent.Task); err != nil {
+		log.Info().Str("task-id", tEvent.Task.Id.String()).Msg("rejecting task, its start is already in progress")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(ErrResponse{
+			Message:        err.Error(),
+			HTTPStatusCode: http.StatusConflict,
+		})
+		return
+	}
 	log.Info().Str("task-id", tEvent.Task.Id.String()).Msg("task queued for creation")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(tEvent.Task)
@@ -67,16 +98,306 @@ func (a *Api) stopTaskHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	t.State = task.Completed
-	a.Worker.AddTask(t) // Submit deletion request
+	a.Worker.AddTask(t) // Submit deletion request, never rejected
 
 	log.Info().Str("task-id", t.Id.String()).Str("container-id", t.ContainerId).Msg("task submitted for deletion")
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// List the stored tasks, with live=true the running tasks are checked against their container first
 func (a *Api) getTasksHandler(w http.ResponseWriter, r *http.Request) {
+	live := r.URL.Query().Get("live") == "true"
+	ndjson := strings.Contains(r.Header.Get("Accept"), ndjsonContentType)
+	if ndjson && !live {
+		a.streamTasks(w)
+		return
+	}
+
+	tasks := a.Worker.GetTasks()
+	if live {
+		tasks = a.Worker.GetLiveTasks()
+	}
+	if ndjson {
+		w.Header().Set("Content-Type", ndjsonContentType)
+		w.WriteHeader(http.StatusOK)
+		encoder := json.NewEncoder(w)
+		for _, t := range tasks {
+			encoder.Encode(t)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(tasks)
+}
+
+// Write the stored tasks as JSON lines while iterating the store, without loading them all in memory
+func (a *Api) streamTasks(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", ndjsonContentType)
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	err := a.Worker.Db.ForEach(func(t task.Task) error {
+		return encoder.Encode(t)
+	})
+	if err != nil {
+		log.Err(err).Msg("failed to stream tasks")
+	}
+}
+
+// Stream the tasks changes as JSON lines until the client goes away, so that the task state changes are pushed
+//
+// The stream ends when the client doesn't keep up with the changes, the tasks should then be listed again
+func (a *Api) watchTasksHandler(w http.ResponseWriter, r *http.Request) {
+	changes, err := a.Worker.WatchTasks(r.Context())
+	if err != nil {
+		log.Err(err).Msg("failed to watch tasks")
+		w.WriteHeader(http.StatusNotImplemented)
+		json.NewEncoder(w).Encode(ErrResponse{
+			Message:        err.Error(),
+			HTTPStatusCode: http.StatusNotImplemented,
+		})
+		return
+	}
+
+	// The changes are streamed as long as the client reads them, whatever the server write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", ndjsonContentType)
+	w.WriteHeader(http.StatusOK)
+	fw := &flushWriter{w: w}
+	fw.flusher, _ = w.(http.Flusher)
+	fw.Write(nil) // Send the headers, the client knows the subscription is registered
+	encoder := json.NewEncoder(fw)
+	for change := range changes {
+		if err := encoder.Encode(change); err != nil {
+			return
+		}
+	}
+}
+
+func (a *Api) getTaskOutputHandler(w http.ResponseWriter, r *http.Request) {
+	taskId := chi.URLParam(r, "taskId")
+	taskUuid, err := uuid.Parse(taskId)
+	if err != nil {
+		log.Debug().Msg("taskId parameter isn't a valid uuid")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	t, err := a.Worker.Db.Get(taskUuid)
+	if err != nil {
+		if errors.Is(err, store.ErrKeyNotFound) {
+			log.Debug().Str("task-id", taskUuid.String()).Msg("task not found in store")
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			log.Err(err).Str("task-id", taskUuid.String()).Msg("failed to retrieve task from store")
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+	if !t.CaptureOutput {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ErrResponse{
+			Message:        "output capture isn't enabled for this task",
+			HTTPStatusCode: http.StatusNotFound,
+		})
+		return
+	}
+
+	f, err := os.Open(a.Worker.outputPath(t.Id))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			log.Debug().Str("task-id", taskUuid.String()).Msg("task output file not found")
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			log.Err(err).Str("task-id", taskUuid.String()).Msg("failed to open task output file")
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, f)
+}
+
+// Stream the demultiplexed stdout and stderr of a task container, "follow=true" keeps the stream open
+func (a *Api) getTaskLogsHandler(w http.ResponseWriter, r *http.Request) {
+	taskId := chi.URLParam(r, "taskId")
+	taskUuid, err := uuid.Parse(taskId)
+	if err != nil {
+		log.Debug().Msg("taskId parameter isn't a valid uuid")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	t, err := a.Worker.Db.Get(taskUuid)
+	if err != nil {
+		if errors.Is(err, store.ErrKeyNotFound) {
+			log.Debug().Str("task-id", taskUuid.String()).Msg("task not found in store")
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			log.Err(err).Str("task-id", taskUuid.String()).Msg("failed to retrieve task from store")
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+	if t.ContainerId == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ErrResponse{
+			Message:        "task has no container",
+			HTTPStatusCode: http.StatusNotFound,
+		})
+		return
+	}
+
+	out, err := a.Worker.Docker.Logs(t.ContainerId, r.URL.Query().Get("follow") == "true")
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrResponse{
+			Message:        fmt.Sprintf("failed to get container logs: %v", err),
+			HTTPStatusCode: http.StatusInternalServerError,
+		})
+		return
+	}
+	defer out.Close()
+
+	// Stop following the container logs when the client goes away
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-r.Context().Done():
+			out.Close()
+		case <-done:
+		}
+	}()
+
+	// The logs are streamed as long as the client reads them, whatever the server write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	fw := &flushWriter{w: w}
+	fw.flusher, _ = w.(http.Flusher)
+	stdcopy.StdCopy(fw, fw, out)
+}
+
+// Get the resources usage of a task container
+func (a *Api) getTaskStatsHandler(w http.ResponseWriter, r *http.Request) {
+	taskId := chi.URLParam(r, "taskId")
+	taskUuid, err := uuid.Parse(taskId)
+	if err != nil {
+		log.Debug().Msg("taskId parameter isn't a valid uuid")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	t, err := a.Worker.Db.Get(taskUuid)
+	if err != nil {
+		if errors.Is(err, store.ErrKeyNotFound) {
+			log.Debug().Str("task-id", taskUuid.String()).Msg("task not found in store")
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			log.Err(err).Str("task-id", taskUuid.String()).Msg("failed to retrieve task from store")
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if t.ContainerId == "" || t.State != task.Running {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ErrResponse{
+			Message:        "task has no running container",
+			HTTPStatusCode: http.StatusNotFound,
+		})
+		return
+	}
+
+	stats, err := a.Worker.Docker.Stats(t.ContainerId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrResponse{
+			Message:        fmt.Sprintf("failed to get container stats: %v", err),
+			HTTPStatusCode: http.StatusInternalServerError,
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(newTaskStats(taskUuid, stats))
+}
+
+// Writer flushing the HTTP response after each write, so that streamed data reaches the client immediately
+type flushWriter struct {
+	w       io.Writer
+	flusher http.Flusher
+}
+
+func (f *flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if f.flusher != nil {
+		f.flusher.Flush()
+	}
+	return n, err
+}
+
+func (a *Api) getReconcileReportHandler(w http.ResponseWriter, r *http.Request) {
+	report, err := a.Worker.ReconcileReport()
+	if err != nil {
+		log.Err(err).Msg("failed to build reconcile report")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrResponse{
+			Message:        err.Error(),
+			HTTPStatusCode: http.StatusInternalServerError,
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(report)
+}
+
+func (a *Api) healthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(HealthResponse{Status: "ok"})
+}
+
+func (a *Api) readyHandler(w http.ResponseWriter, r *http.Request) {
+	if err := a.Worker.Ready(); err != nil {
+		log.Debug().Err(err).Msg("worker isn't ready")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(ErrResponse{
+			Message:        err.Error(),
+			HTTPStatusCode: http.StatusServiceUnavailable,
+		})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(HealthResponse{Status: "ready"})
+}
+
+func (a *Api) getDebugStatsHandler(w http.ResponseWriter, r *http.Request) {
+	taskCount, err := a.Worker.Db.Count()
+	if err != nil {
+		log.Err(err).Msg("failed to count stored tasks")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(a.Worker.GetTasks())
+	json.NewEncoder(w).Encode(DebugStats{
+		Goroutines:    runtime.NumGoroutine(),
+		PendingTasks:  len(a.Worker.Pending) + len(a.Worker.Stops),
+		StoredTasks:   taskCount,
+		UptimeSeconds: time.Since(a.Worker.StartTime).Seconds(),
+	})
 }
 
 func (a *Api) getMetricsHandler(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,310 @@
+package worker
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/google/uuid"
+
+	"orchestrator/store"
+	"orchestrator/task"
+)
+
+// Get the tasks listed by the API, as JSON lines when streamed or else as an array, sorted by id
+func listedTasks(t *testing.T, api *Api, stream bool) []task.Task {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/tasks", nil)
+	if stream {
+		req.Header.Set("Accept", ndjsonContentType)
+	}
+	rec := httptest.NewRecorder()
+	api.Router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+
+	var tasks []task.Task
+	if stream {
+		decoder := json.NewDecoder(rec.Body)
+		for decoder.More() {
+			var tk task.Task
+			if err := decoder.Decode(&tk); err != nil {
+				t.Fatalf("failed to decode streamed task: %v", err)
+			}
+			tasks = append(tasks, tk)
+		}
+	} else if err := json.NewDecoder(rec.Body).Decode(&tasks); err != nil {
+		t.Fatalf("failed to decode tasks: %v", err)
+	}
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].Id.String() < tasks[j].Id.String() })
+	return tasks
+}
+
+func TestGetTasksStreamMatchesArray(t *testing.T) {
+	w := newTestWorker(t, newFakeDocker(t, ""))
+	for i := 0; i < 5; i++ {
+		tk := task.Task{Id: uuid.New(), Name: fmt.Sprintf("web-%d", i), State: task.Running}
+		if err := w.Db.Put(tk.Id, tk); err != nil {
+			t.Fatalf("failed to store task: %v", err)
+		}
+	}
+	api := &Api{Worker: w}
+	api.initRouter()
+
+	array := listedTasks(t, api, false)
+	streamed := listedTasks(t, api, true)
+	if len(array) != 5 {
+		t.Fatalf("expected 5 listed tasks, got %d", len(array))
+	}
+	if !reflect.DeepEqual(array, streamed) {
+		t.Errorf("streamed tasks differ from the listed ones:\n%v\n%v", streamed, array)
+	}
+}
+
+func TestGetLiveTasksCorrectsMissingContainers(t *testing.T) {
+	w := newTestWorker(t, newFakeDocker(t, ""))
+	tk := task.Task{Id: uuid.New(), Name: "web", State: task.Running, ContainerId: "gone"}
+	if err := w.Db.Put(tk.Id, tk); err != nil {
+		t.Fatalf("failed to store task: %v", err)
+	}
+	api := &Api{Worker: w}
+	api.initRouter()
+
+	// The plain listing reports the stored state
+	if listed := listedTasks(t, api, false); len(listed) != 1 || listed[0].State != task.Running {
+		t.Fatalf("expected the stored running task to be listed, got %+v", listed)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/tasks?live=true", nil)
+	rec := httptest.NewRecorder()
+	api.Router.ServeHTTP(rec, req)
+	var listed []task.Task
+	if err := json.NewDecoder(rec.Body).Decode(&listed); err != nil {
+		t.Fatalf("failed to decode tasks: %v", err)
+	}
+	if len(listed) != 1 || listed[0].State != task.Failed || listed[0].Error != "container not found" {
+		t.Fatalf("expected the task without container to be listed as failed, got %+v", listed)
+	}
+	if stored, _ := w.Db.Get(tk.Id); stored.State != task.Failed {
+		t.Errorf("expected the correction to be stored, got state %v", stored.State)
+	}
+}
+
+func TestGetDebugStats(t *testing.T) {
+	w := newTestWorker(t, newFakeDocker(t, ""))
+	tk := task.Task{Id: uuid.New(), Name: "web", State: task.Running}
+	if err := w.Db.Put(tk.Id, tk); err != nil {
+		t.Fatalf("failed to store task: %v", err)
+	}
+	w.Pending <- task.Task{Id: uuid.New(), State: task.Scheduled}
+	api := &Api{Worker: w}
+	api.initRouter()
+
+	rec := httptest.NewRecorder()
+	api.Router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/stats", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	var fields map[string]float64
+	if err := json.NewDecoder(rec.Body).Decode(&fields); err != nil {
+		t.Fatalf("failed to decode debug stats: %v", err)
+	}
+	for _, name := range []string{"Goroutines", "PendingTasks", "StoredTasks", "UptimeSeconds"} {
+		if _, found := fields[name]; !found {
+			t.Errorf("expected the %s field in the debug stats", name)
+		}
+	}
+	if fields["Goroutines"] < 1 || fields["PendingTasks"] != 1 || fields["StoredTasks"] != 1 || fields["UptimeSeconds"] < 0 {
+		t.Errorf("unexpected debug stats values: %v", fields)
+	}
+}
+
+// Request the container logs of the given task from the worker API
+func getTaskLogs(t *testing.T, w *Worker, taskId uuid.UUID) *httptest.ResponseRecorder {
+	t.Helper()
+	api := &Api{Worker: w}
+	api.initRouter()
+	rec := httptest.NewRecorder()
+	api.Router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/tasks/"+taskId.String()+"/logs", nil))
+	return rec
+}
+
+func TestGetTaskLogsStreamsContainerOutput(t *testing.T) {
+	w := newTestWorker(t, newFakeDocker(t, "hello\nworld\n"))
+	tk := task.Task{Id: uuid.New(), Name: "web", State: task.Running, ContainerId: "container-1"}
+	w.Db.Put(tk.Id, tk)
+
+	rec := getTaskLogs(t, w, tk.Id)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	if body := rec.Body.String(); body != "hello\nworld\n" {
+		t.Errorf("expected the demultiplexed container logs, got %q", body)
+	}
+}
+
+func TestGetTaskLogsWithoutContainer(t *testing.T) {
+	w := newTestWorker(t, newFakeDocker(t, ""))
+	tk := task.Task{Id: uuid.New(), Name: "web", State: task.Scheduled}
+	w.Db.Put(tk.Id, tk)
+
+	if rec := getTaskLogs(t, w, tk.Id); rec.Code != http.StatusNotFound {
+		t.Errorf("expected status %d for a task without container, got %d", http.StatusNotFound, rec.Code)
+	}
+	if rec := getTaskLogs(t, w, uuid.New()); rec.Code != http.StatusNotFound {
+		t.Errorf("expected status %d for an unknown task, got %d", http.StatusNotFound, rec.Code)
+	}
+}
+
+// Request the resources usage of the given task from the worker API
+func getTaskStats(t *testing.T, w *Worker, taskId uuid.UUID) *httptest.ResponseRecorder {
+	t.Helper()
+	api := &Api{Worker: w}
+	api.initRouter()
+	rec := httptest.NewRecorder()
+	api.Router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/tasks/"+taskId.String()+"/stats", nil))
+	return rec
+}
+
+func TestGetTaskStatsReportsContainerUsage(t *testing.T) {
+	fd := newFakeDocker(t, "")
+	fd.stats.CPUStats.CPUUsage.TotalUsage = 200
+	fd.stats.CPUStats.SystemUsage = 1000
+	fd.stats.CPUStats.OnlineCPUs = 2
+	fd.stats.MemoryStats = types.MemoryStats{Usage: 300, Limit: 1000, Stats: map[string]uint64{"inactive_file": 100}}
+	fd.stats.Networks = map[string]types.NetworkStats{"eth0": {RxBytes: 10, TxBytes: 20}, "eth1": {RxBytes: 1, TxBytes: 2}}
+	w := newTestWorker(t, fd)
+	tk := task.Task{Id: uuid.New(), Name: "web", State: task.Running, ContainerId: "container-1"}
+	w.Db.Put(tk.Id, tk)
+
+	rec := getTaskStats(t, w, tk.Id)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	var got TaskStats
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("failed to decode stats: %v", err)
+	}
+	expected := TaskStats{
+		TaskId:         tk.Id,
+		ContainerId:    "container-1",
+		CpuPercent:     40,
+		MemoryUsage:    200,
+		MemoryLimit:    1000,
+		NetworkRxBytes: 11,
+		NetworkTxBytes: 22,
+	}
+	if got != expected {
+		t.Errorf("expected the stats %+v, got %+v", expected, got)
+	}
+	if query := fd.statsQuery; !strings.Contains(query, "one-shot=1") || !strings.Contains(query, "stream=0") {
+		t.Errorf("expected a single one-shot sample to be requested, got the query %q", query)
+	}
+}
+
+func TestGetTaskStatsWithoutRunningContainer(t *testing.T) {
+	w := newTestWorker(t, newFakeDocker(t, ""))
+	tk := task.Task{Id: uuid.New(), Name: "web", State: task.Completed, ContainerId: "container-1"}
+	w.Db.Put(tk.Id, tk)
+
+	if rec := getTaskStats(t, w, tk.Id); rec.Code != http.StatusNotFound {
+		t.Errorf("expected status %d for a task without running container, got %d", http.StatusNotFound, rec.Code)
+	}
+	if rec := getTaskStats(t, w, uuid.New()); rec.Code != http.StatusNotFound {
+		t.Errorf("expected status %d for an unknown task, got %d", http.StatusNotFound, rec.Code)
+	}
+}
+
+func TestStartTaskRejectsStartInFlight(t *testing.T) {
+	w := newTestWorker(t, newFakeDocker(t, ""))
+	api := &Api{Worker: w}
+	api.initRouter()
+	tEvent := task.TaskEvent{Id: uuid.New(), State: task.Scheduled, Task: task.Task{Id: uuid.New(), Name: "web", Namespace: "default", Image: "nginx", State: task.Scheduled}}
+	body, _ := json.Marshal(tEvent)
+
+	statuses := []int{}
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		api.Router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/tasks", bytes.NewReader(body)))
+		statuses = append(statuses, rec.Code)
+	}
+	if statuses[0] != http.StatusCreated || statuses[1] != http.StatusConflict {
+		t.Errorf("expected the second start to be rejected, got the statuses %v", statuses)
+	}
+}
+
+func TestWatchTasksStreamsChanges(t *testing.T) {
+	w := newTestWorker(t, newFakeDocker(t, ""))
+	api := &Api{Worker: w}
+	api.initRouter()
+	server := httptest.NewServer(api.Router)
+	defer server.Close()
+
+	response, err := http.Get(server.URL + "/tasks/watch")
+	if err != nil {
+		t.Fatalf("failed to watch tasks: %v", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status %d", response.StatusCode)
+	}
+
+	tk := task.Task{Id: uuid.New(), Name: "web", Image: "nginx", State: task.Running}
+	w.Db.Put(tk.Id, tk)
+	w.Db.Delete(tk.Id)
+
+	decoder := json.NewDecoder(response.Body)
+	for _, expected := range []store.ChangeType{store.ChangePut, store.ChangeDelete} {
+		var change store.ChangeEvent[uuid.UUID, task.Task]
+		if err := decoder.Decode(&change); err != nil {
+			t.Fatalf("failed to decode change: %v", err)
+		}
+		if change.Type != expected || change.Key != tk.Id {
+			t.Errorf("expected a %s change of the task, got %+v", expected, change)
+		}
+	}
+}
+
+// Store double whose values can't be counted, as when its database is unreachable
+type unreachableStore[TKey comparable, TVal any] struct {
+	store.Store[TKey, TVal]
+}
+
+func (s unreachableStore[TKey, TVal]) Count() (int, error) {
+	return 0, errors.New("database unreachable")
+}
+
+// Get the status of a probe route of the worker API
+func probe(api *Api, url string) int {
+	rec := httptest.NewRecorder()
+	api.Router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+	return rec.Code
+}
+
+func TestHealthAndReadiness(t *testing.T) {
+	w := newTestWorker(t, newFakeDocker(t, ""))
+	api := &Api{Worker: w}
+	api.initRouter()
+	for _, url := range []string{"/health", "/ready"} {
+		if status := probe(api, url); status != http.StatusOK {
+			t.Errorf("expected status %d for %s, got %d", http.StatusOK, url, status)
+		}
+	}
+
+	w.Db = unreachableStore[uuid.UUID, task.Task]{w.Db}
+	if status := probe(api, "/ready"); status != http.StatusServiceUnavailable {
+		t.Errorf("expected the worker with an unreachable store not to be ready, got status %d", status)
+	}
+	if status := probe(api, "/health"); status != http.StatusOK {
+		t.Errorf("expected the worker to stay alive, got status %d", status)
+	}
+}
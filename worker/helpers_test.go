@@ -0,0 +1,314 @@
+package worker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog"
+
+	"orchestrator/stats"
+	"orchestrator/store"
+	"orchestrator/task"
+)
+
+func TestMain(m *testing.M) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+	os.Exit(m.Run())
+}
+
+// Store double whose reads of a single value block until released, recording when it is closed
+type blockingStore[TKey comparable, TVal any] struct {
+	store.Store[TKey, TVal]
+
+	entered chan struct{} // Receives a value when a read starts
+	release chan struct{} // The reads block until it is closed
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func newBlockingStore[TKey comparable, TVal any](inner store.Store[TKey, TVal]) *blockingStore[TKey, TVal] {
+	return &blockingStore[TKey, TVal]{
+		Store:   inner,
+		entered: make(chan struct{}, 1),
+		release: make(chan struct{}),
+	}
+}
+
+func (s *blockingStore[TKey, TVal]) Get(key TKey) (TVal, error) {
+	select {
+	case s.entered <- struct{}{}:
+	default:
+	}
+	<-s.release
+	return s.Store.Get(key)
+}
+
+func (s *blockingStore[TKey, TVal]) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	return s.Store.Close()
+}
+
+func (s *blockingStore[TKey, TVal]) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// Docker daemon double serving the given output as the logs of every container
+type fakeDocker struct {
+	*httptest.Server
+
+	logs       string
+	containers []types.Container // Listed containers
+	pullDelay  time.Duration     // Duration of the images pull
+	strict     bool              // The containers creation fails when their image isn't present
+	hangDelay  time.Duration     // Duration of the containers stops and inspections
+	holdLogs   bool              // The logs streams stay open until their request is cancelled
+
+	mu         sync.Mutex
+	inspected  map[string]types.ContainerJSON // Inspected containers by id
+	removed    []string                       // Ids of the stopped and removed containers
+	pulls      int                            // Images pulls in progress
+	maxPulls   int                            // Highest number of images pulls in progress at the same time
+	pulled     []string                       // Pulled images, in pull order
+	images     map[string]string              // Ids of the local images by reference
+	created    int                            // Number of created containers
+	stats      types.StatsJSON                // Stats served for every container
+	statsQuery string                         // Query of the last stats request
+	logsQuery  string                         // Query of the last logs request
+	openLogs   int                            // Logs streams held open
+}
+
+// Get the query of the last logs request and the number of logs streams held open
+func (fd *fakeDocker) logsRequests() (string, int) {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+	return fd.logsQuery, fd.openLogs
+}
+
+// Get the references of the pulled images, in pull order
+func (fd *fakeDocker) pulledImages() []string {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+	return append([]string(nil), fd.pulled...)
+}
+
+// Set the id of a local image, an empty id removes the image
+func (fd *fakeDocker) setImage(image string, id string) {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+	if fd.images == nil {
+		fd.images = make(map[string]string)
+	}
+	if id == "" {
+		delete(fd.images, image)
+		return
+	}
+	fd.images[image] = id
+}
+
+// Get the highest number of images pulls which were in progress at the same time
+func (fd *fakeDocker) concurrentPulls() int {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+	return fd.maxPulls
+}
+
+// Get the ids of the containers stopped and removed through the daemon
+func (fd *fakeDocker) removedContainers() []string {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+	return append([]string(nil), fd.removed...)
+}
+
+// Set the inspection result of a container
+func (fd *fakeDocker) setContainer(container types.ContainerJSON) {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+	if fd.inspected == nil {
+		fd.inspected = make(map[string]types.ContainerJSON)
+	}
+	fd.inspected[container.ID] = container
+}
+
+// Version prefix of the Docker API paths
+var apiVersionPrefix = regexp.MustCompile(`^/v[0-9.]+`)
+
+func newFakeDocker(t *testing.T, logs string) *fakeDocker {
+	t.Helper()
+	fd := &fakeDocker{logs: logs}
+	router := chi.NewRouter()
+	router.Get("/containers/{id}/logs", func(w http.ResponseWriter, r *http.Request) {
+		fd.mu.Lock()
+		fd.logsQuery = r.URL.RawQuery
+		fd.mu.Unlock()
+		w.Header().Set("Content-Type", "application/vnd.docker.multiplexed-stream")
+		stdcopy.NewStdWriter(w, stdcopy.Stdout).Write([]byte(fd.logs))
+		if fd.holdLogs {
+			w.(http.Flusher).Flush()
+			fd.mu.Lock()
+			fd.openLogs++
+			fd.mu.Unlock()
+			<-r.Context().Done()
+			fd.mu.Lock()
+			fd.openLogs--
+			fd.mu.Unlock()
+		}
+	})
+	router.MethodFunc(http.MethodHead, "/_ping", func(w http.ResponseWriter, r *http.Request) {})
+	router.Get("/_ping", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	})
+	router.Post("/images/create", func(w http.ResponseWriter, r *http.Request) {
+		fd.mu.Lock()
+		fd.pulls++
+		image := r.URL.Query().Get("fromImage")
+		fd.pulled = append(fd.pulled, image)
+		fd.maxPulls = max(fd.maxPulls, fd.pulls)
+		// The pulled image is present locally afterwards
+		if _, found := fd.images[image]; !found {
+			if fd.images == nil {
+				fd.images = make(map[string]string)
+			}
+			fd.images[image] = "sha256:" + image
+		}
+		fd.mu.Unlock()
+		defer func() {
+			fd.mu.Lock()
+			fd.pulls--
+			fd.mu.Unlock()
+		}()
+		select {
+		case <-time.After(fd.pullDelay):
+		case <-r.Context().Done():
+			return
+		}
+		w.Write([]byte("{}"))
+	})
+	router.Get("/images/{name}/json", func(w http.ResponseWriter, r *http.Request) {
+		fd.mu.Lock()
+		id, found := fd.images[chi.URLParam(r, "name")]
+		fd.mu.Unlock()
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"message": "no such image"})
+			return
+		}
+		json.NewEncoder(w).Encode(types.ImageInspect{ID: id})
+	})
+	router.Post("/containers/create", func(w http.ResponseWriter, r *http.Request) {
+		var config container.Config
+		json.NewDecoder(r.Body).Decode(&config)
+		fd.mu.Lock()
+		if _, found := fd.images[config.Image]; fd.strict && !found {
+			fd.mu.Unlock()
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"message": "no such image"})
+			return
+		}
+		fd.created++
+		id := fmt.Sprintf("container-%d", fd.created)
+		fd.mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(container.CreateResponse{ID: id})
+	})
+	router.Post("/containers/{id}/start", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	router.Get("/containers/{id}/json", func(w http.ResponseWriter, r *http.Request) {
+		if !fd.hang(r) {
+			return
+		}
+		fd.mu.Lock()
+		container, found := fd.inspected[chi.URLParam(r, "id")]
+		fd.mu.Unlock()
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"message": "no such container"})
+			return
+		}
+		json.NewEncoder(w).Encode(container)
+	})
+	router.Get("/containers/{id}/stats", func(w http.ResponseWriter, r *http.Request) {
+		fd.mu.Lock()
+		fd.statsQuery = r.URL.RawQuery
+		stats := fd.stats
+		fd.mu.Unlock()
+		stats.ID = chi.URLParam(r, "id")
+		json.NewEncoder(w).Encode(stats)
+	})
+	router.Post("/containers/{id}/stop", func(w http.ResponseWriter, r *http.Request) {
+		if !fd.hang(r) {
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	router.Delete("/containers/{id}", func(w http.ResponseWriter, r *http.Request) {
+		fd.mu.Lock()
+		fd.removed = append(fd.removed, chi.URLParam(r, "id"))
+		fd.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	})
+	router.Get("/containers/json", func(w http.ResponseWriter, r *http.Request) {
+		containers := fd.containers
+		if containers == nil {
+			containers = []types.Container{}
+		}
+		json.NewEncoder(w).Encode(containers)
+	})
+	fd.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.URL.Path = apiVersionPrefix.ReplaceAllString(r.URL.Path, "")
+		router.ServeHTTP(w, r)
+	}))
+	t.Cleanup(fd.Close)
+	return fd
+}
+
+// Wait for the hang delay of the daemon, false when the request was cancelled first
+func (fd *fakeDocker) hang(r *http.Request) bool {
+	select {
+	case <-time.After(fd.hangDelay):
+		return true
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+// Get the settings connecting a container client to the fake Docker daemon
+func (fd *fakeDocker) config() task.DockerConfig {
+	return task.DockerConfig{Host: "tcp://" + fd.Listener.Addr().String()}
+}
+
+// Create a worker using the memory store, whose container client targets the given Docker daemon
+func newTestWorker(t *testing.T, fd *fakeDocker) *Worker {
+	t.Helper()
+	w, err := New("test", "memory", t.TempDir(), fd.config())
+	if err != nil {
+		t.Fatalf("failed to create worker: %v", err)
+	}
+	t.Cleanup(func() { w.Close() })
+	return w
+}
+
+// Stats collector double reporting fixed stats
+type fakeCollector struct {
+	stats *stats.Stats
+}
+
+func (c fakeCollector) Collect() *stats.Stats {
+	return c.stats
+}
@@ -0,0 +1,74 @@
+package worker
+
+import (
+	"context"
+	"errors"
+
+	"github.com/docker/docker/client"
+	"github.com/rs/zerolog/log"
+
+	"orchestrator/store"
+	"orchestrator/task"
+)
+
+// Reference of an image, used as key of the pulled images store
+type ImageName string
+
+func (n ImageName) String() string {
+	return string(n)
+}
+
+// Image pulled by the worker, along with the id of the local image it resolved to
+type ImageRecord struct {
+	Image  string
+	Digest string
+}
+
+// Check if the given image is present locally
+//
+// An image pulled by the worker is only considered present while the local image has the recorded digest, the
+// record is otherwise removed so that the image is pulled again. The images present but never pulled by the worker,
+// such as the ones preloaded on the node, are used as they are
+func (w *Worker) imagePresent(ctx context.Context, c *task.ContainerClient, image string) bool {
+	digest, err := c.ImageId(ctx, image)
+	if err != nil {
+		if !client.IsErrNotFound(err) {
+			log.Err(err).Str("image", image).Msg("failed to inspect image")
+		}
+		w.forgetImage(image)
+		return false
+	}
+
+	record, err := w.Images.Get(ImageName(image))
+	if err != nil {
+		if !errors.Is(err, store.ErrKeyNotFound) {
+			log.Err(err).Str("image", image).Msg("failed to retrieve image from store")
+		}
+		return true
+	}
+	if digest != record.Digest {
+		log.Debug().Str("image", image).Msg("pulled image changed locally")
+		w.forgetImage(image)
+		return false
+	}
+	return true
+}
+
+// Remove the record of a pulled image
+func (w *Worker) forgetImage(image string) {
+	if err := w.Images.Delete(ImageName(image)); err != nil && !errors.Is(err, store.ErrKeyNotFound) {
+		log.Err(err).Str("image", image).Msg("failed to remove image from store")
+	}
+}
+
+// Record the digest of a pulled image
+func (w *Worker) recordImage(ctx context.Context, c *task.ContainerClient, image string) {
+	digest, err := c.ImageId(ctx, image)
+	if err != nil {
+		log.Err(err).Str("image", image).Msg("failed to inspect pulled image")
+		return
+	}
+	if err := w.Images.Put(ImageName(image), ImageRecord{Image: image, Digest: digest}); err != nil {
+		log.Err(err).Str("image", image).Msg("failed to store image")
+	}
+}
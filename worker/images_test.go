@@ -0,0 +1,121 @@
+package worker
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"orchestrator/task"
+)
+
+// Start a task running the given image with the IfNotPresent pull policy
+func startIfNotPresent(t *testing.T, w *Worker, image string) {
+	t.Helper()
+	tk := task.Task{Id: uuid.New(), Name: "web", Image: image, PullPolicy: task.PullIfNotPresent, State: task.Scheduled}
+	if err := w.startTask(tk, nil); err != nil {
+		t.Fatalf("failed to start task: %v", err)
+	}
+}
+
+func TestPresentImageNotPulled(t *testing.T) {
+	fd := newFakeDocker(t, "")
+	// The image was preloaded on the node, it wasn't pulled by the worker
+	fd.setImage("nginx", "sha256:1")
+	w := newTestWorker(t, fd)
+
+	startIfNotPresent(t, w, "nginx")
+	startIfNotPresent(t, w, "nginx")
+
+	if pulled := fd.pulledImages(); len(pulled) != 0 {
+		t.Errorf("expected the present image not to be pulled, got pulls %v", pulled)
+	}
+}
+
+func TestMissingImagePulledOnce(t *testing.T) {
+	fd := newFakeDocker(t, "")
+	w := newTestWorker(t, fd)
+
+	startIfNotPresent(t, w, "nginx")
+	startIfNotPresent(t, w, "nginx")
+
+	if pulled := fd.pulledImages(); len(pulled) != 1 {
+		t.Errorf("expected the image to be pulled once, got pulls %v", pulled)
+	}
+	if record, err := w.Images.Get("nginx"); err != nil || record.Digest != "sha256:nginx" {
+		t.Errorf("expected the pulled image to be recorded, got %+v (%v)", record, err)
+	}
+}
+
+func TestChangedImagePulledAgain(t *testing.T) {
+	fd := newFakeDocker(t, "")
+	w := newTestWorker(t, fd)
+	startIfNotPresent(t, w, "nginx")
+
+	// The local image was replaced since the pull, its record is invalidated
+	fd.setImage("nginx", "sha256:2")
+	startIfNotPresent(t, w, "nginx")
+	// The local image was removed since the pull
+	fd.setImage("nginx", "")
+	startIfNotPresent(t, w, "nginx")
+
+	if pulled := fd.pulledImages(); len(pulled) != 3 {
+		t.Errorf("expected the image to be pulled again after each change, got pulls %v", pulled)
+	}
+}
+
+func TestAlwaysPullPolicyPullsEachTime(t *testing.T) {
+	fd := newFakeDocker(t, "")
+	fd.setImage("nginx", "sha256:1")
+	w := newTestWorker(t, fd)
+
+	for i := 0; i < 2; i++ {
+		tk := task.Task{Id: uuid.New(), Name: "web", Image: "nginx", PullPolicy: task.PullAlways, State: task.Scheduled}
+		if err := w.startTask(tk, nil); err != nil {
+			t.Fatalf("failed to start task: %v", err)
+		}
+	}
+	if pulled := fd.pulledImages(); len(pulled) != 2 {
+		t.Errorf("expected the image to be pulled for each task, got pulls %v", pulled)
+	}
+}
+
+func TestDefaultPullPolicySkipsPresentImage(t *testing.T) {
+	fd := newFakeDocker(t, "")
+	fd.setImage("nginx", "sha256:1")
+	w := newTestWorker(t, fd)
+
+	for i := 0; i < 2; i++ {
+		tk := task.Task{Id: uuid.New(), Name: "web", Image: "nginx", State: task.Scheduled}
+		if err := w.startTask(tk, nil); err != nil {
+			t.Fatalf("failed to start task: %v", err)
+		}
+	}
+	if pulled := fd.pulledImages(); len(pulled) != 0 {
+		t.Errorf("expected the present image not to be pulled, got pulls %v", pulled)
+	}
+}
+
+func TestNeverPullPolicy(t *testing.T) {
+	fd := newFakeDocker(t, "")
+	fd.strict = true
+	fd.setImage("nginx", "sha256:1")
+	w := newTestWorker(t, fd)
+
+	present := task.Task{Id: uuid.New(), Name: "web", Image: "nginx", PullPolicy: "never", State: task.Scheduled}
+	if err := w.startTask(present, nil); err != nil {
+		t.Fatalf("failed to start task: %v", err)
+	}
+	missing := task.Task{Id: uuid.New(), Name: "api", Image: "httpd", PullPolicy: task.PullNever, State: task.Scheduled}
+	if err := w.startTask(missing, nil); err == nil {
+		t.Fatal("expected the task of a missing image to fail")
+	}
+
+	if pulled := fd.pulledImages(); len(pulled) != 0 {
+		t.Errorf("expected no image to be pulled, got pulls %v", pulled)
+	}
+	stored, _ := w.Db.Get(missing.Id)
+	if stored.State != task.Failed || !strings.Contains(stored.Error, "isn't present on the node") {
+		t.Errorf("expected the task to fail because of the missing image, got state %v and error %q", stored.State, stored.Error)
+	}
+}
@@ -0,0 +1,111 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"orchestrator/task"
+)
+
+// Default maximum size in bytes of a task output file
+const DefaultMaxOutputSize = 10 * 1024 * 1024
+
+// Writer which discards data once the maximum size is reached
+type cappedWriter struct {
+	w         io.Writer
+	remaining int64
+}
+
+func (c *cappedWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if c.remaining <= 0 {
+		return n, nil
+	}
+	if int64(n) > c.remaining {
+		p = p[:c.remaining]
+	}
+
+	written, err := c.w.Write(p)
+	c.remaining -= int64(written)
+	if err != nil {
+		return written, err
+	}
+	return n, nil
+}
+
+// Get the path of the file containing the captured output of the given task
+func (w *Worker) outputPath(taskId uuid.UUID) string {
+	return filepath.Join(w.DataDir, "outputs", fmt.Sprintf("%v.log", taskId))
+}
+
+// Capture the container output of the given task in the background until the container or the worker stops
+//
+// When resuming, the output produced since the last write to the output file is appended to it, otherwise
+// the file is truncated
+func (w *Worker) startOutputCapture(t task.Task, resume bool) {
+	w.runInBackground(func(ctx context.Context) {
+		w.captureOutput(ctx, t, resume)
+	})
+}
+
+// Resume the output capture of the running tasks, which was interrupted by the previous worker stop
+func (w *Worker) resumeOutputCaptures() {
+	tasks, err := w.Db.List()
+	if err != nil {
+		log.Err(err).Msg("failed to list tasks to resume their output capture")
+		return
+	}
+	for _, t := range tasks {
+		if t.CaptureOutput && t.State == task.Running && t.ContainerId != "" {
+			w.startOutputCapture(t, true)
+		}
+	}
+}
+
+// Copy the container output of the given task to its output file until the container stops or the context
+// is cancelled
+//
+// The file content is capped to the worker's maximum output size
+func (w *Worker) captureOutput(ctx context.Context, t task.Task, resume bool) {
+	taskLogger := log.With().
+		Str("task-id", t.Id.String()).
+		Str("container-id", t.ContainerId).
+		Logger()
+
+	path := w.outputPath(t.Id)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		taskLogger.Err(err).Msg("failed to create output directory")
+		return
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	remaining := w.MaxOutputSize
+	var since time.Time
+	if resume {
+		if info, err := os.Stat(path); err == nil {
+			flags = os.O_WRONLY | os.O_APPEND
+			remaining -= info.Size()
+			since = info.ModTime()
+		}
+	}
+	f, err := os.OpenFile(path, flags, 0600)
+	if err != nil {
+		taskLogger.Err(err).Msg("failed to open output file")
+		return
+	}
+	defer f.Close()
+
+	err = w.Docker.CaptureLogs(ctx, t.ContainerId, since, &cappedWriter{w: f, remaining: remaining})
+	if err != nil && ctx.Err() == nil {
+		taskLogger.Err(err).Msg("failed to capture container output")
+		return
+	}
+	taskLogger.Debug().Msg("container output capture completed")
+}
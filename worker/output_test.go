@@ -0,0 +1,153 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"orchestrator/task"
+)
+
+func TestCappedWriterDiscardsBeyondLimit(t *testing.T) {
+	var buf bytes.Buffer
+	w := &cappedWriter{w: &buf, remaining: 8}
+
+	for _, chunk := range []string{"hello", " world", "!"} {
+		n, err := w.Write([]byte(chunk))
+		if err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+		if n != len(chunk) {
+			t.Errorf("expected %d bytes reported as written, got %d", len(chunk), n)
+		}
+	}
+	if buf.String() != "hello wo" {
+		t.Errorf("expected capped content %q, got %q", "hello wo", buf.String())
+	}
+}
+
+func TestCapturedOutputIsServedCapped(t *testing.T) {
+	fd := newFakeDocker(t, "hello world\n")
+	w := newTestWorker(t, fd)
+	w.MaxOutputSize = 5
+	tk := task.Task{Id: uuid.New(), Name: "web", State: task.Running, ContainerId: "container-1", CaptureOutput: true}
+	if err := w.Db.Put(tk.Id, tk); err != nil {
+		t.Fatalf("failed to store task: %v", err)
+	}
+
+	w.captureOutput(context.Background(), tk, false)
+
+	status, body := getTaskOutput(t, w, tk.Id)
+	if status != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, status)
+	}
+	if body != "hello" {
+		t.Errorf("expected output %q, got %q", "hello", body)
+	}
+}
+
+func TestOutputCaptureStoppedOnShutdown(t *testing.T) {
+	fd := newFakeDocker(t, "hello\n")
+	fd.holdLogs = true
+	w := newTestWorker(t, fd)
+	w.Start()
+	tk := task.Task{Id: uuid.New(), Name: "web", State: task.Running, ContainerId: "container-1", CaptureOutput: true}
+	if err := w.Db.Put(tk.Id, tk); err != nil {
+		t.Fatalf("failed to store task: %v", err)
+	}
+
+	w.startOutputCapture(tk, false)
+	waitForOutput(t, w, tk.Id, "hello\n")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := w.Shutdown(ctx, nil); err != nil {
+		t.Fatalf("failed to shut down worker: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, open := fd.logsRequests(); open == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the logs stream to be closed by the worker shutdown")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestOutputCaptureResumedOnStart(t *testing.T) {
+	fd := newFakeDocker(t, " world\n")
+	w := newTestWorker(t, fd)
+	tk := task.Task{Id: uuid.New(), Name: "web", State: task.Running, ContainerId: "container-1", CaptureOutput: true}
+	if err := w.Db.Put(tk.Id, tk); err != nil {
+		t.Fatalf("failed to store task: %v", err)
+	}
+	path := w.outputPath(tk.Id)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatalf("failed to create output directory: %v", err)
+	}
+	if err := os.WriteFile(path, []byte("hello"), 0600); err != nil {
+		t.Fatalf("failed to write output file: %v", err)
+	}
+
+	w.Start()
+	waitForOutput(t, w, tk.Id, "hello world\n")
+
+	if query, _ := fd.logsRequests(); !strings.Contains(query, "since=") {
+		t.Errorf("expected the resumed capture to request the logs since the last write, got query %q", query)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := w.Shutdown(ctx, nil); err != nil {
+		t.Fatalf("failed to shut down worker: %v", err)
+	}
+}
+
+func TestTaskOutputNotFoundWithoutCapture(t *testing.T) {
+	w := newTestWorker(t, newFakeDocker(t, ""))
+	tk := task.Task{Id: uuid.New(), Name: "web", State: task.Running, ContainerId: "container-1"}
+	if err := w.Db.Put(tk.Id, tk); err != nil {
+		t.Fatalf("failed to store task: %v", err)
+	}
+
+	if status, _ := getTaskOutput(t, w, tk.Id); status != http.StatusNotFound {
+		t.Errorf("expected status %d, got %d", http.StatusNotFound, status)
+	}
+}
+
+// Request the captured output of the given task from the worker API
+func getTaskOutput(t *testing.T, w *Worker, taskId uuid.UUID) (int, string) {
+	t.Helper()
+	api := &Api{Worker: w}
+	api.initRouter()
+	rec := httptest.NewRecorder()
+	api.Router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/tasks/"+taskId.String()+"/output", nil))
+	body, _ := io.ReadAll(rec.Body)
+	return rec.Code, string(body)
+}
+
+// Wait until the captured output of the given task matches the expected one
+func waitForOutput(t *testing.T, w *Worker, taskId uuid.UUID, expected string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, body := getTaskOutput(t, w, taskId)
+		if body == expected {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected output %q, got %q", expected, body)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
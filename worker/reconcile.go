@@ -0,0 +1,99 @@
+package worker
+
+import (
+	"fmt"
+
+	"github.com/docker/docker/api/types"
+	"github.com/google/uuid"
+
+	"orchestrator/task"
+)
+
+// Kinds of differences between the stored tasks and the actual containers
+const (
+	MismatchOrphanContainer  = "orphan-container"  // A managed container has no matching task
+	MismatchMissingContainer = "missing-container" // An active task has no container
+	MismatchState            = "state-mismatch"    // The task state doesn't match its container state
+)
+
+// Difference between a stored task and the actual containers
+type ReconcileMismatch struct {
+	Kind           string
+	TaskId         uuid.UUID
+	ContainerId    string
+	TaskState      string
+	ContainerState string
+}
+
+// Comparison of the stored tasks with the containers managed by the worker
+type ReconcileReport struct {
+	Tasks      int
+	Containers int
+	Mismatches []ReconcileMismatch
+}
+
+// Compare the stored tasks with the managed containers reported by Docker
+func (w *Worker) ReconcileReport() (ReconcileReport, error) {
+	tasks, err := w.Db.List()
+	if err != nil {
+		return ReconcileReport{}, fmt.Errorf("failed to retrieve task list from store: %w", err)
+	}
+	containers, err := w.Docker.ListManaged()
+	if err != nil {
+		return ReconcileReport{}, fmt.Errorf("failed to list containers: %w", err)
+	}
+
+	return buildReconcileReport(tasks, containers), nil
+}
+
+func buildReconcileReport(tasks []task.Task, containers []types.Container) ReconcileReport {
+	report := ReconcileReport{
+		Tasks:      len(tasks),
+		Containers: len(containers),
+		Mismatches: []ReconcileMismatch{},
+	}
+
+	containersByTask := make(map[string]types.Container, len(containers))
+	for _, c := range containers {
+		containersByTask[c.Labels[task.LabelTaskId]] = c
+	}
+
+	knownTasks := make(map[string]struct{}, len(tasks))
+	for _, t := range tasks {
+		knownTasks[t.Id.String()] = struct{}{}
+		c, found := containersByTask[t.Id.String()]
+		switch {
+		case !found && t.State == task.Running:
+			report.Mismatches = append(report.Mismatches, ReconcileMismatch{
+				Kind:        MismatchMissingContainer,
+				TaskId:      t.Id,
+				ContainerId: t.ContainerId,
+				TaskState:   t.State.String(),
+			})
+		case !found:
+			continue
+		case (t.State == task.Running) != (c.State == "running"):
+			report.Mismatches = append(report.Mismatches, ReconcileMismatch{
+				Kind:           MismatchState,
+				TaskId:         t.Id,
+				ContainerId:    c.ID,
+				TaskState:      t.State.String(),
+				ContainerState: c.State,
+			})
+		}
+	}
+
+	for taskId, c := range containersByTask {
+		if _, found := knownTasks[taskId]; found {
+			continue
+		}
+		id, _ := uuid.Parse(taskId)
+		report.Mismatches = append(report.Mismatches, ReconcileMismatch{
+			Kind:           MismatchOrphanContainer,
+			TaskId:         id,
+			ContainerId:    c.ID,
+			ContainerState: c.State,
+		})
+	}
+	return report
+}
@@ -0,0 +1,70 @@
+package worker
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/google/uuid"
+
+	"orchestrator/task"
+)
+
+// Get a container managed for the given task, in the given state
+func managedContainer(id string, taskId uuid.UUID, state string) types.Container {
+	return types.Container{ID: id, State: state, Labels: map[string]string{task.LabelTaskId: taskId.String()}}
+}
+
+func TestReconcileReportFindsMismatches(t *testing.T) {
+	fd := newFakeDocker(t, "")
+	w := newTestWorker(t, fd)
+	healthy := task.Task{Id: uuid.New(), State: task.Running, ContainerId: "healthy"}
+	missing := task.Task{Id: uuid.New(), State: task.Running, ContainerId: "missing"}
+	stopped := task.Task{Id: uuid.New(), State: task.Completed, ContainerId: "stopped"}
+	gone := task.Task{Id: uuid.New(), State: task.Completed}
+	for _, tk := range []task.Task{healthy, missing, stopped, gone} {
+		if err := w.Db.Put(tk.Id, tk); err != nil {
+			t.Fatalf("failed to store task: %v", err)
+		}
+	}
+	orphanTask := uuid.New()
+	fd.containers = []types.Container{
+		managedContainer("healthy", healthy.Id, "running"),
+		managedContainer("stopped", stopped.Id, "running"),
+		managedContainer("orphan", orphanTask, "exited"),
+	}
+
+	api := &Api{Worker: w}
+	api.initRouter()
+	rec := httptest.NewRecorder()
+	api.Router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/reconcile/report", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	var report ReconcileReport
+	if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+		t.Fatalf("failed to decode report: %v", err)
+	}
+
+	if report.Tasks != 4 || report.Containers != 3 {
+		t.Errorf("expected 4 tasks and 3 containers, got %d and %d", report.Tasks, report.Containers)
+	}
+	mismatches := make(map[string]ReconcileMismatch)
+	for _, m := range report.Mismatches {
+		mismatches[m.Kind] = m
+	}
+	if len(report.Mismatches) != 3 {
+		t.Fatalf("expected 3 mismatches, got %+v", report.Mismatches)
+	}
+	if m := mismatches[MismatchMissingContainer]; m.TaskId != missing.Id {
+		t.Errorf("expected the running task without container to be reported, got %+v", m)
+	}
+	if m := mismatches[MismatchState]; m.TaskId != stopped.Id || m.ContainerState != "running" {
+		t.Errorf("expected the completed task with a running container to be reported, got %+v", m)
+	}
+	if m := mismatches[MismatchOrphanContainer]; m.TaskId != orphanTask || m.ContainerId != "orphan" {
+		t.Errorf("expected the container without task to be reported, got %+v", m)
+	}
+}
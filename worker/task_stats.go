@@ -0,0 +1,55 @@
+package worker
+
+import (
+	"github.com/docker/docker/api/types"
+	"github.com/google/uuid"
+)
+
+// Resources usage of a task container
+type TaskStats struct {
+	TaskId         uuid.UUID
+	ContainerId    string
+	CpuPercent     float64 // Percentage of a single CPU, up to 100 times the number of CPUs
+	MemoryUsage    uint64  // Memory used by the container in bytes, excluding the inactive page cache
+	MemoryLimit    uint64  // Memory available to the container in bytes
+	NetworkRxBytes uint64
+	NetworkTxBytes uint64
+}
+
+// Create the task stats from the container stats reported by the Docker daemon
+func newTaskStats(taskId uuid.UUID, stats types.StatsJSON) TaskStats {
+	taskStats := TaskStats{
+		TaskId:      taskId,
+		ContainerId: stats.ID,
+		CpuPercent:  cpuPercent(stats),
+		MemoryUsage: stats.MemoryStats.Usage,
+		MemoryLimit: stats.MemoryStats.Limit,
+	}
+	// The page cache can be reclaimed, it isn't counted as used memory (cgroup v2 and v1 keys)
+	if inactive, found := stats.MemoryStats.Stats["inactive_file"]; found && inactive < taskStats.MemoryUsage {
+		taskStats.MemoryUsage -= inactive
+	} else if cache, found := stats.MemoryStats.Stats["total_inactive_file"]; found && cache < taskStats.MemoryUsage {
+		taskStats.MemoryUsage -= cache
+	}
+	for _, network := range stats.Networks {
+		taskStats.NetworkRxBytes += network.RxBytes
+		taskStats.NetworkTxBytes += network.TxBytes
+	}
+	return taskStats
+}
+
+// Compute the container CPU usage between the two samples of the stats
+//
+// When the previous sample is missing, as with one-shot stats, this is the average usage since the container start
+func cpuPercent(stats types.StatsJSON) float64 {
+	cpuDelta := float64(stats.CPUStats.CPUUsage.TotalUsage) - float64(stats.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(stats.CPUStats.SystemUsage) - float64(stats.PreCPUStats.SystemUsage)
+	if cpuDelta <= 0 || systemDelta <= 0 {
+		return 0
+	}
+	cpus := float64(stats.CPUStats.OnlineCPUs)
+	if cpus == 0 {
+		cpus = float64(len(stats.CPUStats.CPUUsage.PercpuUsage))
+	}
+	return cpuDelta / systemDelta * cpus * 100
+}
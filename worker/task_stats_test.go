@@ -0,0 +1,39 @@
+package worker
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/google/uuid"
+)
+
+func TestCpuPercent(t *testing.T) {
+	stats := types.StatsJSON{}
+	stats.CPUStats.CPUUsage.TotalUsage = 300
+	stats.CPUStats.SystemUsage = 2000
+	stats.PreCPUStats.CPUUsage.TotalUsage = 100
+	stats.PreCPUStats.SystemUsage = 1000
+	stats.CPUStats.CPUUsage.PercpuUsage = []uint64{150, 150, 0, 0}
+
+	// Without the online CPUs, the per-CPU usage gives the number of CPUs
+	if percent := cpuPercent(stats); percent != 80 {
+		t.Errorf("expected 80%% of a CPU, got %v", percent)
+	}
+	stats.CPUStats.OnlineCPUs = 1
+	if percent := cpuPercent(stats); percent != 20 {
+		t.Errorf("expected 20%% of a CPU, got %v", percent)
+	}
+	stats.CPUStats.SystemUsage = 1000
+	if percent := cpuPercent(stats); percent != 0 {
+		t.Errorf("expected no usage without system time elapsed, got %v", percent)
+	}
+}
+
+func TestNewTaskStatsExcludesCgroupV1PageCache(t *testing.T) {
+	stats := types.StatsJSON{}
+	stats.MemoryStats = types.MemoryStats{Usage: 500, Limit: 1000, Stats: map[string]uint64{"total_inactive_file": 200}}
+
+	if usage := newTaskStats(uuid.New(), stats).MemoryUsage; usage != 300 {
+		t.Errorf("expected the inactive page cache to be excluded, got %d", usage)
+	}
+}
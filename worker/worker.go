@@ -1,10 +1,17 @@
 package worker
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
 	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
 
@@ -13,45 +20,221 @@ import (
 	"orchestrator/task"
 )
 
+// Default maximum duration of a task start
+const DefaultStartTimeout = 5 * time.Minute
+
+// Default maximum duration of the Docker daemon requests other than a task start
+const DefaultDockerTimeout = 30 * time.Second
+
+// Grace period given by Docker to a container to exit after the stop signal when the task doesn't set its stop timeout
+const defaultStopGrace = 10 * time.Second
+
+// Default interval of the tasks state and stats collection loops
+const DefaultLoopInterval = 10 * time.Second
+
+// Error returned when a task is submitted while the start of the same task is still queued or in progress
+var (
+	ErrTaskInFlight  = errors.New("task start already in progress")
+	ErrWatchDisabled = errors.New("tasks store doesn't support watching")
+	ErrDraining      = errors.New("worker is draining")
+)
+
 // Worker manages the execution of tasks
 type Worker struct {
-	Name    string                            // Name of the worker
-	Pending chan task.Task                    // Pending tasks to be executed
-	Db      store.Store[uuid.UUID, task.Task] // Tasks store
-	Stats   *stats.Stats                      // Stats of the worker
+	Name            string                              // Name of the worker
+	Pending         chan task.Task                      // Pending tasks to be executed
+	Stops           chan task.Task                      // Pending tasks to be stopped, processed before the pending starts
+	Db              store.Store[uuid.UUID, task.Task]   // Tasks store
+	Images          store.Store[ImageName, ImageRecord] // Pulled images store
+	Docker          *task.ContainerClient               // Client of the Docker daemon running the containers
+	Stats           *stats.Stats                        // Stats of the worker
+	StatsCollector  stats.StatsCollector                // Source of the worker stats
+	DataDir         string                              // Directory where the worker files are written
+	MaxOutputSize   int64                               // Maximum size in bytes of a captured task output
+	ContainerPrefix string                              // Prefix of the created containers names
+	StartTimeout    time.Duration                       // Maximum duration of a task start, including the image pull, 0 to disable
+	DockerTimeout   time.Duration                       // Maximum duration of a container stop or inspection, 0 to disable
+	MaxConcurrent   int                                 // Maximum number of tasks started or stopped at the same time
+	UpdateInterval  time.Duration                       // Interval between tasks state updates
+	StatsInterval   time.Duration                       // Interval between stats collections
+	StartTime       time.Time                           // Time at which the worker was created
+
+	draining    atomic.Bool             // Set once the worker shuts down, new tasks are then rejected
+	inFlight    sync.WaitGroup          // Tasks being started or stopped
+	taskLocksMu sync.Mutex              // Protects taskLocks
+	taskLocks   map[uuid.UUID]*taskLock // Lock of each task being processed, so that the actions on a task are processed one at a time
+	starting    sync.Map                // Ids of the tasks whose start is queued or in progress, so that a task isn't started twice
+	loopsCtx    context.Context         // Context of the background loops, cancelled to stop them
+	stop        context.CancelFunc      // Cancels the background loops context
+	stopMu      sync.Mutex              // Orders the background goroutines starts before the loops context cancellation
+	loops       sync.WaitGroup          // Running background loops and output captures
 }
 
-// Create a new worker with the given name and store type
+// Lock of a task, shared by the goroutines processing it
+type taskLock struct {
+	sync.Mutex
+	refs int // Number of goroutines holding or waiting for the lock, the lock is discarded when it drops to 0
+}
+
+// Create a new worker with the given name, store type, data directory and Docker daemon settings
 //
-// The Close method should be called when the worker is no longer used
-func New(name string, storeType string) (*Worker, error) {
+// An error is returned if the Docker daemon can't be reached. The Close method should be called
+// when the worker is no longer used
+func New(name string, storeType string, dataDir string, docker task.DockerConfig) (*Worker, error) {
+	dockerClient, err := task.NewConfiguredContainerClient(docker)
+	if err != nil {
+		return nil, err
+	}
+
 	var db store.Store[uuid.UUID, task.Task]
+	var images store.Store[ImageName, ImageRecord]
 	switch storeType {
 	case "memory":
 		db = store.NewMemoryStore[uuid.UUID, task.Task]()
+		images = store.NewMemoryStore[ImageName, ImageRecord]()
 	case "persisted":
-		var err error
-		dbFileName := fmt.Sprintf("%s.db", name)
-		db, err = store.NewPersistedStore[uuid.UUID, task.Task](dbFileName, 0600, "tasks")
+		dbFileName := filepath.Join(dataDir, fmt.Sprintf("%s.db", name))
+		tasksStore, err := store.NewPersistedStore[uuid.UUID, task.Task](dbFileName, 0600, "tasks")
+		if err != nil {
+			dockerClient.Close()
+			return nil, err
+		}
+		if err := tasksStore.SetSchema(task.SchemaVersion, task.Migrations); err != nil {
+			tasksStore.Close()
+			return nil, err
+		}
+		db = tasksStore
+		images, err = store.NewPersistedStoreFromDb[ImageName, ImageRecord](tasksStore.Db, "images")
+		if err != nil {
+			tasksStore.Close()
+			dockerClient.Close()
+			return nil, err
+		}
+	case "sqlite":
+		dbFileName := filepath.Join(dataDir, fmt.Sprintf("%s.sqlite", name))
+		tasksStore, err := store.NewSqliteStore[uuid.UUID, task.Task](dbFileName, "tasks")
 		if err != nil {
+			dockerClient.Close()
+			return nil, err
+		}
+		db = tasksStore
+		images, err = store.NewSqliteStoreFromDb[ImageName, ImageRecord](tasksStore.Db, "images")
+		if err != nil {
+			tasksStore.Close()
+			dockerClient.Close()
 			return nil, err
 		}
 	default:
+		dockerClient.Close()
 		return nil, fmt.Errorf("unsupported store type: %s", storeType)
 	}
+	if _, ok := db.(store.Watcher[uuid.UUID, task.Task]); !ok {
+		// The tasks changes are pushed to the watchers whatever the store type
+		db = store.NewWatchedStore(db)
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	w := &Worker{
+		Name:            name,
+		Pending:         make(chan task.Task, 10),
+		Stops:           make(chan task.Task, 10),
+		Db:              db,
+		Images:          images,
+		Docker:          dockerClient,
+		DataDir:         dataDir,
+		MaxOutputSize:   DefaultMaxOutputSize,
+		ContainerPrefix: task.DefaultContainerPrefix,
+		StartTimeout:    DefaultStartTimeout,
+		DockerTimeout:   DefaultDockerTimeout,
+		MaxConcurrent:   runtime.NumCPU(),
+		UpdateInterval:  DefaultLoopInterval,
+		StatsInterval:   DefaultLoopInterval,
+		StartTime:       time.Now().UTC(),
+		taskLocks:       make(map[uuid.UUID]*taskLock),
+		loopsCtx:        ctx,
+		stop:            stop,
+	}
+	w.StatsCollector, _ = stats.NewCollector(stats.DefaultCollector)
+	return w, nil
+}
+
+// Start the background loops: tasks execution, tasks state updates and stats collection, and resume the output
+// capture of the running tasks
+//
+// The loops run until Shutdown is called
+func (w *Worker) Start() {
+	w.resumeOutputCaptures()
+	for _, loop := range []func(context.Context){w.RunTasks, w.CollectStats, w.UpdateTasks} {
+		w.runInBackground(loop)
+	}
+}
+
+// Run the given function in the background with the loops context, Shutdown waits for it along with the loops
+//
+// Nothing is run once the worker is stopping
+func (w *Worker) runInBackground(f func(context.Context)) {
+	w.stopMu.Lock()
+	defer w.stopMu.Unlock()
+	if w.loopsCtx.Err() != nil {
+		return
+	}
+	w.loops.Add(1)
+	go func() {
+		defer w.loops.Done()
+		f(w.loopsCtx)
+	}()
+}
+
+// Stop the worker in order: the API server stops accepting requests, the background loops are drained,
+// then the store is closed
+//
+// The API server is optional. The context bounds the wait for the in-flight requests and the background loops,
+// the store is left open if the loops didn't return in time
+func (w *Worker) Shutdown(ctx context.Context, api *Api) error {
+	if api != nil {
+		if err := api.Shutdown(ctx); err != nil {
+			return fmt.Errorf("failed to shut down api server: %w", err)
+		}
+	}
+
+	w.stopMu.Lock()
+	w.stop()
+	w.stopMu.Unlock()
+	loopsDone := make(chan struct{})
+	go func() {
+		w.loops.Wait()
+		close(loopsDone)
+	}()
+	select {
+	case <-loopsDone:
+	case <-ctx.Done():
+		return fmt.Errorf("background loops didn't stop: %w", ctx.Err())
+	}
 
-	return &Worker{
-		Name:    name,
-		Pending: make(chan task.Task, 10),
-		Db:      db,
-	}, nil
+	return w.Close()
 }
 
-// Cleanup the worker's resources
+// Cleanup the worker's resources, once the in-flight tasks are processed
 func (w *Worker) Close() error {
+	w.inFlight.Wait()
+	if err := w.Docker.Close(); err != nil {
+		return err
+	}
+	if err := w.Images.Close(); err != nil {
+		return err
+	}
 	return w.Db.Close()
 }
 
+// Subscribe to the tasks changes written to the store, until the context is done
+func (w *Worker) WatchTasks(ctx context.Context) (<-chan store.ChangeEvent[uuid.UUID, task.Task], error) {
+	watcher, ok := w.Db.(store.Watcher[uuid.UUID, task.Task])
+	if !ok {
+		return nil, ErrWatchDisabled
+	}
+	return watcher.Watch(ctx)
+}
+
 // Retrieve all tasks from the data store
 func (w *Worker) GetTasks() []task.Task {
 	taskList, err := w.Db.List()
@@ -63,51 +246,179 @@ func (w *Worker) GetTasks() []task.Task {
 	return taskList
 }
 
-// Add a task to the pending queue
-func (w *Worker) AddTask(t task.Task) {
+// Retrieve all tasks from the data store, the running ones being checked against their container first
+//
+// A running task whose container is gone or exited is marked as failed, and the correction is stored,
+// so that the tasks reported after a crash of the worker or of the Docker daemon are accurate
+func (w *Worker) GetLiveTasks() []task.Task {
+	tasks := w.GetTasks()
+	for i := range tasks {
+		if tasks[i].State != task.Running || !w.checkContainer(&tasks[i]) {
+			continue
+		}
+		tasks[i] = w.storeCheckedTask(tasks[i], false)
+	}
+	return tasks
+}
+
+// Mark the given running task as failed if its container is missing or exited, the returned boolean is true when it is
+func (w *Worker) checkContainer(t *task.Task) bool {
+	taskLogger := log.With().
+		Str("task-id", t.Id.String()).
+		Str("container-id", t.ContainerId).
+		Logger()
+	container, err := w.inspectTask(*t)
+	switch {
+	case client.IsErrNotFound(err):
+		taskLogger.Error().Msg("container not found for task in running state")
+		t.Error = "container not found"
+	case err != nil:
+		taskLogger.Err(err).Msg("task inspection error")
+		return false
+	case container.State.Status == "exited":
+		taskLogger.Error().Msg("container exited for task in running state")
+		t.Error = fmt.Sprintf("container exited with code %d", container.State.ExitCode)
+	default:
+		return false
+	}
+	t.State = task.Failed
+	t.FinishTime = time.Now().UTC()
+	return true
+}
+
+// Add a task to the pending queue, or to the stops queue when the task is to be stopped
+//
+// ErrTaskInFlight is returned when the start of the same task is already queued or in progress,
+// the stops are always queued
+func (w *Worker) AddTask(t task.Task) error {
+	queue := w.Pending
+	if t.State == task.Completed {
+		queue = w.Stops
+	} else if _, loaded := w.starting.LoadOrStore(t.Id, struct{}{}); loaded {
+		return ErrTaskInFlight
+	}
 	// Run inside a goroutine to avoid blocking API call if chan is full
 	go func() {
-		w.Pending <- t
+		queue <- t
 	}()
+	return nil
 }
 
-// Start the pending tasks execution loop
-func (w *Worker) RunTasks() {
+// Wait for the next task to process, the pending stops are taken before the pending starts
+//
+// The boolean is false when the pending tasks channel is closed or the context is cancelled
+func (w *Worker) nextTask(ctx context.Context) (task.Task, bool) {
+	select {
+	case t := <-w.Stops:
+		return t, true
+	default:
+	}
+	select {
+	case <-ctx.Done():
+		return task.Task{}, false
+	case t := <-w.Stops:
+		return t, true
+	case t, ok := <-w.Pending:
+		return t, ok
+	}
+}
+
+// Start the pending tasks execution loop, up to MaxConcurrent tasks are processed at the same time
+//
+// It returns once the context is cancelled, the tasks being processed are awaited by Close
+func (w *Worker) RunTasks(ctx context.Context) {
 	log.Debug().Msg("starting queued tasks processing")
+	slots := make(chan struct{}, max(w.MaxConcurrent, 1))
 	for {
-		t, ok := <-w.Pending
+		t, ok := w.nextTask(ctx)
 		if !ok {
-			log.Debug().Msg("tasks channel closed, stop processing")
+			log.Debug().Msg("tasks processing stopped")
 			return
 		}
 
-		err := w.runTask(t)
-		if err != nil {
-			log.Err(err).Msg("error processing task")
-		}
+		slots <- struct{}{}
+		w.inFlight.Add(1)
+		go func(t task.Task) {
+			defer func() {
+				if t.State != task.Completed {
+					w.starting.Delete(t.Id)
+				}
+				<-slots
+				w.inFlight.Done()
+			}()
+			if err := w.runTask(t); err != nil {
+				log.Err(err).Str("task-id", t.Id.String()).Msg("error processing task")
+			}
+		}(t)
 	}
 }
 
 // Start the tasks update loop, it updates the status and informations of registered tasks
-func (w *Worker) UpdateTasks() {
-	for {
+//
+// It returns once the context is cancelled
+func (w *Worker) UpdateTasks(ctx context.Context) {
+	runEvery(ctx, w.UpdateInterval, func() {
 		log.Debug().Msg("checking tasks status")
 		w.updateTasks()
 		log.Debug().Msg("tasks status check completed")
-		time.Sleep(10 * time.Second)
-	}
+	})
 }
 
-// Start the stats collection loop
-func (w *Worker) CollectStats() {
+// Start the stats collection loop, it returns once the context is cancelled
+func (w *Worker) CollectStats(ctx context.Context) {
+	runEvery(ctx, w.StatsInterval, func() {
+		w.Stats = w.StatsCollector.Collect()
+	})
+}
+
+// Run the function right away then at each interval, until the context is cancelled
+func runEvery(ctx context.Context, interval time.Duration, fn func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 	for {
-		w.Stats = stats.GetStats()
-		time.Sleep(10 * time.Second)
+		fn()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Lock the given task, the returned function releases it
+//
+// The lock is discarded once released by all its holders, so that the locks don't accumulate
+func (w *Worker) lockTask(taskId uuid.UUID) func() {
+	w.taskLocksMu.Lock()
+	lock, found := w.taskLocks[taskId]
+	if !found {
+		lock = &taskLock{}
+		w.taskLocks[taskId] = lock
+	}
+	lock.refs++
+	w.taskLocksMu.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		w.taskLocksMu.Lock()
+		defer w.taskLocksMu.Unlock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(w.taskLocks, taskId)
+		}
 	}
 }
 
 // Decide if the given task should be started or stopped and execute the corresponding action
 func (w *Worker) runTask(queuedTask task.Task) error {
+	unlock := w.lockTask(queuedTask.Id)
+	defer unlock()
+
+	// The registry credentials are only needed to pull the image, they are never stored
+	registryAuth := queuedTask.RegistryAuth
+	queuedTask.RegistryAuth = nil
+
 	storedTask, err := w.Db.Get(queuedTask.Id)
 	if err != nil {
 		storedTask = queuedTask
@@ -122,55 +433,135 @@ func (w *Worker) runTask(queuedTask task.Task) error {
 
 	switch queuedTask.State {
 	case task.Scheduled:
+		if queuedTask.IsAdoption() {
+			return w.adoptTask(queuedTask)
+		}
 		if queuedTask.ContainerId != "" {
-			// Case of a restart when the container is still running
+			// Case of a restart when the container is still running, it may already be removed
 			err = w.stopTask(queuedTask)
-			if err != nil {
+			if err != nil && !client.IsErrNotFound(err) {
 				log.Err(err).Str("task-id", storedTask.Id.String()).Msg("failed to stop task")
 				return err
 			}
 		}
-		return w.startTask(queuedTask)
+		return w.startTask(queuedTask, registryAuth)
 	case task.Completed:
+		// The stop may have been requested while the task was starting, before its container was known
+		if queuedTask.ContainerId == "" {
+			queuedTask.ContainerId = storedTask.ContainerId
+		}
 		return w.stopTask(queuedTask)
 	default:
 		return fmt.Errorf("running a task shouldn't be represented with a %v state", queuedTask.State)
 	}
 }
 
-// Start a task by creating and starting a container for it
-func (w *Worker) startTask(t task.Task) error {
+// Start a task by creating and starting a container for it, its image is pulled with the given registry credentials
+func (w *Worker) startTask(t task.Task, registryAuth *task.RegistryAuth) error {
 	t.StartTime = time.Now().UTC()
 	config := task.NewConfig(t)
-	c := task.NewContainerClient()
+	config.RegistryAuth = registryAuth
+	config.Name = task.ContainerName(w.ContainerPrefix, t.Id)
+	c := w.Docker
 
-	containerId, err := c.Run(config)
+	ctx := context.Background()
+	if w.StartTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.StartTimeout)
+		defer cancel()
+	}
+	pullPolicy := t.EffectivePullPolicy()
+	switch pullPolicy {
+	case task.PullIfNotPresent:
+		config.SkipPull = w.imagePresent(ctx, c, t.Image)
+	case task.PullNever:
+		config.SkipPull = true
+	}
+	containerId, err := c.Run(ctx, config)
 	taskLogger := log.With().
 		Str("task-id", t.Id.String()).
 		Logger()
 	if err != nil {
+		switch {
+		case errors.Is(err, context.DeadlineExceeded):
+			err = fmt.Errorf("task start timed out after %v: %w", w.StartTimeout, err)
+		case pullPolicy == task.PullNever && client.IsErrNotFound(err):
+			err = fmt.Errorf("image %s isn't present on the node and the pull policy is %s: %w", t.Image, pullPolicy, err)
+		}
 		taskLogger.Err(err).Msg("error running task")
 		t.State = task.Failed
+		t.FinishTime = time.Now().UTC()
+		t.Error = err.Error()
 		if err := w.Db.Put(t.Id, t); err != nil {
 			taskLogger.Err(err).Msg("failed to store task")
 		}
 		return err
 	}
 
+	if !config.SkipPull {
+		w.recordImage(ctx, c, t.Image)
+	}
+
 	t.ContainerId = containerId
+	t.ContainerRestarts = 0
 	t.State = task.Running
+	t.Error = ""
 	if err := w.Db.Put(t.Id, t); err != nil {
 		taskLogger.Err(err).Msg("failed to store task")
 	}
 
 	taskLogger.Info().Str("container-id", t.ContainerId).Msg("created and started container")
+	if t.CaptureOutput {
+		w.startOutputCapture(t, false)
+	}
 	return err
 }
 
+// Start tracking the existing container of the given task instead of creating a new one
+//
+// The task image is set from the container, so that the task can later be restarted
+func (w *Worker) adoptTask(t task.Task) error {
+	taskLogger := log.With().
+		Str("task-id", t.Id.String()).
+		Str("container-id", t.ContainerId).
+		Logger()
+
+	container, err := w.inspectTask(t)
+	if err != nil {
+		taskLogger.Err(err).Msg("error adopting container")
+		t.State = task.Failed
+		t.FinishTime = time.Now().UTC()
+		t.Error = fmt.Sprintf("failed to adopt container: %v", err)
+		if err := w.Db.Put(t.Id, t); err != nil {
+			taskLogger.Err(err).Msg("failed to store task")
+		}
+		return err
+	}
+
+	t.ContainerId = container.ID
+	t.Image = container.Config.Image
+	t.ContainerRestarts = container.RestartCount // Restarts prior to the adoption aren't counted
+	t.StartTime = time.Now().UTC()
+	if container.State.Running {
+		t.State = task.Running
+	} else {
+		t.State = task.Failed
+		t.FinishTime = time.Now().UTC()
+		t.Error = fmt.Sprintf("adopted container is %s", container.State.Status)
+	}
+	if err := w.Db.Put(t.Id, t); err != nil {
+		taskLogger.Err(err).Msg("failed to store task")
+	}
+
+	taskLogger.Info().Str("state", t.State.String()).Msg("adopted existing container")
+	return nil
+}
+
 // Stop a task by stopping and removing the linked container
 func (w *Worker) stopTask(t task.Task) error {
-	c := task.NewContainerClient()
-	err := c.Stop(t.ContainerId)
+	ctx, cancel := w.stopContext(t)
+	defer cancel()
+	err := w.Docker.Stop(ctx, t.ContainerId, t.StopTimeout)
 	taskLogger := log.With().
 		Str("task-id", t.Id.String()).
 		Str("container-id", t.ContainerId).
@@ -191,8 +582,32 @@ func (w *Worker) stopTask(t task.Task) error {
 
 // Inspect the container related to the given task
 func (w *Worker) inspectTask(t task.Task) (types.ContainerJSON, error) {
-	c := task.NewContainerClient()
-	return c.Inspect(t.ContainerId)
+	ctx, cancel := w.dockerContext()
+	defer cancel()
+	return w.Docker.Inspect(ctx, t.ContainerId)
+}
+
+// Get a context bounding a Docker daemon request to the worker Docker timeout, when it is set
+func (w *Worker) dockerContext() (context.Context, context.CancelFunc) {
+	if w.DockerTimeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), w.DockerTimeout)
+}
+
+// Get a context bounding the stop of a task container, the Docker timeout being extended by its grace period
+//
+// Docker waits for the task stop timeout, or its default grace period, before killing the container, so the
+// request must not time out before
+func (w *Worker) stopContext(t task.Task) (context.Context, context.CancelFunc) {
+	if w.DockerTimeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	grace := defaultStopGrace
+	if t.StopTimeout != nil {
+		grace = time.Duration(*t.StopTimeout) * time.Second
+	}
+	return context.WithTimeout(context.Background(), w.DockerTimeout+grace)
 }
 
 // Update the status and other informations of all registered tasks
@@ -211,27 +626,80 @@ func (w *Worker) updateTasks() {
 			Str("task-id", t.Id.String()).
 			Logger()
 		container, err := w.inspectTask(t)
-		update := false
+		update, unhealthy := false, false
 		if err != nil {
 			taskLogger.Err(err).Msg("task inspection error")
 		} else if container.State.Status == "exited" {
 			taskLogger.Error().Msg("container exited for task in running state")
 			t.State = task.Failed
 			update = true
+		} else if container.State.Health != nil && container.State.Health.Status == types.Unhealthy {
+			taskLogger.Error().Int("failing-streak", container.State.Health.FailingStreak).Msg("container is unhealthy for task in running state")
+			t.State = task.Failed
+			t.FinishTime = time.Now().UTC()
+			t.Error = "container health check failed"
+			update, unhealthy = true, true
 		} else {
+			if container.RestartCount > t.ContainerRestarts {
+				// Docker restarted the container on its own because of the task restart policy
+				t.RestartCount += container.RestartCount - t.ContainerRestarts
+				t.ContainerRestarts = container.RestartCount
+				update = true
+			}
 			for port, binds := range container.NetworkSettings.NetworkSettingsBase.Ports {
-				if len(binds) != 0 {
-					t.PortBindings[string(port)] = binds[0].HostPort
-					update = true
+				if len(binds) == 0 || t.PortBindings[string(port)] == binds[0].HostPort {
+					continue
+				}
+				if t.PortBindings == nil {
+					t.PortBindings = make(map[string]string)
 				}
+				t.PortBindings[string(port)] = binds[0].HostPort
+				update = true
 			}
 		}
 		if !update {
 			continue
 		}
+		// The hung container of an unhealthy task is removed, a new one is created if the task is restarted
+		w.storeCheckedTask(t, unhealthy)
+	}
+}
 
-		if err := w.Db.Put(t.Id, t); err != nil {
-			taskLogger.Err(err).Msg("failed to store task")
+// Store the changes of a running task found while checking its container, the stored task is returned
+//
+// The task is read again under its lock, the changes are dropped if the task was stopped or restarted since
+// its container was checked. The container is stopped and removed first when stop is set
+func (w *Worker) storeCheckedTask(checked task.Task, stop bool) task.Task {
+	unlock := w.lockTask(checked.Id)
+	defer unlock()
+
+	taskLogger := log.With().
+		Str("task-id", checked.Id.String()).
+		Str("container-id", checked.ContainerId).
+		Logger()
+	t, err := w.Db.Get(checked.Id)
+	if err != nil {
+		taskLogger.Err(err).Msg("failed to retrieve task from store")
+		return checked
+	}
+	if t.State != task.Running || t.ContainerId != checked.ContainerId {
+		return t
+	}
+	if stop {
+		ctx, cancel := w.stopContext(t)
+		defer cancel()
+		if err := w.Docker.Stop(ctx, t.ContainerId, t.StopTimeout); err != nil && !client.IsErrNotFound(err) {
+			taskLogger.Err(err).Msg("failed to stop container")
 		}
 	}
+	t.State = checked.State
+	t.FinishTime = checked.FinishTime
+	t.Error = checked.Error
+	t.RestartCount = checked.RestartCount
+	t.ContainerRestarts = checked.ContainerRestarts
+	t.PortBindings = checked.PortBindings
+	if err := w.Db.Put(t.Id, t); err != nil {
+		taskLogger.Err(err).Msg("failed to store task")
+	}
+	return t
 }
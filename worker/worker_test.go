@@ -0,0 +1,672 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/c9s/goprocinfo/linux"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+	"github.com/google/uuid"
+	bolt "go.etcd.io/bbolt"
+
+	"orchestrator/stats"
+	"orchestrator/task"
+)
+
+func TestShutdownClosesStoreOnceLoopsReturned(t *testing.T) {
+	w, err := New("test", "memory", t.TempDir(), newFakeDocker(t, "").config())
+	if err != nil {
+		t.Fatalf("failed to create worker: %v", err)
+	}
+	db := newBlockingStore(w.Db)
+	w.Db = db
+	w.Start()
+
+	// The tasks execution loop blocks while reading the stored task
+	w.AddTask(task.Task{Id: uuid.New(), Name: "web", State: task.Completed})
+	select {
+	case <-db.entered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the task processing")
+	}
+
+	shutdownErr := make(chan error, 1)
+	go func() {
+		shutdownErr <- w.Shutdown(context.Background(), nil)
+	}()
+	select {
+	case err := <-shutdownErr:
+		t.Fatalf("shutdown returned while a loop was running: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if db.isClosed() {
+		t.Fatal("store closed while a loop was running")
+	}
+
+	close(db.release)
+	select {
+	case err := <-shutdownErr:
+		if err != nil {
+			t.Fatalf("shutdown failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the shutdown")
+	}
+	if !db.isClosed() {
+		t.Error("store not closed by the shutdown")
+	}
+}
+
+func TestStartTaskFailsWhenStartTimesOut(t *testing.T) {
+	fd := newFakeDocker(t, "")
+	fd.pullDelay = time.Second
+	w := newTestWorker(t, fd)
+	w.StartTimeout = 50 * time.Millisecond
+	tk := task.Task{Id: uuid.New(), Name: "web", Image: "nginx", State: task.Scheduled}
+
+	if err := w.startTask(tk, nil); err == nil {
+		t.Fatal("expected the task start to time out")
+	}
+	stored, err := w.Db.Get(tk.Id)
+	if err != nil {
+		t.Fatalf("failed to get task: %v", err)
+	}
+	if stored.State != task.Failed {
+		t.Errorf("expected task state %v, got %v", task.Failed, stored.State)
+	}
+	if !strings.Contains(stored.Error, "timed out") {
+		t.Errorf("expected the timeout to be reported as the task error, got %q", stored.Error)
+	}
+}
+
+func TestStopAndInspectionBoundedByDockerTimeout(t *testing.T) {
+	fd := newFakeDocker(t, "")
+	fd.hangDelay = time.Minute
+	fd.setContainer(newContainer("container-1", "nginx", "running"))
+	w := newTestWorker(t, fd)
+	w.DockerTimeout = 50 * time.Millisecond
+	tk := task.Task{Id: uuid.New(), Name: "web", State: task.Running, ContainerId: "container-1"}
+	w.Db.Put(tk.Id, tk)
+
+	begin := time.Now()
+	if _, err := w.inspectTask(tk); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the inspection to time out, got %v", err)
+	}
+	stop := tk
+	stop.State = task.Completed
+	noGrace := 0
+	stop.StopTimeout = &noGrace
+	if err := w.stopTask(stop); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the stop to time out, got %v", err)
+	}
+	if elapsed := time.Since(begin); elapsed > 5*time.Second {
+		t.Errorf("expected the requests to be given up after the timeout, took %v", elapsed)
+	}
+	if stored, _ := w.Db.Get(tk.Id); stored.State != task.Running {
+		t.Errorf("expected the task whose stop timed out to stay running, got state %v", stored.State)
+	}
+}
+
+func TestPersistedTasksWithoutNamespaceMigrated(t *testing.T) {
+	dataDir := t.TempDir()
+	tk := task.Task{Id: uuid.New(), Name: "web", Image: "nginx", State: task.Running}
+	db, err := bolt.Open(filepath.Join(dataDir, "test.db"), 0600, nil)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	// Record written before the schema versioning and the namespaces
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("tasks"))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(tk.Id.String()), []byte(fmt.Sprintf(`{"Id":"%s","Name":"web","Image":"nginx","State":%d}`, tk.Id, task.Running)))
+	})
+	db.Close()
+	if err != nil {
+		t.Fatalf("failed to write task: %v", err)
+	}
+
+	w, err := New("test", "persisted", dataDir, newFakeDocker(t, "").config())
+	if err != nil {
+		t.Fatalf("failed to create worker: %v", err)
+	}
+	defer w.Close()
+	stored, err := w.Db.Get(tk.Id)
+	if err != nil {
+		t.Fatalf("failed to get migrated task: %v", err)
+	}
+	if stored.Namespace != task.DefaultNamespace || stored.Name != "web" || stored.State != task.Running {
+		t.Errorf("unexpected migrated task %+v", stored)
+	}
+}
+
+// Create the inspection result of a container of the given image and status
+func newContainer(id string, image string, status string) types.ContainerJSON {
+	return types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:    id,
+			State: &types.ContainerState{Status: status, Running: status == "running"},
+		},
+		Config:          &container.Config{Image: image},
+		NetworkSettings: &types.NetworkSettings{},
+	}
+}
+
+// Create the scheduling of a task adopting the given container
+func newAdoption(containerId string) task.Task {
+	return task.Task{Id: uuid.New(), Name: "legacy", Namespace: "default", ContainerId: containerId, PreferredNode: "test", State: task.Scheduled}
+}
+
+func TestAdoptedContainerTracked(t *testing.T) {
+	fd := newFakeDocker(t, "")
+	fd.setContainer(newContainer("legacy-1", "nginx:1.25", "running"))
+	w := newTestWorker(t, fd)
+	tk := newAdoption("legacy-1")
+
+	if err := w.runTask(tk); err != nil {
+		t.Fatalf("failed to adopt container: %v", err)
+	}
+	stored, _ := w.Db.Get(tk.Id)
+	if stored.State != task.Running || stored.Image != "nginx:1.25" || stored.ContainerId != "legacy-1" {
+		t.Fatalf("expected the adopted container to be tracked as running, got %+v", stored)
+	}
+
+	// The adopted container exit is reported like the created containers ones
+	fd.setContainer(newContainer("legacy-1", "nginx:1.25", "exited"))
+	w.updateTasks()
+	if stored, _ := w.Db.Get(tk.Id); stored.State != task.Failed {
+		t.Errorf("expected the exited adopted container to fail the task, got state %v", stored.State)
+	}
+}
+
+func TestAdoptionOfStoppedContainerFails(t *testing.T) {
+	fd := newFakeDocker(t, "")
+	fd.setContainer(newContainer("legacy-1", "nginx", "exited"))
+	w := newTestWorker(t, fd)
+	tk := newAdoption("legacy-1")
+
+	w.runTask(tk)
+
+	stored, _ := w.Db.Get(tk.Id)
+	if stored.State != task.Failed || !strings.Contains(stored.Error, "exited") {
+		t.Errorf("expected the adoption of a stopped container to fail, got state %v and error %q", stored.State, stored.Error)
+	}
+}
+
+func TestAdoptionOfUnknownContainerFails(t *testing.T) {
+	w := newTestWorker(t, newFakeDocker(t, ""))
+	tk := newAdoption("missing")
+
+	if err := w.runTask(tk); err == nil {
+		t.Fatal("expected the adoption of an unknown container to fail")
+	}
+	stored, _ := w.Db.Get(tk.Id)
+	if stored.State != task.Failed || !strings.Contains(stored.Error, "failed to adopt container") {
+		t.Errorf("expected the task to fail, got state %v and error %q", stored.State, stored.Error)
+	}
+}
+
+func TestDockerRestartsCountedInRestartCount(t *testing.T) {
+	fd := newFakeDocker(t, "")
+	w := newTestWorker(t, fd)
+	tk := task.Task{Id: uuid.New(), Name: "web", State: task.Running, ContainerId: "container-1", RestartCount: 1, RestartPolicy: "always"}
+	w.Db.Put(tk.Id, tk)
+
+	restarted := newContainer("container-1", "nginx", "running")
+	restarted.RestartCount = 2
+	fd.setContainer(restarted)
+	w.updateTasks()
+	stored, _ := w.Db.Get(tk.Id)
+	if stored.RestartCount != 3 || stored.ContainerRestarts != 2 {
+		t.Fatalf("expected the 2 docker restarts to be counted, got restart count %d and container restarts %d", stored.RestartCount, stored.ContainerRestarts)
+	}
+
+	// The restarts already counted aren't counted again
+	restarted.RestartCount = 3
+	fd.setContainer(restarted)
+	w.updateTasks()
+	w.updateTasks()
+	if stored, _ := w.Db.Get(tk.Id); stored.RestartCount != 4 {
+		t.Errorf("expected only the new docker restart to be counted, got restart count %d", stored.RestartCount)
+	}
+}
+
+func TestAdoptedContainerPublishedPortsRecorded(t *testing.T) {
+	fd := newFakeDocker(t, "")
+	c := newContainer("legacy-1", "nginx", "running")
+	fd.setContainer(c)
+	w := newTestWorker(t, fd)
+	tk := newAdoption("legacy-1")
+	if err := w.runTask(tk); err != nil {
+		t.Fatalf("failed to adopt container: %v", err)
+	}
+
+	// The adopted task has no port bindings of its own
+	c.NetworkSettings.Ports = nat.PortMap{"80/tcp": {{HostIP: "0.0.0.0", HostPort: "32768"}}}
+	fd.setContainer(c)
+	w.updateTasks()
+	stored, _ := w.Db.Get(tk.Id)
+	if stored.PortBindings["80/tcp"] != "32768" {
+		t.Fatalf("expected the published port to be recorded, got %v", stored.PortBindings)
+	}
+
+	// The task isn't stored again while its published ports are unchanged
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes, err := w.WatchTasks(ctx)
+	if err != nil {
+		t.Fatalf("failed to watch tasks: %v", err)
+	}
+	w.updateTasks()
+	select {
+	case change := <-changes:
+		t.Errorf("expected no task change, got %+v", change)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestUnhealthyContainerFailsTask(t *testing.T) {
+	fd := newFakeDocker(t, "")
+	w := newTestWorker(t, fd)
+	healthy := task.Task{Id: uuid.New(), Name: "api", State: task.Running, ContainerId: "healthy"}
+	unhealthy := task.Task{Id: uuid.New(), Name: "web", State: task.Running, ContainerId: "unhealthy", Image: "nginx"}
+	w.Db.Put(healthy.Id, healthy)
+	w.Db.Put(unhealthy.Id, unhealthy)
+
+	for id, status := range map[string]string{"healthy": types.Healthy, "unhealthy": types.Unhealthy} {
+		c := newContainer(id, "nginx", "running")
+		c.State.Health = &types.Health{Status: status, FailingStreak: 3}
+		fd.setContainer(c)
+	}
+	w.updateTasks()
+
+	if stored, _ := w.Db.Get(healthy.Id); stored.State != task.Running {
+		t.Errorf("expected the healthy task to be running, got state %v", stored.State)
+	}
+	stored, _ := w.Db.Get(unhealthy.Id)
+	if stored.State != task.Failed || stored.Error == "" || stored.FinishTime.IsZero() {
+		t.Errorf("expected the unhealthy task to be failed with an error, got state %v and error %q", stored.State, stored.Error)
+	}
+	if removed := fd.removedContainers(); !slices.Equal(removed, []string{"unhealthy"}) {
+		t.Fatalf("expected the unhealthy container to be stopped and removed, got %v", removed)
+	}
+
+	// The restart of the failed task creates a new container
+	restarted := stored
+	restarted.State = task.Scheduled
+	if err := w.runTask(restarted); err != nil {
+		t.Fatalf("failed to restart task: %v", err)
+	}
+	if stored, _ := w.Db.Get(unhealthy.Id); stored.State != task.Running || stored.ContainerId == "unhealthy" {
+		t.Errorf("expected the task to run in a new container, got state %v in container %s", stored.State, stored.ContainerId)
+	}
+}
+
+// Store a running task whose container has the given id
+func storeRunningTask(t *testing.T, w *Worker, containerId string) task.Task {
+	t.Helper()
+	tk := task.Task{Id: uuid.New(), Name: "web", Namespace: "default", Image: "nginx", State: task.Running, ContainerId: containerId}
+	if err := w.Db.Put(tk.Id, tk); err != nil {
+		t.Fatalf("failed to store task: %v", err)
+	}
+	return tk
+}
+
+func TestGetLiveTasksMarksMissingContainersFailed(t *testing.T) {
+	fd := newFakeDocker(t, "")
+	w := newTestWorker(t, fd)
+	fd.setContainer(newContainer("running", "nginx", "running"))
+	fd.setContainer(newContainer("exited", "nginx", "exited"))
+	running := storeRunningTask(t, w, "running")
+	exited := storeRunningTask(t, w, "exited")
+	gone := storeRunningTask(t, w, "gone")
+
+	states := make(map[string]task.State)
+	for _, tk := range w.GetLiveTasks() {
+		states[tk.ContainerId] = tk.State
+	}
+	expected := map[string]task.State{"running": task.Running, "exited": task.Failed, "gone": task.Failed}
+	for containerId, state := range expected {
+		if states[containerId] != state {
+			t.Errorf("expected task of container %s to be %v, got %v", containerId, state, states[containerId])
+		}
+	}
+
+	// The corrections are stored
+	for _, tk := range []task.Task{running, exited, gone} {
+		stored, err := w.Db.Get(tk.Id)
+		if err != nil {
+			t.Fatalf("failed to get task: %v", err)
+		}
+		if stored.State != expected[tk.ContainerId] {
+			t.Errorf("expected stored task of container %s to be %v, got %v", tk.ContainerId, expected[tk.ContainerId], stored.State)
+		}
+	}
+}
+
+func TestContainerFailureIgnoredOnceTaskStopped(t *testing.T) {
+	w := newTestWorker(t, newFakeDocker(t, ""))
+	tk := storeRunningTask(t, w, "gone")
+
+	failed := tk
+	if !w.checkContainer(&failed) {
+		t.Fatal("expected the missing container to be detected")
+	}
+	// The task is stopped between the container check and the failure write
+	stopped := tk
+	stopped.State = task.Completed
+	w.Db.Put(stopped.Id, stopped)
+
+	if reported := w.storeCheckedTask(failed, false); reported.State != task.Completed {
+		t.Errorf("expected the stopped task to be reported, got state %v", reported.State)
+	}
+	if stored, _ := w.Db.Get(tk.Id); stored.State != task.Completed {
+		t.Errorf("expected the stopped task to be left untouched, got state %v", stored.State)
+	}
+}
+
+// Run the given number of slow tasks through the pending queue, returning the highest number of overlapping starts
+func runSlowTasks(t *testing.T, maxConcurrent int, count int) int {
+	t.Helper()
+	fd := newFakeDocker(t, "")
+	fd.pullDelay = 200 * time.Millisecond
+	w := newTestWorker(t, fd)
+	w.MaxConcurrent = maxConcurrent
+	w.Start()
+	for i := 0; i < count; i++ {
+		w.AddTask(task.Task{Id: uuid.New(), Name: fmt.Sprintf("web-%d", i), Image: "nginx", State: task.Scheduled})
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		tasks, _ := w.Db.List()
+		if len(tasks) == count {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for the tasks to be processed, %d processed", len(tasks))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := w.Shutdown(ctx, nil); err != nil {
+		t.Fatalf("failed to shut down worker: %v", err)
+	}
+	return fd.concurrentPulls()
+}
+
+func TestTasksProcessedConcurrently(t *testing.T) {
+	if pulls := runSlowTasks(t, 3, 3); pulls != 3 {
+		t.Errorf("expected the 3 tasks starts to overlap, got at most %d at the same time", pulls)
+	}
+}
+
+func TestConcurrentTasksLimited(t *testing.T) {
+	if pulls := runSlowTasks(t, 2, 4); pulls != 2 {
+		t.Errorf("expected at most 2 tasks started at the same time, got %d", pulls)
+	}
+}
+
+func TestNewFailsWhenDockerUnreachable(t *testing.T) {
+	fd := newFakeDocker(t, "")
+	config := fd.config()
+	fd.Close()
+
+	if _, err := New("test", "memory", t.TempDir(), config); err == nil || !strings.Contains(err.Error(), "unreachable") {
+		t.Errorf("expected the worker creation to report the unreachable daemon, got %v", err)
+	}
+}
+
+func TestNewRejectsMissingDockerTLSFiles(t *testing.T) {
+	config := newFakeDocker(t, "").config()
+	config.TLSPath = t.TempDir()
+
+	if _, err := New("test", "memory", t.TempDir(), config); err == nil {
+		t.Error("expected the worker creation to fail without the TLS certificates")
+	}
+}
+
+func TestStopsProcessedAheadOfStarts(t *testing.T) {
+	w := newTestWorker(t, newFakeDocker(t, ""))
+	for i := 0; i < 5; i++ {
+		w.AddTask(task.Task{Id: uuid.New(), Name: fmt.Sprintf("web-%d", i), Image: "nginx", State: task.Scheduled})
+	}
+	stop := task.Task{Id: uuid.New(), Name: "db", State: task.Completed}
+	w.AddTask(stop)
+	waitForQueued := time.Now().Add(5 * time.Second)
+	for len(w.Pending)+len(w.Stops) != 6 {
+		if time.Now().After(waitForQueued) {
+			t.Fatal("timed out waiting for the tasks to be queued")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	next, ok := w.nextTask(context.Background())
+	if !ok || next.Id != stop.Id {
+		t.Fatalf("expected the stop to be processed ahead of the starts backlog, got task %s", next.Name)
+	}
+	for i := 0; i < 5; i++ {
+		if next, _ := w.nextTask(context.Background()); next.State != task.Scheduled {
+			t.Errorf("expected the starts to follow the stop, got a %v task", next.State)
+		}
+	}
+}
+
+func TestTaskLocksReleasedOnceProcessed(t *testing.T) {
+	fd := newFakeDocker(t, "")
+	w := newTestWorker(t, fd)
+	tk := task.Task{Id: uuid.New(), Name: "web", Image: "nginx", State: task.Scheduled}
+	if err := w.runTask(tk); err != nil {
+		t.Fatalf("failed to start task: %v", err)
+	}
+	tk.State = task.Completed
+	if err := w.runTask(tk); err != nil {
+		t.Fatalf("failed to stop task: %v", err)
+	}
+
+	w.taskLocksMu.Lock()
+	defer w.taskLocksMu.Unlock()
+	if len(w.taskLocks) != 0 {
+		t.Errorf("expected the task locks to be discarded once released, got %d locks", len(w.taskLocks))
+	}
+}
+
+func TestLoopsReturnWhenContextCancelled(t *testing.T) {
+	w := newTestWorker(t, newFakeDocker(t, ""))
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan struct{})
+	loops := []func(context.Context){w.RunTasks, w.CollectStats, w.UpdateTasks}
+	for _, loop := range loops {
+		go func(loop func(context.Context)) {
+			loop(ctx)
+			done <- struct{}{}
+		}(loop)
+	}
+	cancel()
+	for range loops {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the loops to return")
+		}
+	}
+}
+
+func TestRunEveryFiresUntilCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	runs := make(chan struct{}, 10)
+	done := make(chan struct{})
+	go func() {
+		runEvery(ctx, time.Millisecond, func() {
+			select {
+			case runs <- struct{}{}:
+			default:
+			}
+		})
+		close(done)
+	}()
+	for i := 0; i < 3; i++ {
+		select {
+		case <-runs:
+		case <-time.After(time.Second):
+			t.Fatalf("expected the loop to run several times, got %d runs", i)
+		}
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected the loop to return once the context is cancelled")
+	}
+}
+
+func TestCollectStatsUsesConfiguredCollector(t *testing.T) {
+	w := newTestWorker(t, newFakeDocker(t, ""))
+	collected := &stats.Stats{MemoryStats: &linux.MemInfo{MemTotal: 2048, MemAvailable: 1024}}
+	w.StatsCollector = fakeCollector{stats: collected}
+
+	// The stats are collected once before the loop notices the cancellation
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w.CollectStats(ctx)
+	if w.Stats != collected {
+		t.Fatalf("expected the stats of the configured collector, got %+v", w.Stats)
+	}
+
+	api := &Api{Worker: w}
+	api.initRouter()
+	rec := httptest.NewRecorder()
+	api.Router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	served := stats.Stats{}
+	if err := json.NewDecoder(rec.Body).Decode(&served); err != nil {
+		t.Fatalf("failed to decode stats: %v", err)
+	}
+	if served.MemUsedKb() != 1024 {
+		t.Errorf("expected the collected stats to be served, got %d KB of used memory", served.MemUsedKb())
+	}
+}
+
+// Run with -race: the duplicate starts are submitted concurrently
+func TestDuplicateStartRejectedWhileInFlight(t *testing.T) {
+	fd := newFakeDocker(t, "")
+	w := newTestWorker(t, fd)
+	tk := task.Task{Id: uuid.New(), Name: "web", Image: "nginx", State: task.Scheduled}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	accepted := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := w.AddTask(tk)
+			if err != nil && !errors.Is(err, ErrTaskInFlight) {
+				t.Errorf("expected ErrTaskInFlight for a duplicate start, got %v", err)
+			}
+			if err == nil {
+				mu.Lock()
+				accepted++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if accepted != 1 {
+		t.Fatalf("expected a single start to be accepted, got %d", accepted)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.RunTasks(ctx)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if stored, err := w.Db.Get(tk.Id); err == nil && stored.State == task.Running {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the task to run")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	fd.mu.Lock()
+	created := fd.created
+	fd.mu.Unlock()
+	if created != 1 {
+		t.Errorf("expected a single container to be created, got %d", created)
+	}
+
+	// The start is processed, the task can be started again
+	waitForStart := time.Now().Add(5 * time.Second)
+	for w.AddTask(tk) != nil {
+		if time.Now().After(waitForStart) {
+			t.Fatal("expected the task start to be accepted once the previous one is processed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStopsNeverRejected(t *testing.T) {
+	w := newTestWorker(t, newFakeDocker(t, ""))
+	tk := task.Task{Id: uuid.New(), Name: "web", Image: "nginx", State: task.Scheduled}
+	w.AddTask(tk)
+
+	tk.State = task.Completed
+	for i := 0; i < 2; i++ {
+		if err := w.AddTask(tk); err != nil {
+			t.Errorf("expected the stop to be queued while the start is in flight, got %v", err)
+		}
+	}
+}
+
+func TestRegistryAuthNotStored(t *testing.T) {
+	w := newTestWorker(t, newFakeDocker(t, ""))
+	tk := task.Task{Id: uuid.New(), Name: "web", Image: "registry.example.com/web", State: task.Scheduled}
+	tk.RegistryAuth = &task.RegistryAuth{Username: "user", Password: "s3cr3t"}
+
+	if err := w.runTask(tk); err != nil {
+		t.Fatalf("failed to run task: %v", err)
+	}
+	stored, _ := w.Db.Get(tk.Id)
+	if stored.State != task.Running || stored.RegistryAuth != nil {
+		t.Errorf("expected the task to run without storing its credentials, got state %v and credentials %v", stored.State, stored.RegistryAuth)
+	}
+}
+
+func TestStopContextExtendedByStopTimeout(t *testing.T) {
+	w := newTestWorker(t, newFakeDocker(t, ""))
+	w.DockerTimeout = time.Second
+	timeout := 60
+	grace := map[*int]time.Duration{nil: defaultStopGrace, &timeout: time.Minute}
+
+	for stopTimeout, expected := range grace {
+		ctx, cancel := w.stopContext(task.Task{StopTimeout: stopTimeout})
+		deadline, ok := ctx.Deadline()
+		cancel()
+		if remaining := time.Until(deadline); !ok || remaining > w.DockerTimeout+expected || remaining < expected {
+			t.Errorf("expected the stop to be bound to the docker timeout extended by %v, got %v", expected, remaining)
+		}
+	}
+
+	w.DockerTimeout = 0
+	ctx, cancel := w.stopContext(task.Task{StopTimeout: &timeout})
+	defer cancel()
+	if _, ok := ctx.Deadline(); ok {
+		t.Error("expected the stop not to be bound without docker timeout")
+	}
+}